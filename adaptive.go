@@ -0,0 +1,132 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// adaptiveDecreaseInterval is the minimum time between two decreases, so a
+// burst of failures from requests that were already in flight only counts once
+const adaptiveDecreaseInterval = time.Second
+
+// adaptiveLimiter limits the number of requests in flight with AIMD: the limit
+// is halved when the server signals overload and grows by one per window of
+// successful requests, up to max
+type adaptiveLimiter struct {
+	name string
+	max  float64
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	limit        float64
+	inFlight     int
+	lastDecrease time.Time
+	now          func() time.Time
+}
+
+func newAdaptiveLimiter(name string, max int) *adaptiveLimiter {
+	l := &adaptiveLimiter{name: name, max: float64(max), limit: float64(max), now: time.Now}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire blocks until a request can be started
+func (l *adaptiveLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+// release ends a request and adjusts the limit based on its outcome
+func (l *adaptiveLimiter) release(overloaded bool, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+
+	before := int(l.limit)
+	if overloaded {
+		if now := l.now(); now.Sub(l.lastDecrease) >= adaptiveDecreaseInterval {
+			l.lastDecrease = now
+			l.limit = max(1, l.limit/2)
+		}
+	} else {
+		// one more request per window of successful requests
+		l.limit = min(l.max, l.limit+1/l.limit)
+	}
+
+	if after := int(l.limit); after != before {
+		event := log.Debug()
+		if after < before {
+			event = log.Info().Str("reason", reason)
+		}
+		event.Str("phase", l.name).Int("concurrency", after).Msg("Adjusted concurrency")
+	}
+	l.cond.Broadcast()
+}
+
+// current returns the current limit
+func (l *adaptiveLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// adaptiveClient is an HttpClient whose concurrency adapts to the responses
+// of the server, backing off when it is rate limited or failing
+type adaptiveClient struct {
+	client  HttpClient
+	limiter *adaptiveLimiter
+}
+
+func newAdaptiveClient(name string, client HttpClient, max int) *adaptiveClient {
+	return &adaptiveClient{client: client, limiter: newAdaptiveLimiter(name, max)}
+}
+
+func (c *adaptiveClient) Do(req *http.Request) (*http.Response, error) {
+	c.limiter.acquire()
+	res, err := c.client.Do(req)
+	c.limiter.release(overloaded(res, err))
+	return res, err
+}
+
+func (c *adaptiveClient) Post(url string, contentType string, body io.Reader) (*http.Response, error) {
+	c.limiter.acquire()
+	res, err := c.client.Post(url, contentType, body)
+	c.limiter.release(overloaded(res, err))
+	return res, err
+}
+
+// overloaded reports whether a response signals that the server is overloaded:
+// rate limiting, server errors or a failed request
+func overloaded(res *http.Response, err error) (bool, string) {
+	switch {
+	case err != nil:
+		return true, err.Error()
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError:
+		return true, res.Status
+	default:
+		return false, ""
+	}
+}
@@ -0,0 +1,90 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_adaptiveLimiter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	l := newAdaptiveLimiter("upload", 16)
+	l.now = func() time.Time { return now }
+
+	// a burst of failures within the decrease interval only halves once
+	for i := 0; i < 3; i++ {
+		l.acquire()
+		l.release(true, "503 Service Unavailable")
+	}
+	if got := l.current(); got != 8 {
+		t.Fatalf("limit after a burst of failures = %d, want 8", got)
+	}
+
+	now = now.Add(adaptiveDecreaseInterval)
+	l.acquire()
+	l.release(true, "429 Too Many Requests")
+	if got := l.current(); got != 4 {
+		t.Fatalf("limit after a second failure = %d, want 4", got)
+	}
+
+	// grows by about one per window of successes
+	for i := 0; i < 5; i++ {
+		l.acquire()
+		l.release(false, "")
+	}
+	if got := l.current(); got != 5 {
+		t.Errorf("limit after a window of successes = %d, want 5", got)
+	}
+
+	for i := 0; i < 1000; i++ {
+		l.acquire()
+		l.release(false, "")
+	}
+	if got := l.current(); got != 16 {
+		t.Errorf("limit after recovering = %d, want the maximum of 16", got)
+	}
+}
+
+func Test_adaptiveClient(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	c := newAdaptiveClient("upload", &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		},
+	}, 4)
+
+	req, _ := http.NewRequest(http.MethodPut, "http://example.com", nil)
+	if _, err := c.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.limiter.current(); got != 2 {
+		t.Errorf("limit after a 503 = %d, want 2", got)
+	}
+
+	status = http.StatusOK
+	for i := 0; i < 10; i++ {
+		if _, err := c.Do(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := c.limiter.current(); got != 4 {
+		t.Errorf("limit after successful requests = %d, want 4", got)
+	}
+}
@@ -0,0 +1,237 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// reportCSV is the --report format of flattened per-component rows
+const reportCSV = "csv"
+
+// auditPolicy* are the policies a component row can be flagged by
+const (
+	auditPolicyBlocked    = "blocked-packages"
+	auditPolicyRegistries = "allowed-registries"
+	auditPolicyTyposquat  = "typosquat"
+)
+
+// auditReportHeader are the columns of the CSV report
+var auditReportHeader = []string{"run_id", "file", "document_ref", "subject", "uri", "purl", "registry", "status", "policy", "reason"}
+
+// auditSBOM is an SBOM of the run and its components
+type auditSBOM struct {
+	path    string
+	docRef  string
+	subject string
+	uri     string
+	purls   []string
+}
+
+// auditReport collects the SBOMs of a run and the blocked packages found for
+// them, so --report can list every component with the policies it failed
+type auditReport struct {
+	mu      sync.Mutex
+	sboms   []auditSBOM
+	blocked map[[2]string][]string
+}
+
+// runAudit collects the report of the current run
+var runAudit = &auditReport{}
+
+// addReportFlags defines the flags of the report written at the end of a run
+func addReportFlags(flags *pflag.FlagSet) {
+	flags.String("report", "", "Write a report at the end of the run: csv for a row per SBOM component with its blocked package, registry and typosquat findings, for auditors (optional)")
+	flags.String("report-file", "", "File the report is written to (default: kusari-uploader-<run id>.csv)")
+}
+
+// auditReportEnabled reports whether --report is set, and errors for formats
+// other than csv
+func auditReportEnabled() (bool, error) {
+	switch format := viper.GetString("report"); format {
+	case "":
+		return false, nil
+	case reportCSV:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown report format %q, must be %s", format, reportCSV)
+	}
+}
+
+// recordSBOM records the components of an SBOM of the run, if --report is set
+func (r *auditReport) recordSBOM(path, docRef string, ssau sbomSubjectAndURI, blob []byte) {
+	if enabled, _ := auditReportEnabled(); !enabled {
+		return
+	}
+	sbom := auditSBOM{path: path, docRef: docRef, subject: ssau.subject, uri: ssau.uri, purls: sbomPurls(blob)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sboms = append(r.sboms, sbom)
+}
+
+// recordBlocked records the findings of the blocked package check
+func (r *auditReport) recordBlocked(findings []blockedFinding) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.blocked == nil {
+		r.blocked = map[[2]string][]string{}
+	}
+	for _, f := range findings {
+		key := [2]string{f.Subject, f.URI}
+		r.blocked[key] = append(r.blocked[key], f.BlockedPackages...)
+	}
+}
+
+// rows returns a row per component of every recorded SBOM, with the policies
+// it was flagged by. Blocked packages the tenant found that are not among the
+// components of the local file get a row too, and SBOMs without components get
+// one row so every file is listed.
+func (r *auditReport) rows(runID string, typosquats []typosquatFinding, allowed []*regexp.Regexp) [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	similar := map[[2]string]string{}
+	for _, f := range typosquats {
+		similar[[2]string{f.File, f.Purl}] = f.Similar
+	}
+
+	// uploads complete in any order
+	sboms := slices.Clone(r.sboms)
+	slices.SortStableFunc(sboms, func(a, b auditSBOM) int {
+		return cmp.Compare(a.path, b.path)
+	})
+
+	var rows [][]string
+	for _, sbom := range sboms {
+		blocked := map[string]bool{}
+		purls := slices.Clone(sbom.purls)
+		for _, purl := range r.blocked[[2]string{sbom.subject, sbom.uri}] {
+			blocked[purl] = true
+			if !slices.Contains(purls, purl) {
+				purls = append(purls, purl)
+			}
+		}
+
+		row := func(purl, registry string, policies, reasons []string) {
+			status := "ok"
+			if len(policies) > 0 {
+				status = "flagged"
+			}
+			rows = append(rows, []string{runID, sbom.path, sbom.docRef, sbom.subject, sbom.uri, purl, registry, status,
+				strings.Join(policies, "; "), strings.Join(reasons, "; ")})
+		}
+		if len(purls) == 0 {
+			row("", "", nil, nil)
+		}
+		for _, purl := range purls {
+			registry := purlRegistry(purl)
+			var policies, reasons []string
+			if blocked[purl] {
+				policies = append(policies, auditPolicyBlocked)
+				reasons = append(reasons, "on the blocked package list of the tenant")
+			}
+			if len(allowed) > 0 && registry != "" && len(registryViolations([]componentOrigin{{Purl: purl, Registry: registry}}, allowed)) > 0 {
+				policies = append(policies, auditPolicyRegistries)
+				reasons = append(reasons, "comes from "+registry+", which is not an allowed registry")
+			}
+			if name, ok := similar[[2]string{sbom.path, purl}]; ok {
+				policies = append(policies, auditPolicyTyposquat)
+				reasons = append(reasons, "name is similar to the popular package "+name)
+			}
+			row(purl, registry, policies, reasons)
+		}
+	}
+	return rows
+}
+
+// writeAuditCSV writes the rows as CSV that spreadsheet applications open as
+// is: with a UTF-8 byte order mark, and with cells that would start a formula
+// prefixed with a quote
+func writeAuditCSV(w io.Writer, rows [][]string) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(auditReportHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		escaped := make([]string, len(row))
+		for i, cell := range row {
+			if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+				cell = "'" + cell
+			}
+			escaped[i] = cell
+		}
+		if err := cw.Write(escaped); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// mustWriteAuditReport writes the --report of the run, if it is set
+func mustWriteAuditReport(messages io.Writer, runID string) {
+	if enabled, _ := auditReportEnabled(); !enabled {
+		return
+	}
+
+	var allowed []*regexp.Regexp
+	if patterns := viper.GetStringSlice("allowed-registries"); len(patterns) > 0 {
+		var err error
+		allowed, err = compileRegistryPatterns(patterns)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Invalid allowed-registries")
+		}
+	}
+
+	path := viper.GetString("report-file")
+	if path == "" {
+		path = "kusari-uploader-" + runID + ".csv"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to create the report")
+	}
+	err = writeAuditCSV(f, runAudit.rows(runID, runTyposquats.summary(), allowed))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to write the report")
+	}
+	fmt.Fprintf(messages, "Report written to %s\n", path)
+}
@@ -0,0 +1,133 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_auditReportEnabled(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	if enabled, err := auditReportEnabled(); enabled || err != nil {
+		t.Errorf("auditReportEnabled() = %v, %v, want disabled without report", enabled, err)
+	}
+	viper.Set("report", "csv")
+	if enabled, err := auditReportEnabled(); !enabled || err != nil {
+		t.Errorf("auditReportEnabled() = %v, %v, want enabled with report csv", enabled, err)
+	}
+	viper.Set("report", "xlsx")
+	if _, err := auditReportEnabled(); err == nil {
+		t.Error("auditReportEnabled() accepted an unknown format")
+	}
+}
+
+func Test_auditReport_rows(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("report", "csv")
+
+	r := &auditReport{}
+	r.recordSBOM("sboms/web.json", "ref-web", sbomSubjectAndURI{subject: "web", uri: "web@1"}, []byte(`{"bomFormat": "CycloneDX", "components": [
+		{"purl": "pkg:npm/lodash@4.17.21"},
+		{"purl": "pkg:npm/lodahs@1.0.0"},
+		{"purl": "pkg:npm/left-pad@1.3.0?repository_url=https://npm.evil.example.com"}]}`))
+	r.recordSBOM("sboms/empty.json", "ref-empty", sbomSubjectAndURI{subject: "empty", uri: "empty@1"}, []byte(`{"bomFormat": "CycloneDX"}`))
+	r.recordBlocked([]blockedFinding{{Subject: "web", URI: "web@1", BlockedPackages: []string{"pkg:npm/lodash@4.17.21", "pkg:npm/event-stream@3.3.6"}}})
+
+	allowed, err := compileRegistryPatterns([]string{"registry.npmjs.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	typosquats := []typosquatFinding{{File: "sboms/web.json", Purl: "pkg:npm/lodahs@1.0.0", Similar: "lodash"}}
+
+	got := map[string][]string{}
+	for _, row := range r.rows("run-1", typosquats, allowed) {
+		if len(row) != len(auditReportHeader) {
+			t.Fatalf("row %v has %d columns, want %d", row, len(row), len(auditReportHeader))
+		}
+		got[row[1]+" "+row[5]] = row
+	}
+
+	tests := []struct {
+		key    string
+		status string
+		policy string
+	}{
+		{key: "sboms/web.json pkg:npm/lodash@4.17.21", status: "flagged", policy: auditPolicyBlocked},
+		{key: "sboms/web.json pkg:npm/lodahs@1.0.0", status: "flagged", policy: auditPolicyTyposquat},
+		{key: "sboms/web.json pkg:npm/left-pad@1.3.0?repository_url=https://npm.evil.example.com", status: "flagged", policy: auditPolicyRegistries},
+		{key: "sboms/web.json pkg:npm/event-stream@3.3.6", status: "flagged", policy: auditPolicyBlocked},
+		{key: "sboms/empty.json ", status: "ok", policy: ""},
+	}
+	if len(got) != len(tests) {
+		t.Errorf("rows() returned %d rows, want %d", len(got), len(tests))
+	}
+	for _, tt := range tests {
+		row, ok := got[tt.key]
+		if !ok {
+			t.Errorf("rows() has no row for %q", tt.key)
+			continue
+		}
+		if row[0] != "run-1" || row[7] != tt.status || row[8] != tt.policy {
+			t.Errorf("row %q = %v, want status %q and policy %q", tt.key, row, tt.status, tt.policy)
+		}
+	}
+	if row := got["sboms/web.json pkg:npm/lodash@4.17.21"]; row[2] != "ref-web" || row[3] != "web" || row[6] != "registry.npmjs.org" {
+		t.Errorf("row = %v, want the document ref, subject and registry of the component", row)
+	}
+}
+
+func Test_auditReport_recordSBOM_disabled(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	r := &auditReport{}
+	r.recordSBOM("sboms/web.json", "", sbomSubjectAndURI{}, []byte(`{"bomFormat": "CycloneDX"}`))
+	if rows := r.rows("run-1", nil, nil); len(rows) != 0 {
+		t.Errorf("rows() = %v, want nothing recorded without report", rows)
+	}
+}
+
+func Test_writeAuditCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeAuditCSV(&buf, [][]string{{"run-1", "=HYPERLINK(\"x\")", "", "-1", "@sum", "pkg:npm/a@1", "", "ok", "", ""}}); err != nil {
+		t.Fatal(err)
+	}
+
+	out, ok := strings.CutPrefix(buf.String(), "\ufeff")
+	if !ok {
+		t.Error("writeAuditCSV() did not start with a byte order mark")
+	}
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || strings.Join(records[0], ",") != strings.Join(auditReportHeader, ",") {
+		t.Fatalf("writeAuditCSV() = %v, want the header and one row", records)
+	}
+	for i, want := range []string{"run-1", `'=HYPERLINK("x")`, "", "'-1", "'@sum", "pkg:npm/a@1"} {
+		if records[1][i] != want {
+			t.Errorf("cell %d = %q, want %q", i, records[1][i], want)
+		}
+	}
+}
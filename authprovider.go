@@ -0,0 +1,194 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// authClientCredentials authenticates with the OAuth client credentials
+	// flow, for CI and other unattended runs
+	authClientCredentials = "client-credentials"
+	// authToken attaches the pre-issued bearer token of --token to requests
+	// without going through any OAuth flow
+	authToken = "token"
+)
+
+// AuthProvider gets the tokens the uploader sends to the tenant for one way of
+// authenticating. The provider is selected by --auth, see authProviders.
+type AuthProvider interface {
+	// tokenSource returns the source of tokens issued by the token endpoint at
+	// tokenURL, which may use the client credentials creds
+	tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource
+	// credentials returns which of the client credentials the provider needs
+	credentials() credentialNeed
+	// interactive reports whether getting a token needs a person to sign in
+	interactive() bool
+	// describe describes the credential and where it is read from, without
+	// revealing it
+	describe(flags *pflag.FlagSet, creds []clientCredential) string
+}
+
+// credentialNeed is which of the client credentials an AuthProvider needs
+type credentialNeed int
+
+const (
+	// credentialsNone providers authenticate without a client secret, and
+	// send the client ID only if one is set
+	credentialsNone credentialNeed = iota
+	// credentialsClientID providers need a client ID, and a secret only for
+	// confidential clients
+	credentialsClientID
+	// credentialsClientSecret providers need a client ID and secret, and can
+	// rotate to the secondary credential
+	credentialsClientSecret
+)
+
+// authProviders are the providers by the name --auth selects them with. The
+// token and credential helper providers are selected by setting --token or
+// --credential-helper instead.
+var authProviders = map[string]AuthProvider{
+	authClientCredentials: clientCredentialsProvider{},
+	authDevice:            deviceProvider{},
+	authLogin:             loginProvider{},
+	authAWS:               awsProvider{},
+	authKubernetes:        kubernetesProvider{},
+	authAzure:             azureProvider{},
+	authGCP:               gcpProvider{},
+	authToken:             tokenProvider{},
+	authCredentialHelper:  credentialHelperProvider{},
+}
+
+// authModes are the values of --auth, in the order they are documented
+var authModes = []string{authClientCredentials, authDevice, authLogin, authAWS, authKubernetes, authAzure, authGCP}
+
+// authMode returns the validated --auth setting. A --credential-helper or
+// --token overrides it, and when it is not set and there is no client secret,
+// the login stored by auth login is used if there is one.
+func authMode() (string, error) {
+	if viper.GetString("credential-helper") != "" {
+		if viper.GetString("token") != "" {
+			return "", errors.New("token can't be used with credential-helper")
+		}
+		if mode := viper.GetString("auth"); mode != "" && mode != authClientCredentials {
+			return "", fmt.Errorf("credential-helper can't be used with auth %s", mode)
+		}
+		return authCredentialHelper, nil
+	}
+	if viper.GetString("token") != "" {
+		if mode := viper.GetString("auth"); mode != "" && mode != authClientCredentials {
+			return "", fmt.Errorf("token can't be used with auth %s", mode)
+		}
+		return authToken, nil
+	}
+	if !viper.IsSet("auth") && clientSecret() == "" && hasStoredLogin() {
+		return authLogin, nil
+	}
+	mode := viper.GetString("auth")
+	if mode == "" {
+		return authClientCredentials, nil
+	}
+	if slices.Contains(authModes, mode) {
+		return mode, nil
+	}
+	last := len(authModes) - 1
+	return "", fmt.Errorf("invalid auth %q, must be %s or %s", mode, strings.Join(authModes[:last], ", "), authModes[last])
+}
+
+// authProvider returns the provider of authMode
+func authProvider() (string, AuthProvider, error) {
+	mode, err := authMode()
+	if err != nil {
+		return "", nil, err
+	}
+	return mode, authProviders[mode], nil
+}
+
+// hasClientCredentials reports whether the client credentials the provider of
+// --auth needs are set. The client secret is read from the flag or the
+// keyring.
+func hasClientCredentials() bool {
+	need := credentialsClientSecret
+	if _, provider, err := authProvider(); err == nil {
+		need = provider.credentials()
+	}
+	if need == credentialsNone {
+		return true
+	}
+	if viper.GetString("client-id") == "" {
+		return false
+	}
+	return clientSecret() != "" || need == credentialsClientID
+}
+
+// clientCredentialsProvider gets tokens with the OAuth client credentials
+// flow, rotating to the secondary credential if the primary is rejected
+type clientCredentialsProvider struct{}
+
+func (clientCredentialsProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	if viper.GetBool("token-cache") {
+		return newTokenCache(newRotatingTokenSource(ctx, tokenURL, creds), tokenURL, creds)
+	}
+	return newRotatingTokenSource(ctx, tokenURL, creds)
+}
+
+func (clientCredentialsProvider) credentials() credentialNeed { return credentialsClientSecret }
+
+func (clientCredentialsProvider) interactive() bool { return false }
+
+func (clientCredentialsProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string {
+	return describeClient(flags, creds, false)
+}
+
+// tokenProvider attaches the pre-issued bearer token of --token
+type tokenProvider struct{}
+
+func (tokenProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: viper.GetString("token"), TokenType: "Bearer"})
+}
+
+func (tokenProvider) credentials() credentialNeed { return credentialsNone }
+
+func (tokenProvider) interactive() bool { return false }
+
+func (tokenProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string {
+	_, source := lookupSetting(flags, "token")
+	return "bearer token from " + source
+}
+
+// describeClient describes the client credentials creds and where the secret
+// is read from. Public clients have no secret.
+func describeClient(flags *pflag.FlagSet, creds []clientCredential, public bool) string {
+	desc := "client ID " + creds[0].ID
+	if source := clientSecretSource(flags); source != "" {
+		desc += ", secret from " + source
+	} else if public {
+		desc += ", public client"
+	}
+	if len(creds) > 1 {
+		desc += ", secondary client ID " + creds[1].ID
+	}
+	return desc
+}
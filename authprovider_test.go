@@ -0,0 +1,99 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+func Test_authProviders(t *testing.T) {
+	for _, mode := range append(authModes, authToken, authCredentialHelper) {
+		if authProviders[mode] == nil {
+			t.Errorf("auth %s has no provider", mode)
+		}
+	}
+	if len(authProviders) != len(authModes)+2 {
+		t.Errorf("authProviders has %d providers, want one per auth mode and the token and credential helper providers", len(authProviders))
+	}
+}
+
+func Test_authMode_invalid(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("auth", "password")
+
+	_, err := authMode()
+	if err == nil {
+		t.Fatal("authMode() accepted an unknown auth")
+	}
+	if !strings.Contains(err.Error(), "client-credentials, device, login, aws, kubernetes, azure or gcp") {
+		t.Errorf("authMode() = %v, want the auth modes listed", err)
+	}
+}
+
+// stubProvider is an AuthProvider with a fixed token
+type stubProvider struct {
+	need credentialNeed
+}
+
+func (p stubProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "stub"})
+}
+
+func (p stubProvider) credentials() credentialNeed { return p.need }
+
+func (p stubProvider) interactive() bool { return false }
+
+func (p stubProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string { return "stub" }
+
+func Test_authProvider_selected(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	const mode = "stub"
+	authProviders[mode] = stubProvider{need: credentialsClientID}
+	authModes = append(authModes, mode)
+	t.Cleanup(func() {
+		delete(authProviders, mode)
+		authModes = authModes[:len(authModes)-1]
+	})
+	viper.Set("auth", mode)
+
+	if hasClientCredentials() {
+		t.Error("hasClientCredentials() = true without the client ID the provider needs")
+	}
+	viper.Set("client-id", "uploader")
+	if !hasClientCredentials() {
+		t.Error("hasClientCredentials() = false with the client ID the provider needs")
+	}
+
+	viper.Set("secondary-client-id", "new")
+	viper.Set("secondary-client-secret", "secret")
+	if _, err := clientCredentials(); err == nil {
+		t.Error("clientCredentials() accepted a secondary credential for a provider without client secrets")
+	}
+
+	token, err := newTokenSource(context.Background(), "http://127.0.0.1:1/token", nil).Token()
+	if err != nil || token.AccessToken != "stub" {
+		t.Errorf("newTokenSource().Token() = %v, %v, want the token of the selected provider", token, err)
+	}
+}
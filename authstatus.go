@@ -0,0 +1,253 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+func newAuthStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the active credential, and the expiry and scopes of its token",
+		Long: "Show which auth mode and credential a run would use and where its secret comes from, and get a token " +
+			"with it to show when the token expires and which scopes were granted. Cached and stored tokens are used " +
+			"as a run would use them. Nothing is sent to the tenant.",
+		Args: cobra.NoArgs,
+		Run:  authStatusCmd,
+	}
+}
+
+func newAuthTestCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "test",
+		Short: "Get a token and make a harmless authenticated request to the tenant",
+		Long: "Get a token with the active credential and send a read-only request with it to the tenant, to check " +
+			"the credential independently of an upload. Exits with status 2 if the token endpoint or the tenant rejects it.",
+		Args: cobra.NoArgs,
+		Run:  authTestCmd,
+	}
+}
+
+func authStatusCmd(cmd *cobra.Command, args []string) {
+	ctx, client := mustNewHTTPClient(context.Background())
+
+	_, tokenEndPoint, err := resolveEndpoints(ctx, client)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+	mode, provider, err := authProvider()
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid auth")
+	}
+	creds, err := clientCredentials()
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid client credentials")
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Auth:\t%s\n", mode)
+	fmt.Fprintf(w, "Credential:\t%s\n", provider.describe(cmd.Flags(), creds))
+	fmt.Fprintf(w, "Token endpoint:\t%s\n", tokenEndPoint)
+	if provider.interactive() {
+		// getting a token would prompt to sign in
+		fmt.Fprintln(w, "Token:\tnone kept, every run asks a person to sign in")
+		w.Flush() //nolint:errcheck
+		return
+	}
+
+	token, err := newTokenSource(ctx, tokenEndPoint, creds).Token()
+	if err != nil {
+		w.Flush() //nolint:errcheck
+		fatalErr(err, exitAuth).
+			Msg("Failed to get a token")
+	}
+	writeTokenStatus(w, token, time.Now())
+	w.Flush() //nolint:errcheck
+}
+
+func authTestCmd(cmd *cobra.Command, args []string) {
+	ctx, client := mustNewHTTPClient(context.Background())
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, client)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+	if tenantEndPoint == "" || tokenEndPoint == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: tenant-endpoint, token-endpoint")
+	}
+	creds, err := clientCredentials()
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid client credentials")
+	}
+
+	token, err := newTokenSource(ctx, tokenEndPoint, creds).Token()
+	if err != nil {
+		fatalErr(err, exitAuth).
+			Msg("Failed to get a token")
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Token: ok, from %s, %s\n", tokenEndPoint, describeExpiry(tokenExpiry(token), time.Now()))
+
+	authorizedClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(token))
+	if err := checkTenantToken(ctx, authorizedClient, tenantEndPoint); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Authenticated request failed")
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Tenant: ok, %s accepted the token\n", tenantEndPoint)
+}
+
+// checkTenantToken sends a read-only request to the tenant. Tenants that
+// predate the capabilities endpoint answer 404 once the token was accepted.
+func checkTenantToken(ctx context.Context, client HttpClient, tenantEndpoint string) error {
+	res, err := makePicoReq(ctx, client, tenantEndpoint, "pico/v1/capabilities")
+	if err != nil {
+		return fmt.Errorf("error making request to the tenant: %w", err)
+	}
+	defer res.Body.Close()        //nolint:errcheck
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+		return nil
+	default:
+		return tenantStatusError(res.StatusCode, fmt.Errorf("tenant answered the authenticated request with status code %d", res.StatusCode))
+	}
+}
+
+// clientSecretSource returns where clientSecret reads the secret from, or ""
+// if there is none
+func clientSecretSource(flags *pflag.FlagSet) string {
+	if value, source := lookupSetting(flags, "client-secret"); value != "" {
+		if ref, ok := parseSecretRef(value); ok {
+			return fmt.Sprintf("%s://%s in %s", ref.scheme, ref.name, source)
+		}
+		return source
+	}
+	if file := viper.GetString("client-secret-file"); file != "" {
+		return "client-secret-file " + file
+	}
+	if clientSecret() != "" {
+		return "OS keyring"
+	}
+	return ""
+}
+
+// writeTokenStatus writes the expiry and granted scopes of token
+func writeTokenStatus(w io.Writer, token *oauth2.Token, now time.Time) {
+	fmt.Fprintf(w, "Token expiry:\t%s\n", describeExpiry(tokenExpiry(token), now))
+	scopes := tokenScopes(token)
+	if len(scopes) == 0 {
+		fmt.Fprintln(w, "Scopes:\tnot reported by the token endpoint")
+		return
+	}
+	fmt.Fprintf(w, "Scopes:\t%s\n", strings.Join(scopes, " "))
+}
+
+// describeExpiry describes when a token expires, relative to now
+func describeExpiry(expiry, now time.Time) string {
+	switch {
+	case expiry.IsZero():
+		return "no expiry reported"
+	case !expiry.After(now):
+		return "expired at " + expiry.Format(time.RFC3339)
+	default:
+		return fmt.Sprintf("expires at %s, in %s", expiry.Format(time.RFC3339), expiry.Sub(now).Truncate(time.Second))
+	}
+}
+
+// jwtClaims are the claims of an access token that is a JWT. They are only
+// shown, never trusted, so the signature isn't verified.
+type jwtClaims struct {
+	Exp   int64  `json:"exp"`
+	Scope string `json:"scope"`
+	// Scp is a list, or a space separated string, depending on the issuer
+	Scp any `json:"scp"`
+}
+
+// accessTokenClaims returns the claims of token if its access token is a JWT
+func accessTokenClaims(token *oauth2.Token) (jwtClaims, bool) {
+	var claims jwtClaims
+	parts := strings.Split(token.AccessToken, ".")
+	if len(parts) != 3 {
+		return claims, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, false
+	}
+	return claims, json.Unmarshal(payload, &claims) == nil
+}
+
+// tokenExpiry returns the expiry of token, from the token response or else
+// from the exp claim, as cached and --token tokens have no expiry of their own
+func tokenExpiry(token *oauth2.Token) time.Time {
+	if !token.Expiry.IsZero() {
+		return token.Expiry
+	}
+	if claims, ok := accessTokenClaims(token); ok && claims.Exp > 0 {
+		return time.Unix(claims.Exp, 0)
+	}
+	return time.Time{}
+}
+
+// tokenScopes returns the scopes granted with token, from the scope of the
+// token response or else from the scope or scp claim
+func tokenScopes(token *oauth2.Token) []string {
+	if scope, ok := token.Extra("scope").(string); ok && scope != "" {
+		return strings.Fields(scope)
+	}
+	claims, ok := accessTokenClaims(token)
+	if !ok {
+		return nil
+	}
+	if claims.Scope != "" {
+		return strings.Fields(claims.Scope)
+	}
+	switch scp := claims.Scp.(type) {
+	case string:
+		return strings.Fields(scp)
+	case []any:
+		var scopes []string
+		for _, s := range scp {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
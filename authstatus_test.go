@@ -0,0 +1,150 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// testJWT returns an unsigned JWT with the given claims
+func testJWT(claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func Test_tokenScopes(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *oauth2.Token
+		want  []string
+	}{
+		{
+			name:  "token response",
+			token: (&oauth2.Token{AccessToken: testJWT(`{"scope": "ignored"}`)}).WithExtra(map[string]any{"scope": "upload read"}),
+			want:  []string{"upload", "read"},
+		},
+		{
+			name:  "scope claim",
+			token: &oauth2.Token{AccessToken: testJWT(`{"scope": "upload read"}`)},
+			want:  []string{"upload", "read"},
+		},
+		{
+			name:  "scp list claim",
+			token: &oauth2.Token{AccessToken: testJWT(`{"scp": ["upload", "read"]}`)},
+			want:  []string{"upload", "read"},
+		},
+		{
+			name:  "opaque token",
+			token: &oauth2.Token{AccessToken: "opaque"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenScopes(tt.token); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeTokenStatus(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	// cached tokens only have the expiry of their claims
+	writeTokenStatus(&buf, &oauth2.Token{AccessToken: testJWT(`{"exp": 1714566600, "scope": "upload"}`)}, now)
+	want := "Token expiry:\texpires at " + time.Unix(1714566600, 0).Format(time.RFC3339) + ", in 30m0s\nScopes:\tupload\n"
+	if buf.String() != want {
+		t.Errorf("writeTokenStatus() = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeTokenStatus(&buf, &oauth2.Token{AccessToken: "opaque", Expiry: now.Add(-time.Minute)}, now)
+	if want := "Token expiry:\texpired at 2024-05-01T11:59:00Z\nScopes:\tnot reported by the token endpoint\n"; buf.String() != want {
+		t.Errorf("writeTokenStatus() = %q, want %q", buf.String(), want)
+	}
+}
+
+func Test_AuthProvider_describe(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("client-secret", "", "")
+	flags.String("token", "", "")
+	if err := flags.Parse([]string{"--client-secret", "vault://secret/kusari#client-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	creds := []clientCredential{{Name: "primary", ID: "uploader"}, {Name: "secondary", ID: "uploader-next"}}
+	got := clientCredentialsProvider{}.describe(flags, creds)
+	if want := "client ID uploader, secret from vault://secret/kusari in flag --client-secret, secondary client ID uploader-next"; got != want {
+		t.Errorf("describe() = %q, want %q", got, want)
+	}
+
+	t.Setenv("UPLOADER_TOKEN", "secret-token")
+	if got, want := (tokenProvider{}).describe(flags, nil), "bearer token from env UPLOADER_TOKEN"; got != want {
+		t.Errorf("describe() = %q, want %q", got, want)
+	}
+	if got, want := (loginProvider{}).describe(flags, nil), "login stored by auth login"; got != want {
+		t.Errorf("describe() = %q, want %q", got, want)
+	}
+}
+
+func Test_checkTenantToken(t *testing.T) {
+	tests := []struct {
+		status   int
+		wantErr  bool
+		wantCode int
+	}{
+		{status: http.StatusOK},
+		{status: http.StatusNotFound},
+		{status: http.StatusUnauthorized, wantErr: true, wantCode: exitAuth},
+		{status: http.StatusInternalServerError, wantErr: true, wantCode: exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/pico/v1/capabilities" || r.Header.Get("Authorization") != "Bearer tok" {
+					t.Errorf("request %s with %q, want the capabilities with the token", r.URL.Path, r.Header.Get("Authorization"))
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			ctx := context.WithValue(context.Background(), oauth2.HTTPClient, srv.Client())
+			client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "tok"}))
+			err := checkTenantToken(ctx, client, srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkTenantToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && exitCodeOf(err, exitUsage) != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", exitCodeOf(err, exitUsage), tt.wantCode)
+			}
+		})
+	}
+}
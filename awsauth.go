@@ -0,0 +1,452 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// authAWS exchanges the ambient AWS credentials of the machine, e.g. of an EC2
+// instance profile or an EKS service account, for a Kusari token
+const authAWS = "aws"
+
+// awsProvider exchanges the ambient AWS credentials for tokens
+type awsProvider struct{}
+
+func (awsProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	return newAWSTokenSource(ctx, tokenURL)
+}
+
+func (awsProvider) credentials() credentialNeed { return credentialsNone }
+
+func (awsProvider) interactive() bool { return false }
+
+func (awsProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string {
+	return "ambient AWS credentials"
+}
+
+const (
+	// stsGetCallerIdentityBody is the body of the STS request proving the AWS
+	// identity to the identity exchange
+	stsGetCallerIdentityBody = "Action=GetCallerIdentity&Version=2011-06-15"
+	// awsCredentialsMargin is how long temporary AWS credentials must still be
+	// valid to be used for a signature
+	awsCredentialsMargin = 5 * time.Minute
+	// awsMetadataTimeout bounds the requests to the EC2 and container
+	// credential endpoints, which don't answer outside of AWS
+	awsMetadataTimeout = 5 * time.Second
+)
+
+// ambientAWSCredentials are AWS credentials found on the machine, and where
+type ambientAWSCredentials struct {
+	awsCredentials
+	// Expiry is zero for long-lived credentials
+	Expiry time.Time
+	Source string
+}
+
+// awsCredentialDocument are the credentials of the EC2 instance metadata
+// service and the container credential endpoints
+type awsCredentialDocument struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// stsWebIdentityResponse is the XML response of AssumeRoleWithWebIdentity
+type stsWebIdentityResponse struct {
+	Credentials struct {
+		AccessKeyID     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+}
+
+// awsExchangeEndpoint returns --aws-exchange-endpoint, or else the AWS
+// identity exchange next to the token endpoint, e.g.
+// https://auth.us.kusari.cloud/oauth2/exchange/aws
+func awsExchangeEndpoint(tokenURL string) string {
+	if endpoint := viper.GetString("aws-exchange-endpoint"); endpoint != "" {
+		return endpoint
+	}
+	return strings.TrimSuffix(tokenURL, "/token") + "/exchange/aws"
+}
+
+// awsRegion returns the region of AWS_REGION or AWS_DEFAULT_REGION
+func awsRegion() string {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(env); region != "" {
+			return region
+		}
+	}
+	return ""
+}
+
+// stsEndpoint returns AWS_ENDPOINT_URL_STS, or else the STS endpoint of
+// region, or the global one without a region
+func stsEndpoint(region string) string {
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL_STS"); endpoint != "" {
+		return endpoint
+	}
+	if region == "" {
+		return "https://sts.amazonaws.com"
+	}
+	return "https://sts." + region + ".amazonaws.com"
+}
+
+// loadAmbientAWSCredentials finds AWS credentials the way the AWS SDKs do:
+// the environment variables, the web identity token of an EKS service account
+// (IRSA), the container credentials of ECS and EKS Pod Identity, and the
+// instance profile of the EC2 instance metadata service
+func loadAmbientAWSCredentials(ctx context.Context, client HttpClient) (*ambientAWSCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &ambientAWSCredentials{
+			awsCredentials: awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")},
+			Source:         "environment",
+		}, nil
+	}
+	if tokenFile, roleARN := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN"); tokenFile != "" && roleARN != "" {
+		return assumeRoleWithWebIdentity(ctx, client, roleARN, tokenFile)
+	}
+
+	// the metadata endpoints are link-local, never reached through a proxy
+	metadataClient := &http.Client{Timeout: awsMetadataTimeout}
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" {
+		return containerCredentials(ctx, metadataClient)
+	}
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil, errors.New("no AWS credentials found in the environment, and the EC2 instance metadata service is disabled")
+	}
+	creds, err := instanceProfileCredentials(ctx, metadataClient)
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials found in the environment or the EC2 instance metadata service: %w", err)
+	}
+	return creds, nil
+}
+
+// assumeRoleWithWebIdentity gets credentials for roleARN with the web
+// identity token in tokenFile, which the STS request is not signed for
+func assumeRoleWithWebIdentity(ctx context.Context, client HttpClient, roleARN, tokenFile string) (*ambientAWSCredentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading AWS_WEB_IDENTITY_TOKEN_FILE: %w", err)
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "kusari-uploader"
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stsEndpoint(awsRegion()), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to STS: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		return nil, withExitCode(exitAuth, fmt.Errorf("STS rejected the web identity of %s: %d", roleARN, res.StatusCode))
+	}
+
+	var sts stsWebIdentityResponse
+	if err := xml.NewDecoder(res.Body).Decode(&sts); err != nil {
+		return nil, fmt.Errorf("error unmarshaling STS response: %w", err)
+	}
+	c := sts.Credentials
+	return &ambientAWSCredentials{
+		awsCredentials: awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken},
+		Expiry:         c.Expiration,
+		Source:         "web identity of " + roleARN,
+	}, nil
+}
+
+// containerCredentials gets the credentials of the ECS task role or EKS Pod
+// Identity association from the container credential endpoint
+func containerCredentials(ctx context.Context, client HttpClient) (*ambientAWSCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if endpoint == "" {
+		endpoint = "http://169.254.170.2" + os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorization := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE: %w", err)
+		}
+		authorization = strings.TrimSpace(string(data))
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	doc, err := getAWSCredentialDocument(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("container credentials: %w", err)
+	}
+	return doc.credentials("container credentials"), nil
+}
+
+// instanceProfileCredentials gets the credentials of the EC2 instance profile
+// with IMDSv2, from AWS_EC2_METADATA_SERVICE_ENDPOINT if set
+func instanceProfileCredentials(ctx context.Context, client HttpClient) (*ambientAWSCredentials, error) {
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := getMetadataText(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialsURL := endpoint + "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, credentialsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	roles, err := getMetadataText(client, req)
+	if err != nil {
+		return nil, err
+	}
+	role, _, _ := strings.Cut(roles, "\n")
+	if role == "" {
+		return nil, errors.New("the EC2 instance has no instance profile")
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, credentialsURL+url.PathEscape(role), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	doc, err := getAWSCredentialDocument(client, req)
+	if err != nil {
+		return nil, err
+	}
+	return doc.credentials("instance profile " + role), nil
+}
+
+// getMetadataText returns the trimmed text response of a metadata request
+func getMetadataText(client HttpClient, req *http.Request) (string, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error making request to %s: %w", req.URL.Redacted(), err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status code from %s: %d", req.URL.Redacted(), res.StatusCode)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response from %s: %w", req.URL.Redacted(), err)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// getAWSCredentialDocument returns the credentials of a metadata request
+func getAWSCredentialDocument(client HttpClient, req *http.Request) (*awsCredentialDocument, error) {
+	body, err := getMetadataText(client, req)
+	if err != nil {
+		return nil, err
+	}
+	var doc awsCredentialDocument
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return nil, fmt.Errorf("error unmarshaling AWS credentials: %w", err)
+	}
+	if doc.AccessKeyID == "" || doc.SecretAccessKey == "" {
+		return nil, errors.New("the AWS credentials are incomplete")
+	}
+	return &doc, nil
+}
+
+func (d *awsCredentialDocument) credentials(source string) *ambientAWSCredentials {
+	return &ambientAWSCredentials{
+		awsCredentials: awsCredentials{AccessKeyID: d.AccessKeyID, SecretAccessKey: d.SecretAccessKey, SessionToken: d.Token},
+		Expiry:         d.Expiration,
+		Source:         source,
+	}
+}
+
+// awsExchangeRequest is the signed sts:GetCallerIdentity request sent to the
+// identity exchange, which sends it to STS to learn the AWS identity of the
+// caller. The request can't be altered without invalidating the signature.
+type awsExchangeRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	// Body is base64 encoded
+	Body string `json:"body"`
+}
+
+// awsExchangeResponse is the token issued by the identity exchange
+type awsExchangeResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// awsTokenSource gets a new Kusari token from the identity exchange on every
+// call, proving the AWS identity of the machine with a signed
+// sts:GetCallerIdentity request. The ambient credentials are reused until
+// they are about to expire.
+type awsTokenSource struct {
+	ctx         context.Context
+	client      HttpClient
+	exchangeURL string
+	now         func() time.Time
+
+	mu    sync.Mutex
+	creds *ambientAWSCredentials
+}
+
+func newAWSTokenSource(ctx context.Context, tokenURL string) *awsTokenSource {
+	var client HttpClient = http.DefaultClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = c
+	}
+	return &awsTokenSource{ctx: ctx, client: client, exchangeURL: awsExchangeEndpoint(tokenURL), now: time.Now}
+}
+
+func (s *awsTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.creds == nil || (!s.creds.Expiry.IsZero() && s.creds.Expiry.Before(now.Add(awsCredentialsMargin))) {
+		creds, err := loadAmbientAWSCredentials(s.ctx, s.client)
+		if err != nil {
+			return nil, withExitCode(exitAuth, err)
+		}
+		if s.creds == nil {
+			log.Info().Str("source", creds.Source).Msg("Authenticating with ambient AWS credentials")
+		}
+		s.creds = creds
+	}
+
+	exchange, err := signedCallerIdentityRequest(s.creds.awsCredentials, now)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(exchange)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.exchangeURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to the AWS identity exchange: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, withExitCode(exitAuth, fmt.Errorf("the AWS identity exchange at %s rejected the AWS identity of %s: %d", s.exchangeURL, s.creds.Source, res.StatusCode))
+	case http.StatusNotFound:
+		return nil, withExitCode(exitAuth, fmt.Errorf("there is no AWS identity exchange at %s, set aws-exchange-endpoint", s.exchangeURL))
+	default:
+		return nil, fmt.Errorf("unexpected response status code from the AWS identity exchange: %d", res.StatusCode)
+	}
+
+	var token awsExchangeResponse
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("error unmarshaling AWS identity exchange response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("the AWS identity exchange issued no access token")
+	}
+	issued := &oauth2.Token{AccessToken: token.AccessToken, TokenType: token.TokenType}
+	if token.ExpiresIn > 0 {
+		issued.Expiry = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return issued, nil
+}
+
+// forceRefresh is always possible, every call exchanges the AWS identity anew
+func (s *awsTokenSource) forceRefresh() bool {
+	return true
+}
+
+// signedCallerIdentityRequest signs an sts:GetCallerIdentity request with
+// creds, for the identity exchange to send
+func signedCallerIdentityRequest(creds awsCredentials, now time.Time) (*awsExchangeRequest, error) {
+	region := awsRegion()
+	endpoint := stsEndpoint(region)
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	body := []byte(stsGetCallerIdentityBody)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSv4(req, body, region, "sts", creds, now)
+
+	headers := map[string]string{"Host": req.URL.Host}
+	for name := range req.Header {
+		headers[name] = req.Header.Get(name)
+	}
+	return &awsExchangeRequest{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: headers,
+		Body:    base64.StdEncoding.EncodeToString(body),
+	}, nil
+}
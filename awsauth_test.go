@@ -0,0 +1,199 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// clearAWSEnv unsets the AWS environment variables the credential chain reads
+func clearAWSEnv(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_REGION", "AWS_DEFAULT_REGION",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME", "AWS_ENDPOINT_URL_STS",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+		"AWS_EC2_METADATA_SERVICE_ENDPOINT", "AWS_EC2_METADATA_DISABLED",
+	} {
+		t.Setenv(env, "")
+	}
+}
+
+const testCredentialDocument = `{"AccessKeyId": "ASIAROLE", "SecretAccessKey": "role-secret", "Token": "role-token", "Expiration": "2024-05-01T18:00:00Z"}`
+
+func Test_loadAmbientAWSCredentials_environment(t *testing.T) {
+	clearAWSEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+
+	creds, err := loadAmbientAWSCredentials(context.Background(), http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKIDENV" || creds.Source != "environment" || !creds.Expiry.IsZero() {
+		t.Errorf("loadAmbientAWSCredentials() = %+v, want the environment credentials", creds)
+	}
+}
+
+func Test_loadAmbientAWSCredentials_webIdentity(t *testing.T) {
+	clearAWSEnv(t)
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.PostForm.Get("Action") != "AssumeRoleWithWebIdentity" || r.PostForm.Get("WebIdentityToken") != "pod-token" ||
+			r.PostForm.Get("RoleArn") != "arn:aws:iam::123456789012:role/uploader" {
+			t.Errorf("STS request = %v", r.PostForm)
+		}
+		_, _ = w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>ASIAIRSA</AccessKeyId><SecretAccessKey>irsa-secret</SecretAccessKey><SessionToken>irsa-token</SessionToken>
+<Expiration>2024-05-01T13:00:00Z</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer sts.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("pod-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/uploader")
+	t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+
+	creds, err := loadAmbientAWSCredentials(context.Background(), sts.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAIRSA" || creds.SessionToken != "irsa-token" || !creds.Expiry.Equal(time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("loadAmbientAWSCredentials() = %+v, want the web identity credentials", creds)
+	}
+}
+
+func Test_loadAmbientAWSCredentials_container(t *testing.T) {
+	clearAWSEnv(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "pod-identity" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(testCredentialDocument))
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/v1/credentials")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "pod-identity")
+
+	creds, err := loadAmbientAWSCredentials(context.Background(), http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAROLE" || creds.SessionToken != "role-token" || creds.Source != "container credentials" {
+		t.Errorf("loadAmbientAWSCredentials() = %+v, want the container credentials", creds)
+	}
+}
+
+func Test_loadAmbientAWSCredentials_instanceProfile(t *testing.T) {
+	clearAWSEnv(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			_, _ = w.Write([]byte("imds-token"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			_, _ = w.Write([]byte("build-agent\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/build-agent":
+			_, _ = w.Write([]byte(testCredentialDocument))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", srv.URL)
+
+	creds, err := loadAmbientAWSCredentials(context.Background(), http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ASIAROLE" || creds.Source != "instance profile build-agent" {
+		t.Errorf("loadAmbientAWSCredentials() = %+v, want the instance profile credentials", creds)
+	}
+
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	if _, err := loadAmbientAWSCredentials(context.Background(), http.DefaultClient); err == nil {
+		t.Error("loadAmbientAWSCredentials() succeeded with the instance metadata service disabled")
+	}
+}
+
+func Test_awsTokenSource(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	clearAWSEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	t.Setenv("AWS_REGION", "eu-west-1")
+
+	status := http.StatusOK
+	exchange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth2/exchange/aws" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req awsExchangeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		body, _ := base64.StdEncoding.DecodeString(req.Body)
+		if req.Method != http.MethodPost || req.URL != "https://sts.eu-west-1.amazonaws.com" || string(body) != stsGetCallerIdentityBody {
+			t.Errorf("exchange request = %s %s %q", req.Method, req.URL, body)
+		}
+		if !strings.HasPrefix(req.Headers["Authorization"], "AWS4-HMAC-SHA256 Credential=AKIDENV/20240501/eu-west-1/sts/aws4_request") ||
+			req.Headers["X-Amz-Security-Token"] != "session" || req.Headers["Host"] != "sts.eu-west-1.amazonaws.com" {
+			t.Errorf("exchange request headers = %v", req.Headers)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"access_token": "kusari-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer exchange.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	source := newAWSTokenSource(context.Background(), exchange.URL+"/oauth2/token")
+	source.now = func() time.Time { return now }
+
+	token, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "kusari-token" || !token.Expiry.Equal(now.Add(time.Hour)) {
+		t.Errorf("Token() = %+v, want the exchanged token", token)
+	}
+
+	status = http.StatusForbidden
+	if _, err := source.Token(); err == nil || exitCodeOf(err, exitUsage) != exitAuth {
+		t.Errorf("Token() error = %v, want an auth failure", err)
+	}
+}
@@ -0,0 +1,237 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
+)
+
+// authAzure exchanges a Microsoft Entra token of the workload's Azure identity
+// for a Kusari token, for uploads in AKS, Azure Pipelines and on Azure compute
+const authAzure = "azure"
+
+// azureProvider exchanges the Azure identity of the workload for tokens
+type azureProvider struct{}
+
+func (azureProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	return newTokenExchangeSource(ctx, tokenURL, creds[0].ID, "Azure identity token", azureIdentityToken(workloadIdentityAudience(tokenURL)))
+}
+
+func (azureProvider) credentials() credentialNeed { return credentialsNone }
+
+func (azureProvider) interactive() bool { return false }
+
+func (azureProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string {
+	switch {
+	case os.Getenv("AZURE_FEDERATED_TOKEN_FILE") != "":
+		return "Azure workload identity " + azureClientID()
+	case azurePipelinesServiceConnection() != "":
+		return "Azure Pipelines service connection " + azurePipelinesServiceConnection()
+	case azureClientID() != "":
+		return "Azure managed identity " + azureClientID()
+	default:
+		return "Azure managed identity"
+	}
+}
+
+// azureIMDSEndpoint is the managed identity endpoint of the Azure Instance
+// Metadata Service
+var azureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// azureTokenResponse is the token response of Microsoft Entra ID and of the
+// managed identity endpoints, or their error
+type azureTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// azureClientID returns the client ID of the Azure identity: the app
+// registration of a federated identity, or a user-assigned managed identity
+func azureClientID() string {
+	return firstEnv("AZURE_CLIENT_ID", "AZURESUBSCRIPTION_CLIENT_ID")
+}
+
+// azurePipelinesServiceConnection returns the ID of the Azure Pipelines
+// service connection with workload identity federation, if the job can
+// request its OIDC token
+func azurePipelinesServiceConnection() string {
+	if os.Getenv("SYSTEM_OIDCREQUESTURI") == "" || os.Getenv("SYSTEM_ACCESSTOKEN") == "" {
+		return ""
+	}
+	return firstEnv("AZURE_SERVICE_CONNECTION_ID", "AZURESUBSCRIPTION_SERVICE_CONNECTION_ID")
+}
+
+// azureIdentityToken returns a subjectTokenFunc getting a Microsoft Entra token
+// for audience, the way the Azure SDKs do: with the federated token of AKS
+// workload identity, with the OIDC token of an Azure Pipelines service
+// connection, or else from the managed identity of the App Service, Function,
+// Container App or VM
+func azureIdentityToken(audience string) subjectTokenFunc {
+	return func(ctx context.Context, client HttpClient) (string, error) {
+		if file := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); file != "" {
+			assertion, err := readSubjectTokenFile(file, "Azure federated token")(ctx, client)
+			if err != nil {
+				return "", err
+			}
+			return entraFederatedToken(ctx, client, assertion, audience)
+		}
+		if connection := azurePipelinesServiceConnection(); connection != "" {
+			assertion, err := azurePipelinesOIDCToken(ctx, client, connection)
+			if err != nil {
+				return "", err
+			}
+			return entraFederatedToken(ctx, client, assertion, audience)
+		}
+		return azureManagedIdentityToken(ctx, client, audience)
+	}
+}
+
+// entraFederatedToken gets a token for audience from Microsoft Entra ID with
+// the client credentials of AZURE_CLIENT_ID, using the federated token
+// assertion instead of a secret
+func entraFederatedToken(ctx context.Context, client HttpClient, assertion, audience string) (string, error) {
+	clientID := azureClientID()
+	tenantID := firstEnv("AZURE_TENANT_ID", "AZURESUBSCRIPTION_TENANT_ID")
+	if clientID == "" || tenantID == "" {
+		return "", fmt.Errorf("AZURE_CLIENT_ID and AZURE_TENANT_ID must be set for Azure workload identity federation")
+	}
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = "https://login.microsoftonline.com"
+	}
+	scope := audience
+	if !strings.HasSuffix(scope, "/.default") {
+		scope += "/.default"
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+		"scope":                 {scope},
+	}
+	endpoint := strings.TrimSuffix(authority, "/") + "/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return getAzureToken(client, req, "Microsoft Entra ID")
+}
+
+// azurePipelinesOIDCToken requests the OIDC token of an Azure Pipelines
+// service connection, which needs SYSTEM_ACCESSTOKEN to be mapped into the
+// step's environment
+func azurePipelinesOIDCToken(ctx context.Context, client HttpClient, connection string) (string, error) {
+	u, err := url.Parse(os.Getenv("SYSTEM_OIDCREQUESTURI"))
+	if err != nil {
+		return "", fmt.Errorf("invalid SYSTEM_OIDCREQUESTURI: %w", err)
+	}
+	q := u.Query()
+	q.Set("api-version", "7.1")
+	q.Set("serviceConnectionId", connection)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SYSTEM_ACCESSTOKEN"))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting the Azure Pipelines OIDC token: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status code for the Azure Pipelines OIDC token: %d", res.StatusCode)
+	}
+	var body struct {
+		OIDCToken string `json:"oidcToken"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.OIDCToken == "" {
+		return "", fmt.Errorf("Azure Pipelines issued no OIDC token for service connection %s", connection)
+	}
+	return body.OIDCToken, nil
+}
+
+// azureManagedIdentityToken gets a token for audience from the managed
+// identity endpoint of App Service, Functions and Container Apps, or else of
+// the Instance Metadata Service of VMs and AKS nodes. AZURE_CLIENT_ID selects
+// a user-assigned identity.
+func azureManagedIdentityToken(ctx context.Context, client HttpClient, audience string) (string, error) {
+	endpoint, apiVersion := azureIMDSEndpoint, "2018-02-01"
+	if identityEndpoint := os.Getenv("IDENTITY_ENDPOINT"); identityEndpoint != "" && os.Getenv("IDENTITY_HEADER") != "" {
+		endpoint, apiVersion = identityEndpoint, "2019-08-01"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid managed identity endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("api-version", apiVersion)
+	q.Set("resource", audience)
+	if clientID := azureClientID(); clientID != "" {
+		q.Set("client_id", clientID)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if endpoint == azureIMDSEndpoint {
+		req.Header.Set("Metadata", "true")
+	} else {
+		req.Header.Set("X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER"))
+	}
+	return getAzureToken(client, req, "the Azure managed identity endpoint")
+}
+
+// getAzureToken makes a token request to issuer and returns the access token
+func getAzureToken(client HttpClient, req *http.Request, issuer string) (string, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting a token from %s: %w", issuer, err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading the token response of %s: %w", issuer, err)
+	}
+	var token azureTokenResponse
+	_ = json.Unmarshal(body, &token)
+	if res.StatusCode != http.StatusOK || token.AccessToken == "" {
+		reason := token.ErrorDescription
+		if reason == "" {
+			reason = token.Error
+		}
+		return "", fmt.Errorf("%s issued no token: %d %s", issuer, res.StatusCode, reason)
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,187 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// clearAzureEnv unsets the environment variables azureIdentityToken reads
+func clearAzureEnv(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{
+		"AZURE_CLIENT_ID", "AZURE_TENANT_ID", "AZURE_FEDERATED_TOKEN_FILE", "AZURE_AUTHORITY_HOST",
+		"AZURESUBSCRIPTION_CLIENT_ID", "AZURESUBSCRIPTION_TENANT_ID", "AZURESUBSCRIPTION_SERVICE_CONNECTION_ID",
+		"AZURE_SERVICE_CONNECTION_ID", "SYSTEM_OIDCREQUESTURI", "SYSTEM_ACCESSTOKEN", "IDENTITY_ENDPOINT", "IDENTITY_HEADER",
+	} {
+		t.Setenv(env, "")
+	}
+}
+
+// entraServer returns a Microsoft Entra ID that issues a token for the
+// federated assertion want
+func entraServer(t *testing.T, want string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.URL.Path != "/tenant/oauth2/v2.0/token" || r.PostForm.Get("client_id") != "app" ||
+			r.PostForm.Get("client_assertion") != want || r.PostForm.Get("scope") != "api://kusari/.default" {
+			t.Errorf("unexpected Entra request %s %v", r.URL.Path, r.PostForm)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_client", "error_description": "AADSTS700211: no matching federated identity"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "entra-token"})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_azureIdentityToken_workloadIdentity(t *testing.T) {
+	clearAzureEnv(t)
+	file := filepath.Join(t.TempDir(), "azure-identity-token")
+	if err := os.WriteFile(file, []byte("federated-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server := entraServer(t, "federated-token")
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", file)
+	t.Setenv("AZURE_CLIENT_ID", "app")
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL+"/")
+
+	token, err := azureIdentityToken("api://kusari")(context.Background(), http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "entra-token" {
+		t.Errorf("azureIdentityToken() = %q, want the Entra token", token)
+	}
+
+	t.Setenv("AZURE_TENANT_ID", "")
+	if _, err := azureIdentityToken("api://kusari")(context.Background(), http.DefaultClient); err == nil {
+		t.Error("azureIdentityToken() succeeded without AZURE_TENANT_ID")
+	}
+}
+
+func Test_azureIdentityToken_pipelines(t *testing.T) {
+	clearAzureEnv(t)
+	server := entraServer(t, "pipeline-oidc-token")
+	oidc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer job-token" ||
+			r.URL.Query().Get("serviceConnectionId") != "connection" || r.URL.Query().Get("api-version") != "7.1" {
+			t.Errorf("unexpected OIDC request %s %s", r.Method, r.URL)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"oidcToken": "pipeline-oidc-token"})
+	}))
+	defer oidc.Close()
+	t.Setenv("SYSTEM_OIDCREQUESTURI", oidc.URL+"/oidctoken")
+	t.Setenv("SYSTEM_ACCESSTOKEN", "job-token")
+	t.Setenv("AZURESUBSCRIPTION_SERVICE_CONNECTION_ID", "connection")
+	t.Setenv("AZURESUBSCRIPTION_CLIENT_ID", "app")
+	t.Setenv("AZURESUBSCRIPTION_TENANT_ID", "tenant")
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL)
+
+	token, err := azureIdentityToken("api://kusari")(context.Background(), http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "entra-token" {
+		t.Errorf("azureIdentityToken() = %q, want the Entra token", token)
+	}
+}
+
+func Test_azureIdentityToken_managedIdentity(t *testing.T) {
+	tests := []struct {
+		name        string
+		appService  bool
+		wantVersion string
+	}{
+		{name: "instance metadata service", wantVersion: "2018-02-01"},
+		{name: "app service", appService: true, wantVersion: "2019-08-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearAzureEnv(t)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				q := r.URL.Query()
+				if q.Get("api-version") != tt.wantVersion || q.Get("resource") != "api://kusari" || q.Get("client_id") != "user-assigned" {
+					t.Errorf("unexpected managed identity request %s", r.URL)
+				}
+				if tt.appService && r.Header.Get("X-IDENTITY-HEADER") != "identity-secret" || !tt.appService && r.Header.Get("Metadata") != "true" {
+					t.Errorf("managed identity request without its header: %v", r.Header)
+				}
+				_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "managed-identity-token"})
+			}))
+			defer server.Close()
+
+			imds := azureIMDSEndpoint
+			t.Cleanup(func() { azureIMDSEndpoint = imds })
+			if tt.appService {
+				t.Setenv("IDENTITY_ENDPOINT", server.URL+"/msi/token")
+				t.Setenv("IDENTITY_HEADER", "identity-secret")
+			} else {
+				azureIMDSEndpoint = server.URL + "/metadata/identity/oauth2/token"
+			}
+			t.Setenv("AZURE_CLIENT_ID", "user-assigned")
+
+			token, err := azureIdentityToken("api://kusari")(context.Background(), http.DefaultClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != "managed-identity-token" {
+				t.Errorf("azureIdentityToken() = %q, want the managed identity token", token)
+			}
+		})
+	}
+}
+
+func Test_azureProvider_tokenSource(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	clearAzureEnv(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/identity/oauth2/token":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_request", "error_description": "Identity not found"}`))
+		default:
+			t.Errorf("unexpected token exchange without an Azure token")
+		}
+	}))
+	defer server.Close()
+	imds := azureIMDSEndpoint
+	t.Cleanup(func() { azureIMDSEndpoint = imds })
+	azureIMDSEndpoint = server.URL + "/metadata/identity/oauth2/token"
+
+	_, err := azureProvider{}.tokenSource(context.Background(), server.URL+"/token", []clientCredential{{Name: "primary"}}).Token()
+	if err == nil {
+		t.Fatal("Token() succeeded without a managed identity")
+	}
+	if code := exitCodeOf(err, exitUsage); code != exitAuth {
+		t.Errorf("exit code = %d, want %d: %v", code, exitAuth, err)
+	}
+}
@@ -0,0 +1,625 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kusaridev/kusari-uploader/pkg/source"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// backfillState is persisted between backfill runs so an interrupted import
+// can be resumed without uploading the same files again.
+type backfillState struct {
+	// Completed maps a file path to the document ref it was uploaded as
+	Completed map[string]string `json:"completed"`
+}
+
+// clone returns a copy of the state that can be written while s changes
+func (s *backfillState) clone() *backfillState {
+	completed := make(map[string]string, len(s.Completed))
+	for path, ref := range s.Completed {
+		completed[path] = ref
+	}
+	return &backfillState{Completed: completed}
+}
+
+// refUpload is the upload of the content of a document ref, which the files
+// with the same content wait for
+type refUpload struct {
+	done chan struct{}
+	// err is the outcome of the upload, set before done is closed
+	err error
+}
+
+// completedRefUpload returns the refUpload of content uploaded by an earlier run
+func completedRefUpload() *refUpload {
+	u := &refUpload{done: make(chan struct{})}
+	close(u.done)
+	return u
+}
+
+// finish records the outcome of the upload and releases the files waiting for it
+func (u *refUpload) finish(err error) {
+	u.err = err
+	close(u.done)
+}
+
+// backfillReport summarizes a backfill run and its reconciliation against the tenant
+type backfillReport struct {
+	Uploaded   int
+	Resumed    int
+	Duplicates int
+	Empty      int
+	Failed     map[string]string
+	// Quarantined are the files set aside by the quarantine policy, with
+	// their failure reason
+	Quarantined map[string]string
+	Ingested    int
+	RunID       string
+	Pending     []string
+}
+
+func newBackfillCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "backfill",
+		Short:  "Bulk import a directory of historical documents with resumable state",
+		PreRun: bindUploadFlags,
+		Run:    backfill,
+	}
+
+	cmd.Flags().String("source", "", "Directory or s3:// bucket prefix to import (required)")
+	cmd.Flags().Int("concurrency", 0, "Number of files to upload in parallel")
+	// deprecated, see deprecations
+	_ = cmd.Flags().MarkHidden("concurrency")
+	cmd.Flags().Bool("adaptive-concurrency", true, "Lower the presign and upload concurrency while the tenant or storage return 429 or 5xx responses, and raise it again when they recover")
+	cmd.Flags().Float64("rate-limit", 0, "Maximum number of uploads started per second (0 for unlimited)")
+	cmd.Flags().String("state-file", ".kusari-backfill-state.json", "File used to record progress so an interrupted backfill can resume")
+	cmd.Flags().Int("checkpoint-interval", 100, "Number of completed uploads between writes of the state file")
+	addConcurrencyFlags(cmd.Flags(), "hash-concurrency", "presign-concurrency", "upload-concurrency", "check-concurrency")
+	addDocumentFlags(cmd.Flags())
+	addSBOMCheckFlags(cmd.Flags())
+	addWrapperVersionFlag(cmd.Flags())
+	addPacingFlag(cmd.Flags())
+	addQuarantineFlags(cmd.Flags())
+
+	mustBindPFlag(cmd, "source")
+	mustBindPFlag(cmd, "concurrency")
+	mustBindPFlag(cmd, "rate-limit")
+	mustBindPFlag(cmd, "adaptive-concurrency")
+	// the hash and check concurrency are also upload flags, bound by bindUploadFlags
+	mustBindPFlag(cmd, "presign-concurrency")
+	mustBindPFlag(cmd, "upload-concurrency")
+	mustBindPFlag(cmd, "state-file")
+	mustBindPFlag(cmd, "checkpoint-interval")
+	mustBindPFlag(cmd, "pacing-window")
+	mustBindPFlag(cmd, "file-timeout")
+	mustBindPFlag(cmd, "quarantine-after")
+	mustBindPFlag(cmd, "quarantine-dir")
+
+	return cmd
+}
+
+func backfill(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	sourcePath := viper.GetString("source")
+	limits, err := resolvePhaseLimits()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid concurrency")
+	}
+	// the deprecated --concurrency only ever limited the uploads
+	if concurrency := viper.GetInt("concurrency"); concurrency > 0 && !viper.IsSet("upload-concurrency") {
+		limits.Upload = concurrency
+	}
+	rateLimit := viper.GetFloat64("rate-limit")
+	statePath := viper.GetString("state-file")
+	checkpointInterval := viper.GetInt("checkpoint-interval")
+	pacing, err := resolvePacingWindow()
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid pacing-window")
+	}
+	quarantine, err := resolveQuarantinePolicy()
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid quarantine settings")
+	}
+
+	runID := resolveRunID()
+	results, messages, err := newOutput(viper.GetString("output"), runID)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid output format")
+	}
+
+	ctx, defaultClient := mustNewHTTPClient(ctx)
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, defaultClient)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+
+	if sourcePath == "" || !hasClientCredentials() ||
+		tenantEndPoint == "" || tokenEndPoint == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id, client-secret, source, tenant-endpoint (or org), token-endpoint")
+	}
+
+	mustValidateDocRefTemplate()
+	mustResolveForceFlags()
+
+	stateStore, err := openStateStore("", "backfill")
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid state-store")
+	}
+	state, err := loadBackfillState(ctx, stateStore, filepath.ToSlash(statePath))
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error loading backfill state")
+	}
+
+	creds, err := clientCredentials()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid client credentials")
+	}
+	authorizedClient := getAuthorizedClient(ctx, tokenEndPoint, creds)
+
+	caps := negotiateCapabilities(ctx, authorizedClient, tenantEndPoint)
+	if forceType := viper.GetString("force-type"); forceType != "" && !caps.supportsDocumentType(DocumentType(forceType)) {
+		log.Fatal().Msg("The tenant does not support " + forceType + " documents")
+	}
+	mustNegotiateWrapperVersion(caps)
+
+	documents, err := newBackfillSource(sourcePath)
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid source")
+	}
+	report, err := runBackfill(ctx, authorizedClient, defaultClient, tenantEndPoint, documents, state, backfillOptions{
+		runID:              runID,
+		capabilities:       caps,
+		results:            results,
+		messages:           messages,
+		limits:             limits,
+		adaptive:           viper.GetBool("adaptive-concurrency"),
+		rateLimit:          rateLimit,
+		checkpointInterval: checkpointInterval,
+		pacing:             pacing,
+		quarantine:         quarantine,
+		checkpoint: func(s *backfillState) error {
+			return saveBackfillState(ctx, stateStore, filepath.ToSlash(statePath), s)
+		},
+	})
+	if err != nil {
+		fatalErr(err, exitUpload).
+			Msg("Backfill failed")
+	}
+
+	printBackfillReport(messages, report)
+	printTyposquatReport(messages, runTyposquats.summary())
+	printRetrySummary(messages, runRetries.summary())
+
+	if len(report.Failed) > 0 || len(report.Quarantined) > 0 {
+		exitRun(exitUpload)
+	}
+}
+
+type backfillOptions struct {
+	runID              string
+	limits             phaseLimits
+	rateLimit          float64
+	checkpointInterval int
+	checkpoint         func(*backfillState) error
+	// pacing limits the uploads to a time of day, if set
+	pacing *pacingWindow
+	// quarantine sets aside files that keep failing, if set
+	quarantine *quarantinePolicy
+	// adaptive lowers the presign and upload limits while the server is overloaded
+	adaptive bool
+	// capabilities of the tenant, files it can't accept are recorded as failed
+	capabilities *tenantCapabilities
+	// results receives the outcome of each file as it completes
+	results *resultStream
+	// messages receives progress messages, if set
+	messages io.Writer
+}
+
+// newBackfillSource returns the documents of --source, the objects below an
+// s3:// bucket prefix or else the files below a directory
+func newBackfillSource(path string) (source.Source, error) {
+	if strings.HasPrefix(path, "s3://") {
+		return newObjectStoreSource(path)
+	}
+	return source.NewDirectory(path, nil), nil
+}
+
+// runBackfill uploads every document of documents that is not already recorded in
+// state, then asks the tenant which of the uploaded document refs it knows about.
+// Individual file failures are recorded in the report instead of stopping the run.
+func runBackfill(ctx context.Context, authorizedClient, defaultClient HttpClient, tenantApiEndpoint string, documents source.Source,
+	state *backfillState, opts backfillOptions) (*backfillReport, error) {
+	report := &backfillReport{Failed: map[string]string{}, Quarantined: map[string]string{}, RunID: opts.runID}
+	// uploads holds the upload of each document ref that succeeded or is in
+	// flight, failed ones are removed so a file with the same content retries
+	uploads := map[string]*refUpload{}
+	for _, ref := range state.Completed {
+		uploads[ref] = completedRefUpload()
+	}
+
+	var throttle <-chan time.Time
+	if opts.rateLimit > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.rateLimit))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	// the state only holds the paths of the documents, so they are
+	// listed up front to know which ones are left
+	var pending []source.Item
+	for {
+		item, err := documents.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && item.Path == "" {
+			return nil, fmt.Errorf("failed to list source: %w", err)
+		}
+		if err != nil {
+			report.Failed[item.Path] = err.Error()
+			opts.results.write(fileResult{Path: item.Path, Status: resultFailed, Error: err.Error()})
+			continue
+		}
+		if _, ok := state.Completed[item.Path]; ok {
+			report.Resumed++
+		} else {
+			pending = append(pending, item)
+		}
+	}
+
+	var mu sync.Mutex
+	sinceCheckpoint := 0
+	// checkpointMu keeps checkpoints in order without holding mu while the
+	// state is written, which may be a network request to the state store
+	var checkpointMu sync.Mutex
+
+	g := new(errgroup.Group)
+	g.SetLimit(opts.limits.workers())
+
+	hashSem := semaphore.NewWeighted(int64(opts.limits.Hash))
+	var presignClient HttpClient = newLimitedClient(authorizedClient, opts.limits.Presign)
+	var uploadClient HttpClient = newLimitedClient(defaultClient, opts.limits.Upload)
+	if opts.adaptive {
+		presignClient = newAdaptiveClient("presign", authorizedClient, opts.limits.Presign)
+		uploadClient = newAdaptiveClient("upload", defaultClient, opts.limits.Upload)
+	}
+
+	for _, item := range pending {
+		path := item.Path
+		g.Go(func() error {
+			blob, sum, ref, err := readAndHash(ctx, hashSem, pendingUpload{path: path, meta: map[string]string{"run_id": opts.runID}, read: item.Content})
+			if err != nil {
+				mu.Lock()
+				report.Failed[path] = err.Error()
+				mu.Unlock()
+				opts.results.write(fileResult{Path: path, Status: resultFailed, Error: err.Error()})
+				return nil
+			}
+			if err := opts.capabilities.checkSize(path, int64(len(blob))); err != nil {
+				mu.Lock()
+				report.Failed[path] = err.Error()
+				mu.Unlock()
+				opts.results.write(fileResult{Path: path, Status: resultFailed, Error: err.Error()})
+				return nil
+			}
+			if len(blob) == 0 {
+				mu.Lock()
+				report.Empty++
+				mu.Unlock()
+				opts.results.write(fileResult{Path: path, Status: resultSkipped})
+				return nil
+			}
+
+			// a file with the same content as another one is only recorded as
+			// completed once the upload of the other one succeeded
+			var upload *refUpload
+			for {
+				mu.Lock()
+				other, ok := uploads[ref]
+				if !ok {
+					upload = &refUpload{done: make(chan struct{})}
+					uploads[ref] = upload
+				}
+				mu.Unlock()
+				if !ok {
+					break
+				}
+
+				<-other.done
+				if other.err == nil {
+					mu.Lock()
+					report.Duplicates++
+					state.Completed[path] = ref
+					mu.Unlock()
+					opts.results.write(fileResult{Path: path, Status: resultSkipped, DocumentRef: ref})
+					return nil
+				}
+			}
+
+			if err := opts.pacing.wait(ctx); err != nil {
+				mu.Lock()
+				delete(uploads, ref)
+				mu.Unlock()
+				upload.finish(err)
+				return err
+			}
+			if throttle != nil {
+				<-throttle
+			}
+
+			quarantine := opts.quarantine
+			if quarantine == nil {
+				quarantine = &quarantinePolicy{}
+			}
+			err = quarantine.run(ctx, path, blob, presignClient, uploadClient, func(presignClient, uploadClient HttpClient) error {
+				_, err := uploadHashedBlob(presignClient, uploadClient, tenantApiEndpoint, path, blob, sum, ref, false, map[string]string{"run_id": opts.runID})
+				return err
+			})
+
+			mu.Lock()
+			if err != nil {
+				// let the other files with the same content try again
+				delete(uploads, ref)
+			}
+			var quarantined *quarantinedError
+			switch {
+			case errors.As(err, &quarantined):
+				report.Quarantined[path] = quarantined.Error()
+				opts.results.write(fileResult{Path: path, Status: resultQuarantined, DocumentRef: ref, Error: quarantined.Error()})
+			case err != nil:
+				report.Failed[path] = err.Error()
+				opts.results.write(fileResult{Path: path, Status: resultFailed, DocumentRef: ref, Error: err.Error()})
+			default:
+				opts.results.write(fileResult{Path: path, Status: resultUploaded, DocumentRef: ref})
+				report.Uploaded++
+				state.Completed[path] = ref
+				log.Debug().Str("path", path).Str("documentRef", ref).Msg("Uploaded")
+				sinceCheckpoint++
+			}
+			checkpoint := err == nil && opts.checkpoint != nil && opts.checkpointInterval > 0 && sinceCheckpoint >= opts.checkpointInterval
+			if checkpoint {
+				sinceCheckpoint = 0
+			}
+			mu.Unlock()
+			upload.finish(err)
+
+			if !checkpoint {
+				return nil
+			}
+			checkpointMu.Lock()
+			defer checkpointMu.Unlock()
+			mu.Lock()
+			snapshot := state.clone()
+			mu.Unlock()
+			if err := opts.checkpoint(snapshot); err != nil {
+				return fmt.Errorf("failed to write checkpoint: %w", err)
+			}
+			if opts.messages != nil {
+				fmt.Fprintf(opts.messages, "Checkpoint: %d files completed\n", len(snapshot.Completed))
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	if opts.checkpoint != nil {
+		if err := opts.checkpoint(state); err != nil {
+			return nil, fmt.Errorf("failed to write final state: %w", err)
+		}
+	}
+
+	refs := make([]string, 0, len(uploads))
+	for ref := range uploads {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	ingested, err := lookupDocumentRefs(ctx, authorizedClient, tenantApiEndpoint, refs, opts.limits.Check)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile with tenant: %w", err)
+	}
+	for _, ref := range refs {
+		if ingested[ref] {
+			report.Ingested++
+		} else {
+			report.Pending = append(report.Pending, ref)
+		}
+	}
+
+	return report, nil
+}
+
+// readAndHash reads a file and computes its sha256 sum and document ref while
+// holding a slot of sem
+func readAndHash(ctx context.Context, sem *semaphore.Weighted, file pendingUpload) ([]byte, string, string, error) {
+	if err := sem.Acquire(ctx, 1); err != nil {
+		return nil, "", "", err
+	}
+	defer sem.Release(1)
+
+	blob, err := file.load(ctx)
+	if err != nil {
+		return nil, "", "", err
+	}
+	sum := getHash(blob)
+	if len(blob) == 0 {
+		return blob, sum, contentRef(sum), nil
+	}
+	ref, err := documentRef(blob, sum, file.meta)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return blob, sum, ref, nil
+}
+
+// lookupDocumentRefs asks the tenant whether it knows about each of the given
+// document refs and returns the ones it reports as ingested.
+func lookupDocumentRefs(ctx context.Context, client HttpClient, tenantEndpoint string, refs []string, limit int) (map[string]bool, error) {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(limit)
+
+	var mu sync.Mutex
+	known := map[string]bool{}
+
+	for _, ref := range refs {
+		g.Go(func() error {
+			res, err := makePicoReq(ctx, client, tenantEndpoint, fmt.Sprintf("pico/v1/documents/ref/%s", url.PathEscape(ref)))
+			if err != nil {
+				return fmt.Errorf("error making request for document ref %s: %w", ref, err)
+			}
+			defer res.Body.Close() //nolint:errcheck
+
+			switch res.StatusCode {
+			case http.StatusOK:
+				mu.Lock()
+				known[ref] = true
+				mu.Unlock()
+			case http.StatusNotFound:
+			default:
+				return tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for document ref %s: %d", ref, res.StatusCode))
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return known, nil
+}
+
+// loadBackfillState reads the state stored under key, which is empty if no
+// backfill ran yet
+func loadBackfillState(ctx context.Context, store stateStore, key string) (*backfillState, error) {
+	state := &backfillState{Completed: map[string]string{}}
+
+	data, err := store.Get(ctx, key)
+	if errors.Is(err, errStateNotFound) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file %s: %w", key, err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", key, err)
+	}
+	if state.Completed == nil {
+		state.Completed = map[string]string{}
+	}
+
+	return state, nil
+}
+
+func saveBackfillState(ctx context.Context, store stateStore, key string, state *backfillState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := store.Put(ctx, key, data); err != nil {
+		return fmt.Errorf("failed to write state file %s: %w", key, err)
+	}
+	return nil
+}
+
+func printBackfillReport(w io.Writer, report *backfillReport) {
+	fmt.Fprintln(w, "Backfill completed")
+	fmt.Fprintf(w, "  Run ID:              %s\n", report.RunID)
+	fmt.Fprintf(w, "  Uploaded:            %d\n", report.Uploaded)
+	fmt.Fprintf(w, "  Already completed:   %d\n", report.Resumed)
+	fmt.Fprintf(w, "  Duplicate content:   %d\n", report.Duplicates)
+	fmt.Fprintf(w, "  Empty (skipped):     %d\n", report.Empty)
+	fmt.Fprintf(w, "  Failed:              %d\n", len(report.Failed))
+	if len(report.Quarantined) > 0 {
+		fmt.Fprintf(w, "  Quarantined:         %d\n", len(report.Quarantined))
+	}
+	fmt.Fprintf(w, "  Reported ingested:   %d\n", report.Ingested)
+	fmt.Fprintf(w, "  Not yet ingested:    %d\n", len(report.Pending))
+
+	if len(report.Failed) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Failed files:")
+		paths := make([]string, 0, len(report.Failed))
+		for path := range report.Failed {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Fprintf(w, "  %s: %s\n", path, report.Failed[path])
+		}
+	}
+
+	if len(report.Quarantined) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Quarantined files:")
+		paths := make([]string, 0, len(report.Quarantined))
+		for path := range report.Quarantined {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Fprintf(w, "  %s: %s\n", path, report.Quarantined[path])
+		}
+	}
+
+	if len(report.Pending) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Document refs not yet reported as ingested by the tenant:")
+		for _, ref := range report.Pending {
+			fmt.Fprintf(w, "  %s\n", ref)
+		}
+	}
+}
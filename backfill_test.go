@@ -0,0 +1,269 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kusaridev/kusari-uploader/pkg/source"
+)
+
+func Test_runBackfill(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.json":   "first",
+		"b.json":   "second",
+		"c.json":   "first",
+		"empty":    "",
+		"old.json": "already uploaded",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var puts atomic.Int32
+	authClientMock := &ClientMock{
+		PostFunc: func(url, contentType string, body io.Reader) (resp *http.Response, err error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"presignedUrl": "http://example.com/upload"}`)),
+			}, nil
+		},
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			// only the content of b.json is known to the tenant
+			status := http.StatusNotFound
+			if strings.HasSuffix(req.URL.Path, getDocRef([]byte("second"))) {
+				status = http.StatusOK
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(bytes.NewBufferString("")),
+			}, nil
+		},
+	}
+	defaultClientMock := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			puts.Add(1)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString("")),
+			}, nil
+		},
+	}
+
+	state := &backfillState{Completed: map[string]string{
+		filepath.Join(dir, "old.json"): getDocRef([]byte("already uploaded")),
+	}}
+	checkpoints := 0
+	var results bytes.Buffer
+	report, err := runBackfill(context.Background(), authClientMock, defaultClientMock, "http://example.com", source.NewDirectory(dir, nil), state, backfillOptions{
+		results:            newResultStream(&results, "run"),
+		limits:             phaseLimits{Hash: 1, Presign: 1, Upload: 1, Check: 1},
+		checkpointInterval: 1,
+		checkpoint: func(*backfillState) error {
+			checkpoints++
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("runBackfill() error = %v", err)
+	}
+
+	if report.Uploaded != 2 || puts.Load() != 2 {
+		t.Errorf("runBackfill() uploaded = %d (puts %d), want 2", report.Uploaded, puts.Load())
+	}
+	if report.Resumed != 1 {
+		t.Errorf("runBackfill() resumed = %d, want 1", report.Resumed)
+	}
+	if report.Duplicates != 1 {
+		t.Errorf("runBackfill() duplicates = %d, want 1", report.Duplicates)
+	}
+	if report.Empty != 1 {
+		t.Errorf("runBackfill() empty = %d, want 1", report.Empty)
+	}
+	if report.Ingested != 1 || len(report.Pending) != 2 {
+		t.Errorf("runBackfill() ingested = %d pending = %v, want 1 ingested and 2 pending", report.Ingested, report.Pending)
+	}
+	if len(state.Completed) != 4 {
+		t.Errorf("runBackfill() completed state = %v, want 4 entries", state.Completed)
+	}
+	// one result per file that was not already completed
+	statuses := map[string]int{}
+	dec := json.NewDecoder(&results)
+	for dec.More() {
+		var r fileResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		statuses[r.Status]++
+	}
+	if !reflect.DeepEqual(statuses, map[string]int{resultUploaded: 2, resultSkipped: 2}) {
+		t.Errorf("runBackfill() results = %v, want 2 uploaded and 2 skipped", statuses)
+	}
+	// one checkpoint per upload plus the final one
+	if checkpoints != 3 {
+		t.Errorf("runBackfill() checkpoints = %d, want 3", checkpoints)
+	}
+}
+
+func Test_runBackfillFailedDuplicate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.json", "b.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("same"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authClientMock := &ClientMock{
+		PostFunc: func(url, contentType string, body io.Reader) (resp *http.Response, err error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"presignedUrl": "http://example.com/upload"}`)),
+			}, nil
+		},
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		},
+	}
+	var puts atomic.Int32
+	defaultClientMock := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			puts.Add(1)
+			return &http.Response{StatusCode: http.StatusInternalServerError, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		},
+	}
+
+	state := &backfillState{Completed: map[string]string{}}
+	var results bytes.Buffer
+	report, err := runBackfill(context.Background(), authClientMock, defaultClientMock, "http://example.com", source.NewDirectory(dir, nil), state, backfillOptions{
+		results:            newResultStream(&results, "run"),
+		limits:             phaseLimits{Hash: 2, Presign: 2, Upload: 2, Check: 1},
+		checkpointInterval: 1,
+		checkpoint:         func(*backfillState) error { return nil },
+	})
+	if err != nil {
+		t.Fatalf("runBackfill() error = %v", err)
+	}
+
+	// the duplicate is uploaded itself once the upload of the same content failed
+	if puts.Load() != 2 || len(report.Failed) != 2 || report.Duplicates != 0 {
+		t.Errorf("runBackfill() puts = %d failed = %v duplicates = %d, want 2 puts, 2 failed and no duplicates",
+			puts.Load(), report.Failed, report.Duplicates)
+	}
+	if len(state.Completed) != 0 {
+		t.Errorf("runBackfill() completed state = %v, want none", state.Completed)
+	}
+}
+
+func Test_runBackfillObjectStore(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	objects := map[string]string{"archive/a.json": "first", "archive/b.json": "second", "archive/c.json": "first"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket" {
+			fmt.Fprint(w, objects[strings.TrimPrefix(r.URL.Path, "/bucket/")])
+			return
+		}
+		fmt.Fprint(w, "<ListBucketResult>")
+		for _, key := range []string{"archive/a.json", "archive/b.json", "archive/c.json"} {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", key)
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	}))
+	defer srv.Close()
+
+	src, err := newObjectStoreSource("s3://bucket/archive?region=us-east-1&endpoint=" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.store.client = srv.Client()
+
+	var puts atomic.Int32
+	authClientMock := &ClientMock{
+		PostFunc: func(url, contentType string, body io.Reader) (resp *http.Response, err error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"presignedUrl": "http://example.com/upload"}`)),
+			}, nil
+		},
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		},
+	}
+	defaultClientMock := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			puts.Add(1)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		},
+	}
+
+	// a.json was uploaded by an interrupted run
+	state := &backfillState{Completed: map[string]string{"s3://bucket/archive/a.json": getDocRef([]byte("first"))}}
+	report, err := runBackfill(context.Background(), authClientMock, defaultClientMock, "http://example.com", src, state, backfillOptions{
+		limits: phaseLimits{Hash: 1, Presign: 1, Upload: 1, Check: 1},
+	})
+	if err != nil {
+		t.Fatalf("runBackfill() error = %v", err)
+	}
+	if report.Resumed != 1 || report.Duplicates != 1 || report.Uploaded != 1 || puts.Load() != 1 {
+		t.Errorf("runBackfill() resumed = %d, duplicates = %d, uploaded = %d (puts %d), want 1 each",
+			report.Resumed, report.Duplicates, report.Uploaded, puts.Load())
+	}
+	if _, ok := state.Completed["s3://bucket/archive/b.json"]; !ok || len(state.Completed) != 3 {
+		t.Errorf("runBackfill() completed state = %v, want every object", state.Completed)
+	}
+}
+
+func Test_backfillStateRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := &fileStore{dir: t.TempDir()}
+
+	state, err := loadBackfillState(ctx, store, "state.json")
+	if err != nil {
+		t.Fatalf("loadBackfillState() on missing file error = %v", err)
+	}
+	if len(state.Completed) != 0 {
+		t.Errorf("loadBackfillState() on missing file = %v, want empty", state.Completed)
+	}
+
+	state.Completed["file"] = "sha256_abc"
+	if err := saveBackfillState(ctx, store, "state.json", state); err != nil {
+		t.Fatalf("saveBackfillState() error = %v", err)
+	}
+
+	loaded, err := loadBackfillState(ctx, store, "state.json")
+	if err != nil {
+		t.Fatalf("loadBackfillState() error = %v", err)
+	}
+	if loaded.Completed["file"] != "sha256_abc" {
+		t.Errorf("loadBackfillState() = %v, want saved state", loaded.Completed)
+	}
+}
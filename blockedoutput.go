@@ -0,0 +1,276 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// blockedOutput* are the formats of --blocked-output
+const (
+	blockedOutputText  = "text"
+	blockedOutputJSON  = "json"
+	blockedOutputSARIF = "sarif"
+)
+
+// blockedWebhookTimeout bounds how long a webhook sink may take to accept the
+// findings
+const blockedWebhookTimeout = 30 * time.Second
+
+// blockedFinding is an SBOM that uses blocked packages. Subject and URI are
+// empty when the SBOM was checked by its platform IDs.
+type blockedFinding struct {
+	Subject         string   `json:"subject,omitempty"`
+	URI             string   `json:"uri,omitempty"`
+	SoftwareID      int64    `json:"software_id"`
+	SbomID          int64    `json:"sbom_id"`
+	BlockedPackages []string `json:"blocked_packages"`
+}
+
+// blockedSink is a destination of --blocked-output: stdout for -, a webhook
+// for http:// and https:// URLs, or else a file, written in format
+type blockedSink struct {
+	format string
+	target string
+}
+
+// parseBlockedSinks parses the FORMAT:TARGET values of --blocked-output. The
+// format can be left out for text, and without any value the findings are
+// written to stdout as text.
+func parseBlockedSinks() ([]blockedSink, error) {
+	values := viper.GetStringSlice("blocked-output")
+	if len(values) == 0 {
+		return []blockedSink{{format: blockedOutputText, target: "-"}}, nil
+	}
+
+	formats := []string{blockedOutputText, blockedOutputJSON, blockedOutputSARIF}
+	var sinks []blockedSink
+	for _, value := range values {
+		sink := blockedSink{format: blockedOutputText, target: value}
+		if format, target, ok := strings.Cut(value, ":"); ok && slices.Contains(formats, format) {
+			sink = blockedSink{format: format, target: target}
+		}
+		if sink.target == "" {
+			return nil, fmt.Errorf("invalid blocked-output %q, must be FORMAT:TARGET where TARGET is -, a file or a webhook URL", value)
+		}
+		if strings.HasPrefix(sink.target, "http://") || strings.HasPrefix(sink.target, "https://") {
+			if err := checkOutboundEndpoint("blocked-output webhook", sink.target); err != nil {
+				return nil, err
+			}
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// mustValidateBlockedSinks exits if --blocked-output is invalid, before
+// anything is uploaded
+func mustValidateBlockedSinks() {
+	if _, err := parseBlockedSinks(); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid blocked-output")
+	}
+}
+
+// writeBlockedFindings writes the findings to every --blocked-output sink,
+// including when there are none so files and webhooks always get a result.
+// stdout is where - writes to.
+func writeBlockedFindings(ctx context.Context, stdout io.Writer, findings []blockedFinding) error {
+	sinks, err := parseBlockedSinks()
+	if err != nil {
+		return err
+	}
+	if reproducible() {
+		sortBlockedFindings(findings)
+	}
+
+	var errs []error
+	for _, sink := range sinks {
+		data, contentType, err := renderBlockedFindings(sink.format, findings)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		switch {
+		case sink.target == "-":
+			_, err = stdout.Write(data)
+		case strings.HasPrefix(sink.target, "http://") || strings.HasPrefix(sink.target, "https://"):
+			err = postBlockedFindings(ctx, sink.target, contentType, data)
+		default:
+			err = os.WriteFile(sink.target, data, 0o644)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error writing blocked package findings to %s: %w", redactSetting("", sink.target), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// renderBlockedFindings returns the findings in format with its content type
+func renderBlockedFindings(format string, findings []blockedFinding) ([]byte, string, error) {
+	switch format {
+	case blockedOutputJSON:
+		data, err := json.MarshalIndent(struct {
+			Blocked  bool             `json:"blocked"`
+			Findings []blockedFinding `json:"findings"`
+		}{Blocked: len(findings) > 0, Findings: append([]blockedFinding{}, findings...)}, "", "  ")
+		return append(data, '\n'), "application/json", err
+	case blockedOutputSARIF:
+		data, err := json.MarshalIndent(blockedSARIF(findings), "", "  ")
+		return append(data, '\n'), "application/sarif+json", err
+	default:
+		var buf bytes.Buffer
+		for _, finding := range findings {
+			if finding.Subject != "" || finding.URI != "" {
+				fmt.Fprintf(&buf, "Blocked packages found for SBOM subject %s with URI %s\n", finding.Subject, finding.URI)
+			} else {
+				fmt.Fprintf(&buf, "Blocked packages found for software ID %d, SBOM ID %d\n", finding.SoftwareID, finding.SbomID)
+			}
+			for _, bp := range finding.BlockedPackages {
+				fmt.Fprintln(&buf, bp)
+			}
+			fmt.Fprintln(&buf)
+		}
+		return buf.Bytes(), "text/plain; charset=utf-8", nil
+	}
+}
+
+// sarifLog is the subset of SARIF 2.1.0 the findings are reported with, see
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name           string      `json:"name"`
+			Version        string      `json:"version,omitempty"`
+			InformationURI string      `json:"informationUri"`
+			Rules          []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties map[string]string `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+	} `json:"physicalLocation"`
+}
+
+// blockedPackageRule is the SARIF rule of blocked package results
+const blockedPackageRule = "blocked-package"
+
+// blockedSARIF returns the findings as a SARIF log with a result per blocked
+// package, located at the URI of its SBOM, or at its platform IDs
+func blockedSARIF(findings []blockedFinding) sarifLog {
+	var run sarifRun
+	run.Tool.Driver.Name = "kusari-uploader"
+	run.Tool.Driver.Version = buildVersion()
+	run.Tool.Driver.InformationURI = "https://github.com/kusaridev/kusari-uploader"
+	run.Tool.Driver.Rules = []sarifRule{{
+		ID:               blockedPackageRule,
+		ShortDescription: sarifMessage{Text: "The SBOM uses a package blocked on the Kusari Platform"},
+	}}
+	run.Results = []sarifResult{}
+
+	for _, finding := range findings {
+		var location sarifLocation
+		location.PhysicalLocation.ArtifactLocation.URI = finding.URI
+		if finding.URI == "" {
+			location.PhysicalLocation.ArtifactLocation.URI = fmt.Sprintf("kusari:software/%d/sbom/%d", finding.SoftwareID, finding.SbomID)
+		}
+		for _, bp := range finding.BlockedPackages {
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    blockedPackageRule,
+				Level:     "error",
+				Message:   sarifMessage{Text: "Blocked package " + bp},
+				Locations: []sarifLocation{location},
+				Properties: map[string]string{
+					"package":     bp,
+					"subject":     finding.Subject,
+					"software_id": fmt.Sprint(finding.SoftwareID),
+					"sbom_id":     fmt.Sprint(finding.SbomID),
+				},
+			})
+		}
+	}
+
+	return sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
+
+// postBlockedFindings sends the findings to a webhook
+func postBlockedFindings(ctx context.Context, webhook, contentType string, data []byte) error {
+	client, err := newHTTPClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, blockedWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status code: %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_parseBlockedSinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []blockedSink
+		wantErr bool
+	}{
+		{name: "default", want: []blockedSink{{format: blockedOutputText, target: "-"}}},
+		{
+			name:   "formats",
+			values: []string{"-", "json:findings.json", "sarif:https://hooks.example.com/blocked"},
+			want: []blockedSink{
+				{format: blockedOutputText, target: "-"},
+				{format: blockedOutputJSON, target: "findings.json"},
+				{format: blockedOutputSARIF, target: "https://hooks.example.com/blocked"},
+			},
+		},
+		{name: "webhook without format", values: []string{"https://hooks.example.com/blocked"},
+			want: []blockedSink{{format: blockedOutputText, target: "https://hooks.example.com/blocked"}}},
+		{name: "windows path", values: []string{`C:\findings.txt`}, want: []blockedSink{{format: blockedOutputText, target: `C:\findings.txt`}}},
+		{name: "missing target", values: []string{"json:"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			viper.Set("blocked-output", tt.values)
+
+			got, err := parseBlockedSinks()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBlockedSinks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBlockedSinks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_writeBlockedFindings(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	var webhookType string
+	var webhookBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhookType = r.Header.Get("Content-Type")
+		webhookBody, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "findings.json")
+	viper.Set("blocked-output", []string{"text:-", "json:" + path, "sarif:" + srv.URL})
+
+	findings := []blockedFinding{
+		{Subject: "app", URI: "https://example.com/app.cdx.json", SoftwareID: 1, SbomID: 2, BlockedPackages: []string{"pkg:npm/left-pad@1.0.0", "pkg:npm/evil@6.6.6"}},
+		{SoftwareID: 3, SbomID: 4, BlockedPackages: []string{"pkg:pypi/requestz@1.0"}},
+	}
+	var stdout bytes.Buffer
+	if err := writeBlockedFindings(context.Background(), &stdout, findings); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"Blocked packages found for SBOM subject app with URI https://example.com/app.cdx.json\npkg:npm/left-pad@1.0.0\npkg:npm/evil@6.6.6\n",
+		"Blocked packages found for software ID 3, SBOM ID 4\npkg:pypi/requestz@1.0\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("text output = %q, want %q", stdout.String(), want)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		Blocked  bool             `json:"blocked"`
+		Findings []blockedFinding `json:"findings"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Blocked || !reflect.DeepEqual(report.Findings, findings) {
+		t.Errorf("json output = %s, want the findings", data)
+	}
+
+	if webhookType != "application/sarif+json" {
+		t.Errorf("webhook Content-Type = %q, want application/sarif+json", webhookType)
+	}
+	var sarif sarifLog
+	if err := json.Unmarshal(webhookBody, &sarif); err != nil {
+		t.Fatal(err)
+	}
+	if sarif.Version != "2.1.0" || len(sarif.Runs) != 1 || len(sarif.Runs[0].Results) != 3 {
+		t.Fatalf("sarif output = %s, want a run with a result per blocked package", webhookBody)
+	}
+	if got := sarif.Runs[0].Results[2].Locations[0].PhysicalLocation.ArtifactLocation.URI; got != "kusari:software/3/sbom/4" {
+		t.Errorf("sarif location of an SBOM without URI = %q, want its platform IDs", got)
+	}
+
+	// no findings still writes a result to files
+	stdout.Reset()
+	if err := writeBlockedFindings(context.Background(), &stdout, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), `"findings": []`) {
+		t.Errorf("json output without findings = %s, want an empty list", data)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("text output without findings = %q, want nothing", stdout.String())
+	}
+
+	viper.Set("blocked-output", []string{"json:" + filepath.Join(path, "missing", "findings.json"), "-"})
+	stdout.Reset()
+	if err := writeBlockedFindings(context.Background(), &stdout, findings); err == nil {
+		t.Error("writeBlockedFindings() expected an error for an unwritable file")
+	}
+	if stdout.Len() == 0 {
+		t.Error("writeBlockedFindings() stopped at the failed sink, want the other sinks written")
+	}
+}
@@ -0,0 +1,540 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	bundleVersion      = 1
+	bundleManifestName = "manifest.json"
+	bundleSigName      = "manifest.sig"
+	bundleDocumentDir  = "documents/"
+)
+
+// bundleManifest lists the documents of a bundle. It is signed, and the
+// documents are verified against the hashes it lists, so the signature covers
+// the whole bundle.
+type bundleManifest struct {
+	Version   int              `json:"version"`
+	ID        string           `json:"id"`
+	CreatedAt time.Time        `json:"created_at"`
+	Documents []bundleDocument `json:"documents"`
+}
+
+// bundleDocument is a document of a bundle and the upload metadata resolved
+// for it on the exporting host
+type bundleDocument struct {
+	Path     string            `json:"path"`
+	SHA256   string            `json:"sha256"`
+	Size     int64             `json:"size"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// bundleSource is a local file to export and its path relative to the
+// exported directory, which routing rules are matched against
+type bundleSource struct {
+	Path    string
+	RelPath string
+}
+
+func newExportBundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export-bundle <bundle.tar.gz>",
+		Short: "Package documents and their upload metadata into a signed bundle for upload from another host",
+		Long: "Package documents and their upload metadata into a signed bundle on a host without access to the tenant, " +
+			"such as an air-gapped build network. The bundle is uploaded from a connected host with import-bundle. " +
+			"No network access or credentials are needed.",
+		Args:   cobra.ExactArgs(1),
+		PreRun: bindUploadFlags,
+		Run:    exportBundle,
+	}
+
+	cmd.Flags().StringP("file-path", "f", "", "Path to the file or directory to export (required unless files-from is set)")
+	addFilesFromFlags(cmd.Flags())
+	addMetadataFlags(cmd.Flags())
+	cmd.Flags().Bool("omit-file-metadata", false, "Do not record the file name, extension, size and modification time of the files in the bundle's upload metadata (optional)")
+	cmd.Flags().String("signing-key", "", "PEM encoded Ed25519 private key the bundle is signed with (required)")
+
+	mustBindPFlag(cmd, "signing-key")
+
+	return cmd
+}
+
+func exportBundle(cmd *cobra.Command, args []string) {
+	bundlePath := args[0]
+	filePath := viper.GetString("file-path")
+	filesFrom := viper.GetString("files-from")
+
+	if (filePath == "") == (filesFrom == "") {
+		log.Fatal().Msg("Exactly one of file-path and files-from must be provided")
+	}
+
+	key, err := loadSigningKey(viper.GetString("signing-key"))
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid signing key")
+	}
+
+	var fileList []string
+	if filesFrom != "" {
+		fileList, err = loadFileList(filesFrom, viper.GetBool("null"))
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Error reading file list")
+		}
+	}
+	sources, err := collectBundleSources(filePath, fileList)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error collecting files")
+	}
+
+	rules, err := loadRoutingRules()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid routing rules")
+	}
+	mustValidateCustomMetadata(cmd.Flags())
+	metadata := func(src bundleSource, size int) map[string]string {
+		meta := metadataValues(resolveUploadMetadata(cmd.Flags(), rules, src.RelPath))
+		if !viper.GetBool("omit-file-metadata") {
+			for k, v := range fileMetadata(src.Path, size) {
+				meta[k] = v
+			}
+		}
+		return meta
+	}
+
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error creating bundle")
+	}
+	manifest, err := writeBundle(f, key, resolveRunID(), generatedAt(time.Now()), sources, metadata)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(bundlePath) //nolint:errcheck
+		log.Fatal().
+			Err(err).
+			Msg("Error writing bundle")
+	}
+
+	fmt.Printf("Exported %d document(s) to %s\n", len(manifest.Documents), bundlePath)
+	fmt.Printf("Bundle ID: %s\n", manifest.ID)
+}
+
+// collectBundleSources returns the files of the file list, or else the file
+// or the files below the directory at filePath. Empty files are left out as
+// they are never uploaded.
+func collectBundleSources(filePath string, fileList []string) ([]bundleSource, error) {
+	var sources []bundleSource
+	add := func(path, relPath string, info os.FileInfo) {
+		if info.IsDir() || info.Size() == 0 {
+			return
+		}
+		sources = append(sources, bundleSource{Path: path, RelPath: filepath.ToSlash(relPath)})
+	}
+
+	if fileList != nil {
+		for _, path := range fileList {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get stats on filepath: %s, with error: %w", path, err)
+			}
+			add(path, filepath.Clean(path), info)
+		}
+		return sources, nil
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats on filepath: %s, with error: %w", filePath, err)
+	}
+	if !info.IsDir() {
+		add(filePath, filePath, info)
+		return sources, nil
+	}
+
+	err = filepath.Walk(filePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(filePath, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path of %s: %w", path, err)
+		}
+		add(path, relPath, info)
+		return nil
+	})
+	return sources, err
+}
+
+// writeBundle writes a gzipped tarball of the manifest, its signature and the
+// documents read from sources. Files are read twice, to hash them for the
+// manifest and to copy them, and must not change in between. Documents with
+// the same content are stored once.
+func writeBundle(w io.Writer, key ed25519.PrivateKey, id string, now time.Time, sources []bundleSource,
+	metadata func(src bundleSource, size int) map[string]string) (*bundleManifest, error) {
+	manifest := &bundleManifest{Version: bundleVersion, ID: id, CreatedAt: now.UTC()}
+	for _, src := range sources {
+		blob, err := os.ReadFile(src.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %s, err: %w", src.Path, err)
+		}
+		manifest.Documents = append(manifest.Documents, bundleDocument{
+			Path:     src.RelPath,
+			SHA256:   getHash(blob),
+			Size:     int64(len(blob)),
+			Metadata: metadata(src, len(blob)),
+		})
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifestBytes))
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	writeEntry := func(name string, data []byte) error {
+		return writeTarFile(tw, name, data, now)
+	}
+
+	if err := writeEntry(bundleManifestName, manifestBytes); err != nil {
+		return nil, err
+	}
+	if err := writeEntry(bundleSigName, []byte(sig+"\n")); err != nil {
+		return nil, err
+	}
+	written := map[string]bool{}
+	for i, doc := range manifest.Documents {
+		if written[doc.SHA256] {
+			continue
+		}
+		blob, err := os.ReadFile(sources[i].Path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file: %s, err: %w", sources[i].Path, err)
+		}
+		if getHash(blob) != doc.SHA256 {
+			return nil, fmt.Errorf("%s changed while the bundle was written", sources[i].Path)
+		}
+		if err := writeEntry(bundleDocumentDir+doc.SHA256, blob); err != nil {
+			return nil, err
+		}
+		written[doc.SHA256] = true
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// writeTarFile writes a regular file to a tarball
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// readBundle reads a bundle, verifies the signature of its manifest with pub
+// and returns the manifest and the documents by their sha256. Any document that
+// is missing, unlisted or does not match its hash fails the whole bundle.
+func readBundle(r io.Reader, pub ed25519.PublicKey) (*bundleManifest, map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bundle is not a gzipped tarball: %w", err)
+	}
+	defer gz.Close() //nolint:errcheck
+
+	var manifestBytes, sig []byte
+	blobs := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading %s from bundle: %w", hdr.Name, err)
+		}
+		switch name := path.Clean(hdr.Name); {
+		case name == bundleManifestName:
+			manifestBytes = data
+		case name == bundleSigName:
+			sig, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid bundle signature: %w", err)
+			}
+		case path.Dir(name)+"/" == bundleDocumentDir:
+			blobs[path.Base(name)] = data
+		default:
+			return nil, nil, fmt.Errorf("unexpected file %s in bundle", hdr.Name)
+		}
+	}
+
+	if manifestBytes == nil || sig == nil {
+		return nil, nil, fmt.Errorf("bundle has no signed manifest")
+	}
+	if !ed25519.Verify(pub, manifestBytes, sig) {
+		return nil, nil, fmt.Errorf("bundle signature does not match the public key")
+	}
+
+	var manifest bundleManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if manifest.Version != bundleVersion {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+
+	listed := map[string]bool{}
+	for _, doc := range manifest.Documents {
+		blob, ok := blobs[doc.SHA256]
+		if !ok {
+			return nil, nil, fmt.Errorf("document %s is missing from the bundle", doc.Path)
+		}
+		if getHash(blob) != doc.SHA256 {
+			return nil, nil, fmt.Errorf("document %s does not match its hash", doc.Path)
+		}
+		listed[doc.SHA256] = true
+	}
+	for sum := range blobs {
+		if !listed[sum] {
+			return nil, nil, fmt.Errorf("document %s of the bundle is not in its manifest", sum)
+		}
+	}
+
+	return &manifest, blobs, nil
+}
+
+func newImportBundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import-bundle <bundle.tar.gz>",
+		Short: "Verify a bundle written by export-bundle and upload its documents",
+		Long: "Verify the signature and contents of a bundle written by export-bundle and upload its documents " +
+			"with the upload metadata recorded when it was exported. Nothing is uploaded unless the whole bundle verifies.",
+		Args:   cobra.ExactArgs(1),
+		PreRun: bindUploadFlags,
+		Run:    importBundle,
+	}
+
+	cmd.Flags().String("public-key", "", "PEM encoded Ed25519 public key the bundle must be signed with (required)")
+	addDocumentFlags(cmd.Flags())
+	addSBOMCheckFlags(cmd.Flags())
+	addWrapperVersionFlag(cmd.Flags())
+
+	mustBindPFlag(cmd, "public-key")
+
+	return cmd
+}
+
+func importBundle(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	pub, err := loadVerifyKey(viper.GetString("public-key"))
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid public key")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error opening bundle")
+	}
+	manifest, blobs, err := readBundle(f, pub)
+	f.Close() //nolint:errcheck
+	if err != nil {
+		fatalErr(err, exitValidation).
+			Msg("Bundle verification failed")
+	}
+
+	runID := resolveRunID()
+	results, messages, err := newOutput(viper.GetString("output"), runID)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid output format")
+	}
+
+	mustValidateDocRefTemplate()
+	mustResolveForceFlags()
+
+	ctx, defaultClient := mustNewHTTPClient(ctx)
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, defaultClient)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+
+	if !hasClientCredentials() || tenantEndPoint == "" || tokenEndPoint == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id, client-secret, tenant-endpoint (or org), token-endpoint")
+	}
+
+	creds, err := clientCredentials()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid client credentials")
+	}
+	authorizedClient := getAuthorizedClient(ctx, tokenEndPoint, creds)
+
+	caps := negotiateCapabilities(ctx, authorizedClient, tenantEndPoint)
+	if forceType := viper.GetString("force-type"); forceType != "" && !caps.supportsDocumentType(DocumentType(forceType)) {
+		log.Fatal().Msg("The tenant does not support " + forceType + " documents")
+	}
+	for _, doc := range manifest.Documents {
+		if err := caps.checkSize(doc.Path, doc.Size); err != nil {
+			fatalErr(err, exitValidation).
+				Msg("Document too large for the tenant")
+		}
+	}
+	mustNegotiateWrapperVersion(caps)
+
+	summary := newUploadSummary(time.Now())
+	results = results.withSummary(summary)
+	for _, doc := range manifest.Documents {
+		meta := make(map[string]string, len(doc.Metadata)+2)
+		for k, v := range doc.Metadata {
+			meta[k] = v
+		}
+		meta["run_id"] = runID
+		meta["bundle_id"] = manifest.ID
+
+		ssau, err := uploadFileBlob(authorizedClient, defaultClient, tenantEndPoint, doc.Path, blobs[doc.SHA256], false, meta)
+		if err != nil {
+			results.write(fileResult{Path: doc.Path, Status: resultFailed, Error: err.Error()})
+			reportUploadSummary(messages, summary, runID)
+			fatalErr(err, exitUpload).
+				Msg("Bundle upload failed")
+		}
+		results.write(fileResult{Path: doc.Path, Status: resultUploaded, DocumentRef: ssau.docRef})
+		summary.addSBOMs([]sbomSubjectAndURI{ssau})
+	}
+
+	fmt.Fprintf(messages, "Imported %d document(s) from bundle %s\n", len(manifest.Documents), manifest.ID)
+	fmt.Fprintf(messages, "Run ID: %s\n", runID)
+	reportUploadSummary(messages, summary, runID)
+	printTyposquatReport(messages, runTyposquats.summary())
+	printRetrySummary(messages, runRetries.summary())
+}
+
+// loadSigningKey reads a PEM encoded PKCS #8 Ed25519 private key, as written by
+// openssl genpkey -algorithm ed25519
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		return nil, fmt.Errorf("signing-key is required")
+	}
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// loadVerifyKey reads a PEM encoded PKIX Ed25519 public key, as written by
+// openssl pkey -pubout
+func loadVerifyKey(path string) (ed25519.PublicKey, error) {
+	if path == "" {
+		return nil, fmt.Errorf("public-key is required")
+	}
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// readPEM returns the bytes of the first PEM block of the given type in a file
+func readPEM(path, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no %s PEM block found in %s", blockType, path)
+		}
+		if block.Type == blockType {
+			return block.Bytes, nil
+		}
+	}
+}
@@ -0,0 +1,244 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_bundleRoundTrip(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("api/sbom.json", `{"name": "api"}`)
+	writeFile("web/sbom.json", `{"name": "web"}`)
+	writeFile("copy.json", `{"name": "api"}`)
+	writeFile("empty.json", "")
+
+	sources, err := collectBundleSources(dir, nil)
+	if err != nil {
+		t.Fatalf("collectBundleSources() error = %v", err)
+	}
+	if len(sources) != 3 {
+		t.Fatalf("collectBundleSources() = %v, want 3 non-empty files", sources)
+	}
+
+	var buf bytes.Buffer
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	written, err := writeBundle(&buf, key, "bundle-1", now, sources, func(src bundleSource, size int) map[string]string {
+		return map[string]string{"component_name": strings.Split(src.RelPath, "/")[0]}
+	})
+	if err != nil {
+		t.Fatalf("writeBundle() error = %v", err)
+	}
+
+	manifest, blobs, err := readBundle(bytes.NewReader(buf.Bytes()), pub)
+	if err != nil {
+		t.Fatalf("readBundle() error = %v", err)
+	}
+	if manifest.ID != "bundle-1" || !manifest.CreatedAt.Equal(now) || len(manifest.Documents) != len(written.Documents) {
+		t.Errorf("readBundle() manifest = %+v, want %+v", manifest, written)
+	}
+	// the copy has the same content as the api SBOM and is stored once
+	if len(blobs) != 2 {
+		t.Errorf("readBundle() returned %d documents, want 2 distinct ones", len(blobs))
+	}
+	for _, doc := range manifest.Documents {
+		if string(blobs[doc.SHA256]) == "" {
+			t.Errorf("document %s has no content", doc.Path)
+		}
+		if doc.Path == "web/sbom.json" && doc.Metadata["component_name"] != "web" {
+			t.Errorf("document %s metadata = %v, want the exported metadata", doc.Path, doc.Metadata)
+		}
+	}
+
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readBundle(bytes.NewReader(buf.Bytes()), otherPub); err == nil {
+		t.Error("readBundle() with another public key expected an error")
+	}
+}
+
+func Test_readBundle_tampered(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "sbom.json")
+	if err := os.WriteFile(path, []byte(`{"name": "api"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	_, err = writeBundle(&buf, key, "bundle-1", time.Now(), []bundleSource{{Path: path, RelPath: "sbom.json"}},
+		func(bundleSource, int) map[string]string { return nil })
+	if err != nil {
+		t.Fatalf("writeBundle() error = %v", err)
+	}
+
+	// rewrite the bundle, replacing or adding entries
+	rewrite := func(t *testing.T, edit func(name string, data []byte) []byte, extra map[string]string) []byte {
+		gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gz)
+		var out bytes.Buffer
+		gw := gzip.NewWriter(&out)
+		tw := tar.NewWriter(gw)
+		write := func(name string, data []byte) {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			write(hdr.Name, edit(hdr.Name, data))
+		}
+		for name, data := range extra {
+			write(name, []byte(data))
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+
+	tests := []struct {
+		name  string
+		edit  func(name string, data []byte) []byte
+		extra map[string]string
+	}{
+		{
+			name: "modified document",
+			edit: func(name string, data []byte) []byte {
+				if strings.HasPrefix(name, bundleDocumentDir) {
+					return []byte(`{"name": "evil"}`)
+				}
+				return data
+			},
+		},
+		{
+			name: "modified manifest",
+			edit: func(name string, data []byte) []byte {
+				if name == bundleManifestName {
+					return bytes.Replace(data, []byte("bundle-1"), []byte("bundle-2"), 1)
+				}
+				return data
+			},
+		},
+		{
+			name:  "unlisted document",
+			edit:  func(name string, data []byte) []byte { return data },
+			extra: map[string]string{bundleDocumentDir + "0000": "extra"},
+		},
+		{
+			name:  "unexpected file",
+			edit:  func(name string, data []byte) []byte { return data },
+			extra: map[string]string{"run.sh": "echo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := readBundle(bytes.NewReader(rewrite(t, tt.edit, tt.extra)), pub); err == nil {
+				t.Error("readBundle() expected an error")
+			}
+		})
+	}
+}
+
+func Test_loadBundleKeys(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writePEM := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := writePEM("key.pem", "PRIVATE KEY", keyDER)
+	pubPath := writePEM("pub.pem", "PUBLIC KEY", pubDER)
+
+	loadedKey, err := loadSigningKey(keyPath)
+	if err != nil || !loadedKey.Equal(key) {
+		t.Errorf("loadSigningKey() = %v, want the written key", err)
+	}
+	loadedPub, err := loadVerifyKey(pubPath)
+	if err != nil || !loadedPub.Equal(pub) {
+		t.Errorf("loadVerifyKey() = %v, want the written key", err)
+	}
+
+	if _, err := loadSigningKey(pubPath); err == nil {
+		t.Error("loadSigningKey() of a public key expected an error")
+	}
+	if _, err := loadVerifyKey(""); err == nil {
+		t.Error("loadVerifyKey() without a path expected an error")
+	}
+}
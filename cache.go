@@ -0,0 +1,172 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// errNotCached is returned for requests that are not cached when offline
+var errNotCached = errors.New("response is not cached and offline is set")
+
+// cachedResponse is a response of an external enrichment API stored in the
+// cache
+type cachedResponse struct {
+	URL        string    `json:"url"`
+	StatusCode int       `json:"status_code"`
+	Body       []byte    `json:"body"`
+	FetchedAt  time.Time `json:"fetched_at"`
+}
+
+// cachingClient is an HttpClient that caches the OK and Not Found responses
+// of GET requests in a state store for ttl, so repeated runs don't query external
+// enrichment APIs such as deps.dev again. When offline it only serves cached
+// responses, however old, and fails other requests with errNotCached.
+type cachingClient struct {
+	client  HttpClient
+	store   stateStore
+	ttl     time.Duration
+	offline bool
+	now     func() time.Time
+}
+
+func newCachingClient(client HttpClient, store stateStore, ttl time.Duration, offline bool) *cachingClient {
+	return &cachingClient{client: client, store: store, ttl: ttl, offline: offline, now: time.Now}
+}
+
+// newEnrichmentClient wraps client with the cache configured by --cache-dir,
+// --state-store, --cache-ttl and --offline. Caching is disabled with a ttl of 0 unless offline.
+func newEnrichmentClient(client HttpClient) (HttpClient, error) {
+	ttl := viper.GetDuration("cache-ttl")
+	offline := viper.GetBool("offline")
+	if ttl < 0 {
+		return nil, fmt.Errorf("cache-ttl must not be negative")
+	}
+	if ttl == 0 && !offline {
+		return client, nil
+	}
+
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	store, err := openStateStore(dir, "cache")
+	if err != nil {
+		return nil, err
+	}
+	return newCachingClient(client, store, ttl, offline), nil
+}
+
+// cacheDir returns the directory set with --cache-dir, or else kusari-uploader
+// in the user cache directory
+func cacheDir() (string, error) {
+	if dir := viper.GetString("cache-dir"); dir != "" {
+		return dir, nil
+	}
+	userDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("no cache-dir set and no user cache directory: %w", err)
+	}
+	return filepath.Join(userDir, "kusari-uploader"), nil
+}
+
+func (c *cachingClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return c.client.Do(req)
+	}
+
+	url := req.URL.String()
+	key := c.entryKey(url)
+	if entry, err := c.load(req.Context(), key); err == nil && entry.URL == url && (c.offline || c.now().Sub(entry.FetchedAt) < c.ttl) {
+		return entry.response(req), nil
+	}
+	if c.offline {
+		return nil, fmt.Errorf("%s: %w", url, errNotCached)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil || (res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound) {
+		return res, err
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close() //nolint:errcheck
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cachedResponse{URL: url, StatusCode: res.StatusCode, Body: body, FetchedAt: c.now().UTC()}
+	if err := c.save(req.Context(), key, entry); err != nil {
+		return nil, fmt.Errorf("error caching response: %w", err)
+	}
+	return entry.response(req), nil
+}
+
+func (c *cachingClient) Post(url string, contentType string, body io.Reader) (*http.Response, error) {
+	if c.offline {
+		return nil, fmt.Errorf("%s: %w", url, errNotCached)
+	}
+	return c.client.Post(url, contentType, body)
+}
+
+// entryKey returns the key a response for url is cached under
+func (c *cachingClient) entryKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+func (c *cachingClient) load(ctx context.Context, key string) (*cachedResponse, error) {
+	data, err := c.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var entry cachedResponse
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (c *cachingClient) save(ctx context.Context, key string, entry *cachedResponse) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.store.Put(ctx, key, data)
+}
+
+func (e *cachedResponse) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Header:        http.Header{},
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,131 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func Test_cachingClient(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	calls := 0
+	status := http.StatusOK
+	upstream := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(`{"n": 1}`))}, nil
+		},
+	}
+
+	get := func(c *cachingClient, url string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Do(req)
+	}
+	readBody := func(res *http.Response) string {
+		defer res.Body.Close() //nolint:errcheck
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	c := newCachingClient(upstream, &fileStore{dir: dir}, time.Hour, false)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		res, err := get(c, "https://api.example.com/a")
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		if body := readBody(res); res.StatusCode != http.StatusOK || body != `{"n": 1}` {
+			t.Errorf("Do() = %d %s, want the upstream response", res.StatusCode, body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("upstream called %d times, want 1 with a fresh cache entry", calls)
+	}
+
+	// expired entries are fetched again
+	c.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err := get(c, "https://api.example.com/a"); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("upstream called %d times, want 2 after the entry expired", calls)
+	}
+
+	// server errors are not cached
+	status = http.StatusInternalServerError
+	for i := 0; i < 2; i++ {
+		if res, err := get(c, "https://api.example.com/b"); err != nil || res.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("Do() = %v, %v, want the upstream error status", res, err)
+		}
+	}
+	if calls != 4 {
+		t.Errorf("upstream called %d times, want 4 as errors are not cached", calls)
+	}
+
+	// offline, cached entries are used however old and others fail
+	offline := newCachingClient(upstream, &fileStore{dir: dir}, time.Hour, true)
+	offline.now = func() time.Time { return now.Add(365 * 24 * time.Hour) }
+	if res, err := get(offline, "https://api.example.com/a"); err != nil || readBody(res) != `{"n": 1}` {
+		t.Errorf("offline Do() of a cached URL = %v, %v, want the cached response", res, err)
+	}
+	if _, err := get(offline, "https://api.example.com/b"); !errors.Is(err, errNotCached) {
+		t.Errorf("offline Do() of an uncached URL error = %v, want errNotCached", err)
+	}
+	if calls != 4 {
+		t.Errorf("upstream called %d times offline, want no calls", calls)
+	}
+}
+
+func Test_newEnrichmentClient(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	upstream := &ClientMock{}
+
+	viper.Set("cache-ttl", time.Duration(0))
+	if client, err := newEnrichmentClient(upstream); err != nil || client != upstream {
+		t.Errorf("newEnrichmentClient() with cache-ttl 0 = %v, %v, want the client itself", client, err)
+	}
+
+	viper.Set("offline", true)
+	viper.Set("cache-dir", t.TempDir())
+	if client, err := newEnrichmentClient(upstream); err != nil {
+		t.Errorf("newEnrichmentClient() offline error = %v", err)
+	} else if _, ok := client.(*cachingClient); !ok {
+		t.Errorf("newEnrichmentClient() offline = %T, want a caching client", client)
+	}
+
+	viper.Set("cache-ttl", -time.Hour)
+	if _, err := newEnrichmentClient(upstream); err == nil {
+		t.Error("newEnrichmentClient() expected an error for a negative cache-ttl")
+	}
+}
@@ -0,0 +1,194 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// tenantCapabilities describes what a tenant's platform release supports, so
+// one uploader version can adapt to tenants on different releases
+type tenantCapabilities struct {
+	// DocumentTypes are the document types the tenant ingests
+	DocumentTypes []DocumentType `json:"document_types"`
+	// MaxDocumentSize is the largest document in bytes the tenant accepts, or 0 if unlimited
+	MaxDocumentSize int64 `json:"max_document_size"`
+	// BatchPresign reports whether presigned URLs can be requested in batches
+	BatchPresign bool `json:"batch_presign"`
+	// Compression lists the encodings the tenant can decompress
+	Compression []EncodingType `json:"compression"`
+	// WrapperVersions lists the document wrapper schema versions the tenant can parse
+	WrapperVersions []int `json:"wrapper_versions"`
+}
+
+// wrapperVersions are the document wrapper schema versions this uploader can
+// write, oldest first. Version 1 is the wrapper with upload_metadata.
+var wrapperVersions = []int{1}
+
+// defaultCapabilities are assumed for tenants that predate the capabilities
+// endpoint, and match what the uploader did before it was introduced
+func defaultCapabilities() *tenantCapabilities {
+	return &tenantCapabilities{
+		DocumentTypes:   []DocumentType{DocumentSBOM, DocumentOpenVEX},
+		WrapperVersions: []int{1},
+	}
+}
+
+// negotiateCapabilities returns the capabilities of the tenant, falling back to
+// the defaults if they can't be retrieved
+func negotiateCapabilities(ctx context.Context, client HttpClient, tenantEndpoint string) *tenantCapabilities {
+	caps, err := getTenantCapabilities(ctx, client, tenantEndpoint)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Could not retrieve tenant capabilities, assuming the defaults")
+		return defaultCapabilities()
+	}
+
+	log.Debug().
+		Interface("documentTypes", caps.DocumentTypes).
+		Int64("maxDocumentSize", caps.MaxDocumentSize).
+		Bool("batchPresign", caps.BatchPresign).
+		Interface("compression", caps.Compression).
+		Ints("wrapperVersions", caps.WrapperVersions).
+		Msg("Tenant capabilities")
+	return caps
+}
+
+// getTenantCapabilities queries the capabilities endpoint of the tenant. Tenants
+// without the endpoint get the default capabilities.
+func getTenantCapabilities(ctx context.Context, client HttpClient, tenantEndpoint string) (*tenantCapabilities, error) {
+	res, err := makePicoReq(ctx, client, tenantEndpoint, "pico/v1/capabilities")
+	if err != nil {
+		return nil, fmt.Errorf("error making request for capabilities: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	if res.StatusCode == http.StatusNotFound {
+		return defaultCapabilities(), nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for capabilities: %d", res.StatusCode))
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body for capabilities: %w", err)
+	}
+
+	caps := defaultCapabilities()
+	if err := json.Unmarshal(body, caps); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response body for capabilities: %w", err)
+	}
+	if len(caps.DocumentTypes) == 0 {
+		caps.DocumentTypes = defaultCapabilities().DocumentTypes
+	}
+	if len(caps.WrapperVersions) == 0 {
+		caps.WrapperVersions = defaultCapabilities().WrapperVersions
+	}
+
+	return caps, nil
+}
+
+// supportsDocumentType reports whether the tenant ingests documents of type t
+func (c *tenantCapabilities) supportsDocumentType(t DocumentType) bool {
+	return slices.Contains(c.DocumentTypes, t)
+}
+
+// checkSize returns an error if a document of size bytes is too large for the
+// tenant. A nil tenantCapabilities accepts any size.
+func (c *tenantCapabilities) checkSize(path string, size int64) error {
+	if c == nil || c.MaxDocumentSize <= 0 || size <= c.MaxDocumentSize {
+		return nil
+	}
+	return fmt.Errorf("%s is %d bytes, larger than the %d bytes the tenant accepts", path, size, c.MaxDocumentSize)
+}
+
+// checkUploadSizes checks every file that will be uploaded, from a file list,
+// a directory or a single file, against the tenant's maximum document size
+// before anything is uploaded
+func checkUploadSizes(c *tenantCapabilities, filePath string, fileList []string) error {
+	if c.MaxDocumentSize <= 0 {
+		return nil
+	}
+
+	if fileList != nil {
+		for _, path := range fileList {
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("failed to get stats on filepath: %s, with error: %w", path, err)
+			}
+			if err := c.checkSize(path, info.Size()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return filepath.Walk(filePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return c.checkSize(path, info.Size())
+	})
+}
+
+// negotiateWrapperVersion returns the document wrapper schema version to write.
+// A requested version of 0 selects the newest version both the uploader and
+// the tenant support.
+func negotiateWrapperVersion(c *tenantCapabilities, requested int) (int, error) {
+	if requested != 0 {
+		if !slices.Contains(wrapperVersions, requested) {
+			return 0, fmt.Errorf("wrapper version %d is not supported by this uploader, supported versions: %v", requested, wrapperVersions)
+		}
+		if !slices.Contains(c.WrapperVersions, requested) {
+			return 0, fmt.Errorf("wrapper version %d is not supported by the tenant, supported versions: %v", requested, c.WrapperVersions)
+		}
+		return requested, nil
+	}
+
+	for i := len(wrapperVersions) - 1; i >= 0; i-- {
+		if slices.Contains(c.WrapperVersions, wrapperVersions[i]) {
+			return wrapperVersions[i], nil
+		}
+	}
+	return 0, fmt.Errorf("no common wrapper version, the uploader supports %v and the tenant supports %v", wrapperVersions, c.WrapperVersions)
+}
+
+// mustNegotiateWrapperVersion negotiates the document wrapper schema version
+// and records it as the wrapper-version setting used by uploads
+func mustNegotiateWrapperVersion(c *tenantCapabilities) {
+	version, err := negotiateWrapperVersion(c, viper.GetInt("wrapper-version"))
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to negotiate the document wrapper version")
+	}
+	viper.Set("wrapper-version", version)
+}
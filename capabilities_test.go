@@ -0,0 +1,139 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_getTenantCapabilities(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    *tenantCapabilities
+		wantErr bool
+	}{
+		{
+			name:   "capabilities",
+			status: http.StatusOK,
+			body:   `{"document_types": ["SBOM"], "max_document_size": 1024, "batch_presign": true, "compression": ["ZSTD"], "wrapper_versions": [1, 2]}`,
+			want: &tenantCapabilities{
+				DocumentTypes:   []DocumentType{DocumentSBOM},
+				MaxDocumentSize: 1024,
+				BatchPresign:    true,
+				Compression:     []EncodingType{EncodingZstd},
+				WrapperVersions: []int{1, 2},
+			},
+		},
+		{
+			name:   "missing document types",
+			status: http.StatusOK,
+			body:   `{"max_document_size": 1024}`,
+			want: &tenantCapabilities{
+				DocumentTypes:   []DocumentType{DocumentSBOM, DocumentOpenVEX},
+				MaxDocumentSize: 1024,
+				WrapperVersions: []int{1},
+			},
+		},
+		{
+			name:   "tenant without capabilities endpoint",
+			status: http.StatusNotFound,
+			want:   defaultCapabilities(),
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &ClientMock{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					if req.URL.Path != "/pico/v1/capabilities" {
+						t.Errorf("request path = %s", req.URL.Path)
+					}
+					return &http.Response{
+						StatusCode: tt.status,
+						Body:       io.NopCloser(bytes.NewBufferString(tt.body)),
+					}, nil
+				},
+			}
+			got, err := getTenantCapabilities(context.Background(), client, "http://example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getTenantCapabilities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getTenantCapabilities() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkUploadSizes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "small.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "large.json"), bytes.Repeat([]byte("a"), 100), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	caps := &tenantCapabilities{MaxDocumentSize: 10}
+	if err := checkUploadSizes(caps, dir, nil); err == nil {
+		t.Error("checkUploadSizes() expected an error for the large file in the directory")
+	}
+	if err := checkUploadSizes(caps, "", []string{filepath.Join(dir, "small.json")}); err != nil {
+		t.Errorf("checkUploadSizes() error = %v for a small file", err)
+	}
+	if err := checkUploadSizes(defaultCapabilities(), dir, nil); err != nil {
+		t.Errorf("checkUploadSizes() error = %v without a size limit", err)
+	}
+}
+
+func Test_negotiateWrapperVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		tenant    []int
+		requested int
+		want      int
+		wantErr   bool
+	}{
+		{name: "newest common version", tenant: []int{1, 2}, want: 1},
+		{name: "requested version", tenant: []int{1}, requested: 1, want: 1},
+		{name: "requested version unknown to the uploader", tenant: []int{1, 2}, requested: 2, wantErr: true},
+		{name: "tenant only supports newer versions", tenant: []int{2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := negotiateWrapperVersion(&tenantCapabilities{WrapperVersions: tt.tenant}, tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("negotiateWrapperVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("negotiateWrapperVersion() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
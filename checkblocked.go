@@ -0,0 +1,173 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func newCheckBlockedCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check-blocked",
+		Short: "Run the blocked package check for an SBOM by its platform IDs",
+		Long: "Run the blocked package check for an SBOM whose software and SBOM IDs are already known, " +
+			"without looking them up from the SBOM subject and URI. Without --sbom-id the latest SBOM of the software is checked.",
+		Args: cobra.NoArgs,
+		// blocked-output is also a flag of the upload command, so it is bound
+		// when this command runs
+		PreRun: func(cmd *cobra.Command, args []string) {
+			mustBindPFlag(cmd, "blocked-output")
+		},
+		Run: checkBlocked,
+	}
+
+	// software-id is also the upload metadata flag of the root command, so these
+	// flags are read with lookupSetting instead of being bound to viper
+	cmd.Flags().Int64("software-id", 0, "Kusari Platform Software ID (required)")
+	cmd.Flags().Int64("sbom-id", 0, "Kusari Platform SBOM ID (optional, defaults to the latest SBOM of the software)")
+	addBlockedOutputFlag(cmd.Flags())
+
+	return cmd
+}
+
+func checkBlocked(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	softwareID, err := lookupIDSetting(cmd.Flags(), "software-id")
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid software ID")
+	}
+	sbomID, err := lookupIDSetting(cmd.Flags(), "sbom-id")
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid SBOM ID")
+	}
+
+	ctx, defaultClient := mustNewHTTPClient(ctx)
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, defaultClient)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+
+	if softwareID == 0 || !hasClientCredentials() || tenantEndPoint == "" || tokenEndPoint == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id, client-secret, software-id, tenant-endpoint (or org), token-endpoint")
+	}
+
+	mustValidateBlockedSinks()
+
+	creds, err := clientCredentials()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid client credentials")
+	}
+	authorizedClient := getAuthorizedClient(ctx, tokenEndPoint, creds)
+
+	ids := &softwareIDAndSbomID{SoftwareID: softwareID, SbomID: sbomID}
+	if sbomID == 0 {
+		ids, err = lookupLatestSbomID(ctx, authorizedClient, tenantEndPoint, softwareID)
+		if err != nil {
+			fatalErr(err, exitUsage).
+				Msg("Error looking up the latest SBOM")
+		}
+	}
+
+	bps, err := getBlockedPackages(ctx, authorizedClient, tenantEndPoint, ids)
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Error checking for blocked packages")
+	}
+
+	blocked, err := reportBlockedPackages(ctx, os.Stdout, ids, bps)
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Error writing blocked package findings")
+	}
+	if blocked {
+		exitRun(exitBlocked)
+	}
+}
+
+// lookupIDSetting returns the platform ID set with the flag name or its
+// environment variables, or 0 if it is not set
+func lookupIDSetting(flags *pflag.FlagSet, name string) (int64, error) {
+	value, source := lookupSetting(flags, name)
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("%s from %s is not a valid ID: %q", name, source, value)
+	}
+	return id, nil
+}
+
+// lookupLatestSbomID returns the IDs of the latest SBOM of a software
+func lookupLatestSbomID(ctx context.Context, client HttpClient, tenantEndpoint string, softwareID int64) (*softwareIDAndSbomID, error) {
+	res, err := makePicoReq(ctx, client, tenantEndpoint, fmt.Sprintf("pico/v1/software/%d/sbom/latest", softwareID))
+	if err != nil {
+		return nil, fmt.Errorf("error making request for latest SBOM: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("software %d has no SBOM", softwareID)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for latest SBOM: %d", res.StatusCode))
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body for latest SBOM: %w", err)
+	}
+
+	var ids softwareIDAndSbomID
+	if err := json.Unmarshal(body, &ids); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response body for latest SBOM: %w", err)
+	}
+	ids.SoftwareID = softwareID
+
+	return &ids, nil
+}
+
+// reportBlockedPackages writes the result of the check to w and the
+// --blocked-output sinks, and reports whether the SBOM is blocked
+func reportBlockedPackages(ctx context.Context, w io.Writer, ids *softwareIDAndSbomID, bps *blockedPackages) (bool, error) {
+	var findings []blockedFinding
+	if bps.Blocked {
+		findings = append(findings, blockedFinding{SoftwareID: ids.SoftwareID, SbomID: ids.SbomID, BlockedPackages: bps.BlockedPackages})
+	} else {
+		fmt.Fprintf(w, "No blocked packages found for software ID %d, SBOM ID %d\n", ids.SoftwareID, ids.SbomID)
+	}
+	return bps.Blocked, writeBlockedFindings(ctx, w, findings)
+}
@@ -0,0 +1,85 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_checkBlockedByIDs(t *testing.T) {
+	client := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			var body string
+			switch req.URL.Path {
+			case "/pico/v1/software/7/sbom/latest":
+				body = `{"software_id": 7, "sbom_id": 42}`
+			case "/pico/v1/packages/blocked/check/software/7/sbom/42":
+				body = `{"blocked": true, "blocked_packages": ["pkg:npm/left-pad@1.0.0"]}`
+			default:
+				t.Errorf("unexpected request %s", req.URL.Path)
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		},
+	}
+
+	ids, err := lookupLatestSbomID(context.Background(), client, "http://example.com", 7)
+	if err != nil {
+		t.Fatalf("lookupLatestSbomID() error = %v", err)
+	}
+	if ids.SbomID != 42 {
+		t.Errorf("lookupLatestSbomID() = %+v, want SBOM ID 42", ids)
+	}
+
+	bps, err := getBlockedPackages(context.Background(), client, "http://example.com", ids)
+	if err != nil {
+		t.Fatalf("getBlockedPackages() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	blocked, err := reportBlockedPackages(context.Background(), &buf, ids, bps)
+	if err != nil || !blocked {
+		t.Errorf("reportBlockedPackages() = %v, %v, want blocked", blocked, err)
+	}
+	if !strings.Contains(buf.String(), "pkg:npm/left-pad@1.0.0") {
+		t.Errorf("reportBlockedPackages() = %q, want the blocked package", buf.String())
+	}
+}
+
+func Test_lookupIDSetting(t *testing.T) {
+	cmd := newCheckBlockedCmd()
+	if err := cmd.Flags().Parse([]string{"--sbom-id", "12"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("UPLOADER_SOFTWARE_ID", "3")
+
+	if got, err := lookupIDSetting(cmd.Flags(), "software-id"); err != nil || got != 3 {
+		t.Errorf("lookupIDSetting(software-id) = %d, %v, want 3 from the environment", got, err)
+	}
+	if got, err := lookupIDSetting(cmd.Flags(), "sbom-id"); err != nil || got != 12 {
+		t.Errorf("lookupIDSetting(sbom-id) = %d, %v, want 12", got, err)
+	}
+
+	t.Setenv("UPLOADER_SOFTWARE_ID", "abc")
+	if _, err := lookupIDSetting(cmd.Flags(), "software-id"); err == nil {
+		t.Error("lookupIDSetting() expected an error for a non-numeric ID")
+	}
+}
@@ -0,0 +1,107 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// collectSBOMSubjects extracts the subjects and URIs of the SBOMs in the file
+// list, or else under filePath, so they can be checked without being uploaded.
+// Files that are not SBOMs are skipped.
+func collectSBOMSubjects(filePath string, fileList []string) ([]sbomSubjectAndURI, error) {
+	var ssaus []sbomSubjectAndURI
+
+	addFile := func(path string) error {
+		blob, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s, err: %w", path, err)
+		}
+		if err := checkTyposquats(path, blob); err != nil {
+			return withExitCode(exitValidation, err)
+		}
+		if err := recordComponents(blob); err != nil {
+			return err
+		}
+		ssau := localSBOMSubject(blob)
+		runAudit.recordSBOM(path, "", ssau, blob)
+		if ssau.subject != "" {
+			ssaus = append(ssaus, ssau)
+		}
+		return nil
+	}
+
+	if fileList != nil {
+		for _, path := range fileList {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get stats on filepath: %s, with error: %w", path, err)
+			}
+			if info.IsDir() {
+				continue
+			}
+			if err := addFile(path); err != nil {
+				return nil, err
+			}
+		}
+		return ssaus, nil
+	}
+
+	err := filepath.Walk(filePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Size() == 0 {
+			return nil
+		}
+		return addFile(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ssaus, nil
+}
+
+func newCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Run the blocked package check for SBOMs that were already uploaded",
+		Long: "Run the blocked package check for SBOMs that were already uploaded, using the subjects and URIs " +
+			"in the local files, without uploading anything. This is the same as the upload command with --check-only.",
+		Args: cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			bindUploadFlags(cmd, args)
+			viper.Set("check-only", true)
+		},
+		Run: uploadFiles,
+	}
+
+	cmd.Flags().StringP("file-path", "f", "", "Path to the SBOM or directory of SBOMs to check (required unless files-from is set)")
+	addFilesFromFlags(cmd.Flags())
+	addBlockedOutputFlag(cmd.Flags())
+	addSBOMCheckFlags(cmd.Flags())
+	addMaintenanceFlags(cmd.Flags())
+	addConcurrencyFlags(cmd.Flags(), "check-concurrency")
+	addReportFlags(cmd.Flags())
+
+	return cmd
+}
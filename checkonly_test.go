@@ -0,0 +1,58 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_collectSBOMSubjects(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.cdx.json":  `{"bomFormat": "CycloneDX", "serialNumber": "urn:uuid:1", "metadata": {"component": {"name": "app"}}}`,
+		"lib.spdx.json": `{"SPDXID": "SPDXRef-DOCUMENT", "name": "lib", "documentNamespace": "https://example.com/lib"}`,
+		"vex.json":      `{"@context": "https://openvex.dev/ns/v0.2.0", "statements": []}`,
+		"empty":         "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := collectSBOMSubjects(dir, nil)
+	if err != nil {
+		t.Fatalf("collectSBOMSubjects() error = %v", err)
+	}
+	want := []sbomSubjectAndURI{
+		{subject: "app", uri: "urn:uuid:1"},
+		{subject: "lib", uri: "https://example.com/lib#DOCUMENT"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectSBOMSubjects() = %+v, want %+v", got, want)
+	}
+
+	got, err = collectSBOMSubjects("", []string{filepath.Join(dir, "lib.spdx.json"), dir})
+	if err != nil {
+		t.Fatalf("collectSBOMSubjects() with file list error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("collectSBOMSubjects() with file list = %+v, want %+v", got, want[1:])
+	}
+}
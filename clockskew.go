@@ -0,0 +1,86 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// clockSkewErrorCodes are the S3 error codes returned when a presigned request
+// is rejected because of its timestamp
+var clockSkewErrorCodes = [][]byte{
+	[]byte("RequestTimeTooSkewed"),
+	[]byte("Request has expired"),
+}
+
+// clockSkewError is returned when an upload to a presigned URL is rejected
+// because the request time is outside of the window accepted by the server
+type clockSkewError struct {
+	StatusCode int
+	// Skew is how far the local clock is ahead of the server's clock, or zero
+	// when the server did not send a Date header
+	Skew time.Duration
+}
+
+func (e *clockSkewError) Error() string {
+	return fmt.Sprintf("upload rejected because of the request time (status code %d): %s", e.StatusCode, e.diagnosis())
+}
+
+// diagnosis explains the failure in terms of the local clock
+func (e *clockSkewError) diagnosis() string {
+	if e.Skew == 0 {
+		return "the presigned URL expired or the local clock differs from the server's, check that this machine's clock is synchronized"
+	}
+	direction := "ahead of"
+	skew := e.Skew
+	if skew < 0 {
+		direction = "behind"
+		skew = -skew
+	}
+	return fmt.Sprintf("the local clock is %s %s the server's, synchronize this machine's clock (e.g. with NTP)",
+		skew.Round(time.Second), direction)
+}
+
+// detectClockSkew returns a clockSkewError if the failed response to an upload
+// was caused by the request time, comparing now with the response Date header
+func detectClockSkew(resp *http.Response, body []byte, now time.Time) *clockSkewError {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusBadRequest {
+		return nil
+	}
+
+	skewed := false
+	for _, code := range clockSkewErrorCodes {
+		if bytes.Contains(body, code) {
+			skewed = true
+			break
+		}
+	}
+	if !skewed {
+		return nil
+	}
+
+	skewErr := &clockSkewError{StatusCode: resp.StatusCode}
+	if serverTime, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		// the Date header only has a resolution of one second
+		if skew := now.Sub(serverTime); skew <= -time.Second || skew >= time.Second {
+			skewErr.Skew = skew
+		}
+	}
+	return skewErr
+}
@@ -0,0 +1,131 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const skewedBody = `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>RequestTimeTooSkewed</Code><Message>The difference between the request time and the current time is too large.</Message></Error>`
+
+func Test_detectClockSkew(t *testing.T) {
+	serverTime := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		status   int
+		date     string
+		body     string
+		wantSkew time.Duration
+		wantNil  bool
+	}{
+		{
+			name:     "local clock ahead",
+			status:   http.StatusForbidden,
+			date:     serverTime.Format(http.TimeFormat),
+			body:     skewedBody,
+			wantSkew: 20 * time.Minute,
+		},
+		{
+			name:   "no date header",
+			status: http.StatusForbidden,
+			body:   skewedBody,
+		},
+		{
+			name:    "other access denied",
+			status:  http.StatusForbidden,
+			body:    "<Error><Code>AccessDenied</Code></Error>",
+			wantNil: true,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			body:    skewedBody,
+			wantNil: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.date != "" {
+				resp.Header.Set("Date", tt.date)
+			}
+			got := detectClockSkew(resp, []byte(tt.body), serverTime.Add(20*time.Minute))
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("detectClockSkew() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("detectClockSkew() = nil, want a clock skew error")
+			}
+			if got.Skew != tt.wantSkew {
+				t.Errorf("detectClockSkew() skew = %v, want %v", got.Skew, tt.wantSkew)
+			}
+		})
+	}
+}
+
+func Test_uploadFileBlobRetriesClockSkew(t *testing.T) {
+	presigns := 0
+	authClientMock := &ClientMock{
+		PostFunc: func(url, contentType string, body io.Reader) (resp *http.Response, err error) {
+			presigns++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"presignedUrl": "http://example.com/upload"}`)),
+			}, nil
+		},
+	}
+	puts := 0
+	defaultClientMock := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			puts++
+			if puts == 1 {
+				return &http.Response{
+					StatusCode: http.StatusForbidden,
+					Header:     http.Header{"Date": []string{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}},
+					Body:       io.NopCloser(bytes.NewBufferString(skewedBody)),
+				}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString("")),
+			}, nil
+		},
+	}
+
+	if _, err := uploadFileBlob(authClientMock, defaultClientMock, "http://example.com", "doc.json", []byte("{}"), false, nil); err != nil {
+		t.Fatalf("uploadFileBlob() error = %v", err)
+	}
+	if presigns != 2 || puts != 2 {
+		t.Errorf("uploadFileBlob() made %d presign requests and %d uploads, want 2 each", presigns, puts)
+	}
+}
+
+func Test_clockSkewErrorDiagnosis(t *testing.T) {
+	err := &clockSkewError{StatusCode: http.StatusForbidden, Skew: -90 * time.Second}
+	if !strings.Contains(err.Error(), "1m30s behind") {
+		t.Errorf("clockSkewError.Error() = %q, want the skew and direction", err.Error())
+	}
+}
@@ -0,0 +1,130 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// ANSI colors of status cells. They all have the same length, so that columns
+// of a tabwriter table stay aligned when every cell of a column is painted.
+const (
+	ansiBold   = "\x1b[01m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// statusColors are the colors of the result and check statuses
+var statusColors = map[string]string{
+	resultUploaded:    ansiGreen,
+	resultSkipped:     ansiYellow,
+	resultFailed:      ansiRed,
+	resultQuarantined: ansiRed,
+	doctorPass:        ansiGreen,
+	doctorSkip:        ansiYellow,
+	doctorFail:        ansiRed,
+	reportIngested:    ansiGreen,
+	reportMissing:     ansiRed,
+	reportPassed:      ansiGreen,
+	reportBlocked:     ansiRed,
+	reportNotIngested: ansiYellow,
+}
+
+// colorEnabled reports whether output to w is colored: only terminals are,
+// unless --no-color or the NO_COLOR environment variable is set
+func colorEnabled(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && isTerminal(f) && useColor(viper.GetBool("no-color"), os.Getenv("NO_COLOR"))
+}
+
+// useColor reports whether color is allowed by --no-color and NO_COLOR, which
+// disables color when set to any non-empty value, see https://no-color.org
+func useColor(noColor bool, noColorEnv string) bool {
+	return !noColor && noColorEnv == ""
+}
+
+// paintStatus paints a status in its color, or in bold for the header of a
+// status column, so that the cells of the column have the same length
+func paintStatus(status string, color bool) string {
+	if !color {
+		return status
+	}
+	code, ok := statusColors[status]
+	if !ok {
+		code = ansiBold
+	}
+	return code + status + ansiReset
+}
+
+// statusLine keeps a line of progress at the bottom of a terminal. Logs are
+// written through it, so that they are written above the line instead of
+// into it.
+type statusLine struct {
+	mu   sync.Mutex
+	w    io.Writer
+	line string
+}
+
+// progress is the status line on stderr, or nil when stderr is no terminal
+var progress *statusLine
+
+// Write clears the status line, writes p and draws the line again below it
+func (s *statusLine) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.line != "" {
+		io.WriteString(s.w, "\r\x1b[K") //nolint:errcheck
+	}
+	n, err := s.w.Write(p)
+	if s.line != "" {
+		io.WriteString(s.w, s.line) //nolint:errcheck
+	}
+	return n, err
+}
+
+// set replaces the status line, nothing happens for a nil line
+func (s *statusLine) set(line string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.line = line
+	io.WriteString(s.w, "\r\x1b[K"+line) //nolint:errcheck
+}
+
+// clear removes the status line
+func (s *statusLine) clear() {
+	s.set("")
+}
+
+// progressText describes the files processed so far
+func progressText(counts map[string]int) string {
+	text := fmt.Sprintf("Processed %d file(s): %d uploaded, %d skipped, %d failed",
+		counts[resultUploaded]+counts[resultSkipped]+counts[resultFailed]+counts[resultQuarantined],
+		counts[resultUploaded], counts[resultSkipped], counts[resultFailed])
+	if counts[resultQuarantined] > 0 {
+		text += fmt.Sprintf(", %d quarantined", counts[resultQuarantined])
+	}
+	return text
+}
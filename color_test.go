@@ -0,0 +1,91 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/tabwriter"
+)
+
+func Test_useColor(t *testing.T) {
+	tests := []struct {
+		name       string
+		noColor    bool
+		noColorEnv string
+		want       bool
+	}{
+		{name: "default", want: true},
+		{name: "no-color flag", noColor: true},
+		{name: "NO_COLOR", noColorEnv: "1"},
+		{name: "empty NO_COLOR", noColorEnv: "", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := useColor(tt.noColor, tt.noColorEnv); got != tt.want {
+				t.Errorf("useColor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_paintStatus(t *testing.T) {
+	if got := paintStatus(resultFailed, false); got != resultFailed {
+		t.Errorf("paintStatus() without color = %q", got)
+	}
+	if got := paintStatus(resultFailed, true); got != ansiRed+resultFailed+ansiReset {
+		t.Errorf("paintStatus() = %q", got)
+	}
+
+	// painted columns stay aligned
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	for _, status := range []string{"STATUS", doctorPass, resultUploaded} {
+		tw.Write([]byte(paintStatus(status, true) + "\tx\n")) //nolint:errcheck
+	}
+	tw.Flush() //nolint:errcheck
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for _, line := range lines {
+		if len(line) != len(lines[0]) {
+			t.Errorf("painted table is not aligned:\n%s", buf.String())
+			break
+		}
+	}
+}
+
+func Test_statusLine(t *testing.T) {
+	var buf bytes.Buffer
+	s := &statusLine{w: &buf}
+
+	s.Write([]byte("first log\n")) //nolint:errcheck
+	s.set("Processed 1 file(s)")
+	s.Write([]byte("second log\n")) //nolint:errcheck
+	s.clear()
+
+	want := "first log\n" +
+		"\r\x1b[KProcessed 1 file(s)" +
+		"\r\x1b[Ksecond log\nProcessed 1 file(s)" +
+		"\r\x1b[K"
+	if got := buf.String(); got != want {
+		t.Errorf("statusLine wrote %q, want %q", got, want)
+	}
+
+	// a nil status line does nothing
+	var none *statusLine
+	none.set("ignored")
+	none.clear()
+}
@@ -0,0 +1,124 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/spf13/viper"
+)
+
+// componentNames returns the component names the documents of the run are
+// uploaded with: that of --component-name and of the routing rules, sorted
+func componentNames(uploadMeta map[string]string, rules []routingRule) []string {
+	var names []string
+	if name := uploadMeta["component_name"]; name != "" {
+		names = append(names, name)
+	}
+	for _, rule := range rules {
+		if rule.ComponentName != "" {
+			names = append(names, rule.ComponentName)
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// componentExists reports whether the tenant has a component named name
+func componentExists(ctx context.Context, client HttpClient, tenantEndpoint, name string) (bool, error) {
+	res, err := makePicoReq(ctx, client, tenantEndpoint, "pico/v1/components/name/"+url.PathEscape(name))
+	if err != nil {
+		return false, fmt.Errorf("error making request for component: %w", err)
+	}
+	defer res.Body.Close()        //nolint:errcheck
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for component: %d", res.StatusCode))
+	}
+}
+
+// registerComponent creates the component named name. A component created in
+// the meantime, by a concurrent run, is not an error.
+func registerComponent(ctx context.Context, client HttpClient, tenantEndpoint, name string) error {
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tenantEndpoint+"/pico/v1/components", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request to register component: %w", err)
+	}
+	defer res.Body.Close()        //nolint:errcheck
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusConflict:
+		return nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return errors.New("the tenant does not support registering components")
+	default:
+		return tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for registering component: %d", res.StatusCode))
+	}
+}
+
+// mustRegisterComponents creates the components of the run that don't exist
+// yet, if --auto-register-component is set, so first-time uploads don't need
+// the component to be created in the UI beforehand
+func mustRegisterComponents(ctx context.Context, messages io.Writer, client HttpClient, tenantEndpoint string, names []string) {
+	if !viper.GetBool("auto-register-component") {
+		return
+	}
+	if len(names) == 0 {
+		fatal(exitUsage).Msg("auto-register-component requires component-name, or routing rules with a component-name")
+	}
+
+	for _, name := range names {
+		exists, err := componentExists(ctx, client, tenantEndpoint, name)
+		if err != nil {
+			fatalErr(err, exitUpload).
+				Str("component", name).
+				Msg("Failed to look up the component")
+		}
+		if exists {
+			continue
+		}
+		if err := registerComponent(ctx, client, tenantEndpoint, name); err != nil {
+			fatalErr(err, exitUpload).
+				Str("component", name).
+				Msg("Failed to register the component")
+		}
+		fmt.Fprintf(messages, "Component %s registered\n", name)
+	}
+}
@@ -0,0 +1,146 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_componentNames(t *testing.T) {
+	rules := []routingRule{
+		{Pattern: "web/", ComponentName: "web"},
+		{Pattern: "api/", ComponentName: "api"},
+		{Pattern: "docs/", Tag: "docs"},
+		{Pattern: "web2/", ComponentName: "web"},
+	}
+	got := componentNames(map[string]string{"component_name": "cli"}, rules)
+	if want := []string{"api", "cli", "web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("componentNames() = %v, want %v", got, want)
+	}
+	if got := componentNames(map[string]string{}, nil); len(got) != 0 {
+		t.Errorf("componentNames() = %v, want none", got)
+	}
+}
+
+func Test_mustRegisterComponents(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("auto-register-component", true)
+
+	var registered []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/pico/v1/components/name/existing":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && r.URL.Path == "/pico/v1/components":
+			var body struct {
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			registered = append(registered, body.Name)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer srv.Close()
+
+	var messages bytes.Buffer
+	mustRegisterComponents(context.Background(), &messages, srv.Client(), srv.URL, []string{"existing", "my app"})
+	if want := []string{"my app"}; !reflect.DeepEqual(registered, want) {
+		t.Errorf("registered %v, want %v", registered, want)
+	}
+	if got, want := messages.String(), "Component my app registered\n"; got != want {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+
+	viper.Set("auto-register-component", false)
+	registered = nil
+	mustRegisterComponents(context.Background(), &messages, srv.Client(), srv.URL, []string{"new"})
+	if len(registered) != 0 {
+		t.Errorf("registered %v without auto-register-component", registered)
+	}
+}
+
+func Test_registerComponent(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr string
+	}{
+		{name: "created", status: http.StatusCreated},
+		{name: "ok", status: http.StatusOK},
+		{name: "created concurrently", status: http.StatusConflict},
+		{name: "unsupported", status: http.StatusNotFound, wantErr: "the tenant does not support registering components"},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: "unexpected response status code for registering component: 500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := registerComponent(context.Background(), srv.Client(), srv.URL, "web")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("registerComponent() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("registerComponent() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_componentExists(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/pico/v1/components/name/a%2Fb":
+			w.WriteHeader(http.StatusOK)
+		case "/pico/v1/components/name/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	if exists, err := componentExists(context.Background(), srv.Client(), srv.URL, "a/b"); err != nil || !exists {
+		t.Errorf("componentExists(a/b) = %v, %v, want true", exists, err)
+	}
+	if exists, err := componentExists(context.Background(), srv.Client(), srv.URL, "missing"); err != nil || exists {
+		t.Errorf("componentExists(missing) = %v, %v, want false", exists, err)
+	}
+	if _, err := componentExists(context.Background(), srv.Client(), srv.URL, "broken"); err == nil {
+		t.Error("componentExists(broken) succeeded, want an error")
+	}
+}
@@ -0,0 +1,134 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/semaphore"
+)
+
+// concurrencyAuto selects a concurrency limit based on the machine
+const concurrencyAuto = "auto"
+
+// phaseLimits are the concurrency limits of the phases of a run. Separate
+// limits keep a high upload parallelism from hammering the auth server with
+// presign requests, and vice versa.
+type phaseLimits struct {
+	// Hash limits reading and hashing local files
+	Hash int
+	// Presign limits presigned URL requests to the tenant
+	Presign int
+	// Upload limits uploads to presigned URLs
+	Upload int
+	// Check limits lookups and checks against the tenant
+	Check int
+}
+
+// autoPhaseLimits returns the limits used in auto mode. Hashing is bound by the
+// CPU and uploads mostly wait on the network, while presign requests and checks
+// hit the tenant and keep conservative limits.
+func autoPhaseLimits() phaseLimits {
+	cpus := runtime.NumCPU()
+	return phaseLimits{
+		Hash:    cpus,
+		Presign: 4,
+		Upload:  min(4*cpus, 32),
+		Check:   5,
+	}
+}
+
+// concurrencyFlagUsages are the usages of the per phase concurrency flags,
+// stating the limits of auto mode
+var concurrencyFlagUsages = map[string]string{
+	"hash-concurrency":    "Number of files read and hashed in parallel, or auto for the number of CPUs",
+	"presign-concurrency": "Number of presigned URL requests in flight, or auto for 4",
+	"upload-concurrency":  "Number of uploads to presigned URLs in flight, or auto for 4 per CPU and at most 32; auto does not measure the bandwidth, see adaptive-concurrency",
+	"check-concurrency":   "Number of lookups and checks against the tenant in flight, or auto for 5",
+}
+
+// addConcurrencyFlags defines the concurrency flags of the phases a command runs
+func addConcurrencyFlags(flags *pflag.FlagSet, names ...string) {
+	for _, name := range names {
+		flags.String(name, concurrencyAuto, concurrencyFlagUsages[name])
+	}
+}
+
+// resolvePhaseLimits reads the per phase concurrency settings, each of which is
+// a positive number or "auto". Phases whose flag the command doesn't define use
+// the auto limit.
+func resolvePhaseLimits() (phaseLimits, error) {
+	limits := autoPhaseLimits()
+	for _, phase := range []struct {
+		name  string
+		limit *int
+	}{
+		{"hash-concurrency", &limits.Hash},
+		{"presign-concurrency", &limits.Presign},
+		{"upload-concurrency", &limits.Upload},
+		{"check-concurrency", &limits.Check},
+	} {
+		value := viper.GetString(phase.name)
+		if value == "" || value == concurrencyAuto {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return phaseLimits{}, fmt.Errorf("%s must be a positive number or %s, got %q", phase.name, concurrencyAuto, value)
+		}
+		*phase.limit = n
+	}
+	return limits, nil
+}
+
+// workers returns the number of goroutines needed to keep every upload phase busy
+func (l phaseLimits) workers() int {
+	return max(l.Hash, l.Presign, l.Upload)
+}
+
+// limitedClient is an HttpClient that allows at most a fixed number of
+// requests in flight
+type limitedClient struct {
+	client HttpClient
+	sem    *semaphore.Weighted
+}
+
+func newLimitedClient(client HttpClient, limit int) *limitedClient {
+	return &limitedClient{client: client, sem: semaphore.NewWeighted(int64(limit))}
+}
+
+func (c *limitedClient) Do(req *http.Request) (*http.Response, error) {
+	if err := c.sem.Acquire(req.Context(), 1); err != nil {
+		return nil, err
+	}
+	defer c.sem.Release(1)
+	return c.client.Do(req)
+}
+
+func (c *limitedClient) Post(url string, contentType string, body io.Reader) (*http.Response, error) {
+	if err := c.sem.Acquire(context.Background(), 1); err != nil {
+		return nil, err
+	}
+	defer c.sem.Release(1)
+	return c.client.Post(url, contentType, body)
+}
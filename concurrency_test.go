@@ -0,0 +1,84 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func Test_resolvePhaseLimits(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("presign-concurrency", "2")
+	viper.Set("upload-concurrency", concurrencyAuto)
+	limits, err := resolvePhaseLimits()
+	if err != nil {
+		t.Fatalf("resolvePhaseLimits() error = %v", err)
+	}
+	auto := autoPhaseLimits()
+	if limits.Presign != 2 || limits.Upload != auto.Upload || limits.Hash != auto.Hash || limits.Check != auto.Check {
+		t.Errorf("resolvePhaseLimits() = %+v, want presign 2 and auto for the rest (%+v)", limits, auto)
+	}
+
+	viper.Set("check-concurrency", "0")
+	if _, err := resolvePhaseLimits(); err == nil {
+		t.Error("resolvePhaseLimits() expected an error for a limit of 0")
+	}
+}
+
+func Test_limitedClient(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	client := newLimitedClient(&ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			n := inFlight.Add(1)
+			for {
+				m := maxInFlight.Load()
+				if n <= m || maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			inFlight.Add(-1)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		},
+	}, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodPut, "http://example.com", nil)
+			if _, err := client.Do(req); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := maxInFlight.Load(); got > 2 {
+		t.Errorf("limitedClient allowed %d requests in flight, want at most 2", got)
+	}
+}
@@ -0,0 +1,169 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// runConstraints restrict where the uploader may send documents and what they
+// must carry. A platform team can bake them into a config file shipped to all
+// repositories, for example:
+//
+//	constraints:
+//	  allowed-tenant-endpoints:
+//	    - https://acme.api.us.kusari.cloud
+//	  allowed-token-endpoints:
+//	    - https://auth.us.kusari.cloud/oauth2/token
+//	  allowed-endpoints:
+//	    - https://auth.us.kusari.cloud/oauth2/
+//	    - https://hooks.acme.example.com
+//	  required-metadata:
+//	    - tag
+//	    - software_id
+//
+// Hosts may start with "*." to allow any subdomain. Empty lists allow anything.
+type runConstraints struct {
+	AllowedTenantEndpoints []string `mapstructure:"allowed-tenant-endpoints"`
+	AllowedTokenEndpoints  []string `mapstructure:"allowed-token-endpoints"`
+	// AllowedEndpoints are the other endpoints credentials or tenant data may
+	// be sent to: the device authorization, authorize and AWS exchange
+	// endpoints, the telemetry and deps.dev endpoints and blocked-output webhooks
+	AllowedEndpoints []string `mapstructure:"allowed-endpoints"`
+	// RequiredMetadata are the upload metadata keys every document must have,
+	// including those of --require-meta
+	RequiredMetadata []string `mapstructure:"required-metadata"`
+}
+
+// loadConstraints reads the constraints from the loaded configuration
+func loadConstraints() (*runConstraints, error) {
+	var c runConstraints
+	if err := viper.UnmarshalKey("constraints", &c); err != nil {
+		return nil, fmt.Errorf("failed to parse constraints: %w", err)
+	}
+	for _, allowed := range slices.Concat(c.AllowedTenantEndpoints, c.AllowedTokenEndpoints, c.AllowedEndpoints) {
+		if _, err := parseAllowedEndpoint(allowed); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range viper.GetStringSlice("require-meta") {
+		key := metadataKey(strings.TrimSpace(name))
+		if key != "" && !slices.Contains(c.RequiredMetadata, key) {
+			c.RequiredMetadata = append(c.RequiredMetadata, key)
+		}
+	}
+	return &c, nil
+}
+
+// checkEndpoints returns an error if the tenant or token endpoint is not allowed
+func (c *runConstraints) checkEndpoints(tenantEndpoint, tokenEndpoint string) error {
+	if err := checkAllowedEndpoint("tenant endpoint", tenantEndpoint, c.AllowedTenantEndpoints); err != nil {
+		return err
+	}
+	return checkAllowedEndpoint("token endpoint", tokenEndpoint, c.AllowedTokenEndpoints)
+}
+
+// checkEndpoint returns an error if the endpoint named name, other than the
+// tenant or token endpoint, is not allowed
+func (c *runConstraints) checkEndpoint(name, endpoint string) error {
+	return checkAllowedEndpoint(name, endpoint, c.AllowedEndpoints)
+}
+
+// checkOutboundEndpoint returns an error if the constraints of the loaded
+// configuration don't allow the endpoint named name, other than the tenant or
+// token endpoint
+func checkOutboundEndpoint(name, endpoint string) error {
+	c, err := loadConstraints()
+	if err != nil {
+		return err
+	}
+	return c.checkEndpoint(name, endpoint)
+}
+
+// checkMetadata returns an error if the upload metadata of path lacks a required key
+func (c *runConstraints) checkMetadata(filePath string, meta map[string]string) error {
+	var missing []string
+	for _, key := range c.RequiredMetadata {
+		if meta[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is missing required upload metadata: %s", filePath, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkAllowedEndpoint returns an error unless endpoint matches one of allowed.
+// An empty allowed list allows any endpoint.
+func checkAllowedEndpoint(name, endpoint string, allowed []string) error {
+	if len(allowed) == 0 || endpoint == "" {
+		return nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, endpoint, err)
+	}
+	for _, a := range allowed {
+		pattern, err := parseAllowedEndpoint(a)
+		if err != nil {
+			return err
+		}
+		if endpointMatches(pattern, u) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s is not allowed by the constraints, allowed: %s", name, u.Redacted(), strings.Join(allowed, ", "))
+}
+
+// parseAllowedEndpoint parses an allowed endpoint, which must be a URL with a scheme and host
+func parseAllowedEndpoint(allowed string) (*url.URL, error) {
+	u, err := url.Parse(allowed)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid allowed endpoint %q in constraints, it must be a URL such as https://acme.api.us.kusari.cloud", allowed)
+	}
+	return u, nil
+}
+
+// endpointMatches reports whether u has the scheme, host and port of pattern
+// and a path at or below the pattern's path
+func endpointMatches(pattern, u *url.URL) bool {
+	if !strings.EqualFold(pattern.Scheme, u.Scheme) || pattern.Port() != u.Port() {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	patternHost := strings.ToLower(pattern.Hostname())
+	if domain, ok := strings.CutPrefix(patternHost, "*"); ok {
+		// the wildcard stands for exactly one label
+		label, found := strings.CutSuffix(host, domain)
+		if !found || label == "" || strings.Contains(label, ".") {
+			return false
+		}
+	} else if host != patternHost {
+		return false
+	}
+
+	prefix := strings.TrimSuffix(pattern.Path, "/")
+	p := strings.TrimSuffix(u.Path, "/")
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
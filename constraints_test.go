@@ -0,0 +1,156 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_checkAllowedEndpoint(t *testing.T) {
+	allowed := []string{"https://acme.api.us.kusari.cloud", "https://*.kusari.dev/v1/"}
+
+	tests := []struct {
+		endpoint string
+		wantErr  bool
+	}{
+		{endpoint: "https://acme.api.us.kusari.cloud"},
+		{endpoint: "https://ACME.api.us.kusari.cloud/pico/v1"},
+		{endpoint: "https://tenant.kusari.dev/v1/presign"},
+		{endpoint: "http://acme.api.us.kusari.cloud", wantErr: true},
+		{endpoint: "https://acme.api.us.kusari.cloud:8443", wantErr: true},
+		{endpoint: "https://acme.api.us.kusari.cloud.evil.example", wantErr: true},
+		{endpoint: "https://a.b.kusari.dev/v1", wantErr: true},
+		{endpoint: "https://tenant.kusari.dev/v10", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			if err := checkAllowedEndpoint("tenant endpoint", tt.endpoint, allowed); (err != nil) != tt.wantErr {
+				t.Errorf("checkAllowedEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := checkAllowedEndpoint("tenant endpoint", "https://anything.example.com", nil); err != nil {
+		t.Errorf("checkAllowedEndpoint() without allowed endpoints error = %v", err)
+	}
+}
+
+func Test_loadConstraints(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.SetConfigType("yaml")
+	config := `
+constraints:
+  allowed-tenant-endpoints: [https://acme.api.us.kusari.cloud]
+  allowed-token-endpoints: [https://auth.us.kusari.cloud/oauth2/token]
+  required-metadata: [tag, software_id]
+`
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loadConstraints()
+	if err != nil {
+		t.Fatalf("loadConstraints() error = %v", err)
+	}
+	if err := c.checkEndpoints("https://acme.api.us.kusari.cloud", "https://auth.us.kusari.cloud/oauth2/token"); err != nil {
+		t.Errorf("checkEndpoints() error = %v", err)
+	}
+	if err := c.checkEndpoints("https://acme.api.us.kusari.cloud", "https://rogue.example.com/token"); err == nil {
+		t.Errorf("checkEndpoints() allowed a rogue token endpoint")
+	}
+	if err := c.checkMetadata("sbom.json", map[string]string{"tag": "build", "software_id": "7"}); err != nil {
+		t.Errorf("checkMetadata() error = %v", err)
+	}
+	if err := c.checkMetadata("sbom.json", map[string]string{"tag": "build"}); err == nil || !strings.Contains(err.Error(), "software_id") {
+		t.Errorf("checkMetadata() error = %v, want the missing software_id", err)
+	}
+
+	viper.Set("constraints.allowed-tenant-endpoints", []string{"acme.api.us.kusari.cloud"})
+	if _, err := loadConstraints(); err == nil {
+		t.Errorf("loadConstraints() accepted an allowed endpoint without a scheme")
+	}
+}
+
+func Test_checkOutboundEndpoint(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.SetConfigType("yaml")
+	config := `
+constraints:
+  allowed-endpoints: [https://auth.us.kusari.cloud/oauth2/, https://hooks.acme.example.com]
+`
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkOutboundEndpoint("device authorization endpoint", "https://auth.us.kusari.cloud/oauth2/device_authorization"); err != nil {
+		t.Errorf("checkOutboundEndpoint() error = %v", err)
+	}
+	if err := checkOutboundEndpoint("telemetry endpoint", defaultTelemetryEndpoint); err == nil {
+		t.Errorf("checkOutboundEndpoint() allowed an endpoint that isn't listed")
+	}
+
+	viper.Set("blocked-output", []string{"json:https://hooks.acme.example.com/kusari"})
+	if _, err := parseBlockedSinks(); err != nil {
+		t.Errorf("parseBlockedSinks() error = %v", err)
+	}
+	viper.Set("blocked-output", []string{"json:https://rogue.example.com/collect"})
+	if _, err := parseBlockedSinks(); err == nil {
+		t.Errorf("parseBlockedSinks() allowed a rogue webhook")
+	}
+
+	viper.Set("auth", authDevice)
+	viper.Set("tenant-endpoint", "https://acme.api.us.kusari.cloud")
+	viper.Set("device-auth-endpoint", "https://rogue.example.com/device")
+	if _, _, err := resolveEndpoints(context.Background(), nil); err == nil {
+		t.Errorf("resolveEndpoints() allowed a rogue device authorization endpoint")
+	} else if !strings.Contains(err.Error(), "device authorization endpoint") {
+		t.Errorf("resolveEndpoints() error = %v, want the device authorization endpoint rejected", err)
+	}
+}
+
+func Test_loadConstraints_requireMeta(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader("constraints:\n  required-metadata: [tag]\n")); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("require-meta", []string{"alias", " component-name", "tag", "team", ""})
+
+	c, err := loadConstraints()
+	if err != nil {
+		t.Fatalf("loadConstraints() error = %v", err)
+	}
+	want := []string{"tag", "alias", "component_name", "team"}
+	if !reflect.DeepEqual(c.RequiredMetadata, want) {
+		t.Errorf("RequiredMetadata = %v, want %v", c.RequiredMetadata, want)
+	}
+
+	err = c.checkMetadata("sbom.json", map[string]string{"tag": "build", "alias": "app", "team": "payments"})
+	if err == nil || err.Error() != "sbom.json is missing required upload metadata: component_name" {
+		t.Errorf("checkMetadata() error = %v, want the missing component_name", err)
+	}
+}
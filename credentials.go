@@ -0,0 +1,149 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// clientCredential is an OAuth client ID and secret
+type clientCredential struct {
+	// Name identifies the credential in logs, without revealing it
+	Name   string
+	ID     string
+	Secret string
+}
+
+// clientCredentials returns the primary client credential, followed by the
+// secondary one if it is configured
+func clientCredentials() ([]clientCredential, error) {
+	mode, provider, err := authProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	creds := []clientCredential{{
+		Name:   "primary",
+		ID:     viper.GetString("client-id"),
+		Secret: clientSecret(),
+	}}
+
+	secondaryID := viper.GetString("secondary-client-id")
+	secondarySecret := viper.GetString("secondary-client-secret")
+	if (secondaryID == "") != (secondarySecret == "") {
+		return nil, fmt.Errorf("secondary-client-id and secondary-client-secret must be set together")
+	}
+	if secondaryID != "" && mode == authToken {
+		return nil, fmt.Errorf("secondary-client-id can't be used with token")
+	}
+	if secondaryID != "" && provider.credentials() != credentialsClientSecret {
+		return nil, fmt.Errorf("secondary-client-id can't be used with auth %s", mode)
+	}
+	if secondaryID != "" {
+		creds = append(creds, clientCredential{Name: "secondary", ID: secondaryID, Secret: secondarySecret})
+	}
+
+	return creds, nil
+}
+
+// newTokenSource returns the source of tokens of the provider selected by
+// --auth, see authProviders
+func newTokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	_, provider, err := authProvider()
+	if err != nil {
+		// the callers validate --auth first with clientCredentials
+		provider = clientCredentialsProvider{}
+	}
+	return provider.tokenSource(ctx, tokenURL, creds)
+}
+
+// rotatingTokenSource gets tokens with the first credential that the token
+// endpoint accepts, so a credential can be rotated by configuring the new one
+// as secondary before the primary is revoked. Only errors returned by the
+// token endpoint move on to the next credential, network errors do not.
+type rotatingTokenSource struct {
+	ctx context.Context
+
+	mu      sync.Mutex
+	names   []string
+	configs []*clientcredentials.Config
+	sources []oauth2.TokenSource
+	current int
+	logged  bool
+}
+
+func newRotatingTokenSource(ctx context.Context, tokenURL string, creds []clientCredential) *rotatingTokenSource {
+	s := &rotatingTokenSource{ctx: ctx}
+	for _, cred := range creds {
+		config := &clientcredentials.Config{
+			ClientID:     cred.ID,
+			ClientSecret: cred.Secret,
+			TokenURL:     tokenURL,
+		}
+		s.names = append(s.names, cred.Name)
+		s.configs = append(s.configs, config)
+		s.sources = append(s.sources, config.TokenSource(ctx))
+	}
+	return s
+}
+
+func (s *rotatingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := s.current; i < len(s.sources); i++ {
+		token, err := s.sources[i].Token()
+		if err == nil {
+			if i != s.current || !s.logged {
+				log.Info().Str("credential", s.names[i]).Msg("Authenticated with client credential")
+				s.current = i
+				s.logged = true
+			}
+			return token, nil
+		}
+
+		var retrieveErr *oauth2.RetrieveError
+		if !errors.As(err, &retrieveErr) || i == len(s.sources)-1 {
+			return nil, err
+		}
+		log.Warn().
+			Err(err).
+			Str("credential", s.names[i]).
+			Msg("Client credential was rejected, retrying with the " + s.names[i+1] + " credential")
+	}
+
+	return nil, errors.New("no client credentials configured")
+}
+
+// forceRefresh gets a new token with the current credential next time
+func (s *rotatingTokenSource) forceRefresh() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sources) == 0 {
+		return false
+	}
+	s.sources[s.current] = s.configs[s.current].TokenSource(s.ctx)
+	return true
+}
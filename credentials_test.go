@@ -0,0 +1,93 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_getAuthorizedClientRotation(t *testing.T) {
+	tokenRequests := map[string]int{}
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, _, _ := r.BasicAuth()
+		if clientID == "" {
+			_ = r.ParseForm()
+			clientID = r.PostForm.Get("client_id")
+		}
+		tokenRequests[clientID]++
+
+		w.Header().Set("Content-Type", "application/json")
+		if clientID != "new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "new-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer tokenServer.Close()
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer new-token" {
+			t.Errorf("Authorization = %q, want the token of the secondary credential", got)
+		}
+	}))
+	defer apiServer.Close()
+
+	client := getAuthorizedClient(context.Background(), tokenServer.URL, []clientCredential{
+		{Name: "primary", ID: "old", Secret: "revoked"},
+		{Name: "secondary", ID: "new", Secret: "secret"},
+	})
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		res.Body.Close() //nolint:errcheck
+	}
+
+	// once the primary is rejected, the token is reused and the primary is not tried again
+	if tokenRequests["new"] != 1 || tokenRequests["old"] < 1 {
+		t.Errorf("token requests = %v, want the primary rejected and one request with the secondary", tokenRequests)
+	}
+}
+
+func Test_getAuthorizedClientPrimaryRejected(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+	}))
+	defer tokenServer.Close()
+
+	client := getAuthorizedClient(context.Background(), tokenServer.URL, []clientCredential{
+		{Name: "primary", ID: "old", Secret: "revoked"},
+	})
+	req, err := http.NewRequest(http.MethodGet, "http://example.invalid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(req); err == nil {
+		t.Error("Do() expected an error when the only credential is rejected")
+	}
+}
@@ -0,0 +1,150 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// authCredentialHelper gets a token or client credentials from the program of
+// --credential-helper, which selects it instead of --auth
+const authCredentialHelper = "credential-helper"
+
+// credentialHelperTimeout bounds a run of the credential helper, so a helper
+// waiting for input can't hang the upload
+const credentialHelperTimeout = 30 * time.Second
+
+// credentialHelperProvider runs the credential helper for every token
+type credentialHelperProvider struct{}
+
+func (credentialHelperProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	return &credentialHelperTokenSource{
+		ctx:      ctx,
+		helper:   viper.GetString("credential-helper"),
+		tokenURL: tokenURL,
+		clientID: creds[0].ID,
+		now:      time.Now,
+	}
+}
+
+func (credentialHelperProvider) credentials() credentialNeed { return credentialsNone }
+
+func (credentialHelperProvider) interactive() bool { return false }
+
+func (credentialHelperProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string {
+	return "credential helper " + viper.GetString("credential-helper")
+}
+
+// credentialHelperRequest is written to the stdin of the credential helper
+type credentialHelperRequest struct {
+	TokenEndpoint string `json:"token_endpoint"`
+	ClientID      string `json:"client_id,omitempty"`
+}
+
+// credentialHelperResponse is read from the stdout of the credential helper: an
+// access token, or the client credentials to get one with
+type credentialHelperResponse struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	ExpiresIn    int64     `json:"expires_in"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret"`
+}
+
+// credentialHelperTokenSource runs the credential helper with the argument get
+// and the request as JSON on stdin, similar to Docker credential helpers. Its
+// stderr is only shown when it fails.
+type credentialHelperTokenSource struct {
+	ctx      context.Context
+	helper   string
+	tokenURL string
+	clientID string
+	now      func() time.Time
+}
+
+func (s *credentialHelperTokenSource) Token() (*oauth2.Token, error) {
+	res, err := s.run()
+	if err != nil {
+		return nil, withExitCode(exitAuth, err)
+	}
+
+	switch {
+	case res.AccessToken != "":
+		token := &oauth2.Token{AccessToken: res.AccessToken, TokenType: res.TokenType, Expiry: res.ExpiresAt}
+		if token.TokenType == "" {
+			token.TokenType = "Bearer"
+		}
+		if res.ExpiresIn > 0 {
+			token.Expiry = s.now().Add(time.Duration(res.ExpiresIn) * time.Second)
+		}
+		return token, nil
+	case res.ClientID != "" && res.ClientSecret != "":
+		config := &clientcredentials.Config{ClientID: res.ClientID, ClientSecret: res.ClientSecret, TokenURL: s.tokenURL}
+		return config.Token(s.ctx)
+	default:
+		return nil, withExitCode(exitAuth, fmt.Errorf("credential helper %s returned neither an access_token nor a client_id and client_secret", s.helper))
+	}
+}
+
+// run runs the credential helper and decodes its response
+func (s *credentialHelperTokenSource) run() (*credentialHelperResponse, error) {
+	request, err := json.Marshal(credentialHelperRequest{TokenEndpoint: s.tokenURL, ClientID: s.clientID})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, credentialHelperTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.helper, "get")
+	cmd.Stdin = bytes.NewReader(request)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("credential helper %s did not answer within %s", s.helper, credentialHelperTimeout)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("credential helper %s: %w: %s", s.helper, err, strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("error running credential helper: %w", err)
+	}
+
+	var res credentialHelperResponse
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("credential helper %s returned invalid JSON: %w", s.helper, err)
+	}
+	return &res, nil
+}
+
+// forceRefresh is always possible, every call runs the credential helper anew
+func (s *credentialHelperTokenSource) forceRefresh() bool {
+	return true
+}
@@ -0,0 +1,153 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// writeCredentialHelper writes a shell script credential helper and points
+// --credential-helper at it
+func writeCredentialHelper(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper scripts need a POSIX shell")
+	}
+	helper := filepath.Join(t.TempDir(), "kusari-credential-helper")
+	if err := os.WriteFile(helper, []byte("#!/bin/sh\n"+script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("credential-helper", helper)
+	return helper
+}
+
+func Test_credentialHelperTokenSource_token(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	dir := t.TempDir()
+	writeCredentialHelper(t, `[ "$1" = get ] || exit 1
+cat > `+filepath.Join(dir, "request.json")+`
+echo '{"access_token": "helper-token", "expires_in": 600}'
+`)
+	viper.Set("client-id", "uploader")
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	source := credentialHelperProvider{}.tokenSource(context.Background(), "https://auth.example.com/oauth2/token",
+		[]clientCredential{{Name: "primary", ID: "uploader"}}).(*credentialHelperTokenSource)
+	source.now = func() time.Time { return now }
+
+	token, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "helper-token" || token.Type() != "Bearer" || !token.Expiry.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("Token() = %+v, want the helper's bearer token expiring in 10 minutes", token)
+	}
+
+	request, err := os.ReadFile(filepath.Join(dir, "request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"token_endpoint":"https://auth.example.com/oauth2/token","client_id":"uploader"}`; string(request) != want {
+		t.Errorf("credential helper request = %s, want %s", request, want)
+	}
+}
+
+func Test_credentialHelperTokenSource_clientCredentials(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	writeCredentialHelper(t, `echo '{"client_id": "from-helper", "client_secret": "helper-secret"}'`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "from-helper" || secret != "helper-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "issued", "token_type": "bearer", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	token, err := credentialHelperProvider{}.tokenSource(context.Background(), server.URL, []clientCredential{{Name: "primary"}}).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "issued" {
+		t.Errorf("Token() = %q, want the token issued for the helper's client credentials", token.AccessToken)
+	}
+}
+
+func Test_credentialHelperTokenSource_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{name: "failing helper", script: "echo 'vault is sealed' >&2\nexit 3", wantErr: "vault is sealed"},
+		{name: "invalid JSON", script: "echo 'not json'", wantErr: "invalid JSON"},
+		{name: "no credentials", script: "echo '{}'", wantErr: "neither an access_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			writeCredentialHelper(t, tt.script)
+
+			_, err := credentialHelperProvider{}.tokenSource(context.Background(), "http://127.0.0.1:1/token", []clientCredential{{Name: "primary"}}).Token()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Token() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if code := exitCodeOf(err, exitUsage); code != exitAuth {
+				t.Errorf("exit code = %d, want %d", code, exitAuth)
+			}
+		})
+	}
+}
+
+func Test_authMode_credentialHelper(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("credential-helper", "kusari-credential-helper")
+
+	if mode, err := authMode(); err != nil || mode != authCredentialHelper {
+		t.Errorf("authMode() = %q, %v, want %q", mode, err, authCredentialHelper)
+	}
+	if !hasClientCredentials() {
+		t.Error("hasClientCredentials() = false with a credential helper")
+	}
+
+	viper.Set("auth", authDevice)
+	if _, err := authMode(); err == nil {
+		t.Error("authMode() accepted a credential helper with auth device")
+	}
+	viper.Set("auth", "")
+	viper.Set("token", "pre-issued")
+	if _, err := authMode(); err == nil {
+		t.Error("authMode() accepted a credential helper with token")
+	}
+}
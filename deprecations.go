@@ -0,0 +1,129 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// deprecation is a command or flag that was replaced and will be removed
+type deprecation struct {
+	// Command is the path of the command the deprecation applies to
+	Command string `json:"command"`
+	// Flag is the deprecated flag of Command, or empty if running Command
+	// itself is deprecated
+	Flag string `json:"flag,omitempty"`
+	// Replacement is what to use instead
+	Replacement string `json:"replacement"`
+	// RemovalVersion is the first release without the deprecated usage
+	RemovalVersion string `json:"removal_version"`
+}
+
+// deprecations lists every deprecated usage. Add an entry here when a flag or
+// command is replaced, and keep it until the removal version is released.
+var deprecations = []deprecation{
+	{Command: "kusari-uploader", Replacement: "kusari-uploader upload", RemovalVersion: "v2.0.0"},
+	{Command: "kusari-uploader", Flag: "check-only", Replacement: "kusari-uploader check", RemovalVersion: "v2.0.0"},
+	{Command: "kusari-uploader upload", Flag: "check-only", Replacement: "kusari-uploader check", RemovalVersion: "v2.0.0"},
+	{Command: "kusari-uploader backfill", Flag: "concurrency", Replacement: "--upload-concurrency", RemovalVersion: "v2.0.0"},
+}
+
+// usage describes the deprecated usage for people
+func (d deprecation) usage() string {
+	if d.Flag == "" && !strings.Contains(d.Command, " ") {
+		return d.Command + " without a command"
+	}
+	if d.Flag == "" {
+		return d.Command
+	}
+	return d.Command + " --" + d.Flag
+}
+
+// usedDeprecations returns the deprecations that apply to running cmd with
+// flags, whether the deprecated flags were set on the command line, in the
+// environment or in the config file
+func usedDeprecations(cmd *cobra.Command, flags *pflag.FlagSet) []deprecation {
+	var used []deprecation
+	for _, d := range deprecations {
+		if d.Command != cmd.CommandPath() {
+			continue
+		}
+		if d.Flag != "" {
+			if value, _ := lookupSetting(flags, d.Flag); value == "" || value == "false" {
+				continue
+			}
+		}
+		used = append(used, d)
+	}
+	return used
+}
+
+// checkDeprecations warns about every deprecated usage of the run, and fails
+// if there is any and --strict-deprecations is set
+func checkDeprecations(cmd *cobra.Command, args []string) {
+	used := usedDeprecations(cmd, cmd.Flags())
+	for _, d := range used {
+		log.Warn().
+			Str("deprecated", d.usage()).
+			Str("replacement", d.Replacement).
+			Str("removalVersion", d.RemovalVersion).
+			Msg(fmt.Sprintf("%s is deprecated and will be removed in %s, use %s instead", d.usage(), d.RemovalVersion, d.Replacement))
+	}
+
+	if len(used) > 0 && viper.GetBool("strict-deprecations") {
+		log.Fatal().
+			Int("deprecations", len(used)).
+			Msg("Deprecated usage is not allowed with strict-deprecations")
+	}
+}
+
+func newDeprecationsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "deprecations",
+		Short: "List the deprecated commands and flags, their replacements and removal versions",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			writeDeprecations(cmd.OutOrStdout(), deprecations, viper.GetString("output") == outputNDJSON)
+		},
+	}
+}
+
+// writeDeprecations writes the deprecations as a table, or as one JSON object per line
+func writeDeprecations(w io.Writer, list []deprecation, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		for _, d := range list {
+			enc.Encode(d) //nolint:errcheck
+		}
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPRECATED\tREPLACEMENT\tREMOVED IN")
+	for _, d := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", d.usage(), d.Replacement, d.RemovalVersion)
+	}
+	tw.Flush() //nolint:errcheck
+}
@@ -0,0 +1,80 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func Test_usedDeprecations(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	root := &cobra.Command{Use: "kusari-uploader"}
+	root.Flags().Bool("check-only", false, "")
+	upload := &cobra.Command{Use: "upload"}
+	upload.Flags().Bool("check-only", false, "")
+	backfill := &cobra.Command{Use: "backfill"}
+	backfill.Flags().Int("concurrency", 0, "")
+	root.AddCommand(upload, backfill)
+
+	if got := usedDeprecations(upload, upload.Flags()); len(got) != 0 {
+		t.Errorf("usedDeprecations(upload) = %v, want none", got)
+	}
+
+	if err := upload.Flags().Parse([]string{"--check-only"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []deprecation{{Command: "kusari-uploader upload", Flag: "check-only", Replacement: "kusari-uploader check", RemovalVersion: "v2.0.0"}}
+	if got := usedDeprecations(upload, upload.Flags()); !reflect.DeepEqual(got, want) {
+		t.Errorf("usedDeprecations(upload --check-only) = %v, want %v", got, want)
+	}
+
+	// running the root command is deprecated by itself
+	if got := usedDeprecations(root, root.Flags()); len(got) != 1 || got[0].Flag != "" {
+		t.Errorf("usedDeprecations(root) = %v, want the root command deprecation", got)
+	}
+
+	t.Setenv("UPLOADER_CONCURRENCY", "4")
+	if got := usedDeprecations(backfill, backfill.Flags()); len(got) != 1 || got[0].Flag != "concurrency" {
+		t.Errorf("usedDeprecations(backfill) = %v, want the concurrency deprecation set in the environment", got)
+	}
+}
+
+func Test_writeDeprecations(t *testing.T) {
+	var buf bytes.Buffer
+	writeDeprecations(&buf, deprecations[:2], false)
+	for _, s := range []string{"kusari-uploader without a command", "kusari-uploader --check-only", "kusari-uploader check"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("writeDeprecations() = %q, want it to contain %q", buf.String(), s)
+		}
+	}
+
+	buf.Reset()
+	writeDeprecations(&buf, deprecations[:2], true)
+	want := `{"command":"kusari-uploader","replacement":"kusari-uploader upload","removal_version":"v2.0.0"}
+{"command":"kusari-uploader","flag":"check-only","replacement":"kusari-uploader check","removal_version":"v2.0.0"}
+`
+	if buf.String() != want {
+		t.Errorf("writeDeprecations() = %s, want %s", buf.String(), want)
+	}
+}
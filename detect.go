@@ -0,0 +1,209 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// Kinds of documents detectDocument tells apart
+const (
+	kindCycloneDX = "CycloneDX SBOM"
+	kindSPDX      = "SPDX SBOM"
+	kindOpenVEX   = "OpenVEX"
+	kindInToto    = "in-toto attestation"
+	kindUnknown   = "unknown"
+)
+
+// Formats of documents detectDocument tells apart
+const (
+	formatJSON     = "json"
+	formatJSONL    = "jsonl"
+	formatXML      = "xml"
+	formatTagValue = "tag-value"
+	formatUnknown  = "unknown"
+)
+
+// Encodings of documents decodeDocument undoes
+const (
+	encodingUTF8BOM = "utf-8 with BOM"
+	encodingUTF16   = "utf-16"
+	encodingGzip    = "gzip"
+)
+
+// maxDecodedSize bounds the size of a decompressed document, so a compression
+// bomb can't exhaust the memory of the uploader
+var maxDecodedSize = 256 << 20
+
+// byte order marks and the magic number of gzip
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+	gzipMagic  = []byte{0x1F, 0x8B}
+)
+
+// detectDocument returns the kind and format of a document as far as they can
+// be told locally. The platform detects them again on ingestion.
+func detectDocument(blob []byte) (kind, format string) {
+	trimmed := bytes.TrimSpace(blob)
+	switch {
+	case len(trimmed) == 0:
+		return kindUnknown, formatUnknown
+	case trimmed[0] == '<':
+		if bytes.Contains(trimmed, []byte("cyclonedx.org/schema/bom")) {
+			return kindCycloneDX, formatXML
+		}
+		return kindUnknown, formatXML
+	case bytes.HasPrefix(trimmed, []byte("SPDXVersion:")):
+		return kindSPDX, formatTagValue
+	case trimmed[0] != '{' && trimmed[0] != '[':
+		return kindUnknown, formatUnknown
+	}
+
+	var doc struct {
+		BOMFormat   string `json:"bomFormat"`
+		SPDXVersion string `json:"spdxVersion"`
+		Context     string `json:"@context"`
+		Type        string `json:"_type"`
+		PayloadType string `json:"payloadType"`
+	}
+	if err := json.Unmarshal(trimmed, &doc); err != nil {
+		// JSON lines, such as a stream of attestations, are judged by their first line
+		lines := bytes.Split(trimmed, []byte("\n"))
+		if len(lines) < 2 {
+			return kindUnknown, formatUnknown
+		}
+		for _, line := range lines {
+			if line = bytes.TrimSpace(line); len(line) > 0 && !json.Valid(line) {
+				return kindUnknown, formatUnknown
+			}
+		}
+		kind, _ := detectDocument(lines[0])
+		return kind, formatJSONL
+	}
+
+	switch {
+	case doc.BOMFormat == "CycloneDX":
+		return kindCycloneDX, formatJSON
+	case doc.SPDXVersion != "":
+		return kindSPDX, formatJSON
+	case strings.Contains(doc.Context, "openvex"):
+		return kindOpenVEX, formatJSON
+	case strings.HasPrefix(doc.Type, "https://in-toto.io/Statement/") || doc.PayloadType == "application/vnd.in-toto+json":
+		return kindInToto, formatJSON
+	}
+	return kindUnknown, formatJSON
+}
+
+// detectEncodedDocument is detectDocument for documents that may be gzip
+// compressed or start with a byte order mark, and also returns the encoding,
+// "" for plain UTF-8. Documents that can't be decoded are of unknown kind and
+// format.
+func detectEncodedDocument(blob []byte) (kind, format, encoding string) {
+	decoded, encoding, err := decodeDocument(blob)
+	if err != nil {
+		return kindUnknown, formatUnknown, encoding
+	}
+	kind, format = detectDocument(decoded)
+	return kind, format, encoding
+}
+
+// decodeDocument undoes the gzip compression and the byte order mark of blob,
+// and returns the plain UTF-8 document and how it was encoded, "" if it was
+// plain already. A compressed document is reported as gzip, whatever the
+// encoding of its contents.
+func decodeDocument(blob []byte) ([]byte, string, error) {
+	if !bytes.HasPrefix(blob, gzipMagic) {
+		decoded, encoding := decodeText(blob)
+		return decoded, encoding, nil
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		return nil, encodingGzip, fmt.Errorf("invalid gzip document: %w", err)
+	}
+	defer gz.Close() //nolint:errcheck
+	decoded, err := io.ReadAll(io.LimitReader(gz, int64(maxDecodedSize)+1))
+	if err != nil {
+		return nil, encodingGzip, fmt.Errorf("invalid gzip document: %w", err)
+	}
+	if len(decoded) > maxDecodedSize {
+		return nil, encodingGzip, fmt.Errorf("gzip document decompresses to more than %d bytes", maxDecodedSize)
+	}
+	decoded, _ = decodeText(decoded)
+	return decoded, encodingGzip, nil
+}
+
+// decodeText converts a document starting with a byte order mark to UTF-8
+// without one
+func decodeText(blob []byte) ([]byte, string) {
+	switch {
+	case bytes.HasPrefix(blob, utf8BOM):
+		return blob[len(utf8BOM):], encodingUTF8BOM
+	case bytes.HasPrefix(blob, utf16LEBOM), bytes.HasPrefix(blob, utf16BEBOM):
+		return decodeUTF16(blob), encodingUTF16
+	}
+	return blob, ""
+}
+
+// decodeUTF16 converts a UTF-16 document starting with a byte order mark to
+// UTF-8 without one
+func decodeUTF16(blob []byte) []byte {
+	littleEndian := bytes.HasPrefix(blob, utf16LEBOM)
+	blob = blob[2:]
+	units := make([]uint16, len(blob)/2)
+	for i := range units {
+		if littleEndian {
+			units[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+		} else {
+			units[i] = uint16(blob[2*i])<<8 | uint16(blob[2*i+1])
+		}
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+// parseDocument returns an error if a document of a detected format doesn't parse
+func parseDocument(blob []byte, format string) error {
+	switch format {
+	case formatUnknown:
+		return errors.New("format not recognized, expected JSON, JSON lines, XML or SPDX tag-value")
+	case formatJSON:
+		if !json.Valid(blob) {
+			return errors.New("invalid JSON")
+		}
+	case formatXML:
+		dec := xml.NewDecoder(bytes.NewReader(blob))
+		for {
+			_, err := dec.Token()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid XML: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// gzipped returns doc compressed with gzip
+func gzipped(t testing.TB, doc string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(doc)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func Test_detectDocument(t *testing.T) {
+	tests := []struct {
+		name       string
+		blob       string
+		wantKind   string
+		wantFormat string
+	}{
+		{name: "CycloneDX JSON", blob: `{"bomFormat": "CycloneDX", "specVersion": "1.5"}`, wantKind: kindCycloneDX, wantFormat: formatJSON},
+		{name: "CycloneDX XML", blob: `<?xml version="1.0"?><bom xmlns="http://cyclonedx.org/schema/bom/1.5"></bom>`, wantKind: kindCycloneDX, wantFormat: formatXML},
+		{name: "SPDX JSON", blob: `{"spdxVersion": "SPDX-2.3", "SPDXID": "SPDXRef-DOCUMENT"}`, wantKind: kindSPDX, wantFormat: formatJSON},
+		{name: "SPDX tag-value", blob: "SPDXVersion: SPDX-2.3\nDataLicense: CC0-1.0\n", wantKind: kindSPDX, wantFormat: formatTagValue},
+		{name: "OpenVEX", blob: `{"@context": "https://openvex.dev/ns/v0.2.0", "statements": []}`, wantKind: kindOpenVEX, wantFormat: formatJSON},
+		{name: "in-toto statement", blob: `{"_type": "https://in-toto.io/Statement/v1", "subject": []}`, wantKind: kindInToto, wantFormat: formatJSON},
+		{name: "DSSE envelope", blob: `{"payloadType": "application/vnd.in-toto+json", "payload": ""}`, wantKind: kindInToto, wantFormat: formatJSON},
+		{name: "JSON lines", blob: `{"payloadType": "application/vnd.in-toto+json"}` + "\n" + `{"payloadType": "application/vnd.in-toto+json"}` + "\n", wantKind: kindInToto, wantFormat: formatJSONL},
+		{name: "other JSON", blob: `{"name": "package.json"}`, wantKind: kindUnknown, wantFormat: formatJSON},
+		{name: "other XML", blob: `<project></project>`, wantKind: kindUnknown, wantFormat: formatXML},
+		{name: "broken JSON", blob: `{"bomFormat": `, wantKind: kindUnknown, wantFormat: formatUnknown},
+		{name: "text", blob: "hello\n", wantKind: kindUnknown, wantFormat: formatUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, format := detectDocument([]byte(tt.blob))
+			if kind != tt.wantKind || format != tt.wantFormat {
+				t.Errorf("detectDocument() = %q, %q, want %q, %q", kind, format, tt.wantKind, tt.wantFormat)
+			}
+		})
+	}
+}
+
+func Test_detectEncodedDocument(t *testing.T) {
+	const cyclonedx = `{"bomFormat": "CycloneDX", "specVersion": "1.5"}`
+	compressed := gzipped(t, cyclonedx)
+	tests := []struct {
+		name         string
+		blob         []byte
+		wantKind     string
+		wantFormat   string
+		wantEncoding string
+	}{
+		{name: "plain", blob: []byte(cyclonedx), wantKind: kindCycloneDX, wantFormat: formatJSON},
+		{name: "UTF-8 BOM", blob: append(append([]byte{}, utf8BOM...), cyclonedx...), wantKind: kindCycloneDX, wantFormat: formatJSON, wantEncoding: encodingUTF8BOM},
+		{name: "UTF-16", blob: []byte{0xFF, 0xFE, '{', 0, '}', 0}, wantKind: kindUnknown, wantFormat: formatJSON, wantEncoding: encodingUTF16},
+		{name: "gzip", blob: compressed, wantKind: kindCycloneDX, wantFormat: formatJSON, wantEncoding: encodingGzip},
+		{name: "gzip with BOM", blob: gzipped(t, string(utf8BOM)+cyclonedx), wantKind: kindCycloneDX, wantFormat: formatJSON, wantEncoding: encodingGzip},
+		{name: "truncated gzip", blob: compressed[:len(compressed)/2], wantKind: kindUnknown, wantFormat: formatUnknown, wantEncoding: encodingGzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, format, encoding := detectEncodedDocument(tt.blob)
+			if kind != tt.wantKind || format != tt.wantFormat || encoding != tt.wantEncoding {
+				t.Errorf("detectEncodedDocument() = %q, %q, %q, want %q, %q, %q", kind, format, encoding, tt.wantKind, tt.wantFormat, tt.wantEncoding)
+			}
+		})
+	}
+}
+
+func Test_decodeDocument_bomb(t *testing.T) {
+	limit := maxDecodedSize
+	t.Cleanup(func() { maxDecodedSize = limit })
+	maxDecodedSize = 1 << 20
+
+	compressed := gzipped(t, strings.Repeat("0", maxDecodedSize+1))
+	if _, _, err := decodeDocument(compressed); err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("decodeDocument() = %v, want the decompressed size limit exceeded", err)
+	}
+	if _, _, err := decodeDocument(gzipped(t, strings.Repeat("0", maxDecodedSize))); err != nil {
+		t.Errorf("decodeDocument() = %v, want a document of the limit decoded", err)
+	}
+}
+
+// detectSeeds are documents, broken ones included, the fuzz tests start from
+var detectSeeds = []string{
+	`{"bomFormat": "CycloneDX", "specVersion": "1.5", "components": [{"purl": "pkg:npm/a@1"}]}`,
+	`<?xml version="1.0"?><bom xmlns="http://cyclonedx.org/schema/bom/1.5"><components/></bom>`,
+	`{"spdxVersion": "SPDX-2.3", "packages": []}`,
+	"SPDXVersion: SPDX-2.3\nDataLicense: CC0-1.0\n",
+	`{"@context": "https://openvex.dev/ns/v0.2.0", "statements": []}`,
+	`{"payloadType": "application/vnd.in-toto+json"}` + "\n" + `{"_type": "https://in-toto.io/Statement/v1"}`,
+	`{"bomFormat": `,
+	`<bom xmlns="http://cyclonedx.org/schema/bom/1.5"><component>`,
+	`[[[[[[[[[[[[[[[[`,
+	"\n\n{}\n[",
+	"",
+}
+
+func FuzzDetectEncodedDocument(f *testing.F) {
+	for _, seed := range detectSeeds {
+		f.Add([]byte(seed))
+		f.Add(append(append([]byte{}, utf8BOM...), seed...))
+		compressed := gzipped(f, seed)
+		f.Add(compressed)
+		f.Add(compressed[:len(compressed)/2])
+	}
+	f.Add([]byte{0xFE, 0xFF, 0x00})
+	f.Add([]byte{0x1F, 0x8B})
+
+	formats := map[string]bool{formatJSON: true, formatJSONL: true, formatXML: true, formatTagValue: true, formatUnknown: true}
+	encodings := map[string]bool{"": true, encodingUTF8BOM: true, encodingUTF16: true, encodingGzip: true}
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		kind, format, encoding := detectEncodedDocument(blob)
+		if kind == "" || !formats[format] || !encodings[encoding] {
+			t.Errorf("detectEncodedDocument() = %q, %q, %q, want a known kind, format and encoding", kind, format, encoding)
+		}
+
+		decoded, encoding, err := decodeDocument(blob)
+		if err != nil {
+			return
+		}
+		if len(decoded) > maxDecodedSize {
+			t.Errorf("decodeDocument() returned %d bytes, more than the limit", len(decoded))
+		}
+		if encoding == "" && !bytes.Equal(decoded, blob) {
+			t.Error("decodeDocument() changed a plain document")
+		}
+		// the checks of validate parse the decoded document by its format
+		_, format = detectDocument(decoded)
+		_ = parseDocument(decoded, format)
+	})
+}
+
+func FuzzParseDocument(f *testing.F) {
+	for _, seed := range detectSeeds {
+		for _, format := range []string{formatJSON, formatJSONL, formatXML, formatTagValue, formatUnknown} {
+			f.Add([]byte(seed), format)
+		}
+	}
+	// JSON doesn't allow the whitespace of unicode.IsSpace
+	f.Add([]byte("\f0"), formatJSON)
+	f.Fuzz(func(t *testing.T, blob []byte, format string) {
+		err := parseDocument(blob, format)
+		switch {
+		case format == formatUnknown && err == nil:
+			t.Error("parseDocument() accepted a document of unknown format")
+		case format == formatJSON && err == nil && !json.Valid(blob):
+			t.Error("parseDocument() accepted invalid JSON")
+		}
+	})
+}
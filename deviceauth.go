@@ -0,0 +1,140 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// authDevice authenticates a person with the OAuth device authorization grant,
+// who signs in by visiting a URL and entering a code
+const authDevice = "device"
+
+// deviceProvider signs a person in with the device authorization grant.
+// Public clients work too, so only the client ID is needed.
+type deviceProvider struct{}
+
+func (deviceProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	return newDeviceTokenSource(ctx, tokenURL, creds[0], os.Stderr)
+}
+
+func (deviceProvider) credentials() credentialNeed { return credentialsClientID }
+
+func (deviceProvider) interactive() bool { return true }
+
+func (deviceProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string {
+	return describeClient(flags, creds, true)
+}
+
+// deviceAuthEndpoint returns --device-auth-endpoint, or else the device
+// authorization endpoint next to the token endpoint, e.g.
+// https://auth.us.kusari.cloud/oauth2/device_authorization
+func deviceAuthEndpoint(tokenURL string) string {
+	if endpoint := viper.GetString("device-auth-endpoint"); endpoint != "" {
+		return endpoint
+	}
+	return strings.TrimSuffix(tokenURL, "/token") + "/device_authorization"
+}
+
+// deviceTokenSource gets a token with the device authorization grant the
+// first time one is needed, printing the URL and code to sign in with to out,
+// and refreshes it afterwards. A failed sign in is not retried, so concurrent
+// uploads don't prompt again.
+type deviceTokenSource struct {
+	ctx    context.Context
+	config *oauth2.Config
+	out    io.Writer
+
+	mu     sync.Mutex
+	source oauth2.TokenSource
+	err    error
+}
+
+func newDeviceTokenSource(ctx context.Context, tokenURL string, cred clientCredential, out io.Writer) *deviceTokenSource {
+	return &deviceTokenSource{
+		ctx: ctx,
+		config: &oauth2.Config{
+			ClientID:     cred.ID,
+			ClientSecret: cred.Secret,
+			Endpoint: oauth2.Endpoint{
+				TokenURL:      tokenURL,
+				DeviceAuthURL: deviceAuthEndpoint(tokenURL),
+			},
+		},
+		out: out,
+	}
+}
+
+func (s *deviceTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source == nil && s.err == nil {
+		s.source, s.err = s.signIn()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.source.Token()
+}
+
+// forceRefresh redeems the refresh token of the sign in next time. Without
+// one the user would have to sign in again, which a running upload doesn't
+// wait for.
+func (s *deviceTokenSource) forceRefresh() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source == nil {
+		return false
+	}
+	token, err := s.source.Token()
+	if err != nil || token.RefreshToken == "" {
+		return false
+	}
+	s.source = s.config.TokenSource(s.ctx, &oauth2.Token{RefreshToken: token.RefreshToken})
+	return true
+}
+
+func (s *deviceTokenSource) signIn() (oauth2.TokenSource, error) {
+	res, err := s.config.DeviceAuth(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request to %s failed: %w", s.config.Endpoint.DeviceAuthURL, err)
+	}
+
+	fmt.Fprintf(s.out, "To sign in, open %s and enter the code %s\n", res.VerificationURI, res.UserCode)
+	if res.VerificationURIComplete != "" {
+		fmt.Fprintf(s.out, "or open %s\n", res.VerificationURIComplete)
+	}
+	fmt.Fprintln(s.out, "Waiting for the sign in to complete...")
+
+	token, err := s.config.DeviceAccessToken(s.ctx, res)
+	if err != nil {
+		return nil, fmt.Errorf("device sign in failed: %w", err)
+	}
+	log.Info().Msg("Signed in with device authorization")
+	return s.config.TokenSource(s.ctx, token), nil
+}
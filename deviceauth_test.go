@@ -0,0 +1,171 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_hasClientCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   string
+		id     string
+		secret string
+		token  string
+		want   bool
+	}{
+		{name: "client credentials", auth: authClientCredentials, id: "id", secret: "secret", want: true},
+		{name: "client credentials without secret", auth: authClientCredentials, id: "id", want: false},
+		{name: "device without secret", auth: authDevice, id: "id", want: true},
+		{name: "device without client ID", auth: authDevice, secret: "secret", want: false},
+		{name: "token without client ID", token: "token", want: true},
+		{name: "token with auth device", auth: authDevice, token: "token", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			viper.Set("auth", tt.auth)
+			viper.Set("client-id", tt.id)
+			viper.Set("client-secret", tt.secret)
+			viper.Set("token", tt.token)
+			if got := hasClientCredentials(); got != tt.want {
+				t.Errorf("hasClientCredentials() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_clientCredentials_auth(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	viper.Set("auth", "password")
+	if _, err := clientCredentials(); err == nil {
+		t.Error("clientCredentials() accepted an unknown auth")
+	}
+
+	viper.Set("auth", authDevice)
+	viper.Set("secondary-client-id", "new")
+	viper.Set("secondary-client-secret", "secret")
+	if _, err := clientCredentials(); err == nil {
+		t.Error("clientCredentials() accepted a secondary credential with auth device")
+	}
+}
+
+func Test_newTokenSource_token(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("token", "pre-issued")
+	viper.Set("client-secret", "ignored")
+
+	token, err := newTokenSource(context.Background(), "http://127.0.0.1:1/token", nil).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "pre-issued" || token.Type() != "Bearer" {
+		t.Errorf("Token() = %s %q, want the bearer token of token", token.Type(), token.AccessToken)
+	}
+
+	viper.Set("secondary-client-id", "new")
+	viper.Set("secondary-client-secret", "secret")
+	if _, err := clientCredentials(); err == nil {
+		t.Error("clientCredentials() accepted a secondary credential with token")
+	}
+}
+
+func Test_deviceAuthEndpoint(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	if got, want := deviceAuthEndpoint("https://auth.us.kusari.cloud/oauth2/token"), "https://auth.us.kusari.cloud/oauth2/device_authorization"; got != want {
+		t.Errorf("deviceAuthEndpoint() = %q, want %q", got, want)
+	}
+	viper.Set("device-auth-endpoint", "https://idp.example.com/device")
+	if got, want := deviceAuthEndpoint("https://auth.us.kusari.cloud/oauth2/token"), "https://idp.example.com/device"; got != want {
+		t.Errorf("deviceAuthEndpoint() = %q, want %q", got, want)
+	}
+}
+
+func Test_deviceTokenSource(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	var deviceRequests, tokenRequests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/oauth2/device_authorization":
+			deviceRequests++
+			if r.PostForm.Get("client_id") != "laptop" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"device_code": "dev-123", "user_code": "ABCD-EFGH", "verification_uri": "https://example.com/activate",
+				"verification_uri_complete": "https://example.com/activate?code=ABCD-EFGH", "expires_in": 60, "interval": 1}`))
+		case "/oauth2/token":
+			tokenRequests++
+			if r.PostForm.Get("device_code") != "dev-123" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "person-token", "token_type": "Bearer", "expires_in": 3600}`))
+		}
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	source := newDeviceTokenSource(context.Background(), srv.URL+"/oauth2/token", clientCredential{ID: "laptop"}, &out)
+	for i := 0; i < 2; i++ {
+		token, err := source.Token()
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if token.AccessToken != "person-token" {
+			t.Errorf("Token() = %q, want the token of the device grant", token.AccessToken)
+		}
+	}
+	if deviceRequests != 1 || tokenRequests != 1 {
+		t.Errorf("requests = %d device, %d token, want a single sign in", deviceRequests, tokenRequests)
+	}
+	for _, want := range []string{"https://example.com/activate", "ABCD-EFGH", "https://example.com/activate?code=ABCD-EFGH"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output %q does not contain %q", out.String(), want)
+		}
+	}
+
+	// a failed sign in is not retried
+	rejected := newDeviceTokenSource(context.Background(), srv.URL+"/oauth2/token", clientCredential{ID: "unknown"}, &out)
+	for i := 0; i < 2; i++ {
+		if _, err := rejected.Token(); err == nil {
+			t.Error("Token() expected an error when the device authorization is rejected")
+		}
+	}
+	if deviceRequests != 2 {
+		t.Errorf("device requests = %d, want 2 as a failed sign in is not retried", deviceRequests)
+	}
+}
@@ -0,0 +1,261 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// discoveryURLTemplate is the location of the discovery document for an organization
+const discoveryURLTemplate = "https://%s.kusari.cloud/.well-known/kusari-configuration"
+
+var orgNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// regionTokenEndpoints are the token endpoints of the Kusari Platform regions
+// that --region selects
+var regionTokenEndpoints = map[string]string{
+	"us": "https://auth.us.kusari.cloud/oauth2/token",
+	"eu": "https://auth.eu.kusari.cloud/oauth2/token",
+}
+
+// tenantHostRegexp matches the host of a tenant endpoint, capturing its region
+var tenantHostRegexp = regexp.MustCompile(`^[a-z0-9-]+\.api\.([a-z0-9-]+)\.kusari\.cloud$`)
+
+// oidcConfigurationPath is the location of the configuration of an OpenID
+// Provider, relative to its issuer URL
+const oidcConfigurationPath = "/.well-known/openid-configuration"
+
+// discoveryDocument describes the endpoints published for an organization
+type discoveryDocument struct {
+	TenantEndpoint string `json:"tenant_endpoint"`
+	TokenEndpoint  string `json:"token_endpoint"`
+}
+
+// oidcConfiguration is the part of the configuration of an OpenID Provider
+// that is used to sign in
+type oidcConfiguration struct {
+	Issuer                      string `json:"issuer"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// resolveEndpoints returns the tenant and token endpoints to use. When --org is
+// set, endpoints that were not explicitly configured are filled in from the
+// organization's discovery document. Endpoints not allowed by the constraints
+// of the config file are rejected, including the endpoints the auth mode sends
+// credentials to.
+func resolveEndpoints(ctx context.Context, client HttpClient) (string, string, error) {
+	constraints, err := loadConstraints()
+	if err != nil {
+		return "", "", err
+	}
+
+	tenantEndPoint, tokenEndPoint, err := lookupEndpoints(ctx, client)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := constraints.checkEndpoints(tenantEndPoint, tokenEndPoint); err != nil {
+		return "", "", err
+	}
+	// the auth modes that send credentials to other endpoints than the token endpoint
+	switch viper.GetString("auth") {
+	case authDevice:
+		err = constraints.checkEndpoint("device authorization endpoint", deviceAuthEndpoint(tokenEndPoint))
+	case authAWS:
+		err = constraints.checkEndpoint("AWS exchange endpoint", awsExchangeEndpoint(tokenEndPoint))
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return tenantEndPoint, tokenEndPoint, nil
+}
+
+// lookupEndpoints returns the configured endpoints, filled in from the
+// discovery document of --org. Unless it is set, the token endpoint is the one
+// the OpenID configuration of --issuer names, that of --region, or else
+// derived from the tenant endpoint's region.
+func lookupEndpoints(ctx context.Context, client HttpClient) (string, string, error) {
+	tenantEndPoint := viper.GetString("tenant-endpoint")
+	tokenEndPoint := viper.GetString("token-endpoint")
+
+	tokenSet := viper.IsSet("token-endpoint")
+	if issuer := viper.GetString("issuer"); issuer != "" && !tokenSet {
+		config, err := discoverOIDCConfiguration(ctx, client, issuer)
+		if err != nil {
+			return "", "", err
+		}
+		tokenEndPoint, tokenSet = config.TokenEndpoint, true
+		// the provider's device authorization endpoint may not be next to
+		// its token endpoint; --device-auth-endpoint still wins
+		if config.DeviceAuthorizationEndpoint != "" {
+			viper.SetDefault("device-auth-endpoint", config.DeviceAuthorizationEndpoint)
+		}
+	}
+	if region := viper.GetString("region"); region != "" && !tokenSet {
+		endpoint, ok := regionTokenEndpoints[region]
+		if !ok {
+			return "", "", fmt.Errorf("unknown region %q, must be one of: %s", region, strings.Join(slices.Sorted(maps.Keys(regionTokenEndpoints)), ", "))
+		}
+		tokenEndPoint, tokenSet = endpoint, true
+	}
+
+	org := viper.GetString("org")
+	if org != "" && !(viper.IsSet("tenant-endpoint") && tokenSet) {
+		doc, err := discoverEndpoints(ctx, client, org)
+		if err != nil {
+			return "", "", err
+		}
+
+		if !viper.IsSet("tenant-endpoint") {
+			tenantEndPoint = doc.TenantEndpoint
+		}
+		if !tokenSet {
+			tokenEndPoint, tokenSet = doc.TokenEndpoint, true
+		}
+	}
+
+	if !tokenSet {
+		if derived := deriveTokenEndpoint(tenantEndPoint); derived != "" {
+			tokenEndPoint = derived
+		}
+	}
+
+	return tenantEndPoint, tokenEndPoint, nil
+}
+
+// deriveTokenEndpoint returns the token endpoint of the region of a Kusari
+// Platform tenant endpoint such as https://acme.api.eu.kusari.cloud, or an
+// empty string for other endpoints
+func deriveTokenEndpoint(tenantEndpoint string) string {
+	u, err := url.Parse(tenantEndpoint)
+	if err != nil {
+		return ""
+	}
+	m := tenantHostRegexp.FindStringSubmatch(strings.ToLower(u.Hostname()))
+	if m == nil {
+		return ""
+	}
+	return "https://auth." + m[1] + ".kusari.cloud/oauth2/token"
+}
+
+// discoverEndpoints fetches the discovery document for org
+func discoverEndpoints(ctx context.Context, client HttpClient, org string) (*discoveryDocument, error) {
+	if !orgNameRegexp.MatchString(org) {
+		return nil, fmt.Errorf("invalid organization name: %q", org)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(discoveryURLTemplate, org), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document for organization %s: %w", org, err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("unknown organization: %s", org)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status code for discovery document: %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading discovery document: %w", err)
+	}
+
+	var doc discoveryDocument
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("error unmarshaling discovery document: %w", err)
+	}
+	if doc.TenantEndpoint == "" || doc.TokenEndpoint == "" {
+		return nil, fmt.Errorf("discovery document for organization %s is missing endpoints", org)
+	}
+
+	return &doc, nil
+}
+
+// discoverOIDCConfiguration fetches the configuration of the OpenID Provider
+// issuer, so its token endpoint is found even after the provider moves it. The
+// issuer, its token endpoint and its device authorization endpoint must use
+// https, unless TLS verification is turned off for a lab with
+// --insecure-skip-tls-verify.
+func discoverOIDCConfiguration(ctx context.Context, client HttpClient, issuer string) (*oidcConfiguration, error) {
+	u, err := url.Parse(issuer)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid issuer URL: %q", issuer)
+	}
+	insecure := viper.GetBool("insecure-skip-tls-verify")
+	if u.Scheme != "https" && !insecure {
+		return nil, fmt.Errorf("issuer URL %q must use https", issuer)
+	}
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+oidcConfigurationPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the OpenID configuration of issuer %s: %w", issuer, err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status code for the OpenID configuration of issuer %s: %d", issuer, res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading OpenID configuration: %w", err)
+	}
+
+	var config oidcConfiguration
+	if err := json.Unmarshal(body, &config); err != nil {
+		return nil, fmt.Errorf("error unmarshaling OpenID configuration: %w", err)
+	}
+	// a configuration for another issuer could send the credentials elsewhere
+	if strings.TrimSuffix(config.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OpenID configuration of issuer %s is for issuer %q", issuer, config.Issuer)
+	}
+	if config.TokenEndpoint == "" {
+		return nil, fmt.Errorf("OpenID configuration of issuer %s is missing the token endpoint", issuer)
+	}
+	if !strings.HasPrefix(config.TokenEndpoint, "https://") && !insecure {
+		return nil, fmt.Errorf("OpenID configuration of issuer %s has a token endpoint without https: %q", issuer, config.TokenEndpoint)
+	}
+	if config.DeviceAuthorizationEndpoint != "" && !strings.HasPrefix(config.DeviceAuthorizationEndpoint, "https://") && !insecure {
+		return nil, fmt.Errorf("OpenID configuration of issuer %s has a device authorization endpoint without https: %q", issuer, config.DeviceAuthorizationEndpoint)
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,302 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func Test_discoverEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		org     string
+		status  int
+		body    string
+		want    discoveryDocument
+		wantErr bool
+	}{
+		{
+			name:   "discovered",
+			org:    "acme",
+			status: http.StatusOK,
+			body:   `{"tenant_endpoint": "https://acme.api.us.kusari.cloud", "token_endpoint": "https://auth.us.kusari.cloud/oauth2/token"}`,
+			want: discoveryDocument{
+				TenantEndpoint: "https://acme.api.us.kusari.cloud",
+				TokenEndpoint:  "https://auth.us.kusari.cloud/oauth2/token",
+			},
+		},
+		{
+			name:    "unknown organization",
+			org:     "acme",
+			status:  http.StatusNotFound,
+			wantErr: true,
+		},
+		{
+			name:    "incomplete document",
+			org:     "acme",
+			status:  http.StatusOK,
+			body:    `{"tenant_endpoint": "https://acme.api.us.kusari.cloud"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid organization name",
+			org:     "evil.com/x",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &ClientMock{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					if req.URL.Host != tt.org+".kusari.cloud" {
+						t.Errorf("unexpected discovery host %s", req.URL.Host)
+					}
+					return &http.Response{
+						StatusCode: tt.status,
+						Body:       io.NopCloser(bytes.NewBufferString(tt.body)),
+					}, nil
+				},
+			}
+			got, err := discoverEndpoints(context.Background(), client, tt.org)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("discoverEndpoints() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("discoverEndpoints() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lookupEndpoints(t *testing.T) {
+	discovery := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path == "/realms/kusari"+oidcConfigurationPath {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body: io.NopCloser(bytes.NewBufferString(`{"issuer": "https://idp.example.com/realms/kusari",
+						"token_endpoint": "https://idp.example.com/realms/kusari/protocol/openid-connect/token"}`)),
+				}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(bytes.NewBufferString(
+					`{"tenant_endpoint": "https://acme.api.us.kusari.cloud", "token_endpoint": "https://auth.us.kusari.cloud/oauth2/token"}`)),
+			}, nil
+		},
+	}
+	const euToken = "https://auth.eu.kusari.cloud/oauth2/token"
+
+	tests := []struct {
+		name       string
+		settings   map[string]string
+		wantTenant string
+		wantToken  string
+		wantErr    bool
+	}{
+		{
+			name:       "derived from the tenant endpoint",
+			settings:   map[string]string{"tenant-endpoint": "https://acme.api.eu.kusari.cloud"},
+			wantTenant: "https://acme.api.eu.kusari.cloud",
+			wantToken:  euToken,
+		},
+		{
+			name:       "default for other tenant endpoints",
+			settings:   map[string]string{"tenant-endpoint": "https://kusari.example.com"},
+			wantTenant: "https://kusari.example.com",
+			wantToken:  "https://auth.us.kusari.cloud/oauth2/token",
+		},
+		{
+			name:       "region",
+			settings:   map[string]string{"tenant-endpoint": "https://kusari.example.com", "region": "eu"},
+			wantTenant: "https://kusari.example.com",
+			wantToken:  euToken,
+		},
+		{
+			name:       "region overrides discovery",
+			settings:   map[string]string{"org": "acme", "region": "eu"},
+			wantTenant: "https://acme.api.us.kusari.cloud",
+			wantToken:  euToken,
+		},
+		{
+			name: "explicit token endpoint wins",
+			settings: map[string]string{"tenant-endpoint": "https://acme.api.eu.kusari.cloud", "region": "eu",
+				"token-endpoint": "https://auth.example.com/token"},
+			wantTenant: "https://acme.api.eu.kusari.cloud",
+			wantToken:  "https://auth.example.com/token",
+		},
+		{
+			name:       "issuer",
+			settings:   map[string]string{"tenant-endpoint": "https://kusari.example.com", "issuer": "https://idp.example.com/realms/kusari/", "region": "eu"},
+			wantTenant: "https://kusari.example.com",
+			wantToken:  "https://idp.example.com/realms/kusari/protocol/openid-connect/token",
+		},
+		{
+			name: "explicit token endpoint wins over issuer",
+			settings: map[string]string{"tenant-endpoint": "https://kusari.example.com", "issuer": "https://idp.example.com/realms/kusari",
+				"token-endpoint": "https://auth.example.com/token"},
+			wantTenant: "https://kusari.example.com",
+			wantToken:  "https://auth.example.com/token",
+		},
+		{
+			name:     "unknown region",
+			settings: map[string]string{"tenant-endpoint": "https://acme.api.eu.kusari.cloud", "region": "mars"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			flags.String("tenant-endpoint", "", "")
+			flags.String("token-endpoint", "https://auth.us.kusari.cloud/oauth2/token", "")
+			flags.String("org", "", "")
+			flags.String("region", "", "")
+			flags.String("issuer", "", "")
+			for name, value := range tt.settings {
+				if err := flags.Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := viper.BindPFlags(flags); err != nil {
+				t.Fatal(err)
+			}
+
+			tenant, token, err := lookupEndpoints(context.Background(), discovery)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookupEndpoints() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tenant != tt.wantTenant || token != tt.wantToken {
+				t.Errorf("lookupEndpoints() = %s, %s, want %s, %s", tenant, token, tt.wantTenant, tt.wantToken)
+			}
+		})
+	}
+}
+
+func Test_discoverOIDCConfiguration(t *testing.T) {
+	tests := []struct {
+		name     string
+		issuer   string
+		insecure bool
+		status   int
+		body     string
+		want     oidcConfiguration
+		wantErr  bool
+	}{
+		{
+			name:   "discovered",
+			issuer: "https://idp.example.com",
+			status: http.StatusOK,
+			body: `{"issuer": "https://idp.example.com", "token_endpoint": "https://idp.example.com/oauth2/v2/token",
+				"device_authorization_endpoint": "https://idp.example.com/oauth2/v2/device"}`,
+			want: oidcConfiguration{
+				Issuer:                      "https://idp.example.com",
+				TokenEndpoint:               "https://idp.example.com/oauth2/v2/token",
+				DeviceAuthorizationEndpoint: "https://idp.example.com/oauth2/v2/device",
+			},
+		},
+		{
+			name:    "other issuer",
+			issuer:  "https://idp.example.com",
+			status:  http.StatusOK,
+			body:    `{"issuer": "https://evil.example.com", "token_endpoint": "https://evil.example.com/token"}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing token endpoint",
+			issuer:  "https://idp.example.com",
+			status:  http.StatusOK,
+			body:    `{"issuer": "https://idp.example.com"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not found",
+			issuer:  "https://idp.example.com",
+			status:  http.StatusNotFound,
+			wantErr: true,
+		},
+		{
+			name:    "invalid issuer",
+			issuer:  "idp.example.com",
+			wantErr: true,
+		},
+		{
+			name:    "http issuer",
+			issuer:  "http://idp.example.com",
+			status:  http.StatusOK,
+			body:    `{"issuer": "http://idp.example.com", "token_endpoint": "https://idp.example.com/token"}`,
+			wantErr: true,
+		},
+		{
+			name:    "http token endpoint",
+			issuer:  "https://idp.example.com",
+			status:  http.StatusOK,
+			body:    `{"issuer": "https://idp.example.com", "token_endpoint": "http://idp.example.com/token"}`,
+			wantErr: true,
+		},
+		{
+			name:   "http device authorization endpoint",
+			issuer: "https://idp.example.com",
+			status: http.StatusOK,
+			body: `{"issuer": "https://idp.example.com", "token_endpoint": "https://idp.example.com/token",
+				"device_authorization_endpoint": "http://idp.example.com/device"}`,
+			wantErr: true,
+		},
+		{
+			name:     "http issuer in a lab",
+			issuer:   "http://idp.lab",
+			insecure: true,
+			status:   http.StatusOK,
+			body:     `{"issuer": "http://idp.lab", "token_endpoint": "http://idp.lab/token"}`,
+			want:     oidcConfiguration{Issuer: "http://idp.lab", TokenEndpoint: "http://idp.lab/token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			viper.Set("insecure-skip-tls-verify", tt.insecure)
+
+			client := &ClientMock{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					if got := req.URL.String(); got != tt.issuer+oidcConfigurationPath {
+						t.Errorf("unexpected OpenID configuration URL %s", got)
+					}
+					return &http.Response{
+						StatusCode: tt.status,
+						Body:       io.NopCloser(bytes.NewBufferString(tt.body)),
+					}, nil
+				},
+			}
+			got, err := discoverOIDCConfiguration(context.Background(), client, tt.issuer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("discoverOIDCConfiguration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("discoverOIDCConfiguration() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
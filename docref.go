@@ -0,0 +1,144 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// docRefPlaceholderRegexp matches the placeholders of a document ref template
+var docRefPlaceholderRegexp = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// docRefRegexp restricts document refs to characters that are safe in blob
+// store keys and URLs, so a template can't escape the tenant's key space
+var docRefRegexp = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,255}$`)
+
+// docRefUnsafeRegexp matches the characters that are replaced in artifact names
+var docRefUnsafeRegexp = regexp.MustCompile(`[^A-Za-z0-9._:-]+`)
+
+// docRefFixedPlaceholders are the placeholders that are not prefixed with meta. or env.
+var docRefFixedPlaceholders = map[string]bool{
+	"sha256":          true,
+	"artifact_digest": true,
+	"artifact_name":   true,
+}
+
+// validateDocRefTemplate checks that a document ref template only uses known
+// placeholders and safe literal characters. The placeholders are
+//
+//	{sha256}          sha256 of the document content
+//	{artifact_digest} sha256 digest of the artifact an SBOM describes
+//	{artifact_name}   name of the artifact an SBOM describes
+//	{meta.<key>}      an upload metadata value, e.g. {meta.run_id}
+//	{env.<NAME>}      an environment variable, e.g. {env.GITHUB_RUN_ID}
+func validateDocRefTemplate(tmpl string) error {
+	placeholders := docRefPlaceholderRegexp.FindAllStringSubmatch(tmpl, -1)
+	if len(placeholders) == 0 {
+		// every document would be uploaded under the same ref
+		return fmt.Errorf("document ref template %q has no placeholders", tmpl)
+	}
+	for _, m := range placeholders {
+		name := m[1]
+		switch {
+		case docRefFixedPlaceholders[name]:
+		case strings.HasPrefix(name, "meta.") && len(name) > len("meta."):
+		case strings.HasPrefix(name, "env.") && len(name) > len("env."):
+		default:
+			return fmt.Errorf("unknown placeholder %s in document ref template", m[0])
+		}
+	}
+
+	literal := docRefPlaceholderRegexp.ReplaceAllString(tmpl, "x")
+	if !docRefRegexp.MatchString(literal) {
+		return fmt.Errorf("document ref template %q may only contain letters, digits, '.', '_', ':', '-' and placeholders", tmpl)
+	}
+	return nil
+}
+
+// mustValidateDocRefTemplate exits if the --docref-template setting is invalid
+func mustValidateDocRefTemplate() {
+	tmpl := viper.GetString("docref-template")
+	if tmpl == "" {
+		return
+	}
+	if err := validateDocRefTemplate(tmpl); err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid document ref template")
+	}
+}
+
+// expandDocRef expands a validated document ref template for a document whose
+// content hashes to sum. It fails if a placeholder has no value for the
+// document or the result is not a safe document ref.
+func expandDocRef(tmpl string, blob []byte, sum string, meta map[string]string) (string, error) {
+	var expandErr error
+	ref := docRefPlaceholderRegexp.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+
+		var value string
+		switch {
+		case name == "sha256":
+			value = sum
+		case name == "artifact_digest" || name == "artifact_name":
+			if digest := sbomArtifactDigest(blob); digest != nil {
+				value = digest.SHA256
+				if name == "artifact_name" {
+					value = docRefSafeName(digest.Name)
+				}
+			}
+		case strings.HasPrefix(name, "meta."):
+			value = meta[strings.TrimPrefix(name, "meta.")]
+		case strings.HasPrefix(name, "env."):
+			value = os.Getenv(strings.TrimPrefix(name, "env."))
+		}
+
+		if value == "" && expandErr == nil {
+			expandErr = fmt.Errorf("document ref template placeholder %s has no value for this document", placeholder)
+		}
+		return value
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	if !docRefRegexp.MatchString(ref) {
+		return "", fmt.Errorf("document ref %q from template %q is not a valid document ref", ref, tmpl)
+	}
+	return ref, nil
+}
+
+// docRefSafeName makes an artifact name such as ghcr.io/org/image usable in a
+// document ref by replacing unsafe characters with '-'
+func docRefSafeName(name string) string {
+	return strings.Trim(docRefUnsafeRegexp.ReplaceAllString(name, "-"), "-")
+}
+
+// documentRef returns the document ref to upload a document as: the expansion
+// of --docref-template if one is set, or else sum, the sha256 of its content
+func documentRef(blob []byte, sum string, meta map[string]string) (string, error) {
+	tmpl := viper.GetString("docref-template")
+	if tmpl == "" {
+		return contentRef(sum), nil
+	}
+	return expandDocRef(tmpl, blob, sum, meta)
+}
@@ -0,0 +1,78 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func Test_validateDocRefTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		wantErr bool
+	}{
+		{name: "content hash", tmpl: "{sha256}"},
+		{name: "artifact digest", tmpl: "image:{artifact_name}:{artifact_digest}"},
+		{name: "metadata and environment", tmpl: "build-{env.BUILD_ID}-{meta.run_id}"},
+		{name: "no placeholders", tmpl: "sbom", wantErr: true},
+		{name: "unknown placeholder", tmpl: "{digest}", wantErr: true},
+		{name: "empty meta key", tmpl: "{meta.}", wantErr: true},
+		{name: "path separator", tmpl: "../{sha256}", wantErr: true},
+		{name: "unterminated placeholder", tmpl: "{sha256", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateDocRefTemplate(tt.tmpl); (err != nil) != tt.wantErr {
+				t.Errorf("validateDocRefTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_expandDocRef(t *testing.T) {
+	t.Setenv("DOCREF_BUILD_ID", "1234")
+	t.Setenv("DOCREF_UNSAFE", "a/b")
+
+	sbom := []byte(`{"bomFormat": "CycloneDX", "metadata": {"component": {"name": "ghcr.io/org/app", "hashes": [{"alg": "SHA-256", "content": "ABC123"}]}}}`)
+	meta := map[string]string{"run_id": "run-1"}
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		blob    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "content hash", tmpl: "{sha256}", blob: []byte("hello"), want: getHash([]byte("hello"))},
+		{name: "artifact", tmpl: "{artifact_name}_{artifact_digest}", blob: sbom, want: "ghcr.io-org-app_abc123"},
+		{name: "metadata and environment", tmpl: "build-{env.DOCREF_BUILD_ID}-{meta.run_id}", blob: sbom, want: "build-1234-run-1"},
+		{name: "no artifact digest", tmpl: "{artifact_digest}", blob: []byte("hello"), wantErr: true},
+		{name: "missing metadata", tmpl: "{meta.tag}", blob: sbom, wantErr: true},
+		{name: "unsafe value", tmpl: "{env.DOCREF_UNSAFE}", blob: sbom, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandDocRef(tt.tmpl, tt.blob, getHash(tt.blob), meta)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandDocRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expandDocRef() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// doctor* are the statuses of a doctor check
+const (
+	doctorPass = "PASS"
+	doctorFail = "FAIL"
+	doctorSkip = "SKIP"
+)
+
+// doctorCheck is the result of one diagnostic
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// doctorDryRunRef is the document ref of the presign request made by doctor.
+// The presigned URL is never used, so nothing is uploaded.
+const doctorDryRunRef = "kusari-uploader-doctor-dry-run"
+
+func newDoctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose connectivity and authentication problems with the tenant",
+		Long: "Check that the tenant and token endpoints resolve and are reachable over TLS, that a token can be " +
+			"fetched with the client credentials, and that the presign endpoint accepts it, and print a pass/fail table. " +
+			"Nothing is uploaded. Exits with status 1 if any check fails.",
+		Args: cobra.NoArgs,
+		Run:  doctor,
+	}
+}
+
+func doctor(cmd *cobra.Command, args []string) {
+	checks := doctorChecks(context.Background(), cmd.Root().PersistentFlags())
+
+	printDoctorChecks(cmd.OutOrStdout(), checks, viper.GetString("output") == outputNDJSON)
+	for _, check := range checks {
+		if check.Status == doctorFail {
+			exitRun(exitUsage)
+		}
+	}
+}
+
+// doctorChecks resolves the endpoints and credentials from the configuration
+// and runs the diagnostics. flags are the root flags, which tell where the
+// credentials are read from.
+func doctorChecks(ctx context.Context, flags *pflag.FlagSet) []doctorCheck {
+	ctx, client := mustNewHTTPClient(ctx)
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, client)
+	if err != nil {
+		return []doctorCheck{{Name: "endpoint discovery", Status: doctorFail, Detail: err.Error()}}
+	}
+	creds, err := clientCredentials()
+	if err != nil {
+		return []doctorCheck{{Name: "configuration", Status: doctorFail, Detail: err.Error()}}
+	}
+	return runDoctor(ctx, client, flags, tenantEndPoint, tokenEndPoint, creds)
+}
+
+// runDoctor runs the diagnostics in order. Checks that depend on a failed
+// check are skipped, so the first failure points at the cause.
+func runDoctor(ctx context.Context, client *http.Client, flags *pflag.FlagSet, tenantEndpoint, tokenEndpoint string, creds []clientCredential) []doctorCheck {
+	var checks []doctorCheck
+	failed := false
+	add := func(name, status, detail string) {
+		if failed && status != doctorFail {
+			status, detail = doctorSkip, "skipped after an earlier failure"
+		}
+		checks = append(checks, doctorCheck{Name: name, Status: status, Detail: detail})
+		failed = failed || status == doctorFail
+	}
+
+	mode, provider, err := authProvider()
+	if err != nil {
+		provider = clientCredentialsProvider{}
+	}
+	need := provider.credentials()
+	missing := ""
+	switch {
+	case err != nil:
+		missing = err.Error()
+	case tenantEndpoint == "":
+		missing = "tenant-endpoint (or org) is not set"
+	case tokenEndpoint == "":
+		missing = "token-endpoint is not set"
+	case need != credentialsNone && (creds[0].ID == "" || (creds[0].Secret == "" && need == credentialsClientSecret)):
+		missing = "client-id and client-secret must be set"
+	}
+	if missing != "" {
+		add("configuration", doctorFail, missing)
+	} else {
+		add("configuration", doctorPass, "auth "+mode+", "+provider.describe(flags, creds))
+	}
+
+	for _, endpoint := range []struct{ name, url string }{{"tenant endpoint", tenantEndpoint}, {"token endpoint", tokenEndpoint}} {
+		if failed {
+			add(endpoint.name+" DNS", doctorSkip, "")
+			add(endpoint.name+" TLS", doctorSkip, "")
+			continue
+		}
+
+		u, err := url.Parse(endpoint.url)
+		if err != nil || u.Hostname() == "" {
+			add(endpoint.name+" DNS", doctorFail, fmt.Sprintf("invalid URL %q", endpoint.url))
+			add(endpoint.name+" TLS", doctorSkip, "")
+			continue
+		}
+
+		addrs, err := net.DefaultResolver.LookupHost(ctx, u.Hostname())
+		if err != nil {
+			add(endpoint.name+" DNS", doctorFail, err.Error())
+			add(endpoint.name+" TLS", doctorSkip, "")
+			continue
+		}
+		add(endpoint.name+" DNS", doctorPass, fmt.Sprintf("%s resolves to %v", u.Hostname(), addrs))
+
+		status, detail := reachable(ctx, client, u)
+		add(endpoint.name+" TLS", status, detail)
+	}
+
+	if failed {
+		add("token fetch", doctorSkip, "")
+		add("presign", doctorSkip, "")
+		return checks
+	}
+	if provider.interactive() {
+		add("token fetch", doctorSkip, "auth "+mode+" needs a person to sign in, run a command to test it")
+		add("presign", doctorSkip, "")
+		return checks
+	}
+
+	if mode == authToken {
+		add("token fetch", doctorSkip, "the token is pre-issued, presign checks that it is accepted")
+	} else if _, err := newTokenSource(ctx, tokenEndpoint, creds).Token(); err != nil {
+		add("token fetch", doctorFail, err.Error())
+	} else {
+		add("token fetch", doctorPass, "token issued")
+	}
+
+	if failed {
+		add("presign", doctorSkip, "")
+		return checks
+	}
+
+	payload, _ := json.Marshal(map[string]string{"filename": doctorDryRunRef})
+	if _, err := getPresignedUrl(getAuthorizedClient(ctx, tokenEndpoint, creds), tenantEndpoint, payload); err != nil {
+		add("presign", doctorFail, err.Error())
+	} else {
+		add("presign", doctorPass, "presigned URL issued, nothing was uploaded")
+	}
+
+	return checks
+}
+
+// reachable makes a request to u and reports whether a response came back,
+// whatever its status, naming the proxy the request went through
+func reachable(ctx context.Context, client *http.Client, u *url.URL) (string, string) {
+	via := ""
+	if cfg, err := resolveProxyConfig(); err == nil {
+		if proxy, err := cfg.proxyFor(&http.Request{URL: u}); err == nil && proxy != nil {
+			via = " via proxy " + proxy.Redacted()
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return doctorFail, err.Error()
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return doctorFail, err.Error() + via
+	}
+	res.Body.Close() //nolint:errcheck
+
+	detail := fmt.Sprintf("HTTP %d%s", res.StatusCode, via)
+	if res.TLS != nil {
+		detail = fmt.Sprintf("%s, HTTP %d%s", tlsVersionName(res.TLS.Version), res.StatusCode, via)
+	}
+	return doctorPass, detail
+}
+
+// tlsVersionName returns the name of a TLS version as accepted by --tls-min-version
+func tlsVersionName(v uint16) string {
+	for name, version := range tlsVersions {
+		if version == v {
+			return "TLS " + name
+		}
+	}
+	return fmt.Sprintf("TLS 0x%04x", v)
+}
+
+// printDoctorChecks writes the checks as a table, or as one JSON object per line
+func printDoctorChecks(w io.Writer, checks []doctorCheck, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		for _, check := range checks {
+			enc.Encode(check) //nolint:errcheck
+		}
+		return
+	}
+
+	color := colorEnabled(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "CHECK\t%s\tDETAIL\n", paintStatus("STATUS", color))
+	for _, check := range checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Name, paintStatus(check.Status, color), check.Detail)
+	}
+	tw.Flush() //nolint:errcheck
+}
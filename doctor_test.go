@@ -0,0 +1,83 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
+)
+
+func Test_runDoctor(t *testing.T) {
+	presigned := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if err := r.ParseForm(); err != nil || r.Form.Get("client_secret") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error": "invalid_client"}`)) //nolint:errcheck
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "token", "token_type": "bearer", "expires_in": 3600}`)) //nolint:errcheck
+		case "/presign":
+			presigned = true
+			json.NewEncoder(w).Encode(map[string]string{"presignedUrl": "https://storage.example.com/upload"}) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+
+	statuses := func(checks []doctorCheck) map[string]string {
+		m := map[string]string{}
+		for _, check := range checks {
+			m[check.Name] = check.Status
+		}
+		return m
+	}
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	creds := []clientCredential{{Name: "primary", ID: "id", Secret: "secret"}}
+	got := statuses(runDoctor(ctx, client, flags, server.URL, server.URL+"/token", creds))
+	for _, name := range []string{"configuration", "tenant endpoint DNS", "tenant endpoint TLS", "token endpoint DNS", "token endpoint TLS", "token fetch", "presign"} {
+		if got[name] != doctorPass {
+			t.Errorf("check %s = %s, want %s", name, got[name], doctorPass)
+		}
+	}
+	if !presigned {
+		t.Errorf("runDoctor() did not request a presigned URL")
+	}
+
+	creds = []clientCredential{{Name: "primary", ID: "id", Secret: "wrong"}}
+	got = statuses(runDoctor(ctx, client, flags, server.URL, server.URL+"/token", creds))
+	if got["token fetch"] != doctorFail || got["presign"] != doctorSkip {
+		t.Errorf("checks with a rejected credential = %v, want the token fetch to fail and presign to be skipped", got)
+	}
+
+	got = statuses(runDoctor(ctx, client, flags, "", server.URL+"/token", creds))
+	if got["configuration"] != doctorFail || got["tenant endpoint DNS"] != doctorSkip || got["token fetch"] != doctorSkip {
+		t.Errorf("checks without a tenant endpoint = %v, want the configuration to fail and the rest to be skipped", got)
+	}
+}
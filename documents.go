@@ -0,0 +1,141 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+type documentRefs struct {
+	DocumentRefs []string `json:"document_refs"`
+}
+
+// errLimitReached stops paging once enough items were returned
+var errLimitReached = errors.New("limit reached")
+
+func newListDocumentsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list-documents",
+		Short: "List the refs of the documents ingested by the tenant",
+		Args:  cobra.NoArgs,
+		Run:   listDocuments,
+	}
+
+	cmd.Flags().Int("limit", 100, "Maximum number of documents to list")
+	cmd.Flags().Bool("all", false, "List every document, ignoring limit")
+
+	mustBindPFlag(cmd, "limit")
+	mustBindPFlag(cmd, "all")
+
+	return cmd
+}
+
+func listDocuments(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	limit := viper.GetInt("limit")
+	if viper.GetBool("all") {
+		limit = 0
+	} else if limit < 1 {
+		log.Fatal().Msg("limit must be at least 1, use --all to list every document")
+	}
+
+	format := viper.GetString("output")
+	results, _, err := newOutput(format, "")
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid output format")
+	}
+
+	ctx, defaultClient := mustNewHTTPClient(ctx)
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, defaultClient)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+
+	if !hasClientCredentials() || tenantEndPoint == "" || tokenEndPoint == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id, client-secret, tenant-endpoint (or org), token-endpoint")
+	}
+
+	creds, err := clientCredentials()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid client credentials")
+	}
+	authorizedClient := getAuthorizedClient(ctx, tokenEndPoint, creds)
+
+	// refs are written as each page arrives rather than after the whole listing
+	enc := json.NewEncoder(os.Stdout)
+	err = forEachDocumentRef(ctx, authorizedClient, tenantEndPoint, limit, func(ref string) error {
+		switch {
+		case format == outputNDJSON:
+			return enc.Encode(map[string]string{"document_ref": ref})
+		case results != nil:
+			results.write(fileResult{DocumentRef: ref})
+			return nil
+		}
+		_, err := fmt.Println(ref)
+		return err
+	})
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Error listing tenant documents")
+	}
+}
+
+// forEachDocumentRef calls fn with the ref of every document ingested by the
+// tenant, page by page, stopping after limit refs unless limit is 0
+func forEachDocumentRef(ctx context.Context, client HttpClient, tenantEndpoint string, limit int, fn func(ref string) error) error {
+	pageSize := defaultPageSize
+	if limit > 0 && limit < pageSize {
+		pageSize = limit
+	}
+
+	count := 0
+	err := forEachPage(ctx, client, tenantEndpoint, "pico/v1/documents/refs", pageSize, func(body []byte) (bool, error) {
+		var refs documentRefs
+		if err := json.Unmarshal(body, &refs); err != nil {
+			return false, fmt.Errorf("error unmarshaling response body for document refs: %w", err)
+		}
+		for _, ref := range refs.DocumentRefs {
+			if limit > 0 && count >= limit {
+				return false, errLimitReached
+			}
+			if err := fn(ref); err != nil {
+				return false, err
+			}
+			count++
+		}
+		return limit == 0 || count < limit, nil
+	})
+	if errors.Is(err, errLimitReached) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func newEnvTemplateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "env-template",
+		Short: "Print an env file template listing the environment variable for every flag",
+		Long: "Print an env file template listing the environment variable for every flag. " +
+			"Every variable is commented out with its default, uncomment the ones to set. " +
+			"The output can be used with docker run --env-file or as the base of a Kubernetes ConfigMap.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			writeEnvTemplate(os.Stdout, cmd.Root())
+		},
+	}
+}
+
+// writeEnvTemplate writes one commented out variable for each flag of root and
+// its subcommands, grouped by command. Variables are commented out because a
+// set variable, even to the default, counts as set and turns off the defaults
+// derived from other settings, such as the token endpoint of --org.
+func writeEnvTemplate(w io.Writer, root *cobra.Command) {
+	seen := map[string]bool{"help": true}
+
+	writeSection := func(title string, flags *pflag.FlagSet) {
+		var names []string
+		flags.VisitAll(func(f *pflag.Flag) {
+			if !seen[f.Name] && !f.Hidden {
+				names = append(names, f.Name)
+			}
+		})
+		if len(names) == 0 {
+			return
+		}
+
+		fmt.Fprintf(w, "# --- %s ---\n", title)
+		for _, name := range names {
+			seen[name] = true
+			f := flags.Lookup(name)
+			fmt.Fprintf(w, "# %s\n", f.Usage)
+			value := f.DefValue
+			if _, ok := f.Value.(pflag.SliceValue); ok && value == "[]" {
+				value = ""
+			}
+			fmt.Fprintf(w, "# %s=%s\n", envVarNames(name)[0], value)
+		}
+		fmt.Fprintln(w)
+	}
+
+	writeSection("common", root.PersistentFlags())
+	writeSection(root.Name(), root.LocalNonPersistentFlags())
+	for _, sub := range root.Commands() {
+		writeSection(sub.Name(), sub.LocalNonPersistentFlags())
+	}
+}
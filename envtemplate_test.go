@@ -0,0 +1,138 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func Test_writeEnvTemplate(t *testing.T) {
+	root := &cobra.Command{Use: "root"}
+	root.PersistentFlags().String("tenant-endpoint", "", "Tenant endpoint")
+	root.Flags().String("token-endpoint", "https://auth.example.com", "Token endpoint")
+	root.Flags().Bool("dry", false, "Dry run")
+	root.Flags().StringSlice("profile", nil, "Profiles")
+	sub := &cobra.Command{Use: "sub"}
+	sub.Flags().String("token-endpoint", "", "Duplicate of a root flag")
+	sub.Flags().Int("concurrency", 10, "Concurrency")
+	root.AddCommand(sub)
+
+	var buf bytes.Buffer
+	writeEnvTemplate(&buf, root)
+
+	want := `# --- common ---
+# Tenant endpoint
+# UPLOADER_TENANT_ENDPOINT=
+
+# --- root ---
+# Dry run
+# UPLOADER_DRY=false
+# Profiles
+# UPLOADER_PROFILE=
+# Token endpoint
+# UPLOADER_TOKEN_ENDPOINT=https://auth.example.com
+
+# --- sub ---
+# Concurrency
+# UPLOADER_CONCURRENCY=10
+
+`
+	if got := buf.String(); got != want {
+		t.Errorf("writeEnvTemplate() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// setEnvFile sets the variables of an env file the way docker run --env-file
+// reads it, skipping comments and blank lines
+func setEnvFile(t *testing.T, envFile string) {
+	t.Helper()
+	scanner := bufio.NewScanner(strings.NewReader(envFile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, "=")
+		t.Setenv(name, value)
+	}
+}
+
+func Test_writeEnvTemplate_loadsBack(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	for _, env := range os.Environ() {
+		if name, _, _ := strings.Cut(env, "="); strings.HasPrefix(name, "UPLOADER_") || strings.HasPrefix(name, "KUSARI_") {
+			t.Setenv(name, "")
+			os.Unsetenv(name) //nolint:errcheck
+		}
+	}
+
+	root := &cobra.Command{Use: "kusari-uploader"}
+	root.PersistentFlags().String("auth", authClientCredentials, "How to authenticate")
+	root.PersistentFlags().String("tenant-endpoint", "", "Tenant endpoint")
+	root.PersistentFlags().String("token-endpoint", "https://auth.us.kusari.cloud/oauth2/token", "Token endpoint")
+	root.PersistentFlags().String("org", "", "Organization")
+	root.PersistentFlags().String("region", "", "Region")
+	root.PersistentFlags().String("issuer", "", "Issuer")
+	root.PersistentFlags().StringSlice("ca-cert", nil, "Private CAs")
+	upload := &cobra.Command{Use: "upload"}
+	addUploadFlags(upload.Flags())
+	root.AddCommand(upload)
+	for _, name := range []string{"auth", "tenant-endpoint", "token-endpoint", "org", "region", "issuer", "ca-cert"} {
+		mustBindPFlag(root, name)
+	}
+	for _, name := range []string{"profile", "meta"} {
+		mustBindPFlag(upload, name)
+	}
+
+	var buf bytes.Buffer
+	writeEnvTemplate(&buf, root)
+	if strings.Contains(buf.String(), "[]") {
+		t.Errorf("writeEnvTemplate() printed a slice default as []:\n%s", buf.String())
+	}
+
+	// the template is used with only the tenant endpoint uncommented
+	envFile := strings.Replace(buf.String(), "# UPLOADER_TENANT_ENDPOINT=", "UPLOADER_TENANT_ENDPOINT=https://acme.api.eu.kusari.cloud", 1)
+	setEnvFile(t, envFile)
+
+	for _, name := range []string{"auth", "token-endpoint", "profile", "ca-cert", "meta"} {
+		if viper.IsSet(name) {
+			t.Errorf("viper.IsSet(%q) = true, want the template to leave it unset", name)
+		}
+	}
+	if profiles := viper.GetStringSlice("profile"); len(profiles) != 0 {
+		t.Errorf("profile = %q, want no profiles", profiles)
+	}
+	if mode, err := authMode(); err != nil || mode != authClientCredentials {
+		t.Errorf("authMode() = %q, %v, want %q", mode, err, authClientCredentials)
+	}
+	tenant, token, err := lookupEndpoints(context.Background(), http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant != "https://acme.api.eu.kusari.cloud" || token != deriveTokenEndpoint(tenant) {
+		t.Errorf("lookupEndpoints() = %q, %q, want the token endpoint derived from the EU tenant", tenant, token)
+	}
+}
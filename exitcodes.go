@@ -0,0 +1,169 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+// Exit codes of the uploader. They are part of its interface for CI gating and
+// must not change meaning.
+const (
+	// exitUsage is for invalid flags, configuration or input, and any other
+	// failure without a more specific code
+	exitUsage = 1
+	// exitAuth is for credentials rejected by the token endpoint, or requests
+	// rejected by the tenant as unauthorized or forbidden
+	exitAuth = 2
+	// exitUpload is for documents that could not be uploaded, including
+	// because a tenant lookup the upload depends on failed
+	exitUpload = 3
+	// exitBlocked is for SBOMs that use blocked packages, or packages reported
+	// by the maintenance check with --maintenance-check fail
+	exitBlocked = 4
+	// exitValidation is for documents rejected by a local check before upload,
+	// such as constraints, allowed registries or provenance, and for bundles
+	// and binaries that fail verification
+	exitValidation = 5
+)
+
+// exitCategories name the failure category of each exit code, for reports
+// that must not contain the error itself
+var exitCategories = map[int]string{
+	exitUsage:      "usage",
+	exitAuth:       "auth",
+	exitUpload:     "upload",
+	exitBlocked:    "blocked",
+	exitValidation: "validation",
+}
+
+// exitFunc exits the process, replaced in tests
+var exitFunc = os.Exit
+
+// logOutput is the writer logs are written to, set by configureLogging
+var logOutput io.Writer = os.Stderr
+
+// codedError attaches the exit code of its category to an error
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+
+func (e *codedError) Unwrap() error { return e.err }
+
+// withExitCode attaches an exit code to err, which is returned when a run
+// fails because of err. The outermost code attached to an error wins.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// tenantStatusError categorizes an error for an unexpected status code of a
+// tenant request as an authentication failure for 401 and 403
+func tenantStatusError(status int, err error) error {
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return withExitCode(exitAuth, err)
+	}
+	return err
+}
+
+// exitCodeOf returns the exit code attached to err, exitAuth for credentials
+// rejected by the token endpoint, or else fallback
+func exitCodeOf(err error, fallback int) int {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return exitAuth
+	}
+	return fallback
+}
+
+// exitWriter exits with code once a log entry was written
+type exitWriter struct {
+	w    io.Writer
+	code int
+}
+
+func (w exitWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	exitRun(w.code)
+	return n, err
+}
+
+// exitRun exits with code after finishing the run. Every exit of a command goes
+// through it, as os.Exit skips the post run functions.
+func exitRun(code int) {
+	finishRun(code)
+	exitFunc(code)
+}
+
+// finishRun does what the post run functions do for a run that fails with
+// code: it writes the profiles and sends the telemetry report of the run
+func finishRun(code int) {
+	stopProfiling(nil, nil)
+	failTelemetry(code)
+}
+
+// exitHook finishes the run before log.Fatal exits, which can't be redirected
+// to exitRun
+type exitHook struct{}
+
+func (exitHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if level == zerolog.FatalLevel {
+		finishRun(fatalExitCode(e))
+	}
+}
+
+// exitCodeKey is the context key of the exit code of a fatal log entry
+type exitCodeKey struct{}
+
+// fatal starts a fatal log entry like log.Fatal, which always exits with 1,
+// but exits with code once the entry is sent
+func fatal(code int) *zerolog.Event {
+	logger := log.Logger.Output(exitWriter{w: logOutput, code: code})
+	return logger.WithLevel(zerolog.FatalLevel).
+		Ctx(context.WithValue(context.Background(), exitCodeKey{}, code))
+}
+
+// fatalExitCode returns the exit code of a fatal log entry, which is exitUsage
+// for entries of log.Fatal
+func fatalExitCode(e *zerolog.Event) int {
+	if code, ok := e.GetCtx().Value(exitCodeKey{}).(int); ok {
+		return code
+	}
+	return exitUsage
+}
+
+// fatalErr starts a fatal log entry for err that exits with the code of the
+// category of err, or else with fallback
+func fatalErr(err error, fallback int) *zerolog.Event {
+	return fatal(exitCodeOf(err, fallback)).Err(err)
+}
@@ -0,0 +1,108 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+func Test_exitCodeOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{
+			name: "coded error",
+			err:  withExitCode(exitValidation, errors.New("constraint violated")),
+			want: exitValidation,
+		},
+		{
+			name: "wrapped coded error",
+			err:  fmt.Errorf("upload sbom.json: %w", withExitCode(exitAuth, errors.New("forbidden"))),
+			want: exitAuth,
+		},
+		{
+			name: "outermost code wins",
+			err:  withExitCode(exitUpload, withExitCode(exitValidation, errors.New("too large"))),
+			want: exitUpload,
+		},
+		{
+			name: "token endpoint rejection",
+			err:  &url.Error{Op: "Post", URL: "https://auth.example.com/token", Err: &oauth2.RetrieveError{}},
+			want: exitAuth,
+		},
+		{
+			name: "plain error",
+			err:  errors.New("connection refused"),
+			want: exitUpload,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeOf(tt.err, exitUpload); got != tt.want {
+				t.Errorf("exitCodeOf() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_tenantStatusError(t *testing.T) {
+	tests := map[int]int{
+		http.StatusUnauthorized:        exitAuth,
+		http.StatusForbidden:           exitAuth,
+		http.StatusNotFound:            exitUsage,
+		http.StatusInternalServerError: exitUsage,
+	}
+	for status, want := range tests {
+		err := tenantStatusError(status, fmt.Errorf("unexpected response status code: %d", status))
+		if got := exitCodeOf(err, exitUsage); got != want {
+			t.Errorf("exitCodeOf(tenantStatusError(%d)) = %d, want %d", status, got, want)
+		}
+	}
+	if err := withExitCode(exitAuth, nil); err != nil {
+		t.Errorf("withExitCode(nil) = %v, want nil", err)
+	}
+}
+
+func Test_fatal(t *testing.T) {
+	var buf bytes.Buffer
+	exited := -1
+	oldExit, oldOutput, oldLogger := exitFunc, logOutput, log.Logger
+	t.Cleanup(func() { exitFunc, logOutput, log.Logger = oldExit, oldOutput, oldLogger })
+	exitFunc = func(code int) { exited = code }
+	logOutput = &buf
+	log.Logger = zerolog.New(&buf)
+
+	fatalErr(withExitCode(exitBlocked, errors.New("blocked")), exitUsage).Msg("Check failed")
+
+	if exited != exitBlocked {
+		t.Errorf("fatalErr() exited with %d, want %d", exited, exitBlocked)
+	}
+	if out := buf.String(); !strings.Contains(out, `"level":"fatal"`) || !strings.Contains(out, "Check failed") {
+		t.Errorf("fatalErr() logged %q", out)
+	}
+}
@@ -0,0 +1,138 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func newExplainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "explain <file>",
+		Short:  "Show the upload metadata that would be attached to a file and where each value comes from",
+		Args:   cobra.ExactArgs(1),
+		PreRun: bindUploadFlags,
+		Run:    explain,
+	}
+
+	addMetadataFlags(cmd.Flags())
+	addDocumentFlags(cmd.Flags())
+	cmd.Flags().String("base-dir", "", "Directory the file would be uploaded from, used to match routing rules (optional)")
+
+	mustBindPFlag(cmd, "base-dir")
+
+	return cmd
+}
+
+func explain(cmd *cobra.Command, args []string) {
+	filePath := args[0]
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error getting file info")
+	}
+
+	relPath := filePath
+	if baseDir := viper.GetString("base-dir"); baseDir != "" {
+		var err error
+		relPath, err = filepath.Rel(baseDir, filePath)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("File is not below base-dir")
+		}
+	}
+
+	rules, err := loadRoutingRules()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid routing rules")
+	}
+
+	mustValidateCustomMetadata(cmd.Flags())
+	mustValidateDocRefTemplate()
+	meta := resolveUploadMetadata(cmd.Flags(), rules, relPath)
+	addDocumentMetadata(cmd.Flags(), meta, filePath, info.Size())
+	if runID, source := lookupSetting(cmd.Flags(), "run-id"); runID != "" {
+		meta["run_id"] = metadataValue{Value: runID, Source: source}
+	} else {
+		meta["run_id"] = metadataValue{Value: "<generated>", Source: "generated for each run"}
+	}
+
+	printMetadataExplanation(cmd.OutOrStdout(), relPath, meta)
+}
+
+// addDocumentMetadata adds the upload metadata documentMetadata adds to the
+// flag and routing rule metadata of the file at filePath, with the same
+// precedence: the metadata of the file, unless meta already has its keys, and
+// the forced type and format and the content hash recorded when the document
+// ref comes from a template
+func addDocumentMetadata(flags *pflag.FlagSet, meta map[string]metadataValue, filePath string, size int64) {
+	if !viper.GetBool("omit-file-metadata") {
+		for k, v := range fileMetadata(filePath, int(size)) {
+			if _, ok := meta[k]; !ok {
+				meta[k] = metadataValue{Value: v, Source: "file"}
+			}
+		}
+	}
+
+	for _, forced := range []struct{ flag, key string }{{"force-type", "forced_type"}, {"force-format", "forced_format"}} {
+		if value, source := lookupSetting(flags, forced.flag); value != "" {
+			meta[forced.key] = metadataValue{Value: value, Source: source}
+		}
+	}
+
+	if tmpl, source := lookupSetting(flags, "docref-template"); tmpl != "" {
+		if blob, err := os.ReadFile(filePath); err == nil {
+			meta["content_sha256"] = metadataValue{Value: getHash(blob), Source: source}
+		}
+	}
+}
+
+func printMetadataExplanation(w io.Writer, relPath string, meta map[string]metadataValue) {
+	if len(meta) == 0 {
+		fmt.Fprintf(w, "No upload metadata would be attached to %s\n", relPath)
+		return
+	}
+
+	fmt.Fprintf(w, "Upload metadata for %s:\n", relPath)
+
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", k, meta[k].Value, meta[k].Source)
+	}
+	tw.Flush() //nolint:errcheck
+}
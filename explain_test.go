@@ -0,0 +1,137 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func Test_explain(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "services", "api", "sbom.json")
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	rules := []map[string]string{{"pattern": "services/api/", "component-name": "api", "tag": "backend"}}
+
+	tests := []struct {
+		name  string
+		args  []string
+		runID string
+		rules []map[string]string
+		// want is the output with {file} standing for the path of the file
+		want string
+	}{
+		{
+			name: "file metadata and run ID",
+			want: "Upload metadata for {file}:\n" +
+				"KEY             VALUE                 SOURCE\n" +
+				"file_extension  json                  file\n" +
+				"file_mtime      2024-05-01T12:00:00Z  file\n" +
+				"file_size       2                     file\n" +
+				"filename        sbom.json             file\n" +
+				"run_id          <generated>           generated for each run\n",
+		},
+		{
+			name: "run ID only without file metadata",
+			args: []string{"--omit-file-metadata"},
+			want: "Upload metadata for {file}:\n" +
+				"KEY     VALUE        SOURCE\n" +
+				"run_id  <generated>  generated for each run\n",
+		},
+		{
+			name: "forced type and docref template",
+			args: []string{"--omit-file-metadata", "--force-type", "SBOM", "--docref-template", "{artifact_digest}"},
+			want: "Upload metadata for {file}:\n" +
+				"KEY             VALUE                                                             SOURCE\n" +
+				"content_sha256  44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a  flag --docref-template\n" +
+				"forced_type     SBOM                                                              flag --force-type\n" +
+				"run_id          <generated>                                                       generated for each run\n",
+		},
+		{
+			name:  "flags and environment",
+			args:  []string{"--omit-file-metadata", "--alias", "app", "--meta", "team=payments"},
+			runID: "nightly-42",
+			want: "Upload metadata for {file}:\n" +
+				"KEY     VALUE       SOURCE\n" +
+				"alias   app         flag --alias\n" +
+				"run_id  nightly-42  env UPLOADER_RUN_ID\n" +
+				"team    payments    flag --meta\n",
+		},
+		{
+			name:  "routing rule below base-dir",
+			args:  []string{"--omit-file-metadata", "--base-dir", dir, "--tag", "release"},
+			rules: rules,
+			want: "Upload metadata for " + filepath.Join("services", "api", "sbom.json") + ":\n" +
+				"KEY             VALUE        SOURCE\n" +
+				"component_name  api          routing rule \"services/api/\"\n" +
+				"run_id          <generated>  generated for each run\n" +
+				"tag             backend      routing rule \"services/api/\"\n",
+		},
+		{
+			name:  "routing rule needs base-dir",
+			args:  []string{"--omit-file-metadata"},
+			rules: rules,
+			want: "Upload metadata for {file}:\n" +
+				"KEY     VALUE        SOURCE\n" +
+				"run_id  <generated>  generated for each run\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			t.Setenv("UPLOADER_RUN_ID", tt.runID)
+			if tt.rules != nil {
+				viper.Set("routing-rules", tt.rules)
+			}
+
+			var out bytes.Buffer
+			cmd := newExplainCmd()
+			cmd.SetOut(&out)
+			cmd.SetArgs(append([]string{file}, tt.args...))
+			if err := cmd.Execute(); err != nil {
+				t.Fatal(err)
+			}
+
+			if want := strings.ReplaceAll(tt.want, "{file}", file); out.String() != want {
+				t.Errorf("explain output =\n%s\nwant\n%s", out.String(), want)
+			}
+		})
+	}
+}
+
+func Test_printMetadataExplanation_empty(t *testing.T) {
+	var out bytes.Buffer
+	printMetadataExplanation(&out, "sbom.json", nil)
+	if want := "No upload metadata would be attached to sbom.json\n"; out.String() != want {
+		t.Errorf("printMetadataExplanation() = %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,163 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fault* are the faults that can be injected with --inject-fault
+const (
+	faultToken401        = "token-401"
+	faultPresign500      = "presign-500"
+	faultUpload500       = "upload-500"
+	faultUploadTimeout   = "upload-timeout"
+	faultUploadClockSkew = "upload-clock-skew"
+	faultCheck500        = "check-500"
+)
+
+// faultNames lists the faults in the order they are documented
+var faultNames = []string{
+	faultToken401, faultPresign500, faultUpload500, faultUploadTimeout, faultUploadClockSkew, faultCheck500,
+}
+
+// injectedFault is a fault and the fraction of matching requests it fails
+type injectedFault struct {
+	Name string
+	Rate float64
+}
+
+// parseFaults parses --inject-fault values of the form name or name:rate,
+// where rate is the fraction of matching requests to fail
+func parseFaults(values []string) ([]injectedFault, error) {
+	var faults []injectedFault
+	for _, value := range values {
+		name, rateText, hasRate := strings.Cut(strings.TrimSpace(value), ":")
+		known := false
+		for _, n := range faultNames {
+			known = known || n == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown fault %q, supported faults: %s", name, strings.Join(faultNames, ", "))
+		}
+
+		rate := 1.0
+		if hasRate {
+			var err error
+			rate, err = strconv.ParseFloat(rateText, 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("rate of fault %q must be a number in (0, 1]", name)
+			}
+		}
+		faults = append(faults, injectedFault{Name: name, Rate: rate})
+	}
+	return faults, nil
+}
+
+// faultTimeoutError is returned for requests failed by the upload-timeout fault
+type faultTimeoutError struct{}
+
+func (faultTimeoutError) Error() string   { return "injected fault: request timed out" }
+func (faultTimeoutError) Timeout() bool   { return true }
+func (faultTimeoutError) Temporary() bool { return true }
+
+// faultTransport fails requests as selected by the injected faults, so retry
+// and alerting behavior around the uploader can be verified without breaking a
+// real tenant. Requests are matched by their shape: token requests are form
+// POSTs, presign requests are POSTs to /presign, uploads are PUTs and other
+// GETs are tenant API requests.
+type faultTransport struct {
+	base   http.RoundTripper
+	faults []injectedFault
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newFaultTransport(base http.RoundTripper, faults []injectedFault) *faultTransport {
+	return &faultTransport{base: base, faults: faults, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, fault := range t.faults {
+		if !faultMatches(fault.Name, req) || !t.roll(fault.Rate) {
+			continue
+		}
+		if req.Body != nil {
+			req.Body.Close() //nolint:errcheck
+		}
+
+		switch fault.Name {
+		case faultToken401:
+			return faultResponse(req, http.StatusUnauthorized, `{"error": "invalid_client", "error_description": "injected fault"}`), nil
+		case faultUploadTimeout:
+			return nil, faultTimeoutError{}
+		case faultUploadClockSkew:
+			res := faultResponse(req, http.StatusForbidden, "<Error><Code>RequestTimeTooSkewed</Code></Error>")
+			res.Header.Set("Date", time.Now().Add(-15*time.Minute).UTC().Format(http.TimeFormat))
+			return res, nil
+		default:
+			return faultResponse(req, http.StatusInternalServerError, "injected fault"), nil
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// roll reports whether a fault with rate fails the current request
+func (t *faultTransport) roll(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rnd.Float64() < rate
+}
+
+// faultMatches reports whether the fault applies to req
+func faultMatches(name string, req *http.Request) bool {
+	switch name {
+	case faultToken401:
+		return req.Method == http.MethodPost && req.Header.Get("Content-Type") == "application/x-www-form-urlencoded"
+	case faultPresign500:
+		return req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/presign")
+	case faultUpload500, faultUploadTimeout, faultUploadClockSkew:
+		return req.Method == http.MethodPut
+	case faultCheck500:
+		return req.Method == http.MethodGet
+	}
+	return false
+}
+
+func faultResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Body:       io.NopCloser(bytes.NewBufferString(body)),
+		Request:    req,
+	}
+}
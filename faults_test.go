@@ -0,0 +1,86 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseFaults(t *testing.T) {
+	faults, err := parseFaults([]string{"presign-500", " upload-timeout:0.25"})
+	if err != nil {
+		t.Fatalf("parseFaults() error = %v", err)
+	}
+	want := []injectedFault{{Name: faultPresign500, Rate: 1}, {Name: faultUploadTimeout, Rate: 0.25}}
+	if len(faults) != len(want) || faults[0] != want[0] || faults[1] != want[1] {
+		t.Errorf("parseFaults() = %v, want %v", faults, want)
+	}
+
+	for _, value := range []string{"presign-404", "upload-500:0", "upload-500:2", "upload-500:x"} {
+		if _, err := parseFaults([]string{value}); err == nil {
+			t.Errorf("parseFaults(%q) accepted an invalid fault", value)
+		}
+	}
+}
+
+func Test_faultTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	faults, err := parseFaults([]string{faultPresign500, faultUploadClockSkew})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: newFaultTransport(http.DefaultTransport, faults)}
+
+	res, err := client.Post(server.URL+"/presign", "application/json", strings.NewReader("{}"))
+	if err != nil || res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("presign = %v, %v, want an injected 500", res, err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/upload", strings.NewReader("{}"))
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("upload error = %v", err)
+	}
+	body := make([]byte, 100)
+	n, _ := res.Body.Read(body)
+	if skewErr := detectClockSkew(res, body[:n], time.Now()); skewErr == nil || skewErr.Skew < 10*time.Minute {
+		t.Errorf("detectClockSkew() = %v, want the injected clock skew", skewErr)
+	}
+
+	res, err = client.Get(server.URL + "/pico/v1/capabilities")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("tenant request = %v, %v, want it to pass through", res, err)
+	}
+
+	faults, _ = parseFaults([]string{faultUploadTimeout})
+	client = &http.Client{Transport: newFaultTransport(http.DefaultTransport, faults)}
+	req, _ = http.NewRequest(http.MethodPut, server.URL+"/upload", strings.NewReader("{}"))
+	_, err = client.Do(req)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("upload error = %v, want an injected timeout", err)
+	}
+}
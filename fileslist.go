@@ -0,0 +1,87 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kusaridev/kusari-uploader/pkg/source"
+)
+
+// loadFileList reads the file list given with --files-from, where "-" is stdin
+func loadFileList(path string, nul bool) ([]string, error) {
+	if path == "-" {
+		return readFileList(os.Stdin, nul)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file list %s: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck
+	return readFileList(f, nul)
+}
+
+// readFileList reads newline delimited paths from r, or NUL delimited paths
+// when nul is set, as written by find -print0. Empty entries are ignored.
+func readFileList(r io.Reader, nul bool) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	if nul {
+		scanner.Split(scanNUL)
+	}
+
+	var paths []string
+	for scanner.Scan() {
+		path := scanner.Text()
+		if !nul {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+	return paths, nil
+}
+
+// scanNUL is a bufio.SplitFunc that splits on NUL bytes
+func scanNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// uploadFileList uploads each of the listed files. Directories are skipped, so
+// the output of find can be used without -type f. Routing rules are matched
+// against the paths as listed.
+func uploadFileList(authorizedClient, defaultClient HttpClient, tenantApiEndpoint string, paths []string,
+	uploadMeta map[string]string, rules []routingRule, results *resultStream) ([]sbomSubjectAndURI, error) {
+	return uploadSource(authorizedClient, defaultClient, tenantApiEndpoint, source.NewList(paths), uploadMeta, rules, results)
+}
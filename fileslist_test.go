@@ -0,0 +1,59 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_readFileList(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		nul   bool
+		want  []string
+	}{
+		{
+			name:  "newline delimited",
+			input: "a.json\nsub dir/b.json\r\n\nc.json",
+			want:  []string{"a.json", "sub dir/b.json", "c.json"},
+		},
+		{
+			name:  "NUL delimited keeps newlines in names",
+			input: "a.json\x00odd\nname.json\x00\x00c.json\x00",
+			nul:   true,
+			want:  []string{"a.json", "odd\nname.json", "c.json"},
+		},
+		{
+			name:  "empty",
+			input: "",
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFileList(strings.NewReader(tt.input), tt.nul)
+			if err != nil {
+				t.Fatalf("readFileList() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readFileList() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// documentTypes are the document types that can be forced with --force-type
+var documentTypes = []DocumentType{DocumentSBOM, DocumentOpenVEX}
+
+// formatNames maps the values of --force-format to document formats
+var formatNames = map[string]FormatType{
+	"json":  FormatJSON,
+	"jsonl": FormatJSONLines,
+	"xml":   FormatXML,
+}
+
+// parseForceType parses a --force-type value, ignoring case
+func parseForceType(s string) (DocumentType, error) {
+	t := DocumentType(strings.ToUpper(s))
+	if !slices.Contains(documentTypes, t) {
+		return "", fmt.Errorf("unknown document type %q, supported types: %v", s, documentTypes)
+	}
+	return t, nil
+}
+
+// parseForceFormat parses a --force-format value, ignoring case
+func parseForceFormat(s string) (FormatType, error) {
+	f, ok := formatNames[strings.ToLower(s)]
+	if !ok {
+		return "", fmt.Errorf("unknown document format %q, supported formats: json, jsonl, xml", s)
+	}
+	return f, nil
+}
+
+// mustResolveForceFlags validates --force-type and --force-format and records
+// their normalized values as the settings used by uploads
+func mustResolveForceFlags() {
+	if s := viper.GetString("force-type"); s != "" {
+		t, err := parseForceType(s)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Invalid force-type")
+		}
+		viper.Set("force-type", string(t))
+	}
+	if s := viper.GetString("force-format"); s != "" {
+		f, err := parseForceFormat(s)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Invalid force-format")
+		}
+		viper.Set("force-format", string(f))
+	}
+}
+
+// documentTypeAndFormat returns the type and format to upload a document as,
+// which are forced if --force-type or --force-format are set
+func documentTypeAndFormat(isOpenVex bool) (DocumentType, FormatType) {
+	doctype := DocumentSBOM
+	if isOpenVex {
+		doctype = DocumentOpenVEX
+	}
+	if t := viper.GetString("force-type"); t != "" {
+		doctype = DocumentType(t)
+	}
+
+	format := FormatUnknown
+	if f := viper.GetString("force-format"); f != "" {
+		format = FormatType(f)
+	}
+	return doctype, format
+}
+
+// forcedMetadata returns the upload metadata recording the forced type and
+// format, so overrides of the auto-detection can be traced
+func forcedMetadata() map[string]string {
+	meta := map[string]string{}
+	if t := viper.GetString("force-type"); t != "" {
+		meta["forced_type"] = t
+	}
+	if f := viper.GetString("force-format"); f != "" {
+		meta["forced_format"] = f
+	}
+	return meta
+}
@@ -0,0 +1,65 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_parseForceType(t *testing.T) {
+	if got, err := parseForceType("open_vex"); err != nil || got != DocumentOpenVEX {
+		t.Errorf("parseForceType() = %v, %v, want %v", got, err, DocumentOpenVEX)
+	}
+	if _, err := parseForceType("SPDX"); err == nil {
+		t.Errorf("parseForceType() accepted an unknown type")
+	}
+}
+
+func Test_parseForceFormat(t *testing.T) {
+	if got, err := parseForceFormat("JSONL"); err != nil || got != FormatJSONLines {
+		t.Errorf("parseForceFormat() = %v, %v, want %v", got, err, FormatJSONLines)
+	}
+	if _, err := parseForceFormat("yaml"); err == nil {
+		t.Errorf("parseForceFormat() accepted an unknown format")
+	}
+}
+
+func Test_documentTypeAndFormat(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	if doctype, format := documentTypeAndFormat(true); doctype != DocumentOpenVEX || format != FormatUnknown {
+		t.Errorf("documentTypeAndFormat() = %v, %v, want the detected type and format", doctype, format)
+	}
+	if meta := forcedMetadata(); len(meta) != 0 {
+		t.Errorf("forcedMetadata() = %v, want none", meta)
+	}
+
+	viper.Set("force-type", "sbom")
+	viper.Set("force-format", "xml")
+	mustResolveForceFlags()
+
+	if doctype, format := documentTypeAndFormat(true); doctype != DocumentSBOM || format != FormatXML {
+		t.Errorf("documentTypeAndFormat() = %v, %v, want the forced type and format", doctype, format)
+	}
+	want := map[string]string{"forced_type": "SBOM", "forced_format": "XML"}
+	if meta := forcedMetadata(); !reflect.DeepEqual(meta, want) {
+		t.Errorf("forcedMetadata() = %v, want %v", meta, want)
+	}
+}
@@ -0,0 +1,83 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/oauth2"
+)
+
+// authGCP exchanges a Google identity token of the workload's service account
+// for a Kusari token, for uploads in GKE, Cloud Build, Cloud Run and on Compute
+// Engine
+const authGCP = "gcp"
+
+// gcpProvider exchanges the service account of the workload for tokens
+type gcpProvider struct{}
+
+func (gcpProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	return newTokenExchangeSource(ctx, tokenURL, creds[0].ID, "Google identity token", gcpIdentityToken(workloadIdentityAudience(tokenURL)))
+}
+
+func (gcpProvider) credentials() credentialNeed { return credentialsNone }
+
+func (gcpProvider) interactive() bool { return false }
+
+func (gcpProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string {
+	return "GCP service account of the metadata server " + gcpMetadataHost()
+}
+
+// gcpMetadataHost returns GCE_METADATA_HOST, or else the metadata server of
+// Google Cloud
+func gcpMetadataHost() string {
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		return host
+	}
+	return "metadata.google.internal"
+}
+
+// gcpIdentityToken returns a subjectTokenFunc getting an identity token for
+// audience from the metadata server. With Workload Identity Federation for GKE
+// the token is of the service account of the Kubernetes service account.
+func gcpIdentityToken(audience string) subjectTokenFunc {
+	return func(ctx context.Context, client HttpClient) (string, error) {
+		u := url.URL{
+			Scheme:   "http",
+			Host:     gcpMetadataHost(),
+			Path:     "/computeMetadata/v1/instance/service-accounts/default/identity",
+			RawQuery: url.Values{"audience": {audience}, "format": {"full"}}.Encode(),
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		token, err := getMetadataText(client, req)
+		if err != nil {
+			return "", err
+		}
+		if token == "" {
+			return "", errors.New("the metadata server issued no identity token")
+		}
+		return token, nil
+	}
+}
@@ -0,0 +1,72 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_gcpProvider_tokenSource(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("workload-identity-audience", "https://kusari.example.com")
+
+	exchanged := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/identity":
+			if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Query().Get("audience") != "https://kusari.example.com" ||
+				r.URL.Query().Get("format") != "full" {
+				t.Errorf("unexpected metadata request %s %v", r.URL, r.Header)
+			}
+			_, _ = w.Write([]byte("google-identity-token"))
+		case "/token":
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			exchanged = r.PostForm.Get("subject_token")
+			_, _ = w.Write([]byte(`{"access_token": "kusari-token", "token_type": "Bearer", "expires_in": 300}`))
+		}
+	}))
+	defer server.Close()
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	token, err := gcpProvider{}.tokenSource(context.Background(), server.URL+"/token", []clientCredential{{Name: "primary"}}).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "kusari-token" || exchanged != "google-identity-token" {
+		t.Errorf("Token() = %q after exchanging %q, want the identity token exchanged", token.AccessToken, exchanged)
+	}
+}
+
+func Test_gcpIdentityToken_noServiceAccount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	if _, err := gcpIdentityToken("https://kusari.example.com")(context.Background(), http.DefaultClient); err == nil {
+		t.Error("gcpIdentityToken() succeeded without a service account")
+	}
+}
@@ -0,0 +1,170 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kusaridev/kusari-uploader/pkg/source"
+	"golang.org/x/sync/semaphore"
+)
+
+// pendingUpload is a file to upload with its upload metadata
+type pendingUpload struct {
+	path string
+	meta map[string]string
+	// read returns the content of the file, nil reads path from disk
+	read func(ctx context.Context) ([]byte, error)
+}
+
+// load returns the content of the file
+func (file pendingUpload) load(ctx context.Context) ([]byte, error) {
+	if file.read != nil {
+		return file.read(ctx)
+	}
+	return os.ReadFile(file.path)
+}
+
+// hashedFile is a file read and hashed ahead of its upload
+type hashedFile struct {
+	pendingUpload
+	blob   []byte
+	sha256 string
+	docRef string
+	err    error
+}
+
+// hashAhead reads and hashes the documents of src on up to workers
+// goroutines while the caller uploads the files hashed before, overlapping the
+// CPU bound hashing with the network bound uploads. Files are delivered in the
+// order of the source with the metadata returned by meta for their relative
+// path, and at most 2*workers files are read ahead of the caller so memory
+// stays bounded. An error from the source is delivered as the last file. Cancel
+// ctx to stop reading when the caller gives up early.
+func hashAhead(ctx context.Context, src source.Source, meta func(relPath string) map[string]string, workers int) <-chan hashedFile {
+	sem := semaphore.NewWeighted(int64(workers))
+	queue := make(chan chan hashedFile, workers)
+	out := make(chan hashedFile)
+
+	go func() {
+		defer close(queue)
+		for {
+			item, err := src.Next(ctx)
+			if err == io.EOF {
+				return
+			}
+			file := pendingUpload{path: item.Path, meta: meta(item.RelPath), read: item.Content}
+			res := make(chan hashedFile, 1)
+			select {
+			case queue <- res:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				res <- hashedFile{pendingUpload: file, err: err}
+				return
+			}
+			go func() {
+				blob, sum, ref, err := readAndHash(ctx, sem, file)
+				res <- hashedFile{pendingUpload: file, blob: blob, sha256: sum, docRef: ref, err: err}
+			}()
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		for res := range queue {
+			select {
+			case out <- <-res:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// uploadSource uploads the documents of src in order, hashing them ahead
+// on --hash-concurrency workers, and stops at the first failure other than of
+// files set aside by the quarantine policy. Each document
+// gets uploadMeta with the routing rules matching its relative path applied.
+// Empty files are skipped. The outcome of each file is written to results as
+// soon as it completes.
+func uploadSource(authorizedClient, defaultClient HttpClient, tenantApiEndpoint string, src source.Source,
+	uploadMeta map[string]string, rules []routingRule, results *resultStream) ([]sbomSubjectAndURI, error) {
+	limits, err := resolvePhaseLimits()
+	if err != nil {
+		return nil, err
+	}
+	pacing, err := resolvePacingWindow()
+	if err != nil {
+		return nil, err
+	}
+	quarantine, err := resolveQuarantinePolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var ssaus []sbomSubjectAndURI
+	meta := func(relPath string) map[string]string {
+		return applyRoutingRules(rules, relPath, uploadMeta)
+	}
+	for file := range hashAhead(ctx, src, meta, limits.Hash) {
+		if file.err != nil && file.path == "" {
+			return ssaus, file.err
+		}
+		if file.err != nil {
+			results.write(fileResult{Path: file.path, Status: resultFailed, Error: file.err.Error()})
+			return ssaus, fmt.Errorf("error reading file: %s, err: %w", file.path, file.err)
+		}
+		if len(file.blob) == 0 {
+			results.write(fileResult{Path: file.path, Status: resultSkipped})
+			ssaus = append(ssaus, sbomSubjectAndURI{})
+			continue
+		}
+
+		if err := pacing.wait(ctx); err != nil {
+			return ssaus, err
+		}
+		var ssau sbomSubjectAndURI
+		err := quarantine.run(ctx, file.path, file.blob, authorizedClient, defaultClient, func(authorizedClient, defaultClient HttpClient) error {
+			var err error
+			ssau, err = uploadHashedBlob(authorizedClient, defaultClient, tenantApiEndpoint, file.path, file.blob, file.sha256, file.docRef, false, file.meta)
+			return err
+		})
+		var quarantined *quarantinedError
+		if errors.As(err, &quarantined) {
+			results.write(fileResult{Path: file.path, Status: resultQuarantined, DocumentRef: file.docRef, Error: quarantined.Error()})
+			ssaus = append(ssaus, sbomSubjectAndURI{})
+			continue
+		}
+		if err != nil {
+			results.write(fileResult{Path: file.path, Status: resultFailed, Error: err.Error()})
+			return ssaus, fmt.Errorf("uploadSingleFile failed with error: %w", err)
+		}
+		results.write(fileResult{Path: file.path, Status: resultUploaded, DocumentRef: ssau.docRef})
+		ssaus = append(ssaus, ssau)
+	}
+	return ssaus, nil
+}
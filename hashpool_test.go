@@ -0,0 +1,122 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kusaridev/kusari-uploader/pkg/source"
+)
+
+func Test_hashAhead(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for i := 0; i < 50; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%02d.json", i))
+		if err := os.WriteFile(path, []byte(fmt.Sprintf(`{"n": %d}`, i)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	files = append(files, filepath.Join(dir, "missing.json"))
+	noMeta := func(string) map[string]string { return nil }
+
+	i := 0
+	for file := range hashAhead(context.Background(), source.NewList(files), noMeta, 4) {
+		if file.path != files[i] {
+			t.Fatalf("hashAhead() file %d = %s, want %s in order", i, file.path, files[i])
+		}
+		if i < 50 {
+			if want := getDocRef([]byte(fmt.Sprintf(`{"n": %d}`, i))); file.err != nil || file.docRef != want {
+				t.Errorf("hashAhead() %s = %s, %v, want %s", file.path, file.docRef, file.err, want)
+			}
+		} else if file.err == nil {
+			t.Errorf("hashAhead() %s expected an error for a missing file", file.path)
+		}
+		i++
+	}
+	if i != len(files) {
+		t.Errorf("hashAhead() returned %d files, want %d", i, len(files))
+	}
+
+	// stopping early does not block
+	ctx, cancel := context.WithCancel(context.Background())
+	<-hashAhead(ctx, source.NewList(files), noMeta, 2)
+	cancel()
+}
+
+func Test_uploadSource(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a.json", "empty.json", "b.json", "c.json"} {
+		content := `{"name": "` + name + `"}`
+		if name == "empty.json" {
+			content = ""
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+
+	authClient := &ClientMock{
+		PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(`{"presignedUrl": "http://example.com/upload"}`))}, nil
+		},
+	}
+	var uploaded []string
+	uploadClient := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(req.Body)
+			status := http.StatusOK
+			if strings.Contains(string(body), "c.json") {
+				status = http.StatusInternalServerError
+			}
+			uploaded = append(uploaded, string(body))
+			return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		},
+	}
+
+	var out bytes.Buffer
+	results := newResultStream(&out, "run")
+
+	ssaus, err := uploadSource(authClient, uploadClient, "http://example.com", source.NewList(files), map[string]string{"run_id": "run"}, nil, results)
+	if err == nil {
+		t.Fatal("uploadSource() expected the failed upload of c.json to stop the run")
+	}
+	if len(ssaus) != 3 {
+		t.Errorf("uploadSource() returned %d SBOMs, want the 3 files before the failure", len(ssaus))
+	}
+	for i, name := range []string{"a.json", "b.json", "c.json"} {
+		if i >= len(uploaded) || !strings.Contains(uploaded[i], name) {
+			t.Errorf("upload %d = %.60q, want %s uploaded in order", i, uploaded, name)
+		}
+	}
+	for _, want := range []string{`"status":"uploaded"`, `"status":"skipped"`, `"status":"failed"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("results %q do not contain %s", out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// authKubernetes exchanges the service account token of the pod for a Kusari
+// token, for uploads running in a cluster
+const authKubernetes = "kubernetes"
+
+// kubernetesProvider exchanges the service account token of the pod for
+// tokens, sending the client ID if one is set
+type kubernetesProvider struct{}
+
+func (kubernetesProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	return newKubernetesTokenSource(ctx, tokenURL, creds[0].ID)
+}
+
+func (kubernetesProvider) credentials() credentialNeed { return credentialsNone }
+
+func (kubernetesProvider) interactive() bool { return false }
+
+func (kubernetesProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string {
+	return "Kubernetes service account token " + serviceAccountTokenFile()
+}
+
+// defaultServiceAccountTokenFile is where Kubernetes mounts the token of the
+// pod's service account
+const defaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// serviceAccountTokenFile returns --service-account-token-file, or else the
+// token Kubernetes mounts for the pod's service account
+func serviceAccountTokenFile() string {
+	if file := viper.GetString("service-account-token-file"); file != "" {
+		return file
+	}
+	return defaultServiceAccountTokenFile
+}
+
+// newKubernetesTokenSource exchanges the service account token of the pod
+// for Kusari tokens. The file is read for every exchange, as the kubelet
+// rotates projected tokens before they expire.
+func newKubernetesTokenSource(ctx context.Context, tokenURL, clientID string) *tokenExchangeSource {
+	return newTokenExchangeSource(ctx, tokenURL, clientID, "service account token",
+		readSubjectTokenFile(serviceAccountTokenFile(), "service account token"))
+}
@@ -0,0 +1,147 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// writeServiceAccountToken writes token to a file and points
+// --service-account-token-file at it
+func writeServiceAccountToken(t *testing.T, token string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte(token), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("service-account-token-file", file)
+	return file
+}
+
+func Test_kubernetesTokenSource_Token(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	var subjects []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.PostForm.Get("grant_type") != tokenExchangeGrant || r.PostForm.Get("subject_token_type") != jwtTokenType ||
+			r.PostForm.Get("requested_token_type") != accessTokenType || r.PostForm.Get("client_id") != "uploader" {
+			t.Errorf("unexpected token exchange form %v", r.PostForm)
+		}
+		subjects = append(subjects, r.PostForm.Get("subject_token"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "kusari-token", "token_type": "Bearer", "expires_in": 300}`))
+	}))
+	defer server.Close()
+
+	file := writeServiceAccountToken(t, "sa-token-1\n")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	source := newKubernetesTokenSource(context.Background(), server.URL, "uploader")
+	source.now = func() time.Time { return now }
+
+	token, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "kusari-token" || !token.Expiry.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("Token() = %+v, want kusari-token expiring in 5 minutes", token)
+	}
+
+	// the kubelet rotated the projected token
+	if err := os.WriteFile(file, []byte("sa-token-2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !source.forceRefresh() {
+		t.Fatal("forceRefresh() = false, want true")
+	}
+	if _, err := source.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if len(subjects) != 2 || subjects[0] != "sa-token-1" || subjects[1] != "sa-token-2" {
+		t.Errorf("exchanged subject tokens %v, want the token read again for every exchange", subjects)
+	}
+}
+
+func Test_kubernetesTokenSource_Token_errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		body   string
+		want   int
+	}{
+		{name: "rejected", token: "sa-token", status: http.StatusBadRequest, body: `{"error": "invalid_grant", "error_description": "untrusted issuer"}`, want: exitAuth},
+		{name: "empty token file", token: " \n", status: http.StatusOK, want: exitAuth},
+		{name: "no access token", token: "sa-token", status: http.StatusOK, body: `{}`, want: exitUsage},
+		{name: "server error", token: "sa-token", status: http.StatusInternalServerError, want: exitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			writeServiceAccountToken(t, tt.token)
+			_, err := newKubernetesTokenSource(context.Background(), server.URL, "").Token()
+			if err == nil {
+				t.Fatal("Token() succeeded, want an error")
+			}
+			if code := exitCodeOf(err, exitUsage); code != tt.want {
+				t.Errorf("exit code = %d, want %d: %v", code, tt.want, err)
+			}
+		})
+	}
+}
+
+func Test_kubernetesTokenSource_Token_missingFile(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("service-account-token-file", filepath.Join(t.TempDir(), "missing"))
+
+	_, err := newKubernetesTokenSource(context.Background(), "http://127.0.0.1:0", "").Token()
+	if code := exitCodeOf(err, exitUsage); err == nil || code != exitAuth {
+		t.Errorf("Token() = %v with exit code %d, want an auth error", err, code)
+	}
+}
+
+func Test_serviceAccountTokenFile(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	if got := serviceAccountTokenFile(); got != defaultServiceAccountTokenFile {
+		t.Errorf("serviceAccountTokenFile() = %q, want the mounted token", got)
+	}
+	viper.Set("service-account-token-file", "/var/run/secrets/tokens/kusari")
+	if got := serviceAccountTokenFile(); got != "/var/run/secrets/tokens/kusari" {
+		t.Errorf("serviceAccountTokenFile() = %q, want the flag", got)
+	}
+}
@@ -0,0 +1,222 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// keyringService is the service the uploader's keyring entries are stored under
+const keyringService = "kusari-uploader"
+
+var (
+	// errKeyringNotFound is returned for keyring entries that don't exist
+	errKeyringNotFound = errors.New("not found in the keyring")
+	// errKeyringUnavailable is returned when the OS has no keyring the
+	// uploader can use
+	errKeyringUnavailable = errors.New("no OS keyring available")
+)
+
+// keyring stores secrets in the OS keyring: the macOS Keychain, the Windows
+// Credential Manager, or a Secret Service such as GNOME Keyring or KWallet
+type keyring interface {
+	Get(account string) (string, error)
+	Set(account, secret string) error
+	Delete(account string) error
+}
+
+// openKeyring returns the keyring of the OS, or errKeyringUnavailable
+var openKeyring = openSystemKeyring
+
+// keyringSecrets memoizes client secrets looked up in the keyring, as a run
+// needs them several times and each lookup may start a process
+var keyringSecrets sync.Map
+
+// clientSecretAccount is the keyring account of the client secret of clientID
+func clientSecretAccount(clientID string) string {
+	return "client-secret/" + clientID
+}
+
+// clientSecret returns --client-secret, or the secret read from
+// --client-secret-file, or else the secret stored for --client-id with auth
+// secret set, unless --keyring is off
+func clientSecret() string {
+	secretFile := viper.GetString("client-secret-file")
+	if secret := viper.GetString("client-secret"); secret != "" {
+		if secretFile != "" {
+			fatal(exitUsage).Msg("client-secret and client-secret-file can't be used together")
+		}
+		return secret
+	}
+	if secretFile != "" {
+		secret, err := readSecretFile(secretFile)
+		if err != nil {
+			fatalErr(err, exitUsage).
+				Msg("Failed to read client-secret-file")
+		}
+		return secret
+	}
+	clientID := viper.GetString("client-id")
+	if clientID == "" || !viper.GetBool("keyring") {
+		return ""
+	}
+	if secret, ok := keyringSecrets.Load(clientID); ok {
+		return secret.(string)
+	}
+
+	secret := ""
+	if kr, err := openKeyring(); err == nil {
+		secret, err = kr.Get(clientSecretAccount(clientID))
+		if err != nil && !errors.Is(err, errKeyringNotFound) {
+			log.Warn().
+				Err(err).
+				Msg("Failed to read the client secret from the keyring")
+		}
+	}
+	keyringSecrets.Store(clientID, secret)
+	return secret
+}
+
+// keyringStore is a stateStore in the keyring, for small secrets such as the
+// tokens of --token-cache
+type keyringStore struct {
+	keyring keyring
+	prefix  string
+}
+
+func (s *keyringStore) Get(ctx context.Context, key string) ([]byte, error) {
+	secret, err := s.keyring.Get(s.prefix + key)
+	if errors.Is(err, errKeyringNotFound) {
+		return nil, fmt.Errorf("%s: %w", key, errStateNotFound)
+	}
+	return []byte(secret), err
+}
+
+func (s *keyringStore) Put(ctx context.Context, key string, data []byte) error {
+	return s.keyring.Set(s.prefix+key, string(data))
+}
+
+func newKeyringSecretCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Store the client secret of client-id in the OS keyring",
+		Long: "Store client secrets in the macOS Keychain, the Windows Credential Manager or a Secret Service " +
+			"instead of shell history or env files. Runs with client-id and no client-secret use the stored secret.",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set",
+		Short: "Read the client secret of client-id from stdin and store it in the keyring",
+		Args:  cobra.NoArgs,
+		Run:   setKeyringSecretCmd,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete",
+		Short: "Remove the client secret of client-id from the keyring",
+		Args:  cobra.NoArgs,
+		Run:   deleteKeyringSecretCmd,
+	})
+	return cmd
+}
+
+func setKeyringSecretCmd(cmd *cobra.Command, args []string) {
+	clientID := viper.GetString("client-id")
+	if clientID == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id")
+	}
+	kr, err := openKeyring()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to open the keyring")
+	}
+
+	secret, err := readSecret(os.Stdin, os.Stderr, "Client secret for "+clientID+": ")
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to read the client secret")
+	}
+	if secret == "" {
+		log.Fatal().Msg("The client secret is empty")
+	}
+	if err := kr.Set(clientSecretAccount(clientID), secret); err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to store the client secret")
+	}
+	fmt.Fprintf(os.Stderr, "Stored the client secret of %s in the keyring\n", clientID)
+}
+
+func deleteKeyringSecretCmd(cmd *cobra.Command, args []string) {
+	clientID := viper.GetString("client-id")
+	if clientID == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id")
+	}
+	kr, err := openKeyring()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to open the keyring")
+	}
+	if err := kr.Delete(clientSecretAccount(clientID)); err != nil && !errors.Is(err, errKeyringNotFound) {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to remove the client secret")
+	}
+	fmt.Fprintf(os.Stderr, "Removed the client secret of %s from the keyring\n", clientID)
+}
+
+// readSecret reads one line from in. When in is a terminal the prompt is
+// written to out and, outside of Windows, the input is not echoed.
+func readSecret(in *os.File, out io.Writer, prompt string) (string, error) {
+	if isTerminal(in) {
+		fmt.Fprint(out, prompt)
+		if runtime.GOOS != "windows" {
+			if err := stty(in, "-echo"); err == nil {
+				defer func() {
+					stty(in, "echo") //nolint:errcheck
+					fmt.Fprintln(out)
+				}()
+			}
+		}
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stty changes the mode of the terminal in
+func stty(in *os.File, mode string) error {
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = in
+	return cmd.Run()
+}
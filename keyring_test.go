@@ -0,0 +1,148 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// memoryKeyring is a keyring in a map
+type memoryKeyring map[string]string
+
+func (k memoryKeyring) Get(account string) (string, error) {
+	secret, ok := k[account]
+	if !ok {
+		return "", errKeyringNotFound
+	}
+	return secret, nil
+}
+
+func (k memoryKeyring) Set(account, secret string) error {
+	k[account] = secret
+	return nil
+}
+
+func (k memoryKeyring) Delete(account string) error {
+	if _, ok := k[account]; !ok {
+		return errKeyringNotFound
+	}
+	delete(k, account)
+	return nil
+}
+
+// useMemoryKeyring replaces the OS keyring for the test
+func useMemoryKeyring(t *testing.T) memoryKeyring {
+	t.Helper()
+	kr := memoryKeyring{}
+	openKeyring = func() (keyring, error) { return kr, nil }
+	t.Cleanup(func() {
+		openKeyring = openSystemKeyring
+		keyringSecrets.Clear()
+	})
+	return kr
+}
+
+func Test_clientSecret(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	kr := useMemoryKeyring(t)
+	kr[clientSecretAccount("stored")] = "from-keyring"
+
+	viper.Set("keyring", true)
+	viper.Set("client-id", "stored")
+	if got := clientSecret(); got != "from-keyring" {
+		t.Errorf("clientSecret() = %q, want the secret stored in the keyring", got)
+	}
+	if !hasClientCredentials() {
+		t.Error("hasClientCredentials() = false with a secret in the keyring")
+	}
+
+	viper.Set("client-secret", "from-flag")
+	if got := clientSecret(); got != "from-flag" {
+		t.Errorf("clientSecret() = %q, want the flag to win over the keyring", got)
+	}
+
+	viper.Set("client-secret", "")
+	viper.Set("client-id", "unknown")
+	if got := clientSecret(); got != "" {
+		t.Errorf("clientSecret() = %q for a client ID without a stored secret, want none", got)
+	}
+
+	viper.Set("client-id", "stored")
+	viper.Set("keyring", false)
+	if got := clientSecret(); got != "" {
+		t.Errorf("clientSecret() = %q with keyring off, want none", got)
+	}
+}
+
+func Test_keyringStore(t *testing.T) {
+	store := &keyringStore{keyring: memoryKeyring{}, prefix: "token/"}
+	ctx := context.Background()
+
+	if _, err := store.Get(ctx, "a.json"); !errors.Is(err, errStateNotFound) {
+		t.Errorf("Get() of a missing key error = %v, want errStateNotFound", err)
+	}
+	if err := store.Put(ctx, "a.json", []byte(`{"access_token":"x"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Get(ctx, "a.json"); err != nil || string(got) != `{"access_token":"x"}` {
+		t.Errorf("Get() = %s, %v, want the stored data", got, err)
+	}
+	if _, ok := store.keyring.(memoryKeyring)["token/a.json"]; !ok {
+		t.Errorf("Put() stored %v, want the account token/a.json", store.keyring)
+	}
+}
+
+func Test_newTokenCache_keyring(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	useMemoryKeyring(t)
+	viper.Set("keyring", true)
+
+	upstream := &countingTokenSource{expiry: time.Now().Add(time.Hour)}
+	creds := []clientCredential{{Name: "primary", ID: "id", Secret: "secret"}}
+	for range 2 {
+		if _, err := newTokenCache(upstream, "https://auth.us.kusari.cloud/oauth2/token", creds).Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if upstream.calls != 1 {
+		t.Errorf("newTokenCache() fetched %d tokens, want the keyring to keep the first", upstream.calls)
+	}
+}
+
+func Test_readSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("s3cr3t\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	if got, err := readSecret(f, nil, "Client secret: "); err != nil || got != "s3cr3t" {
+		t.Errorf("readSecret() = %q, %v, want s3cr3t", got, err)
+	}
+}
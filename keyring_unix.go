@@ -0,0 +1,130 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// execKeyring stores secrets with the security tool of macOS, or with the
+// secret-tool of libsecret for the Secret Service elsewhere. Secrets are
+// passed on stdin, never as arguments other processes could see.
+type execKeyring struct {
+	// tool is security or secret-tool
+	tool string
+	// run runs tool with args and stdin, returning its stdout
+	run func(tool string, args []string, stdin string) (string, error)
+}
+
+func openSystemKeyring() (keyring, error) {
+	tool := "secret-tool"
+	if runtime.GOOS == "darwin" {
+		tool = "security"
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil, fmt.Errorf("%w: %s not found", errKeyringUnavailable, tool)
+	}
+	return &execKeyring{tool: tool, run: runKeyringTool}, nil
+}
+
+func runKeyringTool(tool string, args []string, stdin string) (string, error) {
+	cmd := exec.Command(tool, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return stdout.String(), fmt.Errorf("%s: %w: %s", tool, err, strings.TrimSpace(stderr.String()))
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+func (k *execKeyring) Get(account string) (string, error) {
+	if k.tool == "security" {
+		out, err := k.run(k.tool, []string{"find-generic-password", "-s", keyringService, "-a", account, "-w"}, "")
+		if err != nil {
+			// security exits with 44 for items that don't exist
+			if toolExitCode(err) == 44 {
+				return "", errKeyringNotFound
+			}
+			return "", err
+		}
+		// secrets are stored base64 encoded, so security prints them as is
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(out))
+		return string(secret), err
+	}
+
+	out, err := k.run(k.tool, []string{"lookup", "service", keyringService, "account", account}, "")
+	// secret-tool exits with 1 and prints nothing for items that don't exist
+	if out == "" && (err == nil || toolExitCode(err) == 1) {
+		return "", errKeyringNotFound
+	}
+	return out, err
+}
+
+func (k *execKeyring) Set(account, secret string) error {
+	if k.tool == "security" {
+		// security -i reads the command from stdin, and -X takes the secret
+		// hex encoded so it needs no quoting
+		encoded := hex.EncodeToString([]byte(base64.StdEncoding.EncodeToString([]byte(secret))))
+		command := fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n", keyringService, shellQuote(account), encoded)
+		_, err := k.run(k.tool, []string{"-i"}, command)
+		return err
+	}
+
+	_, err := k.run(k.tool, []string{"store", "--label", keyringService + " " + account, "service", keyringService, "account", account}, secret)
+	return err
+}
+
+func (k *execKeyring) Delete(account string) error {
+	if k.tool == "security" {
+		_, err := k.run(k.tool, []string{"delete-generic-password", "-s", keyringService, "-a", account}, "")
+		if toolExitCode(err) == 44 {
+			return errKeyringNotFound
+		}
+		return err
+	}
+
+	_, err := k.run(k.tool, []string{"clear", "service", keyringService, "account", account}, "")
+	return err
+}
+
+// toolExitCode returns the exit code of a keyring tool that failed, or 0
+func toolExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return 0
+}
+
+// shellQuote quotes s for the command line of security -i
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,103 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_execKeyring(t *testing.T) {
+	// a command that fails with the given exit code, to get an *exec.ExitError
+	exitErr := func(code string) error {
+		return exec.Command("sh", "-c", "exit "+code).Run()
+	}
+
+	t.Run("secret-tool", func(t *testing.T) {
+		stored := map[string]string{}
+		var calls [][]string
+		k := &execKeyring{tool: "secret-tool", run: func(tool string, args []string, stdin string) (string, error) {
+			calls = append(calls, args)
+			account := args[len(args)-1]
+			switch args[0] {
+			case "store":
+				stored[account] = stdin
+			case "lookup":
+				if secret, ok := stored[account]; ok {
+					return secret, nil
+				}
+				return "", exitErr("1")
+			case "clear":
+				delete(stored, account)
+			}
+			return "", nil
+		}}
+
+		if _, err := k.Get("client-secret/id"); !errors.Is(err, errKeyringNotFound) {
+			t.Errorf("Get() of a missing secret error = %v, want errKeyringNotFound", err)
+		}
+		if err := k.Set("client-secret/id", "s3cr3t"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := k.Get("client-secret/id"); err != nil || got != "s3cr3t" {
+			t.Errorf("Get() = %q, %v, want s3cr3t", got, err)
+		}
+		if err := k.Delete("client-secret/id"); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"store", "--label", "kusari-uploader client-secret/id", "service", "kusari-uploader", "account", "client-secret/id"}
+		if !reflect.DeepEqual(calls[1], want) {
+			t.Errorf("Set() ran secret-tool %v, want %v with the secret on stdin", calls[1], want)
+		}
+	})
+
+	t.Run("security", func(t *testing.T) {
+		var command string
+		k := &execKeyring{tool: "security", run: func(tool string, args []string, stdin string) (string, error) {
+			switch args[0] {
+			case "-i":
+				command = stdin
+			case "find-generic-password":
+				if command == "" {
+					return "", exitErr("44")
+				}
+				fields := strings.Fields(command)
+				encoded, _ := hex.DecodeString(fields[len(fields)-1])
+				return string(encoded) + "\n", nil
+			}
+			return "", nil
+		}}
+
+		if _, err := k.Get("client-secret/id"); !errors.Is(err, errKeyringNotFound) {
+			t.Errorf("Get() of a missing secret error = %v, want errKeyringNotFound", err)
+		}
+		if err := k.Set("client-secret/id", "s3cr3t with spaces"); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(command, "s3cr3t") || !strings.HasPrefix(command, "add-generic-password -U -s kusari-uploader -a 'client-secret/id' -X ") {
+			t.Errorf("Set() ran security -i with %q, want the secret hex encoded", command)
+		}
+		if got, err := k.Get("client-secret/id"); err != nil || got != "s3cr3t with spaces" {
+			t.Errorf("Get() = %q, %v, want the stored secret", got, err)
+		}
+	})
+}
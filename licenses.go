@@ -0,0 +1,352 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// componentLicense is the SPDX license expression of a component of an SBOM
+type componentLicense struct {
+	// Component is the purl of the component, or else its name and version
+	Component  string
+	Expression string
+}
+
+// licenseViolation is a component whose license expression --deny-license
+// denies, with the license that denied it
+type licenseViolation struct {
+	componentLicense
+	License string
+}
+
+// compileLicensePatterns returns the --deny-license patterns in lower case.
+// They are SPDX license IDs matched case-insensitively, where * matches any
+// characters, e.g. AGPL-*.
+func compileLicensePatterns(values []string) ([]string, error) {
+	var patterns []string
+	// environment variables and config file strings are comma separated too
+	for _, value := range values {
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid deny-license pattern %q: %w", pattern, err)
+			}
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// checkDeniedLicenses returns an error if components of an SBOM are only
+// available under licenses --deny-license denies. Without --deny-license
+// nothing is checked.
+func checkDeniedLicenses(filePath string, blob []byte) error {
+	patterns, err := compileLicensePatterns(viper.GetStringSlice("deny-license"))
+	if err != nil || len(patterns) == 0 {
+		return err
+	}
+
+	violations := licenseViolations(sbomComponentLicenses(blob), patterns)
+	if len(violations) == 0 {
+		return nil
+	}
+	for _, v := range violations {
+		log.Warn().
+			Str("filePath", filePath).
+			Str("component", v.Component).
+			Str("license", v.Expression).
+			Msg("Component has a denied license")
+	}
+	return fmt.Errorf("%s has %d component(s) with denied licenses, e.g. %s under %s",
+		filePath, len(violations), violations[0].Component, violations[0].License)
+}
+
+// licenseViolations returns the components whose license expression is denied
+// by patterns, sorted by component
+func licenseViolations(licenses []componentLicense, patterns []string) []licenseViolation {
+	var violations []licenseViolation
+	for _, l := range licenses {
+		expr, err := parseLicenseExpression(l.Expression)
+		if err != nil {
+			// match unparseable expressions as a whole
+			expr = &licenseExpr{license: strings.TrimSpace(l.Expression)}
+		}
+		if license, denied := expr.denied(patterns); denied {
+			violations = append(violations, licenseViolation{componentLicense: l, License: license})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Component < violations[j].Component })
+	return violations
+}
+
+// licenseExpr is a parsed SPDX license expression: a license, optionally with
+// an exception, or the AND or OR of expressions
+type licenseExpr struct {
+	op      string
+	license string
+	args    []*licenseExpr
+}
+
+// denied reports whether the expression is denied by patterns, and the
+// license that denied it. A choice of licenses is only denied when every
+// choice is, and a combination when any of its licenses is.
+func (e *licenseExpr) denied(patterns []string) (string, bool) {
+	switch e.op {
+	case "OR":
+		first := ""
+		for _, arg := range e.args {
+			license, denied := arg.denied(patterns)
+			if !denied {
+				return "", false
+			}
+			if first == "" {
+				first = license
+			}
+		}
+		return first, true
+	case "AND":
+		for _, arg := range e.args {
+			if license, denied := arg.denied(patterns); denied {
+				return license, true
+			}
+		}
+		return "", false
+	}
+
+	id := strings.ToLower(e.license)
+	for _, pattern := range patterns {
+		// GPL-2.0+ is also denied by patterns for GPL-2.0
+		if ok, _ := path.Match(pattern, id); ok {
+			return e.license, true
+		}
+		if ok, _ := path.Match(pattern, strings.TrimSuffix(id, "+")); ok {
+			return e.license, true
+		}
+	}
+	return "", false
+}
+
+// parseLicenseExpression parses an SPDX license expression such as
+// "(MIT OR Apache-2.0) AND GPL-2.0-only WITH Classpath-exception-2.0". The
+// operators are also accepted in lower case.
+func parseLicenseExpression(s string) (*licenseExpr, error) {
+	s = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(s)
+	p := &licenseParser{tokens: strings.Fields(s)}
+	if len(p.tokens) == 0 {
+		return nil, errors.New("empty license expression")
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in license expression", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+type licenseParser struct {
+	tokens []string
+	pos    int
+}
+
+// next returns the next token, upper cased if it is an operator
+func (p *licenseParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	token := p.tokens[p.pos]
+	switch upper := strings.ToUpper(token); upper {
+	case "AND", "OR", "WITH":
+		return upper
+	}
+	return token
+}
+
+func (p *licenseParser) parseOr() (*licenseExpr, error) {
+	return p.parseOp("OR", p.parseAnd)
+}
+
+func (p *licenseParser) parseAnd() (*licenseExpr, error) {
+	return p.parseOp("AND", p.parseAtom)
+}
+
+// parseOp parses operands joined by op, which binds weaker than operand
+func (p *licenseParser) parseOp(op string, operand func() (*licenseExpr, error)) (*licenseExpr, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	args := []*licenseExpr{first}
+	for p.next() == op {
+		p.pos++
+		arg, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) == 1 {
+		return first, nil
+	}
+	return &licenseExpr{op: op, args: args}, nil
+}
+
+func (p *licenseParser) parseAtom() (*licenseExpr, error) {
+	switch token := p.next(); token {
+	case "":
+		return nil, errors.New("unexpected end of license expression")
+	case "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing ) in license expression")
+		}
+		p.pos++
+		return expr, nil
+	case ")", "AND", "OR", "WITH":
+		return nil, fmt.Errorf("unexpected %q in license expression", token)
+	default:
+		p.pos++
+		// the exception doesn't change which license applies
+		if p.next() == "WITH" {
+			p.pos += 2
+			if p.pos > len(p.tokens) {
+				return nil, errors.New("missing exception in license expression")
+			}
+		}
+		return &licenseExpr{license: token}, nil
+	}
+}
+
+// cdxLicensedComponent is a CycloneDX component with its licenses
+type cdxLicensedComponent struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Purl     string `json:"purl"`
+	Licenses []struct {
+		Expression string `json:"expression"`
+		License    struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"license"`
+	} `json:"licenses"`
+	Components []cdxLicensedComponent `json:"components"`
+}
+
+// sbomComponentLicenses returns the license expressions of the components of
+// a CycloneDX or SPDX SBOM that declare one. The licenses listed for a
+// CycloneDX component all apply. SPDX packages use their concluded license,
+// or else their declared license. Other documents have none.
+func sbomComponentLicenses(blob []byte) []componentLicense {
+	var licenses []componentLicense
+
+	var cdx struct {
+		BOMFormat  string                 `json:"bomFormat"`
+		Components []cdxLicensedComponent `json:"components"`
+	}
+	if err := json.Unmarshal(blob, &cdx); err == nil && cdx.BOMFormat == "CycloneDX" {
+		var walk func([]cdxLicensedComponent)
+		walk = func(components []cdxLicensedComponent) {
+			for _, c := range components {
+				var exprs []string
+				for _, l := range c.Licenses {
+					switch {
+					case l.Expression != "":
+						exprs = append(exprs, "("+l.Expression+")")
+					case l.License.ID != "":
+						exprs = append(exprs, l.License.ID)
+					case l.License.Name != "":
+						exprs = append(exprs, l.License.Name)
+					}
+				}
+				if len(exprs) > 0 {
+					licenses = append(licenses, componentLicense{
+						Component:  componentName(c.Purl, c.Name, c.Version),
+						Expression: strings.Join(exprs, " AND "),
+					})
+				}
+				walk(c.Components)
+			}
+		}
+		walk(cdx.Components)
+	}
+
+	var spdx struct {
+		SPDXID   string `json:"SPDXID"`
+		Packages []struct {
+			Name             string `json:"name"`
+			VersionInfo      string `json:"versionInfo"`
+			LicenseConcluded string `json:"licenseConcluded"`
+			LicenseDeclared  string `json:"licenseDeclared"`
+			ExternalRefs     []struct {
+				ReferenceType    string `json:"referenceType"`
+				ReferenceLocator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(blob, &spdx); err == nil && spdx.SPDXID == "SPDXRef-DOCUMENT" {
+		for _, p := range spdx.Packages {
+			expr := p.LicenseConcluded
+			if !spdxLicenseKnown(expr) {
+				expr = p.LicenseDeclared
+			}
+			if !spdxLicenseKnown(expr) {
+				continue
+			}
+			purl := ""
+			for _, ref := range p.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					purl = ref.ReferenceLocator
+					break
+				}
+			}
+			licenses = append(licenses, componentLicense{Component: componentName(purl, p.Name, p.VersionInfo), Expression: expr})
+		}
+	}
+
+	return licenses
+}
+
+// spdxLicenseKnown reports whether an SPDX license field holds a license
+func spdxLicenseKnown(expr string) bool {
+	return expr != "" && expr != "NOASSERTION" && expr != "NONE"
+}
+
+// componentName names a component by its purl, or else its name and version
+func componentName(purl, name, version string) string {
+	if purl != "" {
+		return purl
+	}
+	if version != "" {
+		return name + "@" + version
+	}
+	return name
+}
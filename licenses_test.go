@@ -0,0 +1,118 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_licenseExpr_denied(t *testing.T) {
+	patterns, err := compileLicensePatterns([]string{"GPL-3.0-only,AGPL-*", "gpl-2.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr        string
+		wantLicense string
+		wantDenied  bool
+	}{
+		{expr: "MIT"},
+		{expr: "GPL-3.0-only", wantLicense: "GPL-3.0-only", wantDenied: true},
+		{expr: "agpl-3.0-or-later", wantLicense: "agpl-3.0-or-later", wantDenied: true},
+		{expr: "GPL-2.0+", wantLicense: "GPL-2.0+", wantDenied: true},
+		{expr: "MIT OR GPL-3.0-only"},
+		{expr: "AGPL-3.0-only OR GPL-3.0-only", wantLicense: "AGPL-3.0-only", wantDenied: true},
+		{expr: "MIT AND GPL-3.0-only", wantLicense: "GPL-3.0-only", wantDenied: true},
+		{expr: "(MIT OR Apache-2.0) AND (GPL-3.0-only OR BSD-3-Clause)"},
+		{expr: "Apache-2.0 and (GPL-3.0-only with Classpath-exception-2.0 or AGPL-3.0-only)", wantLicense: "GPL-3.0-only", wantDenied: true},
+		{expr: "GPL-3.0-or-later"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parseLicenseExpression(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			license, denied := expr.denied(patterns)
+			if license != tt.wantLicense || denied != tt.wantDenied {
+				t.Errorf("denied() = %q, %v, want %q, %v", license, denied, tt.wantLicense, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func Test_parseLicenseExpression_invalid(t *testing.T) {
+	for _, expr := range []string{"", "(MIT", "MIT OR", "MIT WITH", "MIT )", "AND MIT"} {
+		if _, err := parseLicenseExpression(expr); err == nil {
+			t.Errorf("parseLicenseExpression(%q) returned no error", expr)
+		}
+	}
+}
+
+func Test_sbomComponentLicenses(t *testing.T) {
+	cdx := []byte(`{"bomFormat": "CycloneDX", "components": [
+		{"name": "a", "purl": "pkg:npm/a@1", "licenses": [{"license": {"id": "MIT"}}, {"license": {"name": "Custom"}}],
+		 "components": [{"name": "b", "version": "2", "licenses": [{"expression": "MIT OR GPL-3.0-only"}]}]},
+		{"name": "c", "purl": "pkg:npm/c@1"}
+	]}`)
+	want := []componentLicense{
+		{Component: "pkg:npm/a@1", Expression: "MIT AND Custom"},
+		{Component: "b@2", Expression: "(MIT OR GPL-3.0-only)"},
+	}
+	if got := sbomComponentLicenses(cdx); !reflect.DeepEqual(got, want) {
+		t.Errorf("sbomComponentLicenses(CycloneDX) = %+v, want %+v", got, want)
+	}
+
+	spdx := []byte(`{"SPDXID": "SPDXRef-DOCUMENT", "packages": [
+		{"name": "a", "versionInfo": "1", "licenseConcluded": "NOASSERTION", "licenseDeclared": "AGPL-3.0-only",
+		 "externalRefs": [{"referenceType": "purl", "referenceLocator": "pkg:pypi/a@1"}]},
+		{"name": "b", "licenseConcluded": "Apache-2.0", "licenseDeclared": "GPL-3.0-only"},
+		{"name": "c", "licenseConcluded": "NONE"}
+	]}`)
+	want = []componentLicense{
+		{Component: "pkg:pypi/a@1", Expression: "AGPL-3.0-only"},
+		{Component: "b", Expression: "Apache-2.0"},
+	}
+	if got := sbomComponentLicenses(spdx); !reflect.DeepEqual(got, want) {
+		t.Errorf("sbomComponentLicenses(SPDX) = %+v, want %+v", got, want)
+	}
+}
+
+func Test_checkDeniedLicenses(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	sbom := []byte(`{"bomFormat": "CycloneDX", "components": [
+		{"purl": "pkg:npm/ok@1", "licenses": [{"expression": "MIT OR AGPL-3.0-only"}]},
+		{"purl": "pkg:npm/copyleft@1", "licenses": [{"license": {"id": "AGPL-3.0-only"}}]}
+	]}`)
+
+	if err := checkDeniedLicenses("sbom.json", sbom); err != nil {
+		t.Errorf("checkDeniedLicenses() without deny-license error = %v", err)
+	}
+	viper.Set("deny-license", "GPL-3.0-only,AGPL-*")
+	err := checkDeniedLicenses("sbom.json", sbom)
+	if want := "sbom.json has 1 component(s) with denied licenses, e.g. pkg:npm/copyleft@1 under AGPL-3.0-only"; err == nil || err.Error() != want {
+		t.Errorf("checkDeniedLicenses() error = %v, want %s", err, want)
+	}
+
+	viper.Set("deny-license", "GPL-[")
+	if err := checkDeniedLicenses("sbom.json", sbom); err == nil {
+		t.Error("checkDeniedLicenses() with an invalid pattern returned no error")
+	}
+}
@@ -0,0 +1,160 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// logFormat* are the values of --log-format
+const (
+	logFormatAuto    = "auto"
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+)
+
+// logLevels are the accepted values of --log-level
+var logLevels = []zerolog.Level{zerolog.TraceLevel, zerolog.DebugLevel, zerolog.InfoLevel, zerolog.WarnLevel, zerolog.ErrorLevel}
+
+// resolveLogLevel returns the level set with --log-level, lowered to debug by
+// -v and to trace by -vv, whichever is more verbose
+func resolveLogLevel(name string, verbosity int) (zerolog.Level, error) {
+	level := zerolog.InfoLevel
+	if name != "" {
+		parsed, err := zerolog.ParseLevel(strings.ToLower(name))
+		valid := err == nil
+		if valid {
+			valid = false
+			for _, l := range logLevels {
+				valid = valid || l == parsed
+			}
+		}
+		if !valid {
+			names := make([]string, len(logLevels))
+			for i, l := range logLevels {
+				names[i] = l.String()
+			}
+			return 0, fmt.Errorf("unknown log level %q, must be one of %s", name, strings.Join(names, ", "))
+		}
+		level = parsed
+	}
+
+	switch {
+	case verbosity >= 2:
+		level = min(level, zerolog.TraceLevel)
+	case verbosity == 1:
+		level = min(level, zerolog.DebugLevel)
+	}
+	return level, nil
+}
+
+// configureLogging sets the global log level from --log-level and -v, and
+// where and how logs are written from --log-format and --log-file
+func configureLogging(cmd *cobra.Command, args []string) {
+	level, err := resolveLogLevel(viper.GetString("log-level"), viper.GetInt("verbose"))
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid log level")
+	}
+	zerolog.SetGlobalLevel(level)
+
+	var stderr io.Writer = os.Stderr
+	terminal := isTerminal(os.Stderr)
+	if terminal {
+		progress = &statusLine{w: os.Stderr}
+		stderr = progress
+	}
+	w, err := logWriter(stderr, viper.GetString("log-format"), terminal, colorEnabled(os.Stderr), viper.GetString("log-file"))
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid log output")
+	}
+	logOutput = w
+	log.Logger = zerolog.New(w).With().Timestamp().Logger().Hook(exitHook{})
+}
+
+// logWriter returns the writer for logs to stderr in the given format. auto
+// writes console output to terminals and JSON otherwise, and console output is
+// only colored with color. With a log file, logs are also appended to it as
+// JSON, whatever the format on stderr.
+func logWriter(stderr io.Writer, format string, terminal, color bool, logFile string) (io.Writer, error) {
+	if format == logFormatAuto || format == "" {
+		format = logFormatJSON
+		if terminal {
+			format = logFormatConsole
+		}
+	}
+
+	var w io.Writer
+	switch format {
+	case logFormatJSON:
+		w = stderr
+	case logFormatConsole:
+		w = zerolog.ConsoleWriter{Out: stderr, TimeFormat: time.TimeOnly, NoColor: !color}
+	default:
+		return nil, fmt.Errorf("unknown log format %q, must be %s, %s or %s", format, logFormatAuto, logFormatJSON, logFormatConsole)
+	}
+
+	if logFile == "" {
+		return w, nil
+	}
+	f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return zerolog.MultiLevelWriter(w, f), nil
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// logTransport logs every request and the status code of its response at
+// debug level. Query strings are left out because presigned URLs carry their
+// signature in them.
+type logTransport struct {
+	base http.RoundTripper
+}
+
+func (t *logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+
+	event := log.Debug().
+		Str("method", req.Method).
+		Str("url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path).
+		Dur("duration", time.Since(start))
+	if err != nil {
+		event.Err(err).Msg("HTTP request failed")
+	} else {
+		event.Int("status", res.StatusCode).Msg("HTTP request")
+	}
+	return res, err
+}
@@ -0,0 +1,147 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func Test_resolveLogLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		level     string
+		verbosity int
+		want      zerolog.Level
+		wantErr   bool
+	}{
+		{name: "default", want: zerolog.InfoLevel},
+		{name: "explicit", level: "warn", want: zerolog.WarnLevel},
+		{name: "case insensitive", level: "ERROR", want: zerolog.ErrorLevel},
+		{name: "-v", verbosity: 1, want: zerolog.DebugLevel},
+		{name: "-vv", verbosity: 2, want: zerolog.TraceLevel},
+		{name: "-vvv", verbosity: 3, want: zerolog.TraceLevel},
+		{name: "-v lowers a quieter level", level: "error", verbosity: 1, want: zerolog.DebugLevel},
+		{name: "-v keeps a more verbose level", level: "trace", verbosity: 1, want: zerolog.TraceLevel},
+		{name: "unknown", level: "loud", wantErr: true},
+		{name: "fatal is not a log level choice", level: "fatal", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveLogLevel(tt.level, tt.verbosity)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveLogLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("resolveLogLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_logTransport(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	t.Cleanup(func() { log.Logger = logger })
+
+	rt := &logTransport{base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusForbidden, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})}
+	req, err := http.NewRequest(http.MethodPut, "https://bucket.example.com/doc?X-Amz-Signature=secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatalf("RoundTrip() error = %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{`"method":"PUT"`, `"url":"https://bucket.example.com/doc"`, `"status":403`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("logTransport logged %s, want it to contain %s", out, want)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("logTransport logged the query string: %s", out)
+	}
+}
+
+func Test_logWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		terminal    bool
+		color       bool
+		wantJSON    bool
+		wantErr     bool
+		wantMessage string
+	}{
+		{name: "json", format: logFormatJSON, terminal: true, wantJSON: true},
+		{name: "console", format: logFormatConsole, wantMessage: "INF hello"},
+		{name: "auto on a terminal", format: logFormatAuto, terminal: true, color: true, wantMessage: "\x1b[32mINF"},
+		{name: "auto on a terminal without color", format: logFormatAuto, terminal: true, wantMessage: "INF hello"},
+		{name: "auto in a pipe", format: logFormatAuto, wantJSON: true},
+		{name: "unknown", format: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			logFile := filepath.Join(t.TempDir(), "run.log")
+			w, err := logWriter(&stderr, tt.format, tt.terminal, tt.color, logFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("logWriter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			logger := zerolog.New(w)
+			logger.Info().Msg("hello")
+
+			out := stderr.String()
+			if tt.wantJSON && !strings.HasPrefix(out, `{"level":"info"`) {
+				t.Errorf("logWriter() wrote %q to stderr, want JSON", out)
+			}
+			if tt.wantMessage != "" && !strings.Contains(out, tt.wantMessage) {
+				t.Errorf("logWriter() wrote %q to stderr, want it to contain %q", out, tt.wantMessage)
+			}
+
+			// the log file is always JSON
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != `{"level":"info","message":"hello"}`+"\n" {
+				t.Errorf("logWriter() wrote %q to the log file, want the JSON log", data)
+			}
+		})
+	}
+}
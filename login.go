@@ -0,0 +1,401 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// authLogin authenticates with the refresh token stored by auth login
+const authLogin = "login"
+
+// loginProvider uses the refresh token stored by auth login
+type loginProvider struct{}
+
+func (loginProvider) tokenSource(ctx context.Context, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	return newLoginTokenSource(ctx, tokenURL)
+}
+
+func (loginProvider) credentials() credentialNeed { return credentialsNone }
+
+func (loginProvider) interactive() bool { return false }
+
+func (loginProvider) describe(flags *pflag.FlagSet, creds []clientCredential) string {
+	return "login stored by auth login"
+}
+
+// loginTimeout is how long auth login waits for the browser to complete the
+// sign in
+const loginTimeout = 5 * time.Minute
+
+// storedLogin is the result of auth login for one token endpoint
+type storedLogin struct {
+	ClientID string        `json:"client_id"`
+	Token    *oauth2.Token `json:"token"`
+}
+
+func newAuthCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Sign in as a person, keep client secrets in the OS keyring, or check the active credential",
+	}
+
+	login := &cobra.Command{
+		Use:   "login",
+		Short: "Sign in with a browser and store a refresh token for later runs",
+		Long: "Open the sign in page of the token endpoint's identity provider in a browser, complete an " +
+			"authorization code flow with PKCE on a localhost callback, and store the refresh token. Later runs " +
+			"without a client secret use the stored login.",
+		Args: cobra.NoArgs,
+		Run:  loginWithBrowserCmd,
+	}
+	login.Flags().Bool("no-browser", false, "Print the sign in URL instead of opening a browser")
+	login.Flags().Int("callback-port", 0, "Port of the localhost callback the browser is redirected to, for identity providers that only allow registered redirect URIs (default: a random free port)")
+	login.Flags().String("authorize-endpoint", "", "Authorization endpoint URL (optional, defaults to authorize next to the token endpoint)")
+	login.Flags().StringSlice("scopes", nil, "Comma separated scopes to request (optional)")
+	mustBindPFlag(login, "no-browser")
+	mustBindPFlag(login, "callback-port")
+	mustBindPFlag(login, "authorize-endpoint")
+	mustBindPFlag(login, "scopes")
+	cmd.AddCommand(login)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "logout",
+		Short: "Remove the login stored for the token endpoint",
+		Args:  cobra.NoArgs,
+		Run:   logoutCmd,
+	})
+	cmd.AddCommand(newKeyringSecretCmd())
+	cmd.AddCommand(newAuthStatusCmd())
+	cmd.AddCommand(newAuthTestCmd())
+	return cmd
+}
+
+func loginWithBrowserCmd(cmd *cobra.Command, args []string) {
+	ctx, client := mustNewHTTPClient(context.Background())
+
+	_, tokenEndPoint, err := resolveEndpoints(ctx, client)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+	clientID := viper.GetString("client-id")
+	if clientID == "" || tokenEndPoint == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id, token-endpoint")
+	}
+
+	authorizeEndpoint := viper.GetString("authorize-endpoint")
+	if authorizeEndpoint == "" {
+		authorizeEndpoint = strings.TrimSuffix(tokenEndPoint, "/token") + "/authorize"
+	}
+	if err := checkOutboundEndpoint("authorize endpoint", authorizeEndpoint); err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Authorize endpoint not allowed")
+	}
+	config := &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret(),
+		Endpoint:     oauth2.Endpoint{AuthURL: authorizeEndpoint, TokenURL: tokenEndPoint},
+		Scopes:       viper.GetStringSlice("scopes"),
+	}
+
+	open := openBrowser
+	if viper.GetBool("no-browser") {
+		open = nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
+	defer cancel()
+	token, err := loginWithBrowser(ctx, config, viper.GetInt("callback-port"), cmd.ErrOrStderr(), open)
+	if err != nil {
+		fatalErr(err, exitAuth).
+			Msg("Login failed")
+	}
+
+	if err := saveLogin(tokenEndPoint, storedLogin{ClientID: clientID, Token: token}); err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error storing the login")
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Logged in to %s\n", tokenEndPoint)
+}
+
+func logoutCmd(cmd *cobra.Command, args []string) {
+	ctx, client := mustNewHTTPClient(context.Background())
+
+	_, tokenEndPoint, err := resolveEndpoints(ctx, client)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+	removed, err := removeLogin(tokenEndPoint)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error removing the login")
+	}
+	if !removed {
+		fmt.Fprintf(cmd.OutOrStdout(), "Not logged in to %s\n", tokenEndPoint)
+		return
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Logged out of %s\n", tokenEndPoint)
+}
+
+// loginWithBrowser completes an authorization code flow with PKCE: it serves
+// a callback on localhost, has open show the sign in page, or prints its URL
+// if open is nil or fails, and exchanges the code the browser is redirected
+// back with for a token. Callbacks with another state are ignored.
+func loginWithBrowser(ctx context.Context, config *oauth2.Config, port int, out io.Writer, open func(string) error) (*oauth2.Token, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the login callback: %w", err)
+	}
+	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:%d/callback", l.Addr().(*net.TCPAddr).Port)
+
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	type callback struct {
+		code string
+		err  error
+	}
+	callbacks := make(chan callback, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("state") != hex.EncodeToString(state) {
+			http.Error(w, "Unknown login request, start again with kusari-uploader auth login.", http.StatusBadRequest)
+			return
+		}
+		res := callback{code: q.Get("code")}
+		if e := q.Get("error"); e != "" || res.code == "" {
+			res.err = fmt.Errorf("sign in failed: %s %s", e, q.Get("error_description"))
+			http.Error(w, "Sign in failed, see the terminal for details.", http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Signed in to kusari-uploader, you can close this window.")
+		}
+		select {
+		case callbacks <- res:
+		default:
+		}
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(l)   //nolint:errcheck
+	defer srv.Close() //nolint:errcheck
+
+	authURL := config.AuthCodeURL(hex.EncodeToString(state), oauth2.S256ChallengeOption(verifier))
+	if open != nil && open(authURL) == nil {
+		fmt.Fprintf(out, "Opened the sign in page in your browser, or open %s\n", authURL)
+	} else {
+		fmt.Fprintf(out, "To sign in, open %s\n", authURL)
+	}
+	fmt.Fprintln(out, "Waiting for the sign in to complete...")
+
+	var res callback
+	select {
+	case res = <-callbacks:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no sign in completed: %w", ctx.Err())
+	}
+	if res.err != nil {
+		return nil, withExitCode(exitAuth, res.err)
+	}
+
+	token, err := config.Exchange(ctx, res.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, withExitCode(exitAuth, fmt.Errorf("failed to exchange the authorization code: %w", err))
+	}
+	if token.RefreshToken == "" {
+		return nil, withExitCode(exitAuth, errors.New("the token endpoint did not issue a refresh token, check that the client allows refresh tokens or request the offline_access scope"))
+	}
+	return token, nil
+}
+
+// openBrowser opens url in the default browser
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
+// loginStore returns the store of the logins, kusari-uploader in the user
+// config directory. Logins are never kept in the --state-store, which may be
+// shared.
+func loginStore() (*fileStore, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("no user config directory to store logins in: %w", err)
+	}
+	return &fileStore{dir: filepath.Join(dir, "kusari-uploader")}, nil
+}
+
+// loginsKey is the file of the logins, keyed by token endpoint
+const loginsKey = "logins.json"
+
+func loadLogins() (map[string]storedLogin, error) {
+	store, err := loginStore()
+	if err != nil {
+		return nil, err
+	}
+	logins := map[string]storedLogin{}
+	data, err := store.Get(context.Background(), loginsKey)
+	if errors.Is(err, errStateNotFound) {
+		return logins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &logins); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", store.path(loginsKey), err)
+	}
+	return logins, nil
+}
+
+func writeLogins(logins map[string]storedLogin) error {
+	store, err := loginStore()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(logins, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Put(context.Background(), loginsKey, data)
+}
+
+func saveLogin(tokenURL string, login storedLogin) error {
+	logins, err := loadLogins()
+	if err != nil {
+		return err
+	}
+	logins[tokenURL] = login
+	return writeLogins(logins)
+}
+
+func removeLogin(tokenURL string) (bool, error) {
+	logins, err := loadLogins()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := logins[tokenURL]; !ok {
+		return false, nil
+	}
+	delete(logins, tokenURL)
+	return true, writeLogins(logins)
+}
+
+// hasStoredLogin reports whether auth login stored a login for any token
+// endpoint
+func hasStoredLogin() bool {
+	logins, err := loadLogins()
+	return err == nil && len(logins) > 0
+}
+
+// loginTokenSource gets tokens with the refresh token stored by auth login
+// for its token endpoint, and stores the new refresh token when the identity
+// provider rotates it
+type loginTokenSource struct {
+	ctx      context.Context
+	tokenURL string
+
+	mu      sync.Mutex
+	login   storedLogin
+	source  oauth2.TokenSource
+	refresh string
+}
+
+func newLoginTokenSource(ctx context.Context, tokenURL string) *loginTokenSource {
+	return &loginTokenSource{ctx: ctx, tokenURL: tokenURL}
+}
+
+func (s *loginTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source == nil {
+		logins, err := loadLogins()
+		if err != nil {
+			return nil, err
+		}
+		login, ok := logins[s.tokenURL]
+		if !ok || login.Token == nil {
+			return nil, withExitCode(exitAuth, fmt.Errorf("not logged in to %s, run kusari-uploader auth login or set client-secret", s.tokenURL))
+		}
+		config := &oauth2.Config{ClientID: login.ClientID, Endpoint: oauth2.Endpoint{TokenURL: s.tokenURL}}
+		s.login, s.source, s.refresh = login, config.TokenSource(s.ctx, login.Token), login.Token.RefreshToken
+	}
+
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, withExitCode(exitAuth, fmt.Errorf("the login of %s expired or was revoked, run kusari-uploader auth login again: %w", s.tokenURL, err))
+	}
+	if token.RefreshToken != s.refresh {
+		s.refresh = token.RefreshToken
+		s.login.Token = token
+		if err := saveLogin(s.tokenURL, s.login); err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Could not store the rotated refresh token, run auth login again if the next run is rejected")
+		}
+	}
+	return token, nil
+}
+
+// forceRefresh redeems the refresh token next time, rather than using the
+// access token it was last redeemed for
+func (s *loginTokenSource) forceRefresh() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source == nil || s.refresh == "" {
+		return false
+	}
+	config := &oauth2.Config{ClientID: s.login.ClientID, Endpoint: oauth2.Endpoint{TokenURL: s.tokenURL}}
+	s.source = config.TokenSource(s.ctx, &oauth2.Token{RefreshToken: s.refresh})
+	return true
+}
@@ -0,0 +1,168 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// fakeIdentityProvider issues tokens for the code "good-code" if the PKCE
+// verifier matches the challenge of the last authorization request, and
+// rotates refresh tokens
+func fakeIdentityProvider(t *testing.T, challenge *string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+			if r.PostForm.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "access-1", "refresh_token": "refresh-1", "token_type": "Bearer", "expires_in": 3600}`))
+		case "refresh_token":
+			if r.PostForm.Get("refresh_token") != "refresh-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "access-2", "refresh_token": "refresh-2", "token_type": "Bearer", "expires_in": 3600}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_loginWithBrowser(t *testing.T) {
+	var challenge string
+	idp := fakeIdentityProvider(t, &challenge)
+	config := &oauth2.Config{
+		ClientID: "laptop",
+		Endpoint: oauth2.Endpoint{AuthURL: idp.URL + "/oauth2/authorize", TokenURL: idp.URL + "/oauth2/token"},
+	}
+
+	// the browser signs in and is redirected to the callback, after a stray
+	// request with another state
+	browser := func(code string) func(string) error {
+		return func(authURL string) error {
+			u, err := url.Parse(authURL)
+			if err != nil {
+				return err
+			}
+			q := u.Query()
+			challenge = q.Get("code_challenge")
+			if q.Get("code_challenge_method") != "S256" {
+				t.Errorf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+			}
+			go func() {
+				redirect := q.Get("redirect_uri")
+				for _, state := range []string{"other", q.Get("state")} {
+					res, err := http.Get(redirect + "?" + url.Values{"code": {code}, "state": {state}}.Encode())
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					io.Copy(io.Discard, res.Body) //nolint:errcheck
+					res.Body.Close()              //nolint:errcheck
+				}
+			}()
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	token, err := loginWithBrowser(ctx, config, 0, io.Discard, browser("good-code"))
+	if err != nil {
+		t.Fatalf("loginWithBrowser() error = %v", err)
+	}
+	if token.RefreshToken != "refresh-1" {
+		t.Errorf("loginWithBrowser() refresh token = %q, want refresh-1", token.RefreshToken)
+	}
+
+	if _, err := loginWithBrowser(ctx, config, 0, io.Discard, browser("bad-code")); err == nil {
+		t.Error("loginWithBrowser() expected an error when the code is rejected")
+	}
+}
+
+func Test_loginTokenSource(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	var challenge string
+	idp := fakeIdentityProvider(t, &challenge)
+	tokenURL := idp.URL + "/oauth2/token"
+
+	if hasStoredLogin() {
+		t.Fatal("hasStoredLogin() = true before logging in")
+	}
+	if _, err := newLoginTokenSource(context.Background(), tokenURL).Token(); err == nil {
+		t.Error("Token() expected an error before logging in")
+	}
+
+	expired := &oauth2.Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(-time.Hour)}
+	if err := saveLogin(tokenURL, storedLogin{ClientID: "laptop", Token: expired}); err != nil {
+		t.Fatal(err)
+	}
+	if mode, err := authMode(); err != nil || mode != authLogin {
+		t.Errorf("authMode() without a client secret = %q, %v, want %s", mode, err, authLogin)
+	}
+	if !hasClientCredentials() {
+		t.Error("hasClientCredentials() = false with a stored login")
+	}
+	viper.Set("client-secret", "secret")
+	if mode, _ := authMode(); mode != authClientCredentials {
+		t.Errorf("authMode() with a client secret = %q, want %s", mode, authClientCredentials)
+	}
+
+	token, err := newLoginTokenSource(context.Background(), tokenURL).Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if token.AccessToken != "access-2" {
+		t.Errorf("Token() = %q, want the refreshed access token", token.AccessToken)
+	}
+	logins, err := loadLogins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := logins[tokenURL].Token.RefreshToken; got != "refresh-2" {
+		t.Errorf("stored refresh token = %q, want the rotated refresh-2", got)
+	}
+
+	if removed, err := removeLogin(tokenURL); err != nil || !removed {
+		t.Errorf("removeLogin() = %v, %v, want the login removed", removed, err)
+	}
+	if hasStoredLogin() {
+		t.Error("hasStoredLogin() = true after logging out")
+	}
+}
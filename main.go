@@ -21,20 +21,21 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
-	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/kusaridev/kusari-uploader/pkg/source"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
-	"golang.org/x/oauth2/clientcredentials"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -110,6 +111,8 @@ type HttpClient interface {
 type DocumentWrapper struct {
 	*Document
 	UploadMetaData *map[string]string `json:"upload_metadata,omitempty"`
+	// SchemaVersion is the version of the wrapper's shape, see wrapperVersions
+	SchemaVersion int `json:"schema_version,omitempty"`
 }
 
 // This application utilizes oauth client credentials flow to obtain a jwt
@@ -119,48 +122,168 @@ type DocumentWrapper struct {
 func main() {
 	// Create the root command
 	var rootCmd = &cobra.Command{
-		Use:   "file-uploader",
+		Use:   "kusari-uploader",
 		Short: "Upload files to an S3 bucket using OAuth client credentials",
-		Run:   uploadFiles,
+		Long: "Upload documents to the Kusari Platform and check them against its policies. " +
+			"Running kusari-uploader without a command is the same as kusari-uploader upload.",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			bindEnvVars(cmd, args)
+			configureLogging(cmd, args)
+			resolveSecretRefs(cmd, args)
+			startTelemetry(cmd, args)
+			checkDeprecations(cmd, args)
+			startProfiling(cmd, args)
+		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			stopProfiling(cmd, args)
+			stopTelemetry(cmd, args)
+		},
+		PreRun: bindUploadFlags,
+		Run:    uploadFiles,
 	}
 
+	// --version prints the same as the version command
+	rootCmd.Version = buildVersion()
+	rootCmd.SetVersionTemplate(versionText(getVersionInfo()))
+
 	// Define flags (new flags are optional)
-	rootCmd.Flags().StringP("file-path", "f", "", "Path to file or directory to upload (required)")
-	rootCmd.Flags().StringP("client-id", "c", "", "OAuth client ID (required)")
-	rootCmd.Flags().StringP("client-secret", "s", "", "OAuth client secret (required)")
-	rootCmd.Flags().StringP("tenant-endpoint", "t", "", "Kusari Tenant endpoint URL (required)")
-	rootCmd.Flags().StringP("token-endpoint", "k", "https://auth.us.kusari.cloud/oauth2/token", "Token endpoint URL")
-	rootCmd.Flags().StringP("alias", "a", "", "Alias that supersedes the subject in Kusari platform (optional)")
-	rootCmd.Flags().StringP("document-type", "d", "", "Type of the document (image or build) sbom (optional)")
-	rootCmd.Flags().Bool("open-vex", false, "Indicate that this is an OpenVEX document (optional, only works with files)")
-	rootCmd.Flags().String("tag", "", "Tag value to set in the document wrapper upload meta (optional, e.g. govulncheck)")
-	rootCmd.Flags().String("software-id", "", "Kusari Platform Software ID value to set in the document wrapper upload meta (optional)")
-	rootCmd.Flags().String("sbom-subject", "", "Kusari Platform Software sbom subject substring value to set in the document wrapper upload meta (optional)")
-	rootCmd.Flags().String("component-name", "", "Kusari Platform component name (optional)")
-	rootCmd.Flags().Bool("check-blocked-packages", false, "Check if any of the SBOMs uses a package contained in the blocked package list")
+	rootCmd.PersistentFlags().String("config", "", "Path to a config file (YAML, JSON or TOML) providing flag values and routing rules (optional)")
+	rootCmd.PersistentFlags().String("log-level", "info", "Log level: trace, debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", logFormatAuto, "Log format: json, console for human readable output, or auto for console on a terminal and json otherwise")
+	rootCmd.PersistentFlags().String("log-file", "", "Also append logs to this file as JSON, e.g. to attach to a support request (optional)")
+	rootCmd.PersistentFlags().Bool("no-color", false, "Do not color logs and status tables, which are only colored on a terminal; also set by the NO_COLOR environment variable")
+	rootCmd.PersistentFlags().CountP("verbose", "v", "Log more details: -v logs per-file progress and HTTP status codes (debug), -vv everything (trace)")
+	rootCmd.PersistentFlags().StringP("client-id", "c", "", "OAuth client ID (required)")
+	rootCmd.PersistentFlags().StringP("client-secret", "s", "", "OAuth client secret (required, optional with auth device)")
+	rootCmd.PersistentFlags().String("client-secret-file", "", "Read the OAuth client secret from a file, from stdin with -, or from an inherited file descriptor with fd:N, so it doesn't appear in process arguments or the environment (optional, replaces client-secret)")
+	rootCmd.PersistentFlags().String("auth", authClientCredentials, "How to authenticate: client-credentials, device to sign in as a person by visiting a URL and entering a code, login to use the login stored by auth login, which is also used when no client-secret is set, aws to exchange the ambient AWS credentials of an EC2 instance or EKS pod for a token, kubernetes to exchange the service account token of the pod, azure to exchange the Azure managed or workload identity, or gcp to exchange the Google service account of the metadata server")
+	rootCmd.PersistentFlags().String("token", "", "Pre-issued bearer token to send to the tenant instead of getting one from the token endpoint (optional, replaces client-id and client-secret)")
+	rootCmd.PersistentFlags().Bool("keyring", true, "Use the client secret stored with auth secret set when client-secret is not set, and keep the tokens of token-cache in the OS keyring")
+	rootCmd.PersistentFlags().Bool("token-cache", false, "Keep client credentials tokens in the token cache of cache-dir until they expire, so repeated runs share one token (optional)")
+	rootCmd.PersistentFlags().String("device-auth-endpoint", "", "Device authorization endpoint URL used with auth device (optional, defaults to device_authorization next to the token endpoint)")
+	rootCmd.PersistentFlags().String("aws-exchange-endpoint", "", "AWS identity exchange endpoint URL used with auth aws (optional, defaults to exchange/aws next to the token endpoint)")
+	rootCmd.PersistentFlags().String("service-account-token-file", "", "Kubernetes service account token exchanged with auth kubernetes, e.g. a projected token with the token endpoint as audience (optional, defaults to "+defaultServiceAccountTokenFile+")")
+	rootCmd.PersistentFlags().String("workload-identity-audience", "", "Audience of the Azure or Google identity token exchanged with auth azure or gcp (optional, defaults to the token endpoint)")
+	rootCmd.PersistentFlags().String("credential-helper", "", "Program run with the argument get to get a token or client credentials as JSON on stdout, instead of the client credentials or auth (optional)")
+	rootCmd.PersistentFlags().String("secondary-client-id", "", "OAuth client ID used when the primary client ID is rejected, for credential rotation (optional)")
+	rootCmd.PersistentFlags().String("secondary-client-secret", "", "OAuth client secret of the secondary client ID (optional)")
+	rootCmd.PersistentFlags().StringP("tenant-endpoint", "t", "", "Kusari Tenant endpoint URL (required)")
+	rootCmd.PersistentFlags().StringP("token-endpoint", "k", "https://auth.us.kusari.cloud/oauth2/token", "Token endpoint URL")
+	rootCmd.PersistentFlags().String("run-id", "", "ID attached to every document uploaded in this run (optional, generated if not set)")
+	rootCmd.PersistentFlags().Bool("reproducible", false, "Make generated reports and bundles the same for the same input: sorted, with SOURCE_DATE_EPOCH or the Unix epoch as their time, and a run ID derived from the arguments unless run-id is set")
+	rootCmd.PersistentFlags().StringP("output", "o", outputText, "Output format: text, ndjson to write one JSON object per completed file to stdout as it finishes, or go-template=<template> to render each completed file with a Go template")
+	rootCmd.PersistentFlags().String("ca-bundle", "", "Path to a PEM CA bundle to trust instead of the system CA store (optional)")
+	rootCmd.PersistentFlags().StringSlice("ca-cert", nil, "Comma separated PEM files of private CAs to trust in addition to the system CA store (or ca-bundle), e.g. the CA of a TLS-intercepting proxy (optional)")
+	rootCmd.PersistentFlags().Bool("insecure-skip-tls-verify", false, "Do not verify TLS certificates at all, only for lab environments: the credentials and documents can be read and changed by anyone on the network path")
+	rootCmd.PersistentFlags().String("tls-cert", "", "Path to a PEM client certificate for mutual TLS with gateways that require one (optional, requires tls-key)")
+	rootCmd.PersistentFlags().String("tls-key", "", "Path to the PEM private key of tls-cert (optional)")
+	rootCmd.PersistentFlags().String("tls-min-version", "", "Minimum TLS version for all connections, 1.2 or 1.3 (optional, defaults to 1.2)")
+	rootCmd.PersistentFlags().StringSlice("tls-cipher-suites", nil, "Comma separated TLS 1.2 cipher suites allowed for all connections, e.g. TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 (optional)")
+	rootCmd.PersistentFlags().String("proxy", "", "Proxy URL for all requests, including the token requests and the uploads to presigned URLs, instead of HTTP_PROXY and HTTPS_PROXY; hosts in NO_PROXY still bypass it (optional)")
+	rootCmd.PersistentFlags().String("proxy-auth", proxyAuthBasic, "Proxy authentication scheme: basic, or ntlm for proxies that require Windows authentication")
+	rootCmd.PersistentFlags().String("proxy-user", "", "Proxy user, DOMAIN\\user for ntlm, when the proxy URL has no credentials (optional)")
+	rootCmd.PersistentFlags().String("proxy-password", "", "Password of proxy-user (optional)")
+	rootCmd.PersistentFlags().String("cache-dir", "", "Directory of the on-disk cache of external enrichment API responses such as deps.dev (default: kusari-uploader in the user cache directory)")
+	rootCmd.PersistentFlags().String("state-store", "", "Where to keep backfill state, cached enrichment API responses and the last run report instead of the local disk: a file://, s3://bucket/prefix?region=..., redis:// or rediss:// URL")
+	rootCmd.PersistentFlags().String("pprof-addr", "", "Serve net/http/pprof on this address while the command runs, e.g. localhost:6060 (optional)")
+	rootCmd.PersistentFlags().String("cpuprofile", "", "Write a CPU profile of the run to this file (optional)")
+	rootCmd.PersistentFlags().String("memprofile", "", "Write a heap profile to this file when the run completes (optional)")
+	rootCmd.PersistentFlags().Bool("strict-deprecations", false, "Fail instead of warning when a deprecated command or flag is used, see the deprecations command (optional)")
+	rootCmd.PersistentFlags().String("telemetry", telemetryOff, "Send an anonymous usage report to Kusari when the run ends: off, on, or print to show the report without sending it")
+	rootCmd.PersistentFlags().String("telemetry-endpoint", "", "URL the telemetry report is sent to (optional, defaults to Kusari's telemetry endpoint)")
+	rootCmd.PersistentFlags().StringSlice("inject-fault", nil, "Comma separated faults to simulate, each optionally with the fraction of requests to fail, e.g. presign-500,upload-timeout:0.5")
+	// fault injection is for testing the pipeline around the uploader, not for regular use
+	rootCmd.PersistentFlags().MarkHidden("inject-fault") //nolint:errcheck
+	rootCmd.PersistentFlags().String("org", "", "Kusari organization name used to discover the tenant and token endpoints (optional)")
+	rootCmd.PersistentFlags().String("issuer", "", "OpenID Connect issuer URL whose /.well-known/openid-configuration names the token endpoint, and the device authorization endpoint for auth device (optional, token-endpoint wins if set)")
+	rootCmd.PersistentFlags().String("region", "", "Kusari Platform region whose token endpoint to use, us or eu (optional, by default derived from the tenant endpoint)")
+	// the upload flags are kept on the root command for backward compatibility,
+	// hidden so that its help lists the commands
+	addUploadFlags(rootCmd.Flags())
+	rootCmd.Flags().VisitAll(func(f *pflag.Flag) {
+		f.Hidden = true
+	})
 
 	// Bind flags to Viper with error handling
-	mustBindPFlag(rootCmd, "file-path")
+	mustBindPFlag(rootCmd, "config")
+	mustBindPFlag(rootCmd, "log-level")
+	mustBindPFlag(rootCmd, "verbose")
+	mustBindPFlag(rootCmd, "log-format")
+	mustBindPFlag(rootCmd, "log-file")
+	mustBindPFlag(rootCmd, "no-color")
 	mustBindPFlag(rootCmd, "client-id")
 	mustBindPFlag(rootCmd, "client-secret")
+	mustBindPFlag(rootCmd, "client-secret-file")
+	mustBindPFlag(rootCmd, "auth")
+	mustBindPFlag(rootCmd, "token")
+	mustBindPFlag(rootCmd, "keyring")
+	mustBindPFlag(rootCmd, "token-cache")
+	mustBindPFlag(rootCmd, "device-auth-endpoint")
+	mustBindPFlag(rootCmd, "aws-exchange-endpoint")
+	mustBindPFlag(rootCmd, "service-account-token-file")
+	mustBindPFlag(rootCmd, "workload-identity-audience")
+	mustBindPFlag(rootCmd, "credential-helper")
+	mustBindPFlag(rootCmd, "secondary-client-id")
+	mustBindPFlag(rootCmd, "secondary-client-secret")
 	mustBindPFlag(rootCmd, "tenant-endpoint")
 	mustBindPFlag(rootCmd, "token-endpoint")
-	mustBindPFlag(rootCmd, "alias")
-	mustBindPFlag(rootCmd, "document-type")
-	mustBindPFlag(rootCmd, "open-vex")
-	mustBindPFlag(rootCmd, "tag")
-	mustBindPFlag(rootCmd, "software-id")
-	mustBindPFlag(rootCmd, "sbom-subject")
-	mustBindPFlag(rootCmd, "component-name")
-	mustBindPFlag(rootCmd, "check-blocked-packages")
-
-	// Allow environment variables
-	viper.SetEnvPrefix("UPLOADER")
-	viper.AutomaticEnv()
-
-    // Print the EOL message
-    fmt.Println("WARNING: kusari-uploader will be EOL on April 7, 2026. Use kusari-cli:")
-    fmt.Println("     https://docs.kusari.cloud/software/ingest-sboms/kusari-uploader")
+	mustBindPFlag(rootCmd, "org")
+	mustBindPFlag(rootCmd, "region")
+	mustBindPFlag(rootCmd, "issuer")
+	mustBindPFlag(rootCmd, "inject-fault")
+	mustBindPFlag(rootCmd, "strict-deprecations")
+	mustBindPFlag(rootCmd, "telemetry")
+	mustBindPFlag(rootCmd, "telemetry-endpoint")
+	mustBindPFlag(rootCmd, "cache-dir")
+	mustBindPFlag(rootCmd, "state-store")
+	mustBindPFlag(rootCmd, "pprof-addr")
+	mustBindPFlag(rootCmd, "cpuprofile")
+	mustBindPFlag(rootCmd, "memprofile")
+	mustBindPFlag(rootCmd, "ca-bundle")
+	mustBindPFlag(rootCmd, "ca-cert")
+	mustBindPFlag(rootCmd, "insecure-skip-tls-verify")
+	mustBindPFlag(rootCmd, "tls-cert")
+	mustBindPFlag(rootCmd, "tls-key")
+	mustBindPFlag(rootCmd, "proxy")
+	mustBindPFlag(rootCmd, "proxy-auth")
+	mustBindPFlag(rootCmd, "proxy-user")
+	mustBindPFlag(rootCmd, "proxy-password")
+	mustBindPFlag(rootCmd, "tls-min-version")
+	mustBindPFlag(rootCmd, "tls-cipher-suites")
+	mustBindPFlag(rootCmd, "run-id")
+	mustBindPFlag(rootCmd, "reproducible")
+	mustBindPFlag(rootCmd, "output")
+
+	cobra.OnInitialize(initConfig)
+
+	rootCmd.AddCommand(newUploadCmd())
+	rootCmd.AddCommand(newCheckCmd())
+	rootCmd.AddCommand(newVersionCmd())
+	rootCmd.AddCommand(newBackfillCmd())
+	rootCmd.AddCommand(newReconcileCmd())
+	rootCmd.AddCommand(newExplainCmd())
+	rootCmd.AddCommand(newEnvTemplateCmd())
+	rootCmd.AddCommand(newCheckBlockedCmd())
+	rootCmd.AddCommand(newListDocumentsCmd())
+	rootCmd.AddCommand(newGenSampleCmd())
+	rootCmd.AddCommand(newDeprecationsCmd())
+	rootCmd.AddCommand(newVerifySelfCmd())
+	rootCmd.AddCommand(newDoctorCmd())
+	rootCmd.AddCommand(newExportBundleCmd())
+	rootCmd.AddCommand(newImportBundleCmd())
+	rootCmd.AddCommand(newSupportBundleCmd())
+	rootCmd.AddCommand(newReportCmd())
+	rootCmd.AddCommand(newRetryFailedCmd())
+	rootCmd.AddCommand(newValidateCmd())
+	rootCmd.AddCommand(newAuthCmd())
+	rootCmd.AddCommand(newServiceCmd())
+	if restrictedBuild {
+		restrictCommands(rootCmd)
+	}
+
+	// Print the EOL message to stderr so it does not mix with machine-readable output
+	fmt.Fprintln(os.Stderr, "WARNING: kusari-uploader will be EOL on April 7, 2026. Use kusari-cli:")
+	fmt.Fprintln(os.Stderr, "     https://docs.kusari.cloud/software/ingest-sboms/kusari-uploader")
 
 	// Execute the command
 	if err := rootCmd.Execute(); err != nil {
@@ -168,14 +291,58 @@ func main() {
 	}
 }
 
+// envPrefixes are the environment variable prefixes that can be used to set
+// any flag, in order of precedence. A flag set on the command line always wins
+// over the environment, which wins over the config file, which wins over the
+// flag default.
+var envPrefixes = []string{"UPLOADER", "KUSARI"}
+
+// envPrefixVar names an additional environment variable prefix, which wins
+// over envPrefixes, for CI systems that namespace their variables
+const envPrefixVar = "UPLOADER_ENV_PREFIX"
+
+// envVarNames returns the environment variables that set flagName, in order of precedence
+func envVarNames(flagName string) []string {
+	prefixes := envPrefixes
+	if custom := strings.TrimSuffix(strings.ToUpper(os.Getenv(envPrefixVar)), "_"); custom != "" {
+		prefixes = append([]string{custom}, envPrefixes...)
+	}
+
+	suffix := strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+	names := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		names = append(names, prefix+"_"+suffix)
+	}
+	return names
+}
+
+// bindEnvVars binds the environment variables of every flag of the command
+// being run, so flags that are not bound with mustBindPFlag can be set through
+// the environment as well
+func bindEnvVars(cmd *cobra.Command, args []string) {
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if envErr := viper.BindEnv(append([]string{flag.Name}, envVarNames(flag.Name)...)...); envErr != nil {
+			log.Fatal().
+				Err(envErr).
+				Str("flagName", flag.Name).
+				Msg("Failed bind env")
+		}
+	})
+}
+
+// mustBindPFlag binds a flag and its environment variables to viper
 func mustBindPFlag(cmd *cobra.Command, flagName string) {
-	if bindErr := viper.BindPFlag(flagName, cmd.Flags().Lookup(flagName)); bindErr != nil {
+	flag := cmd.Flags().Lookup(flagName)
+	if flag == nil {
+		flag = cmd.PersistentFlags().Lookup(flagName)
+	}
+	if bindErr := viper.BindPFlag(flagName, flag); bindErr != nil {
 		log.Fatal().
 			Err(bindErr).
 			Str("flagName", flagName).
 			Msg("Failed bind flags")
 	}
-	if envErr := viper.BindEnv(flagName, "UPLOADER_"+strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))); envErr != nil {
+	if envErr := viper.BindEnv(append([]string{flagName}, envVarNames(flagName)...)...); envErr != nil {
 		log.Fatal().
 			Err(envErr).
 			Str("flagName", flagName).
@@ -183,108 +350,445 @@ func mustBindPFlag(cmd *cobra.Command, flagName string) {
 	}
 }
 
+// initConfig reads the config file, if one was given, so its values can be
+// used for any flag that was not set on the command line or in the environment
+func initConfig() {
+	configFile := viper.GetString("config")
+	if configFile == "" {
+		return
+	}
+
+	viper.SetConfigFile(configFile)
+	if err := viper.ReadInConfig(); err != nil {
+		log.Fatal().
+			Err(err).
+			Str("config", configFile).
+			Msg("Failed to read config file")
+	}
+}
+
 type sbomSubjectAndURI struct {
 	subject string
 	uri     string
+	docRef  string
 }
 
 func uploadFiles(cmd *cobra.Command, args []string) {
+	if schedule := viper.GetString("schedule"); schedule != "" {
+		uploadOnSchedule(schedule)
+		return
+	}
+	if profiles := viper.GetStringSlice("profile"); len(profiles) > 0 {
+		uploadProfiles(cmd, profiles)
+		return
+	}
+
 	ctx := context.Background()
 
 	// Retrieve configuration values
 	filePath := viper.GetString("file-path")
-	clientID := viper.GetString("client-id")
-	clientSecret := viper.GetString("client-secret")
-	tenantEndPoint := viper.GetString("tenant-endpoint")
-	tokenEndPoint := viper.GetString("token-endpoint")
-	alias := viper.GetString("alias")
-	docType := viper.GetString("document-type")
+	filesFrom := viper.GetString("files-from")
 	isOpenVex := viper.GetBool("open-vex")
 	tag := viper.GetString("tag")
 	softwareID := viper.GetString("software-id")
 	sbomSubject := viper.GetString("sbom-subject")
-	componentName := viper.GetString("component-name")
+	vexProductMapPath := viper.GetString("vex-product-map")
+	failOnVEXConflict := viper.GetBool("fail-on-vex-conflict")
+	pinSbomID := viper.GetBool("pin-sbom-id")
+	resolveSoftware := viper.GetBool("resolve-software-id")
+	propagateTag := viper.GetBool("propagate-tag")
+	verifyProvenance := viper.GetBool("verify-provenance")
 	checkBlockedPackages := viper.GetBool("check-blocked-packages")
+	checkOnly := viper.GetBool("check-only")
+	interactive := viper.GetBool("interactive")
+
+	runID := resolveRunID()
+	runStart := time.Now()
+	results, messages, err := newOutput(viper.GetString("output"), runID)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid output format")
+	}
+
+	ctx, defaultClient := mustNewHTTPClient(ctx)
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, defaultClient)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
 
 	// Validate required configuration
-	if filePath == "" || clientID == "" || clientSecret == "" ||
+	if (filePath == "" && filesFrom == "") || !hasClientCredentials() ||
 		tenantEndPoint == "" || tokenEndPoint == "" {
-		log.Fatal().Msg("All required flag(s) must be provided: client-id, client-secret, file-path, tenant-endpoint, token-endpoint")
+		log.Fatal().Msg("All required flag(s) must be provided: client-id, client-secret, file-path (or files-from), tenant-endpoint (or org), token-endpoint")
 	}
 
-	if isOpenVex && (tag == "" || (softwareID == "" && sbomSubject == "")) {
-		log.Fatal().Msg("When using OpenVEX, tag must be specified, and so must software-id or sbom-subject")
+	if filePath != "" && filesFrom != "" {
+		log.Fatal().Msg("file-path and files-from can't be used together")
 	}
 
-	// Get authorized client
-	authorizedClient := getAuthorizedClient(ctx, clientID, clientSecret, tokenEndPoint)
-	defaultClient := &http.Client{}
+	if filesFrom == "-" && viper.GetString("client-secret-file") == "-" {
+		log.Fatal().Msg("files-from and client-secret-file can't both be read from stdin")
+	}
+
+	if filesFrom != "" && isOpenVex {
+		log.Fatal().Msg("OpenVEX can't be used with files-from, only single files")
+	}
+
+	// file-path can also be a glob pattern, expanded like a file list, or
+	// the s3:// URL of a bucket prefix, listed as the upload goes
+	glob := filesFrom == "" && isGlobPattern(filePath)
+	objectStore := filesFrom == "" && strings.HasPrefix(filePath, "s3://")
+	if (glob || objectStore) && isOpenVex {
+		log.Fatal().Msg("OpenVEX can't be used with glob patterns or s3:// URLs, only single files")
+	}
+
+	if objectStore && (checkOnly || interactive || verifyProvenance) {
+		log.Fatal().Msg("file-path s3:// URLs can't be used with check-only, interactive or verify-provenance")
+	}
+
+	if isOpenVex && (tag == "" || (softwareID == "" && sbomSubject == "" && vexProductMapPath == "" && !resolveSoftware)) {
+		log.Fatal().Msg("When using OpenVEX, tag must be specified, and so must software-id, sbom-subject, vex-product-map or resolve-software-id")
+	}
+
+	if _, err := compileSubjectMatch(viper.GetString("subject-match"), sbomSubject); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid subject-match")
+	}
+
+	if resolveSoftware && softwareID != "" {
+		log.Fatal().Msg("resolve-software-id can't be used with software-id")
+	}
+
+	mustValidateDocRefTemplate()
+	mustValidateBlockedSinks()
+	if _, err := auditReportEnabled(); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid report")
+	}
+	if _, err := resolvePacingWindow(); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid pacing-window")
+	}
+	if _, err := compileLicensePatterns(viper.GetStringSlice("deny-license")); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid deny-license")
+	}
+	if _, err := resolveQuarantinePolicy(); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid quarantine settings")
+	}
+	mustResolveForceFlags()
+	mustValidateCustomMetadata(cmd.Flags())
+
+	if isOpenVex && viper.GetString("force-type") != "" && viper.GetString("force-type") != string(DocumentOpenVEX) {
+		log.Fatal().Msg("open-vex can't be used with a force-type other than OPEN_VEX")
+	}
+
+	if vexProductMapPath != "" && !isOpenVex {
+		log.Fatal().Msg("vex-product-map can only be used with open-vex")
+	}
+
+	if pinSbomID && (!isOpenVex || sbomSubject == "") {
+		log.Fatal().Msg("pin-sbom-id can only be used with open-vex and sbom-subject")
+	}
+
+	if propagateTag && !isOpenVex {
+		log.Fatal().Msg("propagate-tag can only be used with open-vex")
+	}
+
+	if checkOnly && isOpenVex {
+		log.Fatal().Msg("check-only can't be used with open-vex, it checks SBOMs")
+	}
+
+	if interactive && (checkOnly || isOpenVex || filesFrom == "-") {
+		log.Fatal().Msg("interactive can't be used with check-only, open-vex or files-from from stdin")
+	}
 
-	// Check if path is a directory or file
-	fileInfo, err := os.Stat(filePath)
+	// Get authorized client
+	creds, err := clientCredentials()
 	if err != nil {
 		log.Fatal().
 			Err(err).
-			Msg("Error getting file info")
+			Msg("Invalid client credentials")
+	}
+	authorizedClient := getAuthorizedClient(ctx, tokenEndPoint, creds)
+
+	var fileList []string
+	var fileInfo os.FileInfo
+	if filesFrom != "" {
+		fileList, err = loadFileList(filesFrom, viper.GetBool("null"))
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Error reading file list")
+		}
+	} else if glob {
+		fileList, err = expandGlob(filePath)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Error expanding glob pattern")
+		}
+		if len(fileList) == 0 {
+			log.Fatal().Msg("No files match " + filePath)
+		}
+	} else if !objectStore {
+		// Check if path is a directory or file
+		fileInfo, err = os.Stat(filePath)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Error getting file info")
+		}
+
+		if fileInfo.IsDir() && isOpenVex {
+			log.Fatal().Msg("OpenVEX can't be used with directories, only single files")
+		}
+	}
+
+	// selected are the files chosen with --interactive, nil uploads every file
+	var selected map[string]bool
+	if interactive {
+		picked, err := pickFilesOnTerminal(filePath, fileList)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Interactive file selection failed")
+		}
+		if len(picked) == 0 {
+			fmt.Fprintln(messages, "No files selected, nothing was uploaded")
+			return
+		}
+		selected = map[string]bool{}
+		for _, c := range picked {
+			selected[c.Path] = true
+		}
+		if fileList != nil {
+			fileList = filterFileList(fileList, selected)
+		}
 	}
 
-	if fileInfo.IsDir() && isOpenVex {
-		log.Fatal().Msg("OpenVEX can't be used with directories, only single files")
+	if checkOnly {
+		ssaus, err := collectSBOMSubjects(filePath, fileList)
+		if err != nil {
+			fatalErr(err, exitUsage).
+				Msg("Error reading SBOMs")
+		}
+		if len(ssaus) == 0 {
+			log.Fatal().Msg("No SBOMs with a subject and URI found to check")
+		}
+
+		blocked := mustCheckBlockedPackages(ctx, messages, authorizedClient, tenantEndPoint, ssaus)
+		unmaintained := mustCheckMaintenance(ctx, messages, defaultClient)
+		printTyposquatReport(messages, runTyposquats.summary())
+		printRetrySummary(messages, runRetries.summary())
+		mustWriteAuditReport(messages, runID)
+		if blocked || unmaintained {
+			exitRun(exitBlocked)
+		}
+		fmt.Fprintf(messages, "No blocked packages found in %d SBOM(s)\n", len(ssaus))
+		return
 	}
 
-	uploadMeta := map[string]string{}
-	if alias != "" {
-		uploadMeta["alias"] = alias
+	caps := negotiateCapabilities(ctx, authorizedClient, tenantEndPoint)
+	if isOpenVex && !caps.supportsDocumentType(DocumentOpenVEX) {
+		log.Fatal().Msg("The tenant does not support OpenVEX documents")
 	}
-	if docType != "" {
-		uploadMeta["type"] = docType
+	if forceType := viper.GetString("force-type"); forceType != "" && !caps.supportsDocumentType(DocumentType(forceType)) {
+		log.Fatal().Msg("The tenant does not support " + forceType + " documents")
 	}
-	if tag != "" {
-		uploadMeta["tag"] = tag
+	if !objectStore {
+		if err := checkUploadSizes(caps, filePath, fileList); err != nil {
+			fatalErr(err, exitValidation).
+				Msg("Document too large for the tenant")
+		}
 	}
-	if softwareID != "" {
-		uploadMeta["software_id"] = softwareID
+	mustNegotiateWrapperVersion(caps)
+
+	uploadMeta := metadataValues(resolveUploadMetadata(cmd.Flags(), nil, ""))
+	uploadMeta["run_id"] = runID
+
+	if err := resolveSbomSubject(ctx, authorizedClient, tenantEndPoint, uploadMeta); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Failed to resolve the sbom-subject")
 	}
-	if sbomSubject != "" {
-		uploadMeta["sbom_subject"] = sbomSubject
+	if err := resolveSoftwareID(ctx, authorizedClient, tenantEndPoint, uploadMeta, isOpenVex && sbomSubject == "" && vexProductMapPath == ""); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Failed to resolve the software ID")
 	}
-	if componentName != "" {
-		uploadMeta["component_name"] = componentName
+
+	if pinSbomID {
+		if err := pinSbomSubject(ctx, authorizedClient, tenantEndPoint, sbomSubject, uploadMeta); err != nil {
+			fatalErr(err, exitUsage).
+				Msg("Failed to pin SBOM ID")
+		}
 	}
 
+	if isOpenVex {
+		if err := checkVEXConflicts(ctx, authorizedClient, tenantEndPoint, filePath, failOnVEXConflict); err != nil {
+			fatalErr(err, exitValidation).
+				Msg("OpenVEX conflict check failed")
+		}
+	}
+
+	rules, err := loadRoutingRules()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid routing rules")
+	}
+	mustRegisterComponents(ctx, messages, authorizedClient, tenantEndPoint, componentNames(uploadMeta, rules))
+
 	var ssaus []sbomSubjectAndURI
+	// the software IDs an OpenVEX document split with a product map was uploaded for
+	var vexSoftwareIDs []string
+	// directory and file list uploads are summarized at the end of the run
+	var summary *uploadSummary
+	// pushSummary pushes the report of the run, of the summary or else of the
+	// results of a single file or OpenVEX upload
+	pushSummary := func(gate string, single ...fileResult) {
+		report := singleRunReport(runID, runStart, time.Now(), single...)
+		if summary != nil {
+			report = newRunReport(summary, runID, time.Now())
+		}
+		mustPushRunSummary(ctx, authorizedClient, tenantEndPoint, report, gate)
+	}
+	var single fileResult
 	// Upload based on file type
-	if fileInfo.IsDir() {
-		ssaus, err = uploadDirectory(authorizedClient, defaultClient, tenantEndPoint, filePath, uploadMeta)
+	if vexProductMapPath != "" {
+		productMap, err := loadVEXProductMap(vexProductMapPath)
 		if err != nil {
 			log.Fatal().
 				Err(err).
-				Msg("Directory upload failed")
+				Msg("Invalid OpenVEX product map")
 		}
-	} else {
-		ssau, err := uploadSingleFile(authorizedClient, defaultClient, tenantEndPoint, filePath, isOpenVex, uploadMeta)
+		vexSoftwareIDs, err = uploadSplitVEX(authorizedClient, defaultClient, tenantEndPoint, filePath, productMap, uploadMeta)
+		if err != nil {
+			single = fileResult{Path: filePath, Status: resultFailed, Error: err.Error()}
+			results.write(single)
+			pushSummary(gateFailed, single)
+			fatalErr(err, exitUpload).
+				Msg("OpenVEX upload failed")
+		}
+		single = fileResult{Path: filePath, Status: resultUploaded}
+		results.write(single)
+	} else if objectStore {
+		objects, err := newObjectStoreSource(filePath)
 		if err != nil {
 			log.Fatal().
 				Err(err).
+				Msg("Invalid object store URL")
+		}
+		summary = newUploadSummary(time.Now())
+		ssaus, err = uploadSource(authorizedClient, defaultClient, tenantEndPoint, objects, uploadMeta, rules, results.withSummary(summary))
+		if err != nil {
+			reportUploadSummary(messages, summary, runID)
+			pushSummary(gateFailed)
+			fatalErr(err, exitUpload).
+				Msg("Object store upload failed")
+		}
+	} else if fileList != nil {
+		if verifyProvenance {
+			if err := verifyProvenanceSubjects(filePath, fileList); err != nil {
+				fatalErr(err, exitValidation).
+					Msg("Provenance verification failed")
+			}
+		}
+		summary = newUploadSummary(time.Now())
+		ssaus, err = uploadFileList(authorizedClient, defaultClient, tenantEndPoint, fileList, uploadMeta, rules, results.withSummary(summary))
+		if err != nil {
+			reportUploadSummary(messages, summary, runID)
+			pushSummary(gateFailed)
+			fatalErr(err, exitUpload).
+				Msg("File list upload failed")
+		}
+	} else if fileInfo.IsDir() {
+		if verifyProvenance {
+			if err := verifyProvenanceSubjects(filePath, nil); err != nil {
+				fatalErr(err, exitValidation).
+					Msg("Provenance verification failed")
+			}
+		}
+		summary = newUploadSummary(time.Now())
+		ssaus, err = uploadDirectory(authorizedClient, defaultClient, tenantEndPoint, filePath, uploadMeta, rules, selected, results.withSummary(summary))
+		if err != nil {
+			reportUploadSummary(messages, summary, runID)
+			pushSummary(gateFailed)
+			fatalErr(err, exitUpload).
+				Msg("Directory upload failed")
+		}
+	} else {
+		ssau, err := uploadSingleFile(authorizedClient, defaultClient, tenantEndPoint, filePath, isOpenVex,
+			applyRoutingRules(rules, filePath, uploadMeta))
+		if err != nil {
+			single = fileResult{Path: filePath, Status: resultFailed, Error: err.Error()}
+			results.write(single)
+			pushSummary(gateFailed, single)
+			fatalErr(err, exitUpload).
 				Msg("Single file upload failed")
 		}
+		single = fileResult{Path: filePath, Status: uploadStatus(fileInfo), DocumentRef: ssau.docRef}
+		results.write(single)
 		ssaus = []sbomSubjectAndURI{ssau}
 	}
 
-	fmt.Println("Upload completed successfully")
+	fmt.Fprintln(messages, "Upload completed successfully")
+	fmt.Fprintf(messages, "Run ID: %s\n", runID)
+	if isOpenVex {
+		mustPropagateVEXTag(ctx, messages, authorizedClient, tenantEndPoint, uploadMeta, vexSoftwareIDs)
+	}
+	if summary != nil {
+		summary.addSBOMs(ssaus)
+		reportUploadSummary(messages, summary, runID)
+	}
 
-	if checkBlockedPackages {
-		blocked, err := checkSBOMsForBlockedPackages(ctx, authorizedClient, tenantEndPoint, ssaus)
-		if err != nil {
-			log.Fatal().
-				Err(err).
-				Msg("Error checking for blocked packages")
-		}
+	blocked := checkBlockedPackages && mustCheckBlockedPackages(ctx, messages, authorizedClient, tenantEndPoint, ssaus)
+	unmaintained := mustCheckMaintenance(ctx, messages, defaultClient)
+
+	printTyposquatReport(messages, runTyposquats.summary())
+	printRetrySummary(messages, runRetries.summary())
+	mustWriteAuditReport(messages, runID)
+
+	quarantined := summary != nil && summary.count(resultQuarantined) > 0
+	switch {
+	case blocked || unmaintained:
+		pushSummary(gateBlocked, single)
+		exitRun(exitBlocked)
+	case quarantined:
+		pushSummary(gateFailed, single)
+		exitRun(exitUpload)
+	default:
+		pushSummary(gatePassed, single)
+	}
+}
 
-		if blocked {
-			os.Exit(1)
-		}
+// mustCheckBlockedPackages runs the blocked package check and reports whether
+// any SBOM uses a blocked package
+func mustCheckBlockedPackages(ctx context.Context, messages io.Writer, client HttpClient, tenantEndpoint string,
+	ssaus []sbomSubjectAndURI) bool {
+	limits, err := resolvePhaseLimits()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid concurrency")
 	}
+
+	findings, err := checkSBOMsForBlockedPackages(ctx, client, tenantEndpoint, ssaus, limits.Check)
+	if err != nil {
+		printRetrySummary(messages, runRetries.summary())
+		fatalErr(err, exitUsage).
+			Msg("Error checking for blocked packages")
+	}
+	runAudit.recordBlocked(findings)
+	if err := writeBlockedFindings(ctx, messages, findings); err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Error writing blocked package findings")
+	}
+	return len(findings) > 0
 }
 
 type softwareIDAndSbomID struct {
@@ -297,15 +801,17 @@ type blockedPackages struct {
 	BlockedPackages []string `json:"blocked_packages"`
 }
 
-func checkSBOMsForBlockedPackages(ctx context.Context, client HttpClient, tenantEndpoint string, ssaus []sbomSubjectAndURI) (bool, error) {
+// checkSBOMsForBlockedPackages returns the SBOMs that use blocked packages, in
+// the order given
+func checkSBOMsForBlockedPackages(ctx context.Context, client HttpClient, tenantEndpoint string, ssaus []sbomSubjectAndURI,
+	limit int) ([]blockedFinding, error) {
 	ctx, cancel := context.WithTimeout(ctx, 15*time.Minute)
 	defer cancel()
 
 	g, ctx := errgroup.WithContext(ctx)
-	g.SetLimit(5)
+	g.SetLimit(limit)
 
-	blocked := make([]bool, len(ssaus))
-	blockedPurls := make([][]string, len(ssaus))
+	findings := make([]*blockedFinding, len(ssaus))
 
 	for i, ssau := range ssaus {
 		if ssau.subject == "" && ssau.uri == "" {
@@ -313,58 +819,34 @@ func checkSBOMsForBlockedPackages(ctx context.Context, client HttpClient, tenant
 		}
 
 		g.Go(func() error {
-			var ids softwareIDAndSbomID
-
+			var ids *softwareIDAndSbomID
 			for {
-				res, err := makePicoReq(ctx, client, tenantEndpoint, fmt.Sprintf("pico/v1/software/id?software_name=%s&sbom_uri=%s",
-					url.QueryEscape(ssau.subject), url.QueryEscape(ssau.uri)))
+				var err error
+				ids, err = lookupSoftwareAndSbomID(ctx, client, tenantEndpoint, ssau.subject, ssau.uri)
 				if err != nil {
-					return fmt.Errorf("error making request for IDs: %w", err)
+					return err
 				}
-				defer res.Body.Close() //nolint:errcheck
-
-				if res.StatusCode == 200 {
-					body, err := io.ReadAll(res.Body)
-					if err != nil {
-						return fmt.Errorf("error reading response body for IDs: %w", err)
-					}
-
-					if err := json.Unmarshal(body, &ids); err != nil {
-						return fmt.Errorf("error unmarshaling response body for IDs: %w", err)
-					}
-
+				if ids != nil {
 					break
-				} else if res.StatusCode == 404 {
-					time.Sleep(time.Second)
-				} else {
-					return fmt.Errorf("unexpected response status code for IDs: %d", res.StatusCode)
 				}
+				// the SBOM has not been ingested yet
+				runRetries.record(ssau.subject, retryPhaseIngestion, time.Second)
+				time.Sleep(time.Second)
 			}
 
-			res, err := makePicoReq(ctx, client, tenantEndpoint, fmt.Sprintf("pico/v1/packages/blocked/check/software/%d/sbom/%d",
-				ids.SoftwareID, ids.SbomID))
+			bps, err := getBlockedPackages(ctx, client, tenantEndpoint, ids)
 			if err != nil {
-				return fmt.Errorf("error making request for check: %w", err)
+				return err
 			}
-			defer res.Body.Close() //nolint:errcheck
-
-			if res.StatusCode == 200 {
-				body, err := io.ReadAll(res.Body)
-				if err != nil {
-					return fmt.Errorf("error reading response body for check: %w", err)
-				}
 
-				var bps blockedPackages
-				if err := json.Unmarshal(body, &bps); err != nil {
-					return fmt.Errorf("error unmarshaling response body for check: %w", err)
+			if bps.Blocked {
+				findings[i] = &blockedFinding{
+					Subject:         ssau.subject,
+					URI:             ssau.uri,
+					SoftwareID:      ids.SoftwareID,
+					SbomID:          ids.SbomID,
+					BlockedPackages: bps.BlockedPackages,
 				}
-
-				if bps.Blocked {
-					blocked[i] = true
-					blockedPurls[i] = bps.BlockedPackages
-				}
-			} else {
-				return fmt.Errorf("unexpected response status code for check: %d", res.StatusCode)
 			}
 
 			return nil
@@ -372,20 +854,72 @@ func checkSBOMsForBlockedPackages(ctx context.Context, client HttpClient, tenant
 	}
 
 	if err := g.Wait(); err != nil {
-		return false, err
+		return nil, err
 	}
 
-	for i, v := range blocked {
-		if v {
-			fmt.Printf("Blocked packages found for SBOM subject %s with URI %s\n", ssaus[i].subject, ssaus[i].uri)
-			for _, bp := range blockedPurls[i] {
-				fmt.Println(bp)
-			}
-			fmt.Println()
+	var blocked []blockedFinding
+	for _, finding := range findings {
+		if finding != nil {
+			blocked = append(blocked, *finding)
 		}
 	}
+	return blocked, nil
+}
+
+// getBlockedPackages runs the blocked package check for an ingested SBOM
+func getBlockedPackages(ctx context.Context, client HttpClient, tenantEndpoint string, ids *softwareIDAndSbomID) (*blockedPackages, error) {
+	res, err := makePicoReq(ctx, client, tenantEndpoint, fmt.Sprintf("pico/v1/packages/blocked/check/software/%d/sbom/%d",
+		ids.SoftwareID, ids.SbomID))
+	if err != nil {
+		return nil, fmt.Errorf("error making request for check: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
 
-	return slices.Contains(blocked, true), nil
+	if res.StatusCode != http.StatusOK {
+		return nil, tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for check: %d", res.StatusCode))
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body for check: %w", err)
+	}
+
+	var bps blockedPackages
+	if err := json.Unmarshal(body, &bps); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response body for check: %w", err)
+	}
+
+	return &bps, nil
+}
+
+// lookupSoftwareAndSbomID returns the platform IDs of the SBOM with the given
+// subject and URI, or nil if the platform does not know about it (yet). When uri
+// is empty the most recent SBOM of the software is returned.
+func lookupSoftwareAndSbomID(ctx context.Context, client HttpClient, tenantEndpoint, subject, uri string) (*softwareIDAndSbomID, error) {
+	res, err := makePicoReq(ctx, client, tenantEndpoint, fmt.Sprintf("pico/v1/software/id?software_name=%s&sbom_uri=%s",
+		url.QueryEscape(subject), url.QueryEscape(uri)))
+	if err != nil {
+		return nil, fmt.Errorf("error making request for IDs: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response body for IDs: %w", err)
+		}
+
+		var ids softwareIDAndSbomID
+		if err := json.Unmarshal(body, &ids); err != nil {
+			return nil, fmt.Errorf("error unmarshaling response body for IDs: %w", err)
+		}
+		return &ids, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for IDs: %d", res.StatusCode))
+	}
 }
 
 func makePicoReq(ctx context.Context, client HttpClient, tenantURL, pathAndQS string) (*http.Response, error) {
@@ -402,15 +936,48 @@ func makePicoReq(ctx context.Context, client HttpClient, tenantURL, pathAndQS st
 	return res, nil
 }
 
-// getAuthorizedClient utilizes oauth2 client credential flow to obtain an authorized client
-func getAuthorizedClient(ctx context.Context, clientID, clientSecret, tokenURL string) HttpClient {
-	config := &clientcredentials.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		TokenURL:     tokenURL,
+// pinSbomSubject looks up the SBOM that sbomSubject currently resolves to, or
+// the latest SBOM of the software --subject-match resolved it to, and
+// records its ID in uploadMeta, so the OpenVEX document is attached to that SBOM
+// even if a newer one is uploaded before the document is ingested
+func pinSbomSubject(ctx context.Context, client HttpClient, tenantEndpoint, sbomSubject string, uploadMeta map[string]string) error {
+	var ids *softwareIDAndSbomID
+	var err error
+	if _, ok := uploadMeta["sbom_subject"]; ok {
+		ids, err = lookupSoftwareAndSbomID(ctx, client, tenantEndpoint, sbomSubject, "")
+	} else {
+		// --subject-match resolved the subject to a software ID
+		softwareID, parseErr := strconv.ParseInt(uploadMeta["software_id"], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("invalid software ID %q", uploadMeta["software_id"])
+		}
+		ids, err = lookupLatestSbomID(ctx, client, tenantEndpoint, softwareID)
+	}
+	if err != nil {
+		return err
+	}
+	if ids == nil {
+		return fmt.Errorf("no SBOM found for sbom-subject %s", sbomSubject)
 	}
 
-	return config.Client(ctx)
+	uploadMeta["sbom_id"] = strconv.FormatInt(ids.SbomID, 10)
+	if _, ok := uploadMeta["software_id"]; !ok {
+		uploadMeta["software_id"] = strconv.FormatInt(ids.SoftwareID, 10)
+	}
+	log.Info().
+		Int64("softwareID", ids.SoftwareID).
+		Int64("sbomID", ids.SbomID).
+		Msg("Pinned OpenVEX document to SBOM")
+
+	return nil
+}
+
+// getAuthorizedClient utilizes oauth2 client credential flow to obtain an authorized client.
+// When several credentials are given, the next one is used if the token endpoint rejects the previous one.
+// With auth device or login the user signs in as a person instead, see newTokenSource.
+// Requests the tenant rejects with 401 are retried once with a new token, see refreshingTransport.
+func getAuthorizedClient(ctx context.Context, tokenURL string, creds []clientCredential) HttpClient {
+	return newRefreshingClient(ctx, newTokenSource(ctx, tokenURL, creds))
 }
 
 // getPresignedUrl utilizes authorized client to obtain the presigned URL to upload to S3
@@ -427,10 +994,10 @@ func getPresignedUrl(authorizedClient HttpClient, tenantApiEndpoint string, payl
 
 	if resp.StatusCode != http.StatusOK {
 		if resp.StatusCode == http.StatusUnauthorized {
-			return "", fmt.Errorf("getPresignedUrl failed with unauthorized request: %d", resp.StatusCode)
+			return "", withExitCode(exitAuth, fmt.Errorf("getPresignedUrl failed with unauthorized request: %d", resp.StatusCode))
 		}
 		// otherwise return an error
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", tenantStatusError(resp.StatusCode, &statusCodeError{code: resp.StatusCode})
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -453,25 +1020,22 @@ func getPresignedUrl(authorizedClient HttpClient, tenantApiEndpoint string, payl
 	return presignedUrl, nil
 }
 
-// uploadDirectory uses filepath.Walk to walk through the directory and upload the files that are found
-func uploadDirectory(authorizedClient, defaultClient HttpClient, tenantApiEndpoint, dirPath string, uploadMeta map[string]string) ([]sbomSubjectAndURI, error) {
-	var ssaus []sbomSubjectAndURI
-
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			ssau, err := uploadSingleFile(authorizedClient, defaultClient, tenantApiEndpoint, path, false, uploadMeta)
-			if err != nil {
-				return fmt.Errorf("uploadSingleFile failed with error: %w", err)
-			}
-			ssaus = append(ssaus, ssau)
-		}
-		return nil
-	})
+// uploadDirectory uses filepath.Walk to walk through the directory and upload the files that are found.
+// The metadata of each file is derived from uploadMeta and the routing rules matching its path.
+// The outcome of each file is written to results as soon as it completes.
+// Only the selected files are uploaded, or every file if selected is nil.
+func uploadDirectory(authorizedClient, defaultClient HttpClient, tenantApiEndpoint, dirPath string, uploadMeta map[string]string,
+	rules []routingRule, selected map[string]bool, results *resultStream) ([]sbomSubjectAndURI, error) {
+	return uploadSource(authorizedClient, defaultClient, tenantApiEndpoint, source.NewDirectory(dirPath, selected), uploadMeta, rules, results)
+}
 
-	return ssaus, err
+// uploadStatus returns the result status of a file that was uploaded without
+// error, taking into account that empty files are skipped
+func uploadStatus(info os.FileInfo) string {
+	if info.Size() == 0 {
+		return resultSkipped
+	}
+	return resultUploaded
 }
 
 // uploadSingleFile creates a presigned URL for the filepath and calls uploadFile to upload the actual file
@@ -484,6 +1048,7 @@ func uploadSingleFile(authorizedClient, defaultClient HttpClient, tenantApiEndpo
 	}
 	// if file is empty, do not upload and return nil
 	if checkFile.Size() == 0 {
+		log.Debug().Str("filePath", filePath).Msg("Skipping empty file")
 		return sbomSubjectAndURI{}, nil
 	}
 
@@ -492,9 +1057,58 @@ func uploadSingleFile(authorizedClient, defaultClient HttpClient, tenantApiEndpo
 		return sbomSubjectAndURI{}, fmt.Errorf("error reading file: %s, err: %w", filePath, err)
 	}
 
+	return uploadFileBlob(authorizedClient, defaultClient, tenantApiEndpoint, filePath, blob, isOpenVex, uploadMeta)
+}
+
+// uploadFileBlob requests a presigned URL for an already read file and uploads it
+func uploadFileBlob(authorizedClient, defaultClient HttpClient, tenantApiEndpoint, filePath string, blob []byte, isOpenVex bool,
+	uploadMeta map[string]string) (sbomSubjectAndURI, error) {
+	sum := getHash(blob)
+	docRef, err := documentRef(blob, sum, uploadMeta)
+	if err != nil {
+		return sbomSubjectAndURI{}, err
+	}
+	return uploadHashedBlob(authorizedClient, defaultClient, tenantApiEndpoint, filePath, blob, sum, docRef, isOpenVex, uploadMeta)
+}
+
+// uploadHashedBlob uploads an already read file whose sha256 sum and document
+// ref were already computed, so the content is hashed only once
+func uploadHashedBlob(authorizedClient, defaultClient HttpClient, tenantApiEndpoint, filePath string, blob []byte, sum, docRef string, isOpenVex bool,
+	uploadMeta map[string]string) (sbomSubjectAndURI, error) {
+	if !isOpenVex {
+		if err := checkComponentOrigins(filePath, blob); err != nil {
+			return sbomSubjectAndURI{}, withExitCode(exitValidation, err)
+		}
+		if err := checkTyposquats(filePath, blob); err != nil {
+			return sbomSubjectAndURI{}, withExitCode(exitValidation, err)
+		}
+		if err := checkDeniedLicenses(filePath, blob); err != nil {
+			return sbomSubjectAndURI{}, withExitCode(exitValidation, err)
+		}
+		if err := recordComponents(blob); err != nil {
+			return sbomSubjectAndURI{}, err
+		}
+	}
+
+	uploadMeta = documentMetadata(filePath, blob, sum, docRef, uploadMeta)
+
+	constraints, err := loadConstraints()
+	if err != nil {
+		return sbomSubjectAndURI{}, err
+	}
+	if err := constraints.checkMetadata(filePath, uploadMeta); err != nil {
+		return sbomSubjectAndURI{}, withExitCode(exitValidation, err)
+	}
+
+	log.Debug().
+		Str("filePath", filePath).
+		Str("docRef", docRef).
+		Int("size", len(blob)).
+		Msg("Uploading file")
+
 	// Prepare the payload for the presigned URL request
 	payload := map[string]string{
-		"filename": getDocRef(blob),
+		"filename": docRef,
 	}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
@@ -506,7 +1120,59 @@ func uploadSingleFile(authorizedClient, defaultClient HttpClient, tenantApiEndpo
 	}
 
 	// pass in default client without the jwt other wise it will error with both the presigned url and jwt
-	return uploadBlob(defaultClient, presignedUrl, filePath, blob, isOpenVex, uploadMeta)
+	ssau, err := uploadBlob(defaultClient, presignedUrl, filePath, docRef, blob, isOpenVex, uploadMeta)
+
+	var skewErr *clockSkewError
+	if errors.As(err, &skewErr) {
+		runRetries.record(filePath, retryPhaseUpload, 0)
+		// the presigned URL may have expired while waiting, so retry once with a fresh one
+		log.Warn().
+			Str("filePath", filePath).
+			Dur("clockSkew", skewErr.Skew).
+			Msg("Upload rejected because of the request time, " + skewErr.diagnosis() + "; retrying with a new presigned URL")
+
+		presignedUrl, err = getPresignedUrl(authorizedClient, tenantApiEndpoint, payloadBytes)
+		if err != nil {
+			return sbomSubjectAndURI{}, err
+		}
+		ssau, err = uploadBlob(defaultClient, presignedUrl, filePath, docRef, blob, isOpenVex, uploadMeta)
+	}
+	ssau.docRef = docRef
+
+	if err == nil {
+		log.Debug().
+			Str("filePath", filePath).
+			Str("docRef", docRef).
+			Msg("Uploaded file")
+		if !isOpenVex {
+			runAudit.recordSBOM(filePath, docRef, ssau, blob)
+		}
+	}
+
+	return ssau, err
+}
+
+// documentMetadata returns the upload metadata of a document whose content
+// hashes to sum: the metadata of its file, uploadMeta and the forced type and
+// format
+func documentMetadata(filePath string, blob []byte, sum, docRef string, uploadMeta map[string]string) map[string]string {
+	meta := make(map[string]string, len(uploadMeta)+7)
+	if !viper.GetBool("omit-file-metadata") {
+		for k, v := range fileMetadata(filePath, len(blob)) {
+			meta[k] = v
+		}
+	}
+	for k, v := range uploadMeta {
+		meta[k] = v
+	}
+	for k, v := range forcedMetadata() {
+		meta[k] = v
+	}
+	if docRef != contentRef(sum) {
+		// the ref no longer identifies the content, so record its hash for integrity checks
+		meta["content_sha256"] = sum
+	}
+	return meta
 }
 
 type cdxSBOM struct {
@@ -526,26 +1192,23 @@ type spdxSBOM struct {
 }
 
 // uploadBlob takes the file and creates a `processor.Document` blob which is uploaded to S3
-func uploadBlob(defaultClient HttpClient, presignedUrl, filePath string, readFile []byte, isOpenVex bool,
+func uploadBlob(defaultClient HttpClient, presignedUrl, filePath, docRef string, readFile []byte, isOpenVex bool,
 	uploadMeta map[string]string) (sbomSubjectAndURI, error) {
 
-	doctype := DocumentSBOM
-	if isOpenVex {
-		doctype = DocumentOpenVEX
-	}
+	doctype, format := documentTypeAndFormat(isOpenVex)
 
 	baseDoc := &Document{
 		Blob:   readFile,
 		Type:   doctype,
-		Format: FormatUnknown,
+		Format: format,
 		SourceInformation: SourceInformation{
 			Collector:   "Kusari-Uploader",
 			Source:      fmt.Sprintf("file:///%s", filePath),
-			DocumentRef: getDocRef(readFile),
+			DocumentRef: docRef,
 		},
 	}
 
-	var docByte []byte
+	var body *documentBody
 	var err error
 
 	if len(uploadMeta) != 0 {
@@ -554,23 +1217,28 @@ func uploadBlob(defaultClient HttpClient, presignedUrl, filePath string, readFil
 		docWrapper := DocumentWrapper{
 			Document:       baseDoc,
 			UploadMetaData: &uploadMeta,
+			SchemaVersion:  viper.GetInt("wrapper-version"),
 		}
 
-		docByte, err = json.Marshal(docWrapper)
+		body, err = newDocumentBody(docWrapper, baseDoc, readFile)
 		if err != nil {
 			return sbomSubjectAndURI{}, fmt.Errorf("failed marshal of document: %w", err)
 		}
 	} else {
-		docByte, err = json.Marshal(baseDoc)
+		body, err = newDocumentBody(baseDoc, baseDoc, readFile)
 		if err != nil {
 			return sbomSubjectAndURI{}, fmt.Errorf("failed marshal of document: %w", err)
 		}
 	}
 
-	req, err := http.NewRequest(http.MethodPut, presignedUrl, bytes.NewBuffer(docByte))
+	// the body is streamed, with a known length as presigned URLs don't
+	// accept chunked uploads
+	req, err := http.NewRequest(http.MethodPut, presignedUrl, body.Reader())
 	if err != nil {
 		return sbomSubjectAndURI{}, fmt.Errorf("failed to create new http request with error: %w", err)
 	}
+	req.ContentLength = body.Len()
+	req.GetBody = func() (io.ReadCloser, error) { return body.Reader(), nil }
 
 	req.Header.Set("Content-Type", "multipart/form-data")
 
@@ -585,11 +1253,15 @@ func uploadBlob(defaultClient HttpClient, presignedUrl, filePath string, readFil
 	}()
 
 	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		if skewErr := detectClockSkew(resp, body, time.Now()); skewErr != nil {
+			return sbomSubjectAndURI{}, skewErr
+		}
 		if resp.StatusCode == http.StatusUnauthorized {
 			return sbomSubjectAndURI{}, fmt.Errorf("uploadBlob failed with unauthorized request: %d", resp.StatusCode)
 		}
 		// otherwise return an error
-		return sbomSubjectAndURI{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return sbomSubjectAndURI{}, &statusCodeError{code: resp.StatusCode}
 	}
 
 	if resp.StatusCode != http.StatusOK {
@@ -598,26 +1270,36 @@ func uploadBlob(defaultClient HttpClient, presignedUrl, filePath string, readFil
 	}
 
 	// Get SBOM subjects and URIs for checking against the blocked package list.
+	return localSBOMSubject(readFile), nil
+}
+
+// localSBOMSubject returns the subject and URI the platform identifies an SBOM
+// by, or an empty sbomSubjectAndURI if blob is not a CycloneDX or SPDX SBOM
+func localSBOMSubject(blob []byte) sbomSubjectAndURI {
 	var cdx cdxSBOM
-	if err := json.Unmarshal(readFile, &cdx); err == nil { // inverted error check
+	if err := json.Unmarshal(blob, &cdx); err == nil { // inverted error check
 		if cdx.BOMFormat == "CycloneDX" && cdx.Metadata.Component.Name != "" && cdx.SerialNumber != "" {
-			return sbomSubjectAndURI{subject: cdx.Metadata.Component.Name, uri: cdx.SerialNumber}, nil
+			return sbomSubjectAndURI{subject: cdx.Metadata.Component.Name, uri: cdx.SerialNumber}
 		}
 	}
 
 	var spdx spdxSBOM
-	if err := json.Unmarshal(readFile, &spdx); err == nil { // inverted error check
+	if err := json.Unmarshal(blob, &spdx); err == nil { // inverted error check
 		if spdx.SPDXID == "SPDXRef-DOCUMENT" && spdx.Name != "" && spdx.DocumentNamespace != "" {
-			return sbomSubjectAndURI{subject: spdx.Name, uri: spdx.DocumentNamespace + "#DOCUMENT"}, nil
+			return sbomSubjectAndURI{subject: spdx.Name, uri: spdx.DocumentNamespace + "#DOCUMENT"}
 		}
 	}
 
-	return sbomSubjectAndURI{}, nil
+	return sbomSubjectAndURI{}
 }
 
 func getKey(blob []byte) string {
-	generatedHash := getHash(blob)
-	return fmt.Sprintf("sha256_%s", generatedHash)
+	return contentRef(getHash(blob))
+}
+
+// contentRef returns the document ref of content with the sha256 sum
+func contentRef(sum string) string {
+	return fmt.Sprintf("sha256_%s", sum)
 }
 
 // GetDocRef returns the Document Reference of a blob; i.e. the blob store key for this blob.
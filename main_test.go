@@ -17,11 +17,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
 	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 type ClientMock struct {
@@ -170,7 +175,7 @@ func Test_uploadDirectory(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if _, err := uploadDirectory(authClientMock, defaultClientMock, tt.args.tenantApiEndpoint, tt.args.dirPath, tt.args.uploadMeta); (err != nil) != tt.wantErr {
+			if _, err := uploadDirectory(authClientMock, defaultClientMock, tt.args.tenantApiEndpoint, tt.args.dirPath, tt.args.uploadMeta, nil, nil, nil); (err != nil) != tt.wantErr {
 				t.Errorf("uploadDirectory() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -357,7 +362,7 @@ func Test_uploadBlob(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			for _, isOpenVex := range []bool{false, true} {
 				t.Run(fmt.Sprintf("isOpenVex is %v", isOpenVex), func(t *testing.T) {
-					if _, err := uploadBlob(tt.args.authenticatedClient, tt.args.presignedUrl, tt.args.filePath, []byte("hello"), isOpenVex, tt.args.uploadMeta); (err != nil) != tt.wantErr {
+					if _, err := uploadBlob(tt.args.authenticatedClient, tt.args.presignedUrl, tt.args.filePath, getDocRef([]byte("hello")), []byte("hello"), isOpenVex, tt.args.uploadMeta); (err != nil) != tt.wantErr {
 						t.Errorf("uploadFile() error = %v, wantErr %v", err, tt.wantErr)
 					}
 				})
@@ -365,3 +370,159 @@ func Test_uploadBlob(t *testing.T) {
 		})
 	}
 }
+
+func Test_mustBindPFlagPrecedence(t *testing.T) {
+	const flagName = "precedence-test"
+
+	tests := []struct {
+		name        string
+		args        []string
+		uploaderEnv string
+		kusariEnv   string
+		config      string
+		want        string
+	}{
+		{
+			name: "default",
+			want: "default",
+		},
+		{
+			name:   "config over default",
+			config: "config",
+			want:   "config",
+		},
+		{
+			name:      "env over config",
+			kusariEnv: "kusari",
+			config:    "config",
+			want:      "kusari",
+		},
+		{
+			name:        "UPLOADER_ prefix over KUSARI_ prefix",
+			uploaderEnv: "uploader",
+			kusariEnv:   "kusari",
+			want:        "uploader",
+		},
+		{
+			name:        "flag over env",
+			args:        []string{"--" + flagName, "flag"},
+			uploaderEnv: "uploader",
+			config:      "config",
+			want:        "flag",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			t.Setenv("UPLOADER_PRECEDENCE_TEST", tt.uploaderEnv)
+			t.Setenv("KUSARI_PRECEDENCE_TEST", tt.kusariEnv)
+			if tt.config != "" {
+				viper.SetConfigType("yaml")
+				if err := viper.ReadConfig(bytes.NewBufferString(flagName + ": " + tt.config)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cmd := &cobra.Command{Use: "test"}
+			cmd.Flags().String(flagName, "default", "")
+			mustBindPFlag(cmd, flagName)
+			if err := cmd.Flags().Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := viper.GetString(flagName); got != tt.want {
+				t.Errorf("viper.GetString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pinSbomSubject(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		uploadMeta map[string]string
+		want       map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "pins sbom and software id",
+			status:     http.StatusOK,
+			body:       `{"software_id": 12, "sbom_id": 34}`,
+			uploadMeta: map[string]string{"sbom_subject": "app"},
+			want:       map[string]string{"sbom_subject": "app", "sbom_id": "34", "software_id": "12"},
+		},
+		{
+			name:       "keeps explicit software id",
+			status:     http.StatusOK,
+			body:       `{"software_id": 12, "sbom_id": 34}`,
+			uploadMeta: map[string]string{"sbom_subject": "app", "software_id": "99"},
+			want:       map[string]string{"sbom_subject": "app", "sbom_id": "34", "software_id": "99"},
+		},
+		{
+			name:       "no sbom for subject",
+			status:     http.StatusNotFound,
+			uploadMeta: map[string]string{"sbom_subject": "app"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &ClientMock{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					if got := req.URL.Query().Get("software_name"); got != "app" {
+						t.Errorf("software_name = %q, want app", got)
+					}
+					return &http.Response{
+						StatusCode: tt.status,
+						Body:       io.NopCloser(bytes.NewBufferString(tt.body)),
+					}, nil
+				},
+			}
+			err := pinSbomSubject(context.Background(), client, "http://example.com", "app", tt.uploadMeta)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pinSbomSubject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(tt.uploadMeta, tt.want) {
+				t.Errorf("pinSbomSubject() metadata = %v, want %v", tt.uploadMeta, tt.want)
+			}
+		})
+	}
+}
+
+func Test_envVarNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{name: "default prefixes", want: []string{"UPLOADER_CLIENT_SECRET", "KUSARI_CLIENT_SECRET"}},
+		{name: "custom prefix first", prefix: "acme_", want: []string{"ACME_CLIENT_SECRET", "UPLOADER_CLIENT_SECRET", "KUSARI_CLIENT_SECRET"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envPrefixVar, tt.prefix)
+			if got := envVarNames("client-secret"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("envVarNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_bindEnvVars(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Setenv(envPrefixVar, "ACME")
+	t.Setenv("ACME_UNBOUND_TEST", "acme")
+
+	// the flag is not bound with mustBindPFlag
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().String("unbound-test", "", "")
+	bindEnvVars(cmd, nil)
+
+	if got := viper.GetString("unbound-test"); got != "acme" {
+		t.Errorf("viper.GetString() = %q, want %q", got, "acme")
+	}
+}
@@ -0,0 +1,383 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+// maintenanceCheck* are the values of --maintenance-check
+const (
+	maintenanceCheckOff  = "off"
+	maintenanceCheckWarn = "warn"
+	maintenanceCheckFail = "fail"
+)
+
+const defaultDepsDevEndpoint = "https://api.deps.dev"
+
+// depsDevSystems maps purl types to the package systems of deps.dev
+var depsDevSystems = map[string]string{
+	"npm":    "NPM",
+	"pypi":   "PYPI",
+	"golang": "GO",
+	"cargo":  "CARGO",
+	"maven":  "MAVEN",
+	"nuget":  "NUGET",
+}
+
+// depsDevPackage identifies a package in deps.dev
+type depsDevPackage struct {
+	System string
+	Name   string
+}
+
+func (p depsDevPackage) String() string {
+	return strings.ToLower(p.System) + "/" + p.Name
+}
+
+// maintenanceFinding is a package whose upstream looks unmaintained
+type maintenanceFinding struct {
+	Package       string
+	LatestRelease time.Time
+	Reason        string
+}
+
+// componentReport collects the packages of the SBOMs of a run so the
+// maintenance check can look each of them up once at the end of the run
+type componentReport struct {
+	mu       sync.Mutex
+	packages map[depsDevPackage]bool
+}
+
+// runComponents collects the packages of the current run
+var runComponents = &componentReport{}
+
+// record adds the packages of the purls that deps.dev knows the system of
+func (r *componentReport) record(purls []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.packages == nil {
+		r.packages = map[depsDevPackage]bool{}
+	}
+	for _, purl := range purls {
+		if pkg, ok := depsDevPackageOf(purl); ok {
+			r.packages[pkg] = true
+		}
+	}
+}
+
+// list returns the recorded packages sorted by system and name
+func (r *componentReport) list() []depsDevPackage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pkgs := make([]depsDevPackage, 0, len(r.packages))
+	for pkg := range r.packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].String() < pkgs[j].String() })
+	return pkgs
+}
+
+// depsDevPackageOf returns the deps.dev package of a purl. Maven packages are
+// named group:artifact in deps.dev.
+func depsDevPackageOf(purl string) (depsDevPackage, bool) {
+	purlType, name, ok := parsePurl(purl)
+	if !ok {
+		return depsDevPackage{}, false
+	}
+	system, ok := depsDevSystems[strings.ToLower(purlType)]
+	if !ok {
+		return depsDevPackage{}, false
+	}
+	if system == "MAVEN" {
+		group, artifact, ok := strings.Cut(name, "/")
+		if !ok {
+			return depsDevPackage{}, false
+		}
+		name = group + ":" + artifact
+	}
+	return depsDevPackage{System: system, Name: name}, true
+}
+
+// maintenanceCheckMode returns the --maintenance-check mode
+func maintenanceCheckMode() (string, error) {
+	switch mode := viper.GetString("maintenance-check"); mode {
+	case maintenanceCheckOff, "":
+		return maintenanceCheckOff, nil
+	case maintenanceCheckWarn, maintenanceCheckFail:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("maintenance-check must be %s, %s or %s", maintenanceCheckOff, maintenanceCheckWarn, maintenanceCheckFail)
+	}
+}
+
+// recordComponents records the packages of an SBOM for the maintenance check,
+// if it is enabled
+func recordComponents(blob []byte) error {
+	mode, err := maintenanceCheckMode()
+	if err != nil || mode == maintenanceCheckOff {
+		return err
+	}
+	runComponents.record(sbomPurls(blob))
+	return nil
+}
+
+// mustCheckMaintenance runs the maintenance check for the packages of the run
+// next to the blocked package check and reports whether the run should fail
+// because of its findings
+func mustCheckMaintenance(ctx context.Context, messages io.Writer, client HttpClient) bool {
+	mode, err := maintenanceCheckMode()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid maintenance check")
+	}
+	if mode == maintenanceCheckOff {
+		return false
+	}
+
+	maxAge := viper.GetInt("max-release-age")
+	if maxAge < 1 {
+		log.Fatal().Msg("max-release-age must be at least 1 year")
+	}
+	limits, err := resolvePhaseLimits()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid concurrency")
+	}
+
+	client, err = newEnrichmentClient(client)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid enrichment cache")
+	}
+
+	endpoint := viper.GetString("deps-dev-endpoint")
+	if err := checkOutboundEndpoint("deps-dev-endpoint", endpoint); err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("deps.dev endpoint not allowed")
+	}
+
+	findings, err := checkMaintenance(ctx, client, endpoint, runComponents.list(), maxAge, time.Now(), limits.Check)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error checking package maintenance")
+	}
+	printMaintenanceFindings(messages, findings)
+	return mode == maintenanceCheckFail && len(findings) > 0
+}
+
+// checkMaintenance looks up the packages in deps.dev and returns those whose
+// latest release is more than maxAge years old or whose source repository is
+// archived. Packages deps.dev does not know are skipped.
+func checkMaintenance(ctx context.Context, client HttpClient, endpoint string, pkgs []depsDevPackage, maxAge int,
+	now time.Time, limit int) ([]maintenanceFinding, error) {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(limit)
+
+	cutoff := now.AddDate(-maxAge, 0, 0)
+	findings := make([]*maintenanceFinding, len(pkgs))
+	for i, pkg := range pkgs {
+		g.Go(func() error {
+			finding, err := checkPackageMaintenance(ctx, client, endpoint, pkg, maxAge, cutoff)
+			if err != nil {
+				return fmt.Errorf("%s: %w", pkg, err)
+			}
+			findings[i] = finding
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var result []maintenanceFinding
+	for _, f := range findings {
+		if f != nil {
+			result = append(result, *f)
+		}
+	}
+	return result, nil
+}
+
+// depsDevPackageInfo is the package response of the deps.dev API
+type depsDevPackageInfo struct {
+	Versions []struct {
+		VersionKey struct {
+			Version string `json:"version"`
+		} `json:"versionKey"`
+		PublishedAt time.Time `json:"publishedAt"`
+		IsDefault   bool      `json:"isDefault"`
+	} `json:"versions"`
+}
+
+// depsDevVersionInfo is the version response of the deps.dev API
+type depsDevVersionInfo struct {
+	RelatedProjects []struct {
+		ProjectKey struct {
+			ID string `json:"id"`
+		} `json:"projectKey"`
+		RelationType string `json:"relationType"`
+	} `json:"relatedProjects"`
+}
+
+// depsDevProjectInfo is the project response of the deps.dev API
+type depsDevProjectInfo struct {
+	Scorecard struct {
+		Checks []struct {
+			Name   string `json:"name"`
+			Reason string `json:"reason"`
+		} `json:"checks"`
+	} `json:"scorecard"`
+}
+
+// checkPackageMaintenance returns a finding if the latest release of a package
+// is older than cutoff or its source repository is archived, or nil otherwise
+func checkPackageMaintenance(ctx context.Context, client HttpClient, endpoint string, pkg depsDevPackage, maxAge int,
+	cutoff time.Time) (*maintenanceFinding, error) {
+	pkgPath := fmt.Sprintf("v3/systems/%s/packages/%s", pkg.System, url.PathEscape(pkg.Name))
+
+	var info depsDevPackageInfo
+	if found, err := getDepsDev(ctx, client, endpoint, pkgPath, &info); err != nil || !found {
+		return nil, err
+	}
+
+	var latest time.Time
+	defaultVersion := ""
+	for _, v := range info.Versions {
+		if v.PublishedAt.After(latest) {
+			latest = v.PublishedAt
+		}
+		if v.IsDefault {
+			defaultVersion = v.VersionKey.Version
+		}
+	}
+
+	var reasons []string
+	if !latest.IsZero() && latest.Before(cutoff) {
+		reasons = append(reasons, fmt.Sprintf("no release in over %d years", maxAge))
+	}
+
+	if defaultVersion != "" {
+		archived, err := sourceRepoArchived(ctx, client, endpoint, pkgPath+"/versions/"+url.PathEscape(defaultVersion))
+		if err != nil {
+			return nil, err
+		}
+		if archived {
+			reasons = append(reasons, "source repository is archived")
+		}
+	}
+
+	if len(reasons) == 0 {
+		return nil, nil
+	}
+	return &maintenanceFinding{Package: pkg.String(), LatestRelease: latest, Reason: strings.Join(reasons, ", ")}, nil
+}
+
+// sourceRepoArchived reports whether the source repository of a package
+// version is archived, going by the Maintained check of its OpenSSF Scorecard
+func sourceRepoArchived(ctx context.Context, client HttpClient, endpoint, versionPath string) (bool, error) {
+	var version depsDevVersionInfo
+	if found, err := getDepsDev(ctx, client, endpoint, versionPath, &version); err != nil || !found {
+		return false, err
+	}
+
+	for _, p := range version.RelatedProjects {
+		if p.RelationType != "SOURCE_REPO" {
+			continue
+		}
+		var project depsDevProjectInfo
+		found, err := getDepsDev(ctx, client, endpoint, "v3/projects/"+url.PathEscape(p.ProjectKey.ID), &project)
+		if err != nil || !found {
+			return false, err
+		}
+		for _, c := range project.Scorecard.Checks {
+			if c.Name == "Maintained" && strings.Contains(strings.ToLower(c.Reason), "archived") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// getDepsDev decodes a deps.dev API response into v and reports whether the
+// resource was found
+func getDepsDev(ctx context.Context, client HttpClient, endpoint, path string, v any) (bool, error) {
+	res, err := makePicoReq(ctx, client, strings.TrimRight(endpoint, "/"), path)
+	if errors.Is(err, errNotCached) {
+		log.Warn().
+			Err(err).
+			Msg("Skipping deps.dev lookup while offline")
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error making request to deps.dev: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected response status code from deps.dev: %d", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("error decoding deps.dev response: %w", err)
+	}
+	return true, nil
+}
+
+// printMaintenanceFindings writes the packages that look unmaintained, if there
+// are any
+func printMaintenanceFindings(w io.Writer, findings []maintenanceFinding) {
+	if len(findings) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "Maintenance risks found for %d package(s)\n", len(findings))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  PACKAGE\tLATEST RELEASE\tREASON")
+	for _, f := range findings {
+		latest := "unknown"
+		if !f.LatestRelease.IsZero() {
+			latest = f.LatestRelease.Format(time.DateOnly)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", f.Package, latest, f.Reason)
+	}
+	tw.Flush() //nolint:errcheck
+	fmt.Fprintln(w)
+}
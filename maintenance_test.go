@@ -0,0 +1,145 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_depsDevPackageOf(t *testing.T) {
+	tests := []struct {
+		purl   string
+		want   depsDevPackage
+		wantOK bool
+	}{
+		{"pkg:npm/%40types/node@20.0.0", depsDevPackage{System: "NPM", Name: "@types/node"}, true},
+		{"pkg:golang/github.com/Azure/azure-sdk-for-go@v68.0.0", depsDevPackage{System: "GO", Name: "github.com/Azure/azure-sdk-for-go"}, true},
+		{"pkg:maven/org.apache.commons/commons-lang3@3.14.0", depsDevPackage{System: "MAVEN", Name: "org.apache.commons:commons-lang3"}, true},
+		{"pkg:maven/commons-lang3@3.14.0", depsDevPackage{}, false},
+		{"pkg:generic/tool@1.0", depsDevPackage{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := depsDevPackageOf(tt.purl)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("depsDevPackageOf(%q) = %v, %v, want %v, %v", tt.purl, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func Test_componentReport(t *testing.T) {
+	r := &componentReport{}
+	r.record([]string{"pkg:npm/b@1.0", "pkg:npm/a@1.0", "pkg:generic/c@1.0"})
+	r.record([]string{"pkg:npm/a@2.0"})
+
+	want := []depsDevPackage{{System: "NPM", Name: "a"}, {System: "NPM", Name: "b"}}
+	if got := r.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("componentReport.list() = %v, want %v", got, want)
+	}
+}
+
+func Test_checkMaintenance(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	responses := map[string]string{
+		"/v3/systems/NPM/packages/fresh": `{"versions": [
+			{"versionKey": {"version": "1.0.0"}, "publishedAt": "2019-01-01T00:00:00Z"},
+			{"versionKey": {"version": "2.0.0"}, "publishedAt": "2025-06-01T00:00:00Z", "isDefault": true}]}`,
+		"/v3/systems/NPM/packages/fresh/versions/2.0.0": `{"relatedProjects": [
+			{"projectKey": {"id": "github.com/example/fresh"}, "relationType": "SOURCE_REPO"}]}`,
+		"/v3/projects/github.com/example/fresh": `{"scorecard": {"checks": [
+			{"name": "Maintained", "reason": "30 commit(s) and 2 issue activity found in the last 90 days"}]}}`,
+
+		"/v3/systems/NPM/packages/stale": `{"versions": [
+			{"versionKey": {"version": "0.1.0"}, "publishedAt": "2018-03-01T00:00:00Z", "isDefault": true}]}`,
+		"/v3/systems/NPM/packages/stale/versions/0.1.0": `{"relatedProjects": []}`,
+
+		"/v3/systems/PYPI/packages/archived": `{"versions": [
+			{"versionKey": {"version": "3.0"}, "publishedAt": "2025-01-01T00:00:00Z", "isDefault": true}]}`,
+		"/v3/systems/PYPI/packages/archived/versions/3.0": `{"relatedProjects": [
+			{"projectKey": {"id": "github.com/example/archived"}, "relationType": "ISSUE_TRACKER"},
+			{"projectKey": {"id": "github.com/example/archived"}, "relationType": "SOURCE_REPO"}]}`,
+		"/v3/projects/github.com/example/archived": `{"scorecard": {"checks": [
+			{"name": "Maintained", "reason": "project is archived"}]}}`,
+	}
+	client := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body, ok := responses[req.URL.Path]
+			if !ok {
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		},
+	}
+
+	pkgs := []depsDevPackage{
+		{System: "NPM", Name: "fresh"},
+		{System: "NPM", Name: "stale"},
+		{System: "NPM", Name: "unknown"},
+		{System: "PYPI", Name: "archived"},
+	}
+	got, err := checkMaintenance(context.Background(), client, "https://deps.example.com/", pkgs, 2, now, 2)
+	if err != nil {
+		t.Fatalf("checkMaintenance() error = %v", err)
+	}
+	want := []maintenanceFinding{
+		{Package: "npm/stale", LatestRelease: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), Reason: "no release in over 2 years"},
+		{Package: "pypi/archived", LatestRelease: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), Reason: "source repository is archived"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkMaintenance() = %v, want %v", got, want)
+	}
+}
+
+func Test_checkMaintenance_error(t *testing.T) {
+	client := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusInternalServerError, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		},
+	}
+	_, err := checkMaintenance(context.Background(), client, defaultDepsDevEndpoint, []depsDevPackage{{System: "NPM", Name: "a"}}, 2, time.Now(), 1)
+	if err == nil || !strings.Contains(err.Error(), "npm/a") {
+		t.Errorf("checkMaintenance() error = %v, want an error naming the package", err)
+	}
+}
+
+func Test_printMaintenanceFindings(t *testing.T) {
+	var buf bytes.Buffer
+	printMaintenanceFindings(&buf, nil)
+	if buf.Len() != 0 {
+		t.Errorf("printMaintenanceFindings(nil) wrote %q, want nothing", buf.String())
+	}
+
+	printMaintenanceFindings(&buf, []maintenanceFinding{{Package: "npm/stale", LatestRelease: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), Reason: "no release in over 2 years"}})
+	for _, want := range []string{"Maintenance risks found for 1 package(s)", "npm/stale", "2018-03-01", "no release in over 2 years"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("printMaintenanceFindings() output %q does not contain %q", buf.String(), want)
+		}
+	}
+}
+
+func Test_checkMaintenance_offline(t *testing.T) {
+	client := newCachingClient(&ClientMock{}, &fileStore{dir: t.TempDir()}, time.Hour, true)
+	got, err := checkMaintenance(context.Background(), client, defaultDepsDevEndpoint, []depsDevPackage{{System: "NPM", Name: "a"}}, 2, time.Now(), 1)
+	if err != nil || len(got) != 0 {
+		t.Errorf("checkMaintenance() offline without a cache = %v, %v, want the package skipped", got, err)
+	}
+}
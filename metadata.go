@@ -0,0 +1,409 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+// metadataFlags maps the flags that set upload metadata to their upload_metadata key
+var metadataFlags = []struct {
+	flag string
+	key  string
+}{
+	{flag: "alias", key: "alias"},
+	{flag: "document-type", key: "type"},
+	{flag: "tag", key: "tag"},
+	{flag: "software-id", key: "software_id"},
+	{flag: "sbom-subject", key: "sbom_subject"},
+	{flag: "component-name", key: "component_name"},
+}
+
+// reservedMetadataKeys are the upload metadata keys the uploader sets itself,
+// which --meta can't set
+var reservedMetadataKeys = map[string]bool{
+	"run_id":         true,
+	"bundle_id":      true,
+	"sbom_id":        true,
+	"filename":       true,
+	"file_extension": true,
+	"file_size":      true,
+	"file_mtime":     true,
+	"forced_type":    true,
+	"forced_format":  true,
+	"content_sha256": true,
+}
+
+// metaKeyRegexp matches the keys --meta accepts
+var metaKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// metaNamespaceRegexp matches the reverse domain namespaces of namespaced
+// metadata keys, e.g. org.kusari in org.kusari.team
+var metaNamespaceRegexp = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+$`)
+
+// userMetaKeyPrefix starts the keys of user defined metadata, which is never
+// namespaced and never collides with keys of the uploader
+const userMetaKeyPrefix = "x-"
+
+// metadataValue is an upload metadata value together with where it came from
+type metadataValue struct {
+	Value  string
+	Source string
+}
+
+func addMetadataFlags(flags *pflag.FlagSet) {
+	flags.StringP("alias", "a", "", "Alias that supersedes the subject in Kusari platform (optional)")
+	flags.StringP("document-type", "d", "", "Type of the document (image or build) sbom (optional)")
+	flags.String("tag", "", "Tag value to set in the document wrapper upload meta (optional, e.g. govulncheck)")
+	flags.String("software-id", "", "Kusari Platform Software ID value to set in the document wrapper upload meta (optional)")
+	flags.String("sbom-subject", "", "Kusari Platform Software sbom subject substring value to set in the document wrapper upload meta (optional)")
+	flags.String("component-name", "", "Kusari Platform component name (optional)")
+	flags.StringArray("meta", nil, "Upload metadata key=value to set in the document wrapper upload meta, repeatable, e.g. --meta team=payments (optional)")
+	flags.String("metadata-file", "", "JSON or YAML file of upload metadata keys and values to set in the document wrapper upload meta; --meta overrides its keys (optional)")
+	flags.String("meta-namespace", "", "Reverse domain namespace added to the plain keys of --meta and --metadata-file, e.g. org.kusari turns team into org.kusari.team (optional)")
+	flags.StringSlice("require-meta", nil, "Comma separated upload metadata keys or metadata flag names every document must have, e.g. alias,component-name; documents missing any of them are not uploaded (optional)")
+}
+
+// metadataKey returns the upload metadata key of a metadata flag name, so that
+// --require-meta accepts both, or name itself if it is not a flag
+func metadataKey(name string) string {
+	for _, mf := range metadataFlags {
+		if mf.flag == name {
+			return mf.key
+		}
+	}
+	return name
+}
+
+// lookupSetting returns the value of a setting and a description of where it
+// came from, following the same precedence as viper: flag, environment, config file.
+func lookupSetting(flags *pflag.FlagSet, name string) (string, string) {
+	if f := flags.Lookup(name); f != nil && f.Changed {
+		return f.Value.String(), "flag --" + name
+	}
+
+	for _, env := range envVarNames(name) {
+		if v := os.Getenv(env); v != "" {
+			return v, "env " + env
+		}
+	}
+
+	if viper.InConfig(name) {
+		return viper.GetString(name), "config " + viper.ConfigFileUsed()
+	}
+
+	return "", ""
+}
+
+// resolveUploadMetadata returns the upload metadata for the file at relPath,
+// combining the metadata flags with the routing rules matching the path.
+func resolveUploadMetadata(flags *pflag.FlagSet, rules []routingRule, relPath string) map[string]metadataValue {
+	meta := map[string]metadataValue{}
+
+	for _, mf := range metadataFlags {
+		if value, source := lookupSetting(flags, mf.flag); value != "" {
+			meta[mf.key] = metadataValue{Value: value, Source: source}
+		}
+	}
+
+	// invalid --meta values are rejected by mustValidateCustomMetadata
+	custom, _ := customMetadata(flags)
+	for key, value := range custom {
+		meta[key] = value
+	}
+
+	relPath = filepath.ToSlash(relPath)
+	for _, rule := range rules {
+		if !rule.re.MatchString(relPath) {
+			continue
+		}
+		source := fmt.Sprintf("routing rule %q", rule.Pattern)
+		for key, value := range rule.values() {
+			meta[key] = metadataValue{Value: value, Source: source}
+		}
+	}
+
+	return meta
+}
+
+// lookupMetaPairs returns the key=value pairs set with --meta and where they
+// came from. The environment variables take a comma separated list, and the
+// config file a list of pairs or a map.
+func lookupMetaPairs(flags *pflag.FlagSet) ([]string, string) {
+	if f := flags.Lookup("meta"); f != nil && f.Changed {
+		pairs, _ := flags.GetStringArray("meta")
+		return pairs, "flag --meta"
+	}
+
+	for _, env := range envVarNames("meta") {
+		if v := os.Getenv(env); v != "" {
+			return strings.Split(v, ","), "env " + env
+		}
+	}
+
+	if viper.InConfig("meta") {
+		source := "config " + viper.ConfigFileUsed()
+		if m, ok := viper.Get("meta").(map[string]any); ok {
+			pairs := make([]string, 0, len(m))
+			for k, v := range m {
+				pairs = append(pairs, k+"="+fmt.Sprint(v))
+			}
+			sort.Strings(pairs)
+			return pairs, source
+		}
+		return viper.GetStringSlice("meta"), source
+	}
+
+	return nil, ""
+}
+
+// customMetadata returns the upload metadata set with --metadata-file and
+// --meta, which overrides the keys of the file. Plain keys are put in the
+// --meta-namespace, if set. Keys of the dedicated metadata flags and keys the
+// uploader sets itself are rejected, as are keys that differ only in case or
+// in - and _, and a key given twice with different values with --meta.
+func customMetadata(flags *pflag.FlagSet) (map[string]metadataValue, error) {
+	meta := map[string]metadataValue{}
+
+	namespace, source := lookupSetting(flags, "meta-namespace")
+	if namespace != "" && !metaNamespaceRegexp.MatchString(namespace) {
+		return nil, fmt.Errorf("invalid meta-namespace %q from %s, must be a reverse domain such as org.kusari", namespace, source)
+	}
+	// folded maps the folded keys to the keys set, to detect collisions
+	folded := map[string]string{}
+	set := func(key string, value metadataValue) (string, error) {
+		if err := checkCustomMetadataKey(key); err != nil {
+			return "", err
+		}
+		if namespace != "" && isPlainMetadataKey(key) {
+			key = namespace + "." + key
+		}
+		if prev, ok := folded[foldMetadataKey(key)]; ok && prev != key {
+			return "", fmt.Errorf("meta keys %q and %q collide, they differ only in case or in - and _", prev, key)
+		}
+		folded[foldMetadataKey(key)] = key
+		meta[key] = value
+		return key, nil
+	}
+
+	if path, _ := lookupSetting(flags, "metadata-file"); path != "" {
+		values, err := loadMetadataFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys := slices.Sorted(maps.Keys(values))
+		for _, key := range keys {
+			if _, err := set(key, metadataValue{Value: values[key], Source: "metadata file " + path}); err != nil {
+				return nil, fmt.Errorf("metadata file %s: %w", path, err)
+			}
+		}
+	}
+
+	pairs, source := lookupMetaPairs(flags)
+	seen := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid meta %q, must be key=value", pair)
+		}
+		key, err := set(key, metadataValue{Value: value, Source: source})
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := seen[key]; ok && prev != value {
+			return nil, fmt.Errorf("meta key %q is set more than once with different values", key)
+		}
+		seen[key] = value
+	}
+	return meta, nil
+}
+
+// checkCustomMetadataKey returns an error if key can't be set with --meta or
+// --metadata-file. Keys are plain keys, user defined x- keys, or namespaced
+// keys with a reverse domain namespace. Plain keys must not collide with the
+// keys of the dedicated metadata flags or of the uploader.
+func checkCustomMetadataKey(key string) error {
+	if !metaKeyRegexp.MatchString(key) {
+		return fmt.Errorf("invalid meta key %q, must be at most 64 letters, digits, _, . or -", key)
+	}
+	if namespace, name, ok := cutLast(key, "."); ok {
+		if !metaNamespaceRegexp.MatchString(namespace) || name == "" || strings.HasPrefix(name, userMetaKeyPrefix) {
+			return fmt.Errorf("invalid meta key %q, namespaced keys must be <namespace>.<name> with a lowercase reverse domain namespace such as org.kusari", key)
+		}
+		return nil
+	}
+	if strings.HasPrefix(strings.ToLower(key), userMetaKeyPrefix) {
+		if len(key) == len(userMetaKeyPrefix) {
+			return fmt.Errorf("invalid meta key %q, x- must be followed by a name", key)
+		}
+		return nil
+	}
+
+	for _, mf := range metadataFlags {
+		if foldMetadataKey(mf.key) == foldMetadataKey(key) {
+			return fmt.Errorf("meta key %q is set with --%s, use the flag instead", key, mf.flag)
+		}
+	}
+	for reserved := range reservedMetadataKeys {
+		if foldMetadataKey(reserved) == foldMetadataKey(key) {
+			return fmt.Errorf("meta key %q is set by the uploader and can't be overridden", key)
+		}
+	}
+	return nil
+}
+
+// isPlainMetadataKey reports whether key is neither namespaced nor user defined
+func isPlainMetadataKey(key string) bool {
+	return !strings.Contains(key, ".") && !strings.HasPrefix(strings.ToLower(key), userMetaKeyPrefix)
+}
+
+// foldMetadataKey returns key in lowercase with - replaced by _, so that keys
+// that would be confused with each other compare equal
+func foldMetadataKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "-", "_")
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// loadMetadataFile reads a JSON or YAML object of upload metadata. Values must
+// be strings, numbers or booleans, which are kept as written.
+func loadMetadataFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	invalid := func(key string) error {
+		return fmt.Errorf("metadata file %s: value of %q must be a string, number or boolean", path, key)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var raw map[string]yaml.Node
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse metadata file %s: %w", path, err)
+		}
+		for key, node := range raw {
+			if node.Kind != yaml.ScalarNode || node.Tag == "!!null" {
+				return nil, invalid(key)
+			}
+			values[key] = node.Value
+		}
+	default:
+		var raw map[string]any
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("failed to parse metadata file %s: %w", path, err)
+		}
+		for key, value := range raw {
+			switch v := value.(type) {
+			case string:
+				values[key] = v
+			case json.Number, bool:
+				values[key] = fmt.Sprint(v)
+			default:
+				return nil, invalid(key)
+			}
+		}
+	}
+	return values, nil
+}
+
+// mustValidateCustomMetadata exits if the --meta setting is invalid
+func mustValidateCustomMetadata(flags *pflag.FlagSet) {
+	if _, err := customMetadata(flags); err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid meta")
+	}
+}
+
+// metadataValues drops the sources from resolved metadata
+func metadataValues(meta map[string]metadataValue) map[string]string {
+	values := make(map[string]string, len(meta))
+	for k, v := range meta {
+		values[k] = v.Value
+	}
+	return values
+}
+
+// fileMetadata returns the upload metadata describing the local file a
+// document was read from, so platform users can recognize it by name. The
+// size is that of the uploaded document, which differs from the file for
+// OpenVEX documents split per product. The modification time is left out if
+// the file can't be stat'ed.
+func fileMetadata(filePath string, size int) map[string]string {
+	meta := map[string]string{
+		"filename":       filepath.Base(filePath),
+		"file_extension": strings.TrimPrefix(filepath.Ext(filePath), "."),
+		"file_size":      strconv.Itoa(size),
+	}
+	if meta["file_extension"] == "" {
+		delete(meta, "file_extension")
+	}
+	if info, err := os.Stat(filePath); err == nil {
+		meta["file_mtime"] = info.ModTime().UTC().Format(time.RFC3339)
+	}
+	return meta
+}
+
+// resolveRunID returns the run ID set with --run-id, or generates a random one,
+// or with --reproducible one derived from the arguments. The run ID is attached
+// to every document uploaded in a run so the documents of one release can be
+// found together in the platform.
+func resolveRunID() string {
+	if runID := viper.GetString("run-id"); runID != "" {
+		return runID
+	}
+	if reproducible() {
+		return reproducibleRunID(os.Args[1:])
+	}
+	return newRunID()
+}
+
+// newRunID returns a random version 4 UUID
+func newRunID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,284 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func Test_resolveUploadMetadata(t *testing.T) {
+	t.Setenv("UPLOADER_ALIAS", "from-env")
+	t.Setenv("UPLOADER_TAG", "env-tag")
+
+	re, err := compilePathPattern("services/api/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := []routingRule{{Pattern: "services/api/", ComponentName: "api", re: re}}
+
+	tests := []struct {
+		name    string
+		args    []string
+		relPath string
+		want    map[string]metadataValue
+	}{
+		{
+			name:    "environment only",
+			relPath: "other/sbom.json",
+			want: map[string]metadataValue{
+				"alias": {Value: "from-env", Source: "env UPLOADER_ALIAS"},
+				"tag":   {Value: "env-tag", Source: "env UPLOADER_TAG"},
+			},
+		},
+		{
+			name:    "flag overrides environment and rule adds component",
+			args:    []string{"--alias", "from-flag"},
+			relPath: "services/api/sbom.json",
+			want: map[string]metadataValue{
+				"alias":          {Value: "from-flag", Source: "flag --alias"},
+				"tag":            {Value: "env-tag", Source: "env UPLOADER_TAG"},
+				"component_name": {Value: "api", Source: `routing rule "services/api/"`},
+			},
+		},
+		{
+			name:    "meta pairs",
+			args:    []string{"--meta", "team=payments", "--meta", "cost-center=42"},
+			relPath: "other/sbom.json",
+			want: map[string]metadataValue{
+				"alias":       {Value: "from-env", Source: "env UPLOADER_ALIAS"},
+				"tag":         {Value: "env-tag", Source: "env UPLOADER_TAG"},
+				"team":        {Value: "payments", Source: "flag --meta"},
+				"cost-center": {Value: "42", Source: "flag --meta"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			addMetadataFlags(flags)
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got := resolveUploadMetadata(flags, rules, tt.relPath)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveUploadMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_customMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     string
+		want    map[string]metadataValue
+		wantErr bool
+	}{
+		{
+			name: "flags",
+			args: []string{"--meta", "team=payments", "--meta", "note=a=b, c", "--meta", "team=payments"},
+			want: map[string]metadataValue{
+				"team": {Value: "payments", Source: "flag --meta"},
+				"note": {Value: "a=b, c", Source: "flag --meta"},
+			},
+		},
+		{
+			name: "environment",
+			env:  "team=payments,owner=jane",
+			want: map[string]metadataValue{
+				"team":  {Value: "payments", Source: "env UPLOADER_META"},
+				"owner": {Value: "jane", Source: "env UPLOADER_META"},
+			},
+		},
+		{name: "flag overrides environment", args: []string{"--meta", "a=1"}, env: "b=2",
+			want: map[string]metadataValue{"a": {Value: "1", Source: "flag --meta"}}},
+		{name: "empty value", args: []string{"--meta", "team="}, want: map[string]metadataValue{"team": {Value: "", Source: "flag --meta"}}},
+		{name: "missing value", args: []string{"--meta", "team"}, wantErr: true},
+		{name: "missing key", args: []string{"--meta", "=payments"}, wantErr: true},
+		{name: "invalid key", args: []string{"--meta", "my team=payments"}, wantErr: true},
+		{name: "dedicated flag", args: []string{"--meta", "tag=x"}, wantErr: true},
+		{name: "reserved key", args: []string{"--meta", "run_id=x"}, wantErr: true},
+		{name: "conflicting values", args: []string{"--meta", "team=a", "--meta", "team=b"}, wantErr: true},
+		{name: "namespaced", args: []string{"--meta", "org.kusari.team=payments", "--meta", "x-tag=nightly"},
+			want: map[string]metadataValue{
+				"org.kusari.team": {Value: "payments", Source: "flag --meta"},
+				"x-tag":           {Value: "nightly", Source: "flag --meta"},
+			}},
+		{name: "namespace", args: []string{"--meta-namespace", "org.kusari", "--meta", "team=payments", "--meta", "x-env=ci", "--meta", "com.acme.owner=jane"},
+			want: map[string]metadataValue{
+				"org.kusari.team": {Value: "payments", Source: "flag --meta"},
+				"x-env":           {Value: "ci", Source: "flag --meta"},
+				"com.acme.owner":  {Value: "jane", Source: "flag --meta"},
+			}},
+		{name: "invalid namespace", args: []string{"--meta-namespace", "kusari", "--meta", "team=payments"}, wantErr: true},
+		{name: "single label namespace", args: []string{"--meta", "build.id=1"}, wantErr: true},
+		{name: "uppercase namespace", args: []string{"--meta", "Org.Kusari.team=1"}, wantErr: true},
+		{name: "empty namespaced name", args: []string{"--meta", "org.kusari.=1"}, wantErr: true},
+		{name: "empty user key", args: []string{"--meta", "x-=1"}, wantErr: true},
+		{name: "dedicated flag in another case", args: []string{"--meta", "Tag=x"}, wantErr: true},
+		{name: "dedicated flag with -", args: []string{"--meta", "component-name=x"}, wantErr: true},
+		{name: "reserved key in another case", args: []string{"--meta", "RUN_ID=x"}, wantErr: true},
+		{name: "colliding keys", args: []string{"--meta", "build_id=1", "--meta", "Build-ID=1"}, wantErr: true},
+		{name: "colliding namespaced keys", args: []string{"--meta-namespace", "org.kusari", "--meta", "team=1", "--meta", "org.kusari.Team=1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("UPLOADER_META", tt.env)
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			addMetadataFlags(flags)
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := customMetadata(flags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("customMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("customMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadMetadataFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "json",
+			file:    "meta.json",
+			content: `{"build_id": "1234", "attempt": 2, "ratio": 1.50, "release": true}`,
+			want:    map[string]string{"build_id": "1234", "attempt": "2", "ratio": "1.50", "release": "true"},
+		},
+		{
+			name:    "yaml",
+			file:    "meta.yml",
+			content: "build_id: \"1234\"\nattempt: 2\nratio: 1.50\npipeline_url: https://ci.example.com/p/1\n",
+			want:    map[string]string{"build_id": "1234", "attempt": "2", "ratio": "1.50", "pipeline_url": "https://ci.example.com/p/1"},
+		},
+		{name: "nested json", file: "meta.json", content: `{"env": {"os": "linux"}}`, wantErr: true},
+		{name: "json null", file: "meta.json", content: `{"env": null}`, wantErr: true},
+		{name: "yaml list", file: "meta.yaml", content: "env: [a, b]\n", wantErr: true},
+		{name: "yaml null", file: "meta.yaml", content: "env: ~\n", wantErr: true},
+		{name: "not an object", file: "meta.json", content: `["a"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadMetadataFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadMetadataFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadMetadataFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_customMetadata_metadataFile(t *testing.T) {
+	t.Setenv("UPLOADER_META", "")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "meta.json")
+	if err := os.WriteFile(path, []byte(`{"build_id": "1234", "team": "core"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reserved := filepath.Join(dir, "reserved.json")
+	if err := os.WriteFile(reserved, []byte(`{"tag": "x"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	addMetadataFlags(flags)
+	if err := flags.Parse([]string{"--metadata-file", path, "--meta", "team=payments"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := customMetadata(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]metadataValue{
+		"build_id": {Value: "1234", Source: "metadata file " + path},
+		"team":     {Value: "payments", Source: "flag --meta"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("customMetadata() = %v, want %v", got, want)
+	}
+
+	if err := flags.Set("metadata-file", reserved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := customMetadata(flags); err == nil {
+		t.Errorf("customMetadata() accepted the key of a dedicated flag from the metadata file")
+	}
+}
+
+func Test_newRunID(t *testing.T) {
+	id := newRunID()
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Errorf("newRunID() = %q, want a version 4 UUID", id)
+	}
+	if id == newRunID() {
+		t.Errorf("newRunID() returned the same ID twice")
+	}
+}
+
+func Test_fileMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.cdx.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"filename":       "app.cdx.json",
+		"file_extension": "json",
+		"file_size":      "2",
+		"file_mtime":     "2024-05-01T12:00:00Z",
+	}
+	if got := fileMetadata(path, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("fileMetadata() = %v, want %v", got, want)
+	}
+
+	want = map[string]string{
+		"filename":  "missing",
+		"file_size": "10",
+	}
+	if got := fileMetadata(filepath.Join(dir, "missing"), 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("fileMetadata() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,334 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM proxy authentication, as used by Windows proxies that don't accept
+// basic authentication. Only NTLMv2 responses are sent. See [MS-NLMP].
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+const (
+	ntlmNegotiateUnicode         = 0x00000001
+	ntlmRequestTarget            = 0x00000004
+	ntlmNegotiateNTLM            = 0x00000200
+	ntlmNegotiateAlwaysSign      = 0x00008000
+	ntlmNegotiateExtendedSession = 0x00080000
+	ntlmNegotiate128             = 0x20000000
+	ntlmNegotiate56              = 0x80000000
+
+	ntlmNegotiateFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+		ntlmNegotiateExtendedSession | ntlmNegotiate128 | ntlmNegotiate56
+
+	// ntlmAvTimestamp is the AV pair ID of the server time in the target info
+	ntlmAvTimestamp = 7
+)
+
+// dialNTLMProxy connects to addr through an http:// proxy with CONNECT,
+// authenticating with the NTLM credentials of the proxy URL. The user may be
+// given as DOMAIN\user.
+func dialNTLMProxy(ctx context.Context, dialer *net.Dialer, proxy *url.URL, addr string) (net.Conn, error) {
+	if proxy.Scheme != "http" {
+		return nil, fmt.Errorf("proxy-auth ntlm only supports http:// proxies, not %s", proxy.Redacted())
+	}
+	if proxy.User == nil || proxy.User.Username() == "" {
+		return nil, errors.New("proxy-auth ntlm requires proxy-user or credentials in the proxy URL")
+	}
+	domain, user, ok := strings.Cut(proxy.User.Username(), `\`)
+	if !ok {
+		domain, user = "", domain
+	}
+	password, _ := proxy.User.Password()
+
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), "80")
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if err := ntlmConnect(conn, addr, domain, user, password); err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("proxy %s: %w", proxy.Redacted(), err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// ntlmConnect opens a tunnel to addr on conn, answering the NTLM challenge of
+// the proxy
+func ntlmConnect(conn net.Conn, addr, domain, user, password string) error {
+	br := bufio.NewReader(conn)
+
+	res, err := sendConnect(conn, br, addr, "NTLM "+base64.StdEncoding.EncodeToString(ntlmNegotiateMessage()))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusOK {
+		// the proxy let us through without authentication
+		return nil
+	}
+	if res.StatusCode != http.StatusProxyAuthRequired {
+		return fmt.Errorf("CONNECT %s: unexpected status %s", addr, res.Status)
+	}
+	if res.Close {
+		return errors.New("the proxy closed the connection during NTLM authentication")
+	}
+
+	var challenge []byte
+	for _, value := range res.Header.Values("Proxy-Authenticate") {
+		if encoded, ok := strings.CutPrefix(value, "NTLM "); ok {
+			challenge, err = base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+			if err != nil {
+				return fmt.Errorf("invalid NTLM challenge: %w", err)
+			}
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("the proxy does not offer NTLM authentication: %s", strings.Join(res.Header.Values("Proxy-Authenticate"), ", "))
+	}
+
+	authenticate, err := ntlmAuthenticateMessage(challenge, domain, user, password, time.Now())
+	if err != nil {
+		return err
+	}
+	res, err = sendConnect(conn, br, addr, "NTLM "+base64.StdEncoding.EncodeToString(authenticate))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT %s: NTLM authentication failed with status %s", addr, res.Status)
+	}
+	return nil
+}
+
+// sendConnect sends a CONNECT request for addr and reads the response,
+// discarding its body so the connection can be reused
+func sendConnect(conn net.Conn, br *bufio.Reader, addr, authorization string) (*http.Response, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{
+			"Proxy-Authorization": {authorization},
+			"Proxy-Connection":    {"Keep-Alive"},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, res.Body)
+		res.Body.Close() //nolint:errcheck
+	}
+	return res, nil
+}
+
+// ntlmNegotiateMessage returns the NEGOTIATE_MESSAGE, without domain or
+// workstation
+func ntlmNegotiateMessage() []byte {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateFlags)
+	return msg
+}
+
+// ntlmAuthenticateMessage answers a CHALLENGE_MESSAGE with an
+// AUTHENTICATE_MESSAGE holding the NTLMv2 response
+func ntlmAuthenticateMessage(challenge []byte, domain, user, password string, now time.Time) ([]byte, error) {
+	if len(challenge) < 48 || !bytes.Equal(challenge[:8], ntlmSignature) || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("invalid NTLM challenge message")
+	}
+	serverChallenge := challenge[24:32]
+	infoLen := int(binary.LittleEndian.Uint16(challenge[40:]))
+	infoOffset := int(binary.LittleEndian.Uint32(challenge[44:]))
+	if infoOffset+infoLen > len(challenge) {
+		return nil, errors.New("invalid NTLM challenge message target info")
+	}
+	targetInfo := challenge[infoOffset : infoOffset+infoLen]
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+	timestamp, serverTime := ntlmTimestamp(targetInfo)
+	if !serverTime {
+		timestamp = ntlmFiletime(now)
+	}
+
+	key := ntowfv2(domain, user, password)
+	nt, lm := ntlmV2Response(key, serverChallenge, clientChallenge, timestamp, targetInfo)
+	if serverTime {
+		// the LMv2 response is not sent when the server sent its time
+		lm = make([]byte, 24)
+	}
+
+	fields := [][]byte{lm, nt, utf16le(domain), utf16le(user), nil, nil}
+	msg := make([]byte, 64)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	offset := len(msg)
+	for i, field := range fields {
+		buf := msg[12+8*i:]
+		binary.LittleEndian.PutUint16(buf, uint16(len(field)))
+		binary.LittleEndian.PutUint16(buf[2:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(buf[4:], uint32(offset))
+		offset += len(field)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], ntlmNegotiateFlags)
+	for _, field := range fields {
+		msg = append(msg, field...)
+	}
+	return msg, nil
+}
+
+// ntlmTimestamp returns the server time of the target info AV pairs, if any
+func ntlmTimestamp(targetInfo []byte) ([]byte, bool) {
+	for len(targetInfo) >= 4 {
+		id := binary.LittleEndian.Uint16(targetInfo)
+		n := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if id == 0 || len(targetInfo) < 4+n {
+			break
+		}
+		if id == ntlmAvTimestamp && n == 8 {
+			return targetInfo[4 : 4+n], true
+		}
+		targetInfo = targetInfo[4+n:]
+	}
+	return nil, false
+}
+
+// ntlmFiletime encodes t as a Windows FILETIME, 100ns intervals since 1601
+func ntlmFiletime(t time.Time) []byte {
+	const epochDelta = 116444736000000000
+	return binary.LittleEndian.AppendUint64(nil, uint64(t.UnixNano()/100+epochDelta))
+}
+
+// ntowfv2 derives the NTLMv2 key from the password
+func ntowfv2(domain, user, password string) []byte {
+	hash := md4Sum(utf16le(password))
+	return hmacMD5(hash[:], utf16le(strings.ToUpper(user)+domain))
+}
+
+// ntlmV2Response returns the NTLMv2 and LMv2 responses to the server challenge
+func ntlmV2Response(key, serverChallenge, clientChallenge, timestamp, targetInfo []byte) ([]byte, []byte) {
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, clientChallenge...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	proof := hmacMD5(key, append(append([]byte{}, serverChallenge...), temp...))
+	lm := hmacMD5(key, append(append([]byte{}, serverChallenge...), clientChallenge...))
+	return append(proof, temp...), append(lm, clientChallenge...)
+}
+
+func hmacMD5(key, data []byte) []byte {
+	mac := hmac.New(md5.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// utf16le encodes s as UTF-16 little endian
+func utf16le(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, r)
+	}
+	return b
+}
+
+// md4Sum returns the MD4 digest of data (RFC 1320), which NTLM hashes
+// passwords with and the standard library doesn't provide
+func md4Sum(data []byte) [16]byte {
+	msg := append([]byte{}, data...)
+	msg = append(msg, 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(data))*8)
+
+	f := func(x, y, z uint32) uint32 { return x&y | ^x&z }
+	g := func(x, y, z uint32) uint32 { return x&y | x&z | y&z }
+	h := func(x, y, z uint32) uint32 { return x ^ y ^ z }
+	rotl := bits.RotateLeft32
+
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+	for block := msg; len(block) > 0; block = block[64:] {
+		var x [16]uint32
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(block[4*i:])
+		}
+		aa, bb, cc, dd := a, b, c, d
+		for _, i := range []int{0, 4, 8, 12} {
+			a = rotl(a+f(b, c, d)+x[i], 3)
+			d = rotl(d+f(a, b, c)+x[i+1], 7)
+			c = rotl(c+f(d, a, b)+x[i+2], 11)
+			b = rotl(b+f(c, d, a)+x[i+3], 19)
+		}
+		for _, i := range []int{0, 1, 2, 3} {
+			a = rotl(a+g(b, c, d)+x[i]+0x5a827999, 3)
+			d = rotl(d+g(a, b, c)+x[i+4]+0x5a827999, 5)
+			c = rotl(c+g(d, a, b)+x[i+8]+0x5a827999, 9)
+			b = rotl(b+g(c, d, a)+x[i+12]+0x5a827999, 13)
+		}
+		for _, i := range []int{0, 2, 1, 3} {
+			a = rotl(a+h(b, c, d)+x[i]+0x6ed9eba1, 3)
+			d = rotl(d+h(a, b, c)+x[i+8]+0x6ed9eba1, 9)
+			c = rotl(c+h(d, a, b)+x[i+4]+0x6ed9eba1, 11)
+			b = rotl(b+h(c, d, a)+x[i+12]+0x6ed9eba1, 15)
+		}
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+
+	var sum [16]byte
+	binary.LittleEndian.PutUint32(sum[0:], a)
+	binary.LittleEndian.PutUint32(sum[4:], b)
+	binary.LittleEndian.PutUint32(sum[8:], c)
+	binary.LittleEndian.PutUint32(sum[12:], d)
+	return sum
+}
@@ -0,0 +1,170 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_md4Sum(t *testing.T) {
+	tests := map[string]string{
+		"":    "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"abc": "a448017aaf21d8525fc10ae87aa6729d",
+		"12345678901234567890123456789012345678901234567890123456789012345678901234567890": "e33b4ddc9c38f2199c3e7b164fcc0536",
+	}
+	for data, want := range tests {
+		if got := md4Sum([]byte(data)); hex.EncodeToString(got[:]) != want {
+			t.Errorf("md4Sum(%q) = %x, want %s", data, got, want)
+		}
+	}
+}
+
+// Test_ntlmV2Response checks the NTLMv2 example of [MS-NLMP] 4.2.4
+func Test_ntlmV2Response(t *testing.T) {
+	key := ntowfv2("Domain", "User", "Password")
+	if got := hex.EncodeToString(key); got != "0c868a403bfd7a93a3001ef22ef02e3f" {
+		t.Fatalf("ntowfv2() = %s", got)
+	}
+
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	clientChallenge := bytes.Repeat([]byte{0xaa}, 8)
+	targetInfo, _ := hex.DecodeString("02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+	nt, lm := ntlmV2Response(key, serverChallenge, clientChallenge, make([]byte, 8), targetInfo)
+	if got := hex.EncodeToString(nt[:16]); got != "68cd0ab851e51c96aabc927bebef6a1c" {
+		t.Errorf("ntlmV2Response() NTProofStr = %s", got)
+	}
+	if got := hex.EncodeToString(lm); got != "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa" {
+		t.Errorf("ntlmV2Response() LMv2 = %s", got)
+	}
+}
+
+// ntlmChallengeMessage returns a CHALLENGE_MESSAGE with targetInfo
+func ntlmChallengeMessage(serverChallenge, targetInfo []byte) []byte {
+	msg := make([]byte, 48)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 2)
+	binary.LittleEndian.PutUint32(msg[20:], ntlmNegotiateFlags)
+	copy(msg[24:], serverChallenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(msg[44:], uint32(len(msg)))
+	return append(msg, targetInfo...)
+}
+
+// serveNTLMProxy accepts one CONNECT tunnel, checking the NTLM response of
+// DOMAIN\user with password secret, and echoes a line through it
+func serveNTLMProxy(ln net.Listener) error {
+	conn, err := ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+	br := bufio.NewReader(conn)
+
+	readMessage := func(wantType uint32) ([]byte, error) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return nil, err
+		}
+		encoded, ok := strings.CutPrefix(req.Header.Get("Proxy-Authorization"), "NTLM ")
+		if req.Method != http.MethodConnect || !ok {
+			return nil, fmt.Errorf("unexpected request %s %s", req.Method, req.Header)
+		}
+		msg, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(msg) < 12 || binary.LittleEndian.Uint32(msg[8:]) != wantType {
+			return nil, fmt.Errorf("unexpected NTLM message %x", msg)
+		}
+		return msg, nil
+	}
+
+	if _, err := readMessage(1); err != nil {
+		return err
+	}
+	serverChallenge := []byte("8 bytes!")
+	challenge := base64.StdEncoding.EncodeToString(ntlmChallengeMessage(serverChallenge, []byte{0, 0, 0, 0}))
+	fmt.Fprintf(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: NTLM %s\r\nContent-Length: 4\r\n\r\ndeny", challenge)
+
+	msg, err := readMessage(3)
+	if err != nil {
+		return err
+	}
+	field := func(i int) []byte {
+		n := binary.LittleEndian.Uint16(msg[12+8*i:])
+		offset := binary.LittleEndian.Uint32(msg[16+8*i:])
+		return msg[offset : offset+uint32(n)]
+	}
+	nt := field(1)
+	proof := hmacMD5(ntowfv2("DOMAIN", "user", "secret"), append(append([]byte{}, serverChallenge...), nt[16:]...))
+	if !bytes.Equal(proof, nt[:16]) || !bytes.Equal(field(3), utf16le("user")) {
+		fmt.Fprint(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+		return nil
+	}
+	fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(line))
+	return err
+}
+
+func Test_dialNTLMProxy(t *testing.T) {
+	for _, tt := range []struct {
+		password string
+		wantErr  bool
+	}{
+		{password: "secret"},
+		{password: "wrong", wantErr: true},
+	} {
+		t.Run(tt.password, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close() //nolint:errcheck
+			served := make(chan error, 1)
+			go func() { served <- serveNTLMProxy(ln) }()
+
+			proxy := &url.URL{Scheme: "http", Host: ln.Addr().String(), User: url.UserPassword(`DOMAIN\user`, tt.password)}
+			conn, err := dialNTLMProxy(context.Background(), &net.Dialer{}, proxy, "tenant.example.com:443")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dialNTLMProxy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer conn.Close() //nolint:errcheck
+				fmt.Fprint(conn, "ping\n")
+				if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "ping\n" {
+					t.Errorf("tunnel read %q, %v, want ping", line, err)
+				}
+			}
+			if err := <-served; err != nil {
+				t.Errorf("proxy error = %v", err)
+			}
+		})
+	}
+}
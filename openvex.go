@@ -0,0 +1,185 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// loadVEXProductMap reads a JSON or YAML file mapping OpenVEX product
+// identifiers (usually purls) to Kusari Platform software IDs
+func loadVEXProductMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read product map %s: %w", path, err)
+	}
+
+	productMap := map[string]string{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &productMap)
+	default:
+		err = json.Unmarshal(data, &productMap)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse product map %s: %w", path, err)
+	}
+
+	return productMap, nil
+}
+
+// vexProductID returns the identifier of an OpenVEX product, which is either a
+// plain string (OpenVEX v0.0.x) or an object with an @id (OpenVEX v0.2)
+func vexProductID(raw json.RawMessage) (string, error) {
+	var id string
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id, nil
+	}
+
+	var product struct {
+		ID string `json:"@id"`
+	}
+	if err := json.Unmarshal(raw, &product); err != nil {
+		return "", fmt.Errorf("invalid product: %s", raw)
+	}
+	return product.ID, nil
+}
+
+// splitVEXByProduct splits an OpenVEX document into one document per software
+// ID, using productMap to find the software ID of each product. Statements that
+// cover products of several software IDs are split so every document only
+// lists its own products. All other fields of the document and statements are
+// kept as is. The products missing from productMap are returned as an error.
+func splitVEXByProduct(blob []byte, productMap map[string]string) (map[string][]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(blob, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenVEX document: %w", err)
+	}
+
+	var statements []map[string]json.RawMessage
+	if err := json.Unmarshal(doc["statements"], &statements); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenVEX statements: %w", err)
+	}
+
+	grouped := map[string][]map[string]json.RawMessage{}
+	unmapped := map[string]bool{}
+
+	for _, statement := range statements {
+		var products []json.RawMessage
+		if err := json.Unmarshal(statement["products"], &products); err != nil {
+			return nil, fmt.Errorf("failed to parse OpenVEX statement products: %w", err)
+		}
+
+		bySoftware := map[string][]json.RawMessage{}
+		for _, product := range products {
+			id, err := vexProductID(product)
+			if err != nil {
+				return nil, err
+			}
+			softwareID, ok := productMap[id]
+			if !ok {
+				unmapped[id] = true
+				continue
+			}
+			bySoftware[softwareID] = append(bySoftware[softwareID], product)
+		}
+
+		for softwareID, products := range bySoftware {
+			productsJSON, err := json.Marshal(products)
+			if err != nil {
+				return nil, err
+			}
+			split := make(map[string]json.RawMessage, len(statement))
+			for k, v := range statement {
+				split[k] = v
+			}
+			split["products"] = productsJSON
+			grouped[softwareID] = append(grouped[softwareID], split)
+		}
+	}
+
+	if len(unmapped) > 0 {
+		ids := make([]string, 0, len(unmapped))
+		for id := range unmapped {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return nil, fmt.Errorf("products missing from the product map: %s", strings.Join(ids, ", "))
+	}
+
+	docs := make(map[string][]byte, len(grouped))
+	for softwareID, statements := range grouped {
+		statementsJSON, err := json.Marshal(statements)
+		if err != nil {
+			return nil, err
+		}
+		// the split documents keep the @id of the feed so they can be traced back to it
+		split := make(map[string]json.RawMessage, len(doc))
+		for k, v := range doc {
+			split[k] = v
+		}
+		split["statements"] = statementsJSON
+
+		docs[softwareID], err = json.MarshalIndent(split, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return docs, nil
+}
+
+// uploadSplitVEX splits the OpenVEX document at filePath by software ID and
+// uploads one document per software ID, returning the software IDs uploaded
+func uploadSplitVEX(authorizedClient, defaultClient HttpClient, tenantApiEndpoint, filePath string,
+	productMap map[string]string, uploadMeta map[string]string) ([]string, error) {
+	blob, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %s, err: %w", filePath, err)
+	}
+
+	docs, err := splitVEXByProduct(blob, productMap)
+	if err != nil {
+		return nil, err
+	}
+
+	softwareIDs := make([]string, 0, len(docs))
+	for softwareID := range docs {
+		softwareIDs = append(softwareIDs, softwareID)
+	}
+	sort.Strings(softwareIDs)
+
+	for _, softwareID := range softwareIDs {
+		meta := make(map[string]string, len(uploadMeta)+1)
+		for k, v := range uploadMeta {
+			meta[k] = v
+		}
+		meta["software_id"] = softwareID
+
+		if _, err := uploadFileBlob(authorizedClient, defaultClient, tenantApiEndpoint, filePath, docs[softwareID], true, meta); err != nil {
+			return nil, fmt.Errorf("failed to upload OpenVEX statements for software ID %s: %w", softwareID, err)
+		}
+	}
+
+	return softwareIDs, nil
+}
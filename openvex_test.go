@@ -0,0 +1,115 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testVEXFeed = `{
+  "@context": "https://openvex.dev/ns/v0.2.0",
+  "@id": "https://example.com/vex/feed",
+  "author": "Example",
+  "statements": [
+    {
+      "vulnerability": {"name": "CVE-2024-0001"},
+      "products": [{"@id": "pkg:oci/api"}, {"@id": "pkg:oci/web"}],
+      "status": "not_affected",
+      "justification": "vulnerable_code_not_present"
+    },
+    {
+      "vulnerability": {"name": "CVE-2024-0002"},
+      "products": ["pkg:oci/web"],
+      "status": "fixed"
+    }
+  ]
+}`
+
+func Test_splitVEXByProduct(t *testing.T) {
+	docs, err := splitVEXByProduct([]byte(testVEXFeed), map[string]string{
+		"pkg:oci/api": "1",
+		"pkg:oci/web": "2",
+	})
+	if err != nil {
+		t.Fatalf("splitVEXByProduct() error = %v", err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("splitVEXByProduct() returned %d documents, want 2", len(docs))
+	}
+
+	type statement struct {
+		Vulnerability struct {
+			Name string `json:"name"`
+		} `json:"vulnerability"`
+		Products []json.RawMessage `json:"products"`
+	}
+	type document struct {
+		ID         string      `json:"@id"`
+		Statements []statement `json:"statements"`
+	}
+
+	want := map[string][]string{
+		"1": {"CVE-2024-0001"},
+		"2": {"CVE-2024-0001", "CVE-2024-0002"},
+	}
+	for softwareID, vulns := range want {
+		var doc document
+		if err := json.Unmarshal(docs[softwareID], &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.ID != "https://example.com/vex/feed" {
+			t.Errorf("document %s lost its @id: %q", softwareID, doc.ID)
+		}
+		var got []string
+		for _, s := range doc.Statements {
+			got = append(got, s.Vulnerability.Name)
+			if len(s.Products) != 1 {
+				t.Errorf("document %s statement %s has %d products, want 1", softwareID, s.Vulnerability.Name, len(s.Products))
+			}
+		}
+		if !reflect.DeepEqual(got, vulns) {
+			t.Errorf("document %s statements = %v, want %v", softwareID, got, vulns)
+		}
+	}
+}
+
+func Test_splitVEXByProductUnmapped(t *testing.T) {
+	_, err := splitVEXByProduct([]byte(testVEXFeed), map[string]string{"pkg:oci/api": "1"})
+	if err == nil {
+		t.Fatal("splitVEXByProduct() expected an error for unmapped products")
+	}
+}
+
+func Test_loadVEXProductMap(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "map.yaml")
+	if err := os.WriteFile(yamlPath, []byte(`"pkg:oci/API": "1"`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadVEXProductMap(yamlPath)
+	if err != nil {
+		t.Fatalf("loadVEXProductMap() error = %v", err)
+	}
+	// product identifiers are case sensitive
+	if !reflect.DeepEqual(got, map[string]string{"pkg:oci/API": "1"}) {
+		t.Errorf("loadVEXProductMap() = %v", got)
+	}
+}
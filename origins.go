@@ -0,0 +1,220 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// registryViolation* are the values of --registry-violations
+const (
+	registryViolationFail = "fail"
+	registryViolationWarn = "warn"
+)
+
+// defaultRegistries are the registries packages of a purl type come from when
+// their purl has no repository_url qualifier
+var defaultRegistries = map[string]string{
+	"npm":      "registry.npmjs.org",
+	"pypi":     "pypi.org",
+	"maven":    "repo.maven.apache.org/maven2",
+	"golang":   "proxy.golang.org",
+	"cargo":    "crates.io",
+	"gem":      "rubygems.org",
+	"nuget":    "api.nuget.org",
+	"docker":   "docker.io",
+	"oci":      "docker.io",
+	"composer": "packagist.org",
+}
+
+// componentOrigin is a component of an SBOM and the registry it comes from
+type componentOrigin struct {
+	Purl     string
+	Registry string
+}
+
+// compileRegistryPatterns compiles the --allowed-registries patterns, which are
+// matched against the registry host and path with the glob syntax of routing
+// rules, e.g. artifactory.example.com/**
+func compileRegistryPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := compilePathPattern(normalizeRegistry(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed registry %q: %w", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// checkComponentOrigins returns an error, or only logs a warning with
+// --registry-violations warn, if an SBOM has components from registries that
+// are not allowed. Without --allowed-registries nothing is checked.
+func checkComponentOrigins(filePath string, blob []byte) error {
+	patterns := viper.GetStringSlice("allowed-registries")
+	if len(patterns) == 0 {
+		return nil
+	}
+	mode := viper.GetString("registry-violations")
+	if mode != registryViolationFail && mode != registryViolationWarn {
+		return fmt.Errorf("registry-violations must be %s or %s", registryViolationFail, registryViolationWarn)
+	}
+	allowed, err := compileRegistryPatterns(patterns)
+	if err != nil {
+		return err
+	}
+
+	violations := registryViolations(sbomComponentOrigins(blob), allowed)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	for _, v := range violations {
+		log.Warn().
+			Str("filePath", filePath).
+			Str("purl", v.Purl).
+			Str("registry", v.Registry).
+			Msg("Component comes from a registry that is not allowed")
+	}
+	if mode == registryViolationWarn {
+		return nil
+	}
+	return fmt.Errorf("%s has %d component(s) from registries that are not allowed, e.g. %s from %s",
+		filePath, len(violations), violations[0].Purl, violations[0].Registry)
+}
+
+// registryViolations returns the origins whose registry matches none of allowed,
+// sorted by purl
+func registryViolations(origins []componentOrigin, allowed []*regexp.Regexp) []componentOrigin {
+	var violations []componentOrigin
+	for _, o := range origins {
+		ok := false
+		for _, re := range allowed {
+			if re.MatchString(o.Registry) || re.MatchString(o.Registry+"/") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			violations = append(violations, o)
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Purl < violations[j].Purl })
+	return violations
+}
+
+// sbomComponentOrigins returns the origins of the components of a CycloneDX or
+// SPDX SBOM that have a purl. Other documents have none.
+func sbomComponentOrigins(blob []byte) []componentOrigin {
+	purls := sbomPurls(blob)
+	origins := make([]componentOrigin, 0, len(purls))
+	for _, purl := range purls {
+		if registry := purlRegistry(purl); registry != "" {
+			origins = append(origins, componentOrigin{Purl: purl, Registry: registry})
+		}
+	}
+	return origins
+}
+
+// sbomPurls returns the purls of the components of a CycloneDX or SPDX SBOM,
+// including nested CycloneDX components. Other documents have none.
+func sbomPurls(blob []byte) []string {
+	var purls []string
+
+	var cdx struct {
+		BOMFormat  string         `json:"bomFormat"`
+		Components []cdxComponent `json:"components"`
+	}
+	if err := json.Unmarshal(blob, &cdx); err == nil && cdx.BOMFormat == "CycloneDX" {
+		var walk func([]cdxComponent)
+		walk = func(components []cdxComponent) {
+			for _, c := range components {
+				if c.Purl != "" {
+					purls = append(purls, c.Purl)
+				}
+				walk(c.Components)
+			}
+		}
+		walk(cdx.Components)
+	}
+
+	var spdx struct {
+		SPDXID   string `json:"SPDXID"`
+		Packages []struct {
+			ExternalRefs []struct {
+				ReferenceType    string `json:"referenceType"`
+				ReferenceLocator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(blob, &spdx); err == nil && spdx.SPDXID == "SPDXRef-DOCUMENT" {
+		for _, p := range spdx.Packages {
+			for _, ref := range p.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					purls = append(purls, ref.ReferenceLocator)
+				}
+			}
+		}
+	}
+
+	return purls
+}
+
+// cdxComponent is a CycloneDX component, which may have nested components
+type cdxComponent struct {
+	Purl       string         `json:"purl"`
+	Components []cdxComponent `json:"components"`
+}
+
+// purlRegistry returns the registry a package comes from: its repository_url
+// qualifier, or else the default registry of its type. Types without a known
+// default registry, such as generic, return "".
+func purlRegistry(purl string) string {
+	rest, ok := strings.CutPrefix(purl, "pkg:")
+	if !ok {
+		return ""
+	}
+	rest, _, _ = strings.Cut(rest, "#")
+	rest, qualifiers, _ := strings.Cut(rest, "?")
+	purlType, _, _ := strings.Cut(rest, "/")
+
+	for _, q := range strings.Split(qualifiers, "&") {
+		key, value, _ := strings.Cut(q, "=")
+		if key == "repository_url" {
+			if v, err := url.QueryUnescape(value); err == nil {
+				return normalizeRegistry(v)
+			}
+		}
+	}
+	return defaultRegistries[strings.ToLower(purlType)]
+}
+
+// normalizeRegistry strips the scheme and trailing slashes from a registry URL
+func normalizeRegistry(registry string) string {
+	if i := strings.Index(registry, "://"); i >= 0 {
+		registry = registry[i+3:]
+	}
+	return strings.ToLower(strings.TrimRight(registry, "/"))
+}
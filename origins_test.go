@@ -0,0 +1,91 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_purlRegistry(t *testing.T) {
+	tests := map[string]string{
+		"pkg:npm/left-pad@1.3.0": "registry.npmjs.org",
+		"pkg:maven/org.example/lib@1.0?repository_url=https%3A%2F%2Fartifactory.example.com%2Fmaven%2F": "artifactory.example.com/maven",
+		"pkg:pypi/requests@2.31.0?repository_url=https://PyPI.example.com/simple#sub":                   "pypi.example.com/simple",
+		"pkg:generic/tool@1.0": "",
+		"not-a-purl":           "",
+	}
+	for purl, want := range tests {
+		if got := purlRegistry(purl); got != want {
+			t.Errorf("purlRegistry(%q) = %q, want %q", purl, got, want)
+		}
+	}
+}
+
+func Test_sbomComponentOrigins(t *testing.T) {
+	cdx := []byte(`{"bomFormat": "CycloneDX", "components": [
+		{"purl": "pkg:npm/a@1.0"},
+		{"purl": "pkg:generic/b@1.0", "components": [{"purl": "pkg:npm/c@1.0?repository_url=https://npm.example.com"}]}
+	]}`)
+	want := []componentOrigin{
+		{Purl: "pkg:npm/a@1.0", Registry: "registry.npmjs.org"},
+		{Purl: "pkg:npm/c@1.0?repository_url=https://npm.example.com", Registry: "npm.example.com"},
+	}
+	if got := sbomComponentOrigins(cdx); !reflect.DeepEqual(got, want) {
+		t.Errorf("sbomComponentOrigins(CycloneDX) = %v, want %v", got, want)
+	}
+
+	spdx := []byte(`{"SPDXID": "SPDXRef-DOCUMENT", "packages": [
+		{"externalRefs": [{"referenceType": "cpe23Type", "referenceLocator": "cpe:2.3:a:x"}, {"referenceType": "purl", "referenceLocator": "pkg:pypi/d@1.0"}]}
+	]}`)
+	want = []componentOrigin{{Purl: "pkg:pypi/d@1.0", Registry: "pypi.org"}}
+	if got := sbomComponentOrigins(spdx); !reflect.DeepEqual(got, want) {
+		t.Errorf("sbomComponentOrigins(SPDX) = %v, want %v", got, want)
+	}
+}
+
+func Test_checkComponentOrigins(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	sbom := []byte(`{"bomFormat": "CycloneDX", "components": [
+		{"purl": "pkg:npm/a@1.0?repository_url=https://artifactory.example.com/npm"},
+		{"purl": "pkg:npm/b@1.0"}
+	]}`)
+
+	if err := checkComponentOrigins("sbom.json", sbom); err != nil {
+		t.Errorf("checkComponentOrigins() without allowed registries error = %v", err)
+	}
+
+	viper.Set("allowed-registries", []string{"https://artifactory.example.com/**"})
+	viper.Set("registry-violations", registryViolationFail)
+	if err := checkComponentOrigins("sbom.json", sbom); err == nil {
+		t.Errorf("checkComponentOrigins() allowed a component from the public npm registry")
+	}
+
+	viper.Set("registry-violations", registryViolationWarn)
+	if err := checkComponentOrigins("sbom.json", sbom); err != nil {
+		t.Errorf("checkComponentOrigins() in warn mode error = %v", err)
+	}
+
+	viper.Set("allowed-registries", []string{"artifactory.example.com/**", "registry.npmjs.org"})
+	viper.Set("registry-violations", registryViolationFail)
+	if err := checkComponentOrigins("sbom.json", sbom); err != nil {
+		t.Errorf("checkComponentOrigins() error = %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	outputText   = "text"
+	outputNDJSON = "ndjson"
+
+	// outputGoTemplate prefixes a text/template executed for every completed file
+	outputGoTemplate = "go-template="
+)
+
+const (
+	resultUploaded = "uploaded"
+	resultSkipped  = "skipped"
+	resultFailed   = "failed"
+	// resultQuarantined files kept failing and were set aside, see quarantinePolicy
+	resultQuarantined = "quarantined"
+)
+
+// fileResult is the outcome of a single file of a run
+type fileResult struct {
+	Path        string    `json:"path"`
+	Status      string    `json:"status"`
+	DocumentRef string    `json:"document_ref,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	RunID       string    `json:"run_id,omitempty"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// resultStream writes one JSON object per completed file as soon as the file
+// completes, so that tools tailing the output can react during long runs. A nil
+// resultStream discards the results.
+type resultStream struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	runID string
+
+	// when set, results are rendered with tmpl instead of encoded as JSON
+	w    io.Writer
+	tmpl *template.Template
+
+	// when set, every result is also added to the summary of the run
+	summary *uploadSummary
+}
+
+func newResultStream(w io.Writer, runID string) *resultStream {
+	return &resultStream{enc: json.NewEncoder(w), runID: runID}
+}
+
+// newTemplateStream returns a resultStream that executes tmpl for every
+// completed file, writing a newline after each result
+func newTemplateStream(w io.Writer, runID string, tmpl *template.Template) *resultStream {
+	return &resultStream{w: w, runID: runID, tmpl: tmpl}
+}
+
+// withSummary returns a stream that also adds every result to summary. For
+// text output, where s is nil, the returned stream only does that.
+func (s *resultStream) withSummary(summary *uploadSummary) *resultStream {
+	if s == nil {
+		s = &resultStream{}
+	}
+	s.summary = summary
+	return s
+}
+
+// parseResultTemplate parses the template of a go-template output format. The
+// template is executed with a fileResult, so its fields are referenced by their
+// Go names, e.g. {{.Path}} or {{.DocumentRef}}.
+func parseResultTemplate(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("%s output requires a template, e.g. %s'{{.DocumentRef}}'", strings.TrimSuffix(outputGoTemplate, "="), outputGoTemplate)
+	}
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %w", err)
+	}
+	return tmpl, nil
+}
+
+func (s *resultStream) write(r fileResult) {
+	if s == nil {
+		return
+	}
+	r.RunID = s.runID
+	if r.CompletedAt.IsZero() {
+		r.CompletedAt = generatedAt(time.Now()).UTC()
+	}
+
+	if s.summary != nil {
+		s.summary.add(r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	switch {
+	case s.tmpl != nil:
+		err = s.writeTemplate(r)
+	case s.enc != nil:
+		err = s.enc.Encode(r)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("path", r.Path).Msg("Failed to write result")
+	}
+}
+
+func (s *resultStream) writeTemplate(r fileResult) error {
+	// render to a buffer first so that a failing template does not leave a partial line
+	var buf strings.Builder
+	if err := s.tmpl.Execute(&buf, r); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+	_, err := io.WriteString(s.w, buf.String())
+	return err
+}
+
+// newOutput validates the output format and returns the stream for per-file
+// results (nil for text output) and the writer for human readable messages,
+// which go to stderr when stdout is reserved for results
+func newOutput(format, runID string) (*resultStream, io.Writer, error) {
+	switch format {
+	case outputText, "":
+		return nil, os.Stdout, nil
+	case outputNDJSON:
+		return newResultStream(os.Stdout, runID), os.Stderr, nil
+	}
+	if text, ok := strings.CutPrefix(format, outputGoTemplate); ok {
+		tmpl, err := parseResultTemplate(text)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newTemplateStream(os.Stdout, runID, tmpl), os.Stderr, nil
+	}
+	return nil, nil, fmt.Errorf("unknown output format %q, must be %s, %s or %s<template>", format, outputText, outputNDJSON, outputGoTemplate)
+}
@@ -0,0 +1,107 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_resultStream(t *testing.T) {
+	var buf bytes.Buffer
+	s := newResultStream(&buf, "run-1")
+	s.write(fileResult{Path: "a.json", Status: resultUploaded})
+	s.write(fileResult{Path: "b.json", Status: resultFailed, Error: "boom"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("resultStream wrote %d lines, want 2: %q", len(lines), buf.String())
+	}
+	for _, want := range []string{`"path":"b.json"`, `"status":"failed"`, `"error":"boom"`, `"run_id":"run-1"`} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("result %s does not contain %s", lines[1], want)
+		}
+	}
+
+	// a nil stream discards results
+	var discard *resultStream
+	discard.write(fileResult{Path: "c.json"})
+}
+
+func Test_newOutput(t *testing.T) {
+	if results, _, err := newOutput(outputText, "run"); err != nil || results != nil {
+		t.Errorf("newOutput(text) = %v, %v, want no result stream", results, err)
+	}
+	if results, _, err := newOutput(outputNDJSON, "run"); err != nil || results == nil {
+		t.Errorf("newOutput(ndjson) = %v, %v, want a result stream", results, err)
+	}
+	if results, _, err := newOutput("go-template={{.DocumentRef}}", "run"); err != nil || results == nil {
+		t.Errorf("newOutput(go-template) = %v, %v, want a result stream", results, err)
+	}
+	for _, format := range []string{"xml", "go-template=", "go-template={{.DocumentRef"} {
+		if _, _, err := newOutput(format, "run"); err == nil {
+			t.Errorf("newOutput(%s) expected an error", format)
+		}
+	}
+}
+
+func Test_templateStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		result   fileResult
+		want     string
+	}{
+		{
+			name:     "document ref",
+			template: "{{.DocumentRef}}",
+			result:   fileResult{Path: "a.json", Status: resultUploaded, DocumentRef: "sbom-abc"},
+			want:     "sbom-abc\n",
+		},
+		{
+			name:     "several fields",
+			template: "{{.Path}}\t{{.Status}}\t{{.RunID}}",
+			result:   fileResult{Path: "a.json", Status: resultUploaded},
+			want:     "a.json\tuploaded\trun-1\n",
+		},
+		{
+			name:     "conditional error",
+			template: "{{.Path}}{{if .Error}}: {{.Error}}{{end}}",
+			result:   fileResult{Path: "b.json", Status: resultFailed, Error: "boom"},
+			want:     "b.json: boom\n",
+		},
+		{
+			name:     "unknown field writes nothing",
+			template: "{{.Nope}}",
+			result:   fileResult{Path: "a.json"},
+			want:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseResultTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("parseResultTemplate() error = %v", err)
+			}
+			var buf bytes.Buffer
+			newTemplateStream(&buf, "run-1", tmpl).write(tt.result)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("template stream wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// pacingWindow is the time of day bulk uploads are allowed to run in, such as
+// 22:00-06:00. Outside of it uploads pause until it opens again.
+type pacingWindow struct {
+	// start and end are minutes after midnight, a window whose end is before its
+	// start spans midnight
+	start, end int
+	loc        *time.Location
+	// now and sleep are replaced in tests
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+
+	mu     sync.Mutex
+	paused time.Time
+}
+
+// addPacingFlag defines the pacing-window flag
+func addPacingFlag(flags *pflag.FlagSet) {
+	flags.String("pacing-window", "", "Only upload during this time of day, e.g. \"22:00-06:00\" or \"22:00-06:00 Europe/Berlin\" (local time unless a time zone is given); outside of it uploads pause and resume when it opens (optional)")
+}
+
+// resolvePacingWindow returns the window of --pacing-window, or nil without one
+func resolvePacingWindow() (*pacingWindow, error) {
+	value := viper.GetString("pacing-window")
+	if value == "" {
+		return nil, nil
+	}
+	return parsePacingWindow(value)
+}
+
+// parsePacingWindow parses a window of the form HH:MM-HH:MM, optionally
+// followed by an IANA time zone
+func parsePacingWindow(value string) (*pacingWindow, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid pacing window %q, want HH:MM-HH:MM with an optional time zone", value)
+	}
+	startValue, endValue, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid pacing window %q, want HH:MM-HH:MM with an optional time zone", value)
+	}
+	start, err := parseClock(startValue)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(endValue)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid pacing window %q, the start and end are the same", value)
+	}
+
+	loc := time.Local
+	if len(fields) == 2 {
+		loc, err = time.LoadLocation(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pacing window time zone %q: %w", fields[1], err)
+		}
+	}
+	return &pacingWindow{start: start, end: end, loc: loc, now: time.Now, sleep: sleepContext}, nil
+}
+
+// parseClock returns the minutes after midnight of a HH:MM time
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pacing window time %q, want HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether t is inside the window
+func (w *pacingWindow) contains(t time.Time) bool {
+	t = t.In(w.loc)
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// nextOpen returns t if it is inside the window, or else the time the window
+// opens next
+func (w *pacingWindow) nextOpen(t time.Time) time.Time {
+	if w.contains(t) {
+		return t
+	}
+	local := t.In(w.loc)
+	for day := 0; day <= 1; day++ {
+		open := time.Date(local.Year(), local.Month(), local.Day()+day, w.start/60, w.start%60, 0, 0, w.loc)
+		if open.After(t) {
+			return open
+		}
+	}
+	// not reached, the window opens within a day
+	return t
+}
+
+// wait blocks until the window is open. Uploads already running finish, and
+// the pause is logged once however many workers wait for it.
+func (w *pacingWindow) wait(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	for {
+		now := w.now()
+		open := w.nextOpen(now)
+		if !open.After(now) {
+			w.mu.Lock()
+			if !w.paused.IsZero() {
+				log.Info().Msg("Inside the pacing window, resuming uploads")
+				w.paused = time.Time{}
+			}
+			w.mu.Unlock()
+			return nil
+		}
+
+		w.mu.Lock()
+		if !w.paused.Equal(open) {
+			log.Info().
+				Time("until", open).
+				Msg("Outside the pacing window, pausing uploads")
+			w.paused = open
+		}
+		w.mu.Unlock()
+
+		if err := w.sleep(ctx, open.Sub(now)); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext waits for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
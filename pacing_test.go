@@ -0,0 +1,117 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_parsePacingWindow(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: "22:00-06:00"},
+		{value: "01:30-05:00 UTC"},
+		{value: "22:00-06:00 Europe/Berlin"},
+		{value: "22:00", wantErr: true},
+		{value: "22:00-06:00 Mars/Olympus", wantErr: true},
+		{value: "25:00-06:00", wantErr: true},
+		{value: "06:00-06:00", wantErr: true},
+		{value: "22:00-06:00 UTC extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if _, err := parsePacingWindow(tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("parsePacingWindow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_pacingWindow_nextOpen(t *testing.T) {
+	overnight, err := parsePacingWindow("22:00-06:00 UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daytime, err := parsePacingWindow("09:00-17:00 UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		window *pacingWindow
+		now    time.Time
+		want   time.Time
+	}{
+		{name: "overnight before midnight", window: overnight, now: at(23, 0), want: at(23, 0)},
+		{name: "overnight after midnight", window: overnight, now: at(5, 59), want: at(5, 59)},
+		{name: "overnight closed", window: overnight, now: at(6, 0), want: at(22, 0)},
+		{name: "daytime closed in the morning", window: daytime, now: at(8, 0), want: at(9, 0)},
+		{name: "daytime closed in the evening", window: daytime, now: at(17, 0), want: at(24+9, 0)},
+		{name: "daytime open", window: daytime, now: at(12, 0), want: at(12, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.nextOpen(tt.now); !got.Equal(tt.want) {
+				t.Errorf("nextOpen() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pacingWindow_wait(t *testing.T) {
+	w, err := parsePacingWindow("22:00-06:00 UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var slept []time.Duration
+	w.now = func() time.Time { return now }
+	w.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		return nil
+	}
+
+	if err := w.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(slept) != 1 || slept[0] != 10*time.Hour {
+		t.Errorf("wait() slept %v, want 10h until the window opens", slept)
+	}
+	if err := w.wait(context.Background()); err != nil || len(slept) != 1 {
+		t.Errorf("wait() inside the window slept %v, %v, want no sleep", slept, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	now = time.Date(2024, 5, 2, 7, 0, 0, 0, time.UTC)
+	w.sleep = sleepContext
+	if err := w.wait(ctx); err == nil {
+		t.Error("wait() with a cancelled context returned no error")
+	}
+
+	var none *pacingWindow
+	if err := none.wait(context.Background()); err != nil {
+		t.Errorf("wait() without a window error = %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultPageSize is the number of items requested per page from list endpoints
+const defaultPageSize = 500
+
+// pageCursor is the pagination field of list endpoint responses. Tenants that
+// predate pagination return every item in one response without a cursor.
+type pageCursor struct {
+	NextCursor string `json:"next_cursor"`
+}
+
+// forEachPage requests the pages of the list endpoint at pathAndQS, pageSize
+// items at a time, and calls handle with the body of each page as it arrives,
+// so large results are never held in memory at once. It stops when the tenant
+// returns no next cursor or handle returns false.
+func forEachPage(ctx context.Context, client HttpClient, tenantEndpoint, pathAndQS string, pageSize int,
+	handle func(body []byte) (bool, error)) error {
+	sep := "?"
+	if strings.Contains(pathAndQS, "?") {
+		sep = "&"
+	}
+
+	cursor := ""
+	for {
+		query := url.Values{}
+		query.Set("limit", strconv.Itoa(pageSize))
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		body, err := getPage(ctx, client, tenantEndpoint, pathAndQS+sep+query.Encode())
+		if err != nil {
+			return err
+		}
+
+		more, err := handle(body)
+		if err != nil || !more {
+			return err
+		}
+
+		var page pageCursor
+		if err := json.Unmarshal(body, &page); err != nil {
+			return fmt.Errorf("error unmarshaling page cursor: %w", err)
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		if page.NextCursor == cursor {
+			return fmt.Errorf("tenant returned the same cursor twice for %s", pathAndQS)
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func getPage(ctx context.Context, client HttpClient, tenantEndpoint, pathAndQS string) ([]byte, error) {
+	res, err := makePicoReq(ctx, client, tenantEndpoint, pathAndQS)
+	if err != nil {
+		return nil, fmt.Errorf("error making request for %s: %w", pathAndQS, err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	if res.StatusCode != http.StatusOK {
+		return nil, tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for %s: %d", pathAndQS, res.StatusCode))
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body for %s: %w", pathAndQS, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,90 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func Test_forEachDocumentRef(t *testing.T) {
+	pages := map[string]string{
+		"":   `{"document_refs": ["sha256_a", "sha256_b"], "next_cursor": "c1"}`,
+		"c1": `{"document_refs": ["sha256_c", "sha256_d"], "next_cursor": "c2"}`,
+		"c2": `{"document_refs": ["sha256_e"]}`,
+	}
+
+	tests := []struct {
+		name     string
+		limit    int
+		want     []string
+		wantReqs int
+	}{
+		{name: "all", limit: 0, want: []string{"sha256_a", "sha256_b", "sha256_c", "sha256_d", "sha256_e"}, wantReqs: 3},
+		{name: "limit within the first page", limit: 1, want: []string{"sha256_a"}, wantReqs: 1},
+		{name: "limit across pages", limit: 3, want: []string{"sha256_a", "sha256_b", "sha256_c"}, wantReqs: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqs := 0
+			client := &ClientMock{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					reqs++
+					body, ok := pages[req.URL.Query().Get("cursor")]
+					if !ok {
+						t.Fatalf("unexpected cursor in %s", req.URL)
+					}
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+				},
+			}
+
+			var got []string
+			err := forEachDocumentRef(context.Background(), client, "http://example.com", tt.limit, func(ref string) error {
+				got = append(got, ref)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("forEachDocumentRef() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("forEachDocumentRef() = %v, want %v", got, tt.want)
+			}
+			if reqs != tt.wantReqs {
+				t.Errorf("forEachDocumentRef() made %d requests, want %d", reqs, tt.wantReqs)
+			}
+		})
+	}
+}
+
+func Test_forEachPageRepeatedCursor(t *testing.T) {
+	client := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"document_refs": [], "next_cursor": "same"}`)),
+			}, nil
+		},
+	}
+	err := forEachPage(context.Background(), client, "http://example.com", "pico/v1/documents/refs", 10,
+		func([]byte) (bool, error) { return true, nil })
+	if err == nil {
+		t.Error("forEachPage() expected an error when the cursor does not advance")
+	}
+}
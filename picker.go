@@ -0,0 +1,206 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// errNoTerminal is returned by the picker without a terminal to ask on
+var errNoTerminal = errors.New("interactive requires a terminal on stdin and stderr")
+
+// pickerCandidate is a file offered by the interactive picker
+type pickerCandidate struct {
+	Path   string
+	Kind   string
+	Format string
+	Size   int64
+}
+
+// findPickerCandidates returns the non-empty files of the file list, or else
+// the file or the files below the directory at filePath, with their detected
+// kind and format
+func findPickerCandidates(filePath string, fileList []string) ([]pickerCandidate, error) {
+	var candidates []pickerCandidate
+	add := func(path string, info os.FileInfo) error {
+		if info.IsDir() || info.Size() == 0 {
+			return nil
+		}
+		blob, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s, err: %w", path, err)
+		}
+		kind, format, encoding := detectEncodedDocument(blob)
+		if encoding != "" {
+			format += " (" + encoding + ")"
+		}
+		candidates = append(candidates, pickerCandidate{Path: path, Kind: kind, Format: format, Size: info.Size()})
+		return nil
+	}
+
+	if fileList != nil {
+		for _, path := range fileList {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get stats on filepath: %s, with error: %w", path, err)
+			}
+			if err := add(path, info); err != nil {
+				return nil, err
+			}
+		}
+		return candidates, nil
+	}
+
+	err := filepath.Walk(filePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return add(path, info)
+	})
+	return candidates, err
+}
+
+// pickFiles lists the candidates on out and asks which of them to upload, then
+// asks to confirm the selection. It returns the selected candidates, none if
+// the upload is not confirmed.
+func pickFiles(in io.Reader, out io.Writer, candidates []pickerCandidate) ([]pickerCandidate, error) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tFILE\tTYPE\tFORMAT\tSIZE")
+	for i, c := range candidates {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\n", i+1, c.Path, c.Kind, c.Format, c.Size)
+	}
+	tw.Flush() //nolint:errcheck
+
+	scanner := bufio.NewScanner(in)
+	ask := func(prompt string) (string, error) {
+		fmt.Fprint(out, prompt)
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.ErrUnexpectedEOF
+		}
+		return strings.TrimSpace(scanner.Text()), nil
+	}
+
+	var selected []pickerCandidate
+	for {
+		answer, err := ask("Select files to upload, e.g. 1,3-5, all or none [detected]: ")
+		if err != nil {
+			return nil, err
+		}
+		indexes, err := parseSelection(answer, candidates)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			continue
+		}
+		for _, i := range indexes {
+			selected = append(selected, candidates[i])
+		}
+		break
+	}
+	if len(selected) == 0 {
+		return nil, nil
+	}
+
+	answer, err := ask(fmt.Sprintf("Upload %d file(s)? [y/N]: ", len(selected)))
+	if err != nil {
+		return nil, err
+	}
+	if a := strings.ToLower(answer); a != "y" && a != "yes" {
+		return nil, nil
+	}
+	return selected, nil
+}
+
+// parseSelection returns the indexes of the candidates selected by a comma
+// separated list of numbers and ranges, "all" or "none". An empty answer
+// selects the candidates of a detected kind.
+func parseSelection(answer string, candidates []pickerCandidate) ([]int, error) {
+	var indexes []int
+	switch strings.ToLower(answer) {
+	case "":
+		for i, c := range candidates {
+			if c.Kind != kindUnknown {
+				indexes = append(indexes, i)
+			}
+		}
+		return indexes, nil
+	case "all":
+		for i := range candidates {
+			indexes = append(indexes, i)
+		}
+		return indexes, nil
+	case "none":
+		return nil, nil
+	}
+
+	seen := map[int]bool{}
+	for _, part := range strings.Split(answer, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(strings.TrimSpace(from))
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+		if err != nil || first < 1 || last > len(candidates) || first > last {
+			return nil, fmt.Errorf("invalid selection %q, must be numbers or ranges between 1 and %d", part, len(candidates))
+		}
+		for i := first - 1; i < last; i++ {
+			if !seen[i] {
+				seen[i] = true
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	return indexes, nil
+}
+
+// pickFilesOnTerminal finds the candidates at filePath or in fileList and asks
+// on the terminal which of them to upload
+func pickFilesOnTerminal(filePath string, fileList []string) ([]pickerCandidate, error) {
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		return nil, errNoTerminal
+	}
+	candidates, err := findPickerCandidates(filePath, fileList)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	return pickFiles(os.Stdin, os.Stderr, candidates)
+}
+
+// filterFileList returns the paths of fileList that are selected
+func filterFileList(fileList []string, selected map[string]bool) []string {
+	filtered := []string{}
+	for _, path := range fileList {
+		if selected[path] {
+			filtered = append(filtered, path)
+		}
+	}
+	return filtered
+}
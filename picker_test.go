@@ -0,0 +1,122 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseSelection(t *testing.T) {
+	candidates := []pickerCandidate{{Kind: kindCycloneDX}, {Kind: kindUnknown}, {Kind: kindSPDX}, {Kind: kindOpenVEX}}
+	tests := []struct {
+		answer  string
+		want    []int
+		wantErr bool
+	}{
+		{answer: "", want: []int{0, 2, 3}},
+		{answer: "all", want: []int{0, 1, 2, 3}},
+		{answer: "None"},
+		{answer: "2", want: []int{1}},
+		{answer: "1, 3-4, 3", want: []int{0, 2, 3}},
+		{answer: "5", wantErr: true},
+		{answer: "3-2", wantErr: true},
+		{answer: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.answer, func(t *testing.T) {
+			got, err := parseSelection(tt.answer, candidates)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSelection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSelection() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pickFiles(t *testing.T) {
+	candidates := []pickerCandidate{
+		{Path: "a.json", Kind: kindCycloneDX, Format: formatJSON, Size: 10},
+		{Path: "b.txt", Kind: kindUnknown, Format: formatUnknown, Size: 3},
+	}
+	tests := []struct {
+		name    string
+		input   string
+		want    []pickerCandidate
+		wantErr bool
+	}{
+		{name: "default and confirm", input: "\ny\n", want: candidates[:1]},
+		{name: "retry invalid selection", input: "7\n2\nyes\n", want: candidates[1:]},
+		{name: "not confirmed", input: "all\nn\n"},
+		{name: "none", input: "none\n"},
+		{name: "end of input", input: "1\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			got, err := pickFiles(strings.NewReader(tt.input), &out, candidates)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pickFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pickFiles() = %v, want %v", got, tt.want)
+			}
+			if !strings.Contains(out.String(), "a.json  CycloneDX SBOM") {
+				t.Errorf("pickFiles() did not list the candidates:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func Test_findPickerCandidates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"sbom.json":  `{"bomFormat": "CycloneDX"}`,
+		"empty.json": "",
+		"notes.txt":  "hello",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []pickerCandidate{
+		{Path: filepath.Join(dir, "notes.txt"), Kind: kindUnknown, Format: formatUnknown, Size: 5},
+		{Path: filepath.Join(dir, "sbom.json"), Kind: kindCycloneDX, Format: formatJSON, Size: 26},
+	}
+	got, err := findPickerCandidates(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findPickerCandidates() = %+v, want %+v", got, want)
+	}
+
+	got, err = findPickerCandidates("", []string{filepath.Join(dir, "sbom.json"), filepath.Join(dir, "empty.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("findPickerCandidates() with a file list = %+v, want %+v", got, want[1:])
+	}
+}
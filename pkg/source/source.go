@@ -0,0 +1,136 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package source iterates over the documents of an upload. The uploader reads
+// directories, file lists, globs and object store prefixes through a Source.
+// The package exports the Source interface and the directory and file list
+// sources so other programs can list documents the same way; the upload
+// pipeline that consumes a Source is part of the kusari-uploader command and
+// can't be called from other programs.
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Item is a document produced by a Source
+type Item struct {
+	// Path identifies the document in results and logs
+	Path string
+	// RelPath is the path of the document below the root of its source, which
+	// routing rules match against
+	RelPath string
+	// Read returns the content of the document, nil reads Path from disk
+	Read func(ctx context.Context) ([]byte, error)
+}
+
+// Content returns the content of the item
+func (item Item) Content(ctx context.Context) ([]byte, error) {
+	if item.Read != nil {
+		return item.Read(ctx)
+	}
+	return os.ReadFile(item.Path)
+}
+
+// Source iterates over the documents of an upload. Next returns io.EOF once
+// every document has been returned. A source backed by a catalog or object
+// store can list its documents a page at a time instead of holding every entry
+// in memory, and the upload pipeline only reads a document when it's about to
+// hash it. An error for a single document is returned together with its item
+// so the failure can be reported against its path, an error without a Path
+// ends the upload.
+type Source interface {
+	Next(ctx context.Context) (Item, error)
+}
+
+// Directory returns the files below a directory in lexical order, limited to
+// selected unless it is nil. The first call to Next walks the whole tree and
+// keeps the paths of its files in memory; the files themselves are only read
+// through Item.Content.
+type Directory struct {
+	dir      string
+	selected map[string]bool
+	files    []Item
+	walked   bool
+}
+
+// NewDirectory returns a Source of the files below dir, or of the files below
+// dir that are in selected if it is not nil
+func NewDirectory(dir string, selected map[string]bool) *Directory {
+	return &Directory{dir: dir, selected: selected}
+}
+
+func (s *Directory) Next(ctx context.Context) (Item, error) {
+	if !s.walked {
+		s.walked = true
+		err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && (s.selected == nil || s.selected[path]) {
+				relPath, err := filepath.Rel(s.dir, path)
+				if err != nil {
+					return fmt.Errorf("failed to get relative path of %s: %w", path, err)
+				}
+				s.files = append(s.files, Item{Path: path, RelPath: relPath})
+			}
+			return nil
+		})
+		if err != nil {
+			return Item{}, err
+		}
+	}
+	if len(s.files) == 0 {
+		return Item{}, io.EOF
+	}
+	item := s.files[0]
+	s.files = s.files[1:]
+	return item, nil
+}
+
+// List returns the files of a list of paths, skipping directories
+type List struct {
+	paths []string
+}
+
+// NewList returns a Source of the files of paths
+func NewList(paths []string) *List {
+	return &List{paths: paths}
+}
+
+func (s *List) Next(ctx context.Context) (Item, error) {
+	for len(s.paths) > 0 {
+		path := s.paths[0]
+		s.paths = s.paths[1:]
+
+		item := Item{Path: path, RelPath: filepath.Clean(path)}
+		info, err := os.Stat(path)
+		if err != nil {
+			return item, fmt.Errorf("failed to get stats on filepath: %s, with error: %w", path, err)
+		}
+		if info.IsDir() {
+			log.Debug().Str("path", path).Msg("Skipping directory in file list")
+			continue
+		}
+		return item, nil
+	}
+	return Item{}, io.EOF
+}
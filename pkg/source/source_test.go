@@ -0,0 +1,97 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// drainSource returns the paths and relative paths of every item of src
+func drainSource(t *testing.T, src Source) ([]string, []string) {
+	t.Helper()
+	var paths, relPaths []string
+	for {
+		item, err := src.Next(context.Background())
+		if err == io.EOF {
+			return paths, relPaths
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		paths = append(paths, item.Path)
+		relPaths = append(relPaths, filepath.ToSlash(item.RelPath))
+	}
+}
+
+func writeSourceFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeSourceFiles(t, dir, "b.json", "a/c.json", "a.json")
+
+	_, relPaths := drainSource(t, NewDirectory(dir, nil))
+	if want := []string{"a/c.json", "a.json", "b.json"}; !reflect.DeepEqual(relPaths, want) {
+		t.Errorf("Directory = %v, want %v", relPaths, want)
+	}
+
+	selected := map[string]bool{filepath.Join(dir, "b.json"): true}
+	paths, _ := drainSource(t, NewDirectory(dir, selected))
+	if want := []string{filepath.Join(dir, "b.json")}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Directory with selected files = %v, want %v", paths, want)
+	}
+
+	if _, err := NewDirectory(filepath.Join(dir, "missing"), nil).Next(context.Background()); err == nil || err == io.EOF {
+		t.Errorf("Next() of a missing directory error = %v, want a walk error", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	writeSourceFiles(t, dir, "a.json", "sub/b.json")
+	missing := filepath.Join(dir, "missing.json")
+
+	src := NewList([]string{filepath.Join(dir, "a.json"), filepath.Join(dir, "sub"), missing, filepath.Join(dir, "sub", "b.json")})
+	item, err := src.Next(context.Background())
+	if err != nil || item.Path != filepath.Join(dir, "a.json") {
+		t.Fatalf("Next() = %v, %v, want a.json", item, err)
+	}
+	if data, err := item.Content(context.Background()); err != nil || string(data) != "a.json" {
+		t.Errorf("Content() = %q, %v, want the file content", data, err)
+	}
+	if item, err := src.Next(context.Background()); err == nil || item.Path != missing {
+		t.Errorf("Next() = %v, %v, want the directory skipped and an error for missing.json", item, err)
+	}
+	paths, _ := drainSource(t, src)
+	if want := []string{filepath.Join(dir, "sub", "b.json")}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("List after an error = %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,230 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+// profileRun is the outcome of the upload of one profile
+type profileRun struct {
+	Name     string
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	Elapsed  time.Duration
+}
+
+// loadProfiles returns the environment of each of the named profiles of the
+// profiles section of the config file. Every setting of a profile must be a
+// flag of cmd, and is passed to the upload of the profile as its UPLOADER_
+// environment variable. Flags set on the command line would win over it in
+// every profile, so a profile can't set them.
+func loadProfiles(flags *pflag.FlagSet, names []string) (map[string][]string, error) {
+	all := viper.GetStringMap("profiles")
+	profiles := map[string][]string{}
+	for _, name := range names {
+		if _, ok := profiles[name]; ok {
+			return nil, fmt.Errorf("profile %q is given more than once", name)
+		}
+		raw, ok := all[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("profile %q is not defined in the profiles section of the config file", name)
+		}
+		settings, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("profile %q must be a map of settings", name)
+		}
+
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		env := make([]string, 0, len(keys))
+		for _, key := range keys {
+			flag := flags.Lookup(key)
+			if key == "profile" || flag == nil {
+				return nil, fmt.Errorf("profile %q has unknown setting %q", name, key)
+			}
+			if flag.Changed {
+				return nil, fmt.Errorf("profile %q sets %s, which can't also be given on the command line", name, key)
+			}
+			env = append(env, envVarNames(key)[0]+"="+profileValue(settings[key]))
+		}
+		profiles[name] = env
+	}
+	return profiles, nil
+}
+
+// profileValue formats a setting of a profile like its flag would be given,
+// with list values separated by commas
+func profileValue(v any) string {
+	if list, ok := v.([]any); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// withoutFlag returns args without any occurrence of the long flag name and
+// its value
+func withoutFlag(args []string, name string) []string {
+	var res []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(res, args[i:]...)
+		}
+		if arg == "--"+name {
+			i++
+			continue
+		}
+		if strings.HasPrefix(arg, "--"+name+"=") {
+			continue
+		}
+		res = append(res, arg)
+	}
+	return res
+}
+
+// runProfiles runs the upload once per profile, all at the same time, by
+// running this binary again with the same arguments and the settings of the
+// profile in its environment. All profiles share the run ID.
+func runProfiles(ctx context.Context, exe string, args []string, runID string, profiles map[string][]string,
+	names []string) []profileRun {
+	runs := make([]profileRun, len(names))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, name := range names {
+		g.Go(func() error {
+			var stdout, stderr bytes.Buffer
+			cmd := exec.CommandContext(ctx, exe, args...)
+			cmd.Env = append(os.Environ(), envVarNames("run-id")[0]+"="+runID)
+			cmd.Env = append(cmd.Env, profiles[name]...)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+
+			start := time.Now()
+			err := cmd.Run()
+			runs[i] = profileRun{Name: name, Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), Elapsed: time.Since(start)}
+
+			var exitErr *exec.ExitError
+			switch {
+			case errors.As(err, &exitErr):
+				runs[i].ExitCode = exitErr.ExitCode()
+			case err != nil:
+				runs[i].ExitCode = exitUsage
+				fmt.Fprintf(&stderr, "failed to run profile: %v\n", err)
+				runs[i].Stderr = stderr.Bytes()
+			}
+			// a failing profile does not cancel the others
+			return nil
+		})
+	}
+	g.Wait() //nolint:errcheck
+	return runs
+}
+
+// printProfileRuns writes the output of each profile in its own section, in
+// the order the profiles were given, followed by a table of their outcomes.
+// The results written to stdout by each profile go to stdout unchanged, so
+// that ndjson output stays parseable.
+func printProfileRuns(stdout, messages, stderr io.Writer, runs []profileRun) {
+	for _, run := range runs {
+		fmt.Fprintf(messages, "=== Profile %s ===\n", run.Name)
+		stderr.Write(run.Stderr) //nolint:errcheck
+		stdout.Write(run.Stdout) //nolint:errcheck
+	}
+
+	fmt.Fprintln(messages)
+	fmt.Fprintln(messages, "Profile summary")
+	tw := tabwriter.NewWriter(messages, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  PROFILE\tEXIT CODE\tELAPSED")
+	for _, run := range runs {
+		fmt.Fprintf(tw, "  %s\t%d\t%s\n", run.Name, run.ExitCode, run.Elapsed.Round(time.Millisecond))
+	}
+	tw.Flush() //nolint:errcheck
+}
+
+// profilesExitCode is the exit code of the first profile that failed, in the
+// order the profiles were given, or 0 if all succeeded
+func profilesExitCode(runs []profileRun) int {
+	for _, run := range runs {
+		if run.ExitCode != 0 {
+			return run.ExitCode
+		}
+	}
+	return 0
+}
+
+// uploadProfiles runs the upload for every profile given with --profile and
+// exits with their aggregate exit code
+func uploadProfiles(cmd *cobra.Command, names []string) {
+	if viper.GetBool("interactive") || viper.GetString("files-from") == "-" {
+		log.Fatal().Msg("profile can't be used with interactive or files-from from stdin")
+	}
+
+	profiles, err := loadProfiles(cmd.Flags(), names)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid profiles")
+	}
+	runID := resolveRunID()
+	_, messages, err := newOutput(viper.GetString("output"), runID)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid output format")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to find the running binary")
+	}
+
+	// an empty --profile keeps the uploads of the profiles from fanning out
+	// again because of a profile set in the environment or config file
+	args := append(withoutFlag(os.Args[1:], "profile"), "--profile=")
+	runs := runProfiles(context.Background(), exe, args, runID, profiles, names)
+	printProfileRuns(os.Stdout, messages, os.Stderr, runs)
+	fmt.Fprintf(messages, "Run ID: %s\n", runID)
+
+	if code := profilesExitCode(runs); code != 0 {
+		exitRun(code)
+	}
+}
@@ -0,0 +1,125 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func Test_loadProfiles(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("profiles", map[string]any{
+		"us-prod": map[string]any{"tenant-endpoint": "https://us.example.com", "client-id": "us", "tag": []any{"a", "b"}},
+		"eu-prod": map[string]any{"tenant-endpoint": "https://eu.example.com", "max-release-age": 3},
+		"bad":     map[string]any{"nope": "x"},
+		"flat":    "us-prod",
+	})
+
+	flags := pflag.NewFlagSet("upload", pflag.ContinueOnError)
+	for _, name := range []string{"tenant-endpoint", "client-id", "tag", "max-release-age", "profile"} {
+		flags.String(name, "", "")
+	}
+
+	got, err := loadProfiles(flags, []string{"us-prod", "eu-prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"us-prod": {"UPLOADER_CLIENT_ID=us", "UPLOADER_TAG=a,b", "UPLOADER_TENANT_ENDPOINT=https://us.example.com"},
+		"eu-prod": {"UPLOADER_MAX_RELEASE_AGE=3", "UPLOADER_TENANT_ENDPOINT=https://eu.example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadProfiles() = %v, want %v", got, want)
+	}
+
+	for _, names := range [][]string{{"missing"}, {"bad"}, {"flat"}, {"us-prod", "us-prod"}} {
+		if _, err := loadProfiles(flags, names); err == nil {
+			t.Errorf("loadProfiles(%v) error = nil, want an error", names)
+		}
+	}
+
+	// a flag on the command line would override the tenant of every profile
+	if err := flags.Set("tenant-endpoint", "https://us.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadProfiles(flags, []string{"us-prod", "eu-prod"}); err == nil {
+		t.Errorf("loadProfiles() error = nil with a flag the profiles set")
+	}
+}
+
+func Test_withoutFlag(t *testing.T) {
+	args := []string{"upload", "--profile", "a,b", "-f", "sboms/", "--profile=c", "--profiles-x", "--", "--profile"}
+	want := []string{"upload", "-f", "sboms/", "--profiles-x", "--", "--profile"}
+	if got := withoutFlag(args, "profile"); !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutFlag() = %v, want %v", got, want)
+	}
+}
+
+func Test_runProfiles(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run profiles with")
+	}
+	profiles := map[string][]string{
+		"us": {"UPLOADER_CLIENT_ID=us"},
+		"eu": {"UPLOADER_CLIENT_ID=eu"},
+	}
+	script := `echo "$UPLOADER_CLIENT_ID $UPLOADER_RUN_ID"; echo log >&2; [ "$UPLOADER_CLIENT_ID" = us ] || exit 4`
+	runs := runProfiles(context.Background(), sh, []string{"-c", script}, "run-1", profiles, []string{"us", "eu"})
+
+	if len(runs) != 2 || runs[0].Name != "us" || runs[1].Name != "eu" {
+		t.Fatalf("runProfiles() = %+v, want the runs in the order of the names", runs)
+	}
+	if string(runs[0].Stdout) != "us run-1\n" || string(runs[1].Stdout) != "eu run-1\n" {
+		t.Errorf("runProfiles() stdout = %q, %q", runs[0].Stdout, runs[1].Stdout)
+	}
+	if runs[0].ExitCode != 0 || runs[1].ExitCode != exitBlocked {
+		t.Errorf("runProfiles() exit codes = %d, %d, want 0, %d", runs[0].ExitCode, runs[1].ExitCode, exitBlocked)
+	}
+	if got := profilesExitCode(runs); got != exitBlocked {
+		t.Errorf("profilesExitCode() = %d, want %d", got, exitBlocked)
+	}
+
+	var stdout, messages, stderr bytes.Buffer
+	printProfileRuns(&stdout, &messages, &stderr, runs)
+	if stdout.String() != "us run-1\neu run-1\n" {
+		t.Errorf("printProfileRuns() stdout = %q", stdout.String())
+	}
+	for _, want := range []string{"=== Profile us ===", "=== Profile eu ===", "PROFILE  EXIT CODE", "  eu       4"} {
+		if !strings.Contains(messages.String(), want) {
+			t.Errorf("printProfileRuns() messages do not contain %q:\n%s", want, messages.String())
+		}
+	}
+}
+
+func Test_profilesExitCode(t *testing.T) {
+	runs := []profileRun{{Name: "a"}, {Name: "b", ExitCode: exitAuth}, {Name: "c", ExitCode: exitUpload}}
+	if got := profilesExitCode(runs); got != exitAuth {
+		t.Errorf("profilesExitCode() = %d, want the code of the first failing profile %d", got, exitAuth)
+	}
+	if got := profilesExitCode(runs[:1]); got != 0 {
+		t.Errorf("profilesExitCode() = %d, want 0", got)
+	}
+}
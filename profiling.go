@@ -0,0 +1,120 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// cpuProfile is the file the CPU profile of the run is written to, if one was requested
+var cpuProfile *os.File
+
+// memProfile is the file the heap profile of the run is written to, if one was requested
+var memProfile string
+
+// startProfiling starts the pprof server and the CPU profile requested with
+// --pprof-addr and --cpuprofile
+func startProfiling(cmd *cobra.Command, args []string) {
+	if addr := viper.GetString("pprof-addr"); addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Str("pprofAddr", addr).
+				Msg("Failed to start the pprof server")
+		}
+		log.Info().
+			Str("pprofAddr", listener.Addr().String()).
+			Msg("Serving pprof at /debug/pprof/")
+		go func() {
+			if err := http.Serve(listener, pprofHandler()); err != nil {
+				log.Warn().Err(err).Msg("pprof server stopped")
+			}
+		}()
+	}
+
+	if path := viper.GetString("cpuprofile"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Failed to create CPU profile")
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Failed to start CPU profile")
+		}
+		cpuProfile = f
+	}
+
+	memProfile = viper.GetString("memprofile")
+}
+
+// stopProfiling writes the CPU and heap profiles requested with --cpuprofile
+// and --memprofile, once
+func stopProfiling(cmd *cobra.Command, args []string) {
+	if cpuProfile != nil {
+		rpprof.StopCPUProfile()
+		if err := cpuProfile.Close(); err != nil {
+			log.Warn().Err(err).Msg("Failed to write CPU profile")
+		}
+		cpuProfile = nil
+	}
+
+	if memProfile != "" {
+		if err := writeHeapProfile(memProfile); err != nil {
+			log.Warn().Err(err).Msg("Failed to write memory profile")
+		}
+		memProfile = ""
+	}
+}
+
+// writeHeapProfile writes a heap profile, after a GC so it reflects live memory
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := rpprof.WriteHeapProfile(f); err != nil {
+		f.Close() //nolint:errcheck
+		return fmt.Errorf("error writing heap profile: %w", err)
+	}
+	return f.Close()
+}
+
+// pprofHandler serves the net/http/pprof endpoints without registering them on
+// http.DefaultServeMux
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
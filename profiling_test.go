@@ -0,0 +1,69 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_writeHeapProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mem.out")
+	if err := writeHeapProfile(path); err != nil {
+		t.Fatalf("writeHeapProfile() error = %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Errorf("writeHeapProfile() wrote no profile: %v", err)
+	}
+}
+
+func Test_pprofHandler(t *testing.T) {
+	server := httptest.NewServer(pprofHandler())
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/debug/pprof/heap?debug=1")
+	if err != nil {
+		t.Fatalf("GET heap profile error = %v", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("GET heap profile status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+}
+
+func Test_exitRunWritesProfiles(t *testing.T) {
+	exited := -1
+	oldExit := exitFunc
+	t.Cleanup(func() { exitFunc = oldExit })
+	exitFunc = func(code int) { exited = code }
+
+	path := filepath.Join(t.TempDir(), "mem.out")
+	memProfile = path
+	exitRun(exitUpload)
+
+	if exited != exitUpload {
+		t.Errorf("exitRun() exited with %d, want %d", exited, exitUpload)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Errorf("exitRun() wrote no memory profile: %v", err)
+	}
+	if memProfile != "" {
+		t.Errorf("exitRun() left memProfile = %q, want it written once", memProfile)
+	}
+}
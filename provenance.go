@@ -0,0 +1,248 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// artifactDigest is the sha256 digest of an artifact described by a document
+type artifactDigest struct {
+	Name   string
+	SHA256 string
+	// Path is the file the digest was read from
+	Path string
+}
+
+type inTotoStatement struct {
+	Type          string `json:"_type"`
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+}
+
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+}
+
+// provenanceSubjects returns the subjects of an in-toto provenance attestation,
+// which may be wrapped in a DSSE envelope. Other documents return nil.
+func provenanceSubjects(blob []byte) []artifactDigest {
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(blob, &envelope); err == nil && envelope.PayloadType == "application/vnd.in-toto+json" {
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return nil
+		}
+		blob = payload
+	}
+
+	var statement inTotoStatement
+	if err := json.Unmarshal(blob, &statement); err != nil {
+		return nil
+	}
+	if !strings.HasPrefix(statement.Type, "https://in-toto.io/Statement/") ||
+		!strings.Contains(statement.PredicateType, "provenance") {
+		return nil
+	}
+
+	var subjects []artifactDigest
+	for _, s := range statement.Subject {
+		if digest := s.Digest["sha256"]; digest != "" {
+			subjects = append(subjects, artifactDigest{Name: s.Name, SHA256: strings.ToLower(digest)})
+		}
+	}
+	return subjects
+}
+
+// sbomArtifactDigest returns the sha256 digest of the artifact an SBOM
+// describes, or nil if the SBOM does not record one
+func sbomArtifactDigest(blob []byte) *artifactDigest {
+	var cdx struct {
+		BOMFormat string `json:"bomFormat"`
+		Metadata  struct {
+			Component struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+				Purl    string `json:"purl"`
+				Hashes  []struct {
+					Alg     string `json:"alg"`
+					Content string `json:"content"`
+				} `json:"hashes"`
+			} `json:"component"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(blob, &cdx); err == nil && cdx.BOMFormat == "CycloneDX" {
+		c := cdx.Metadata.Component
+		for _, h := range c.Hashes {
+			if h.Alg == "SHA-256" {
+				return &artifactDigest{Name: c.Name, SHA256: strings.ToLower(h.Content)}
+			}
+		}
+		// container images record their digest in the version or purl
+		for _, s := range []string{c.Version, c.Purl} {
+			if i := strings.Index(s, "sha256:"); i >= 0 {
+				digest := s[i+len("sha256:"):]
+				if j := strings.IndexAny(digest, "?#"); j >= 0 {
+					digest = digest[:j]
+				}
+				return &artifactDigest{Name: c.Name, SHA256: strings.ToLower(digest)}
+			}
+		}
+		return nil
+	}
+
+	var spdx struct {
+		SPDXID            string   `json:"SPDXID"`
+		DocumentDescribes []string `json:"documentDescribes"`
+		Packages          []struct {
+			SPDXID    string `json:"SPDXID"`
+			Name      string `json:"name"`
+			Checksums []struct {
+				Algorithm     string `json:"algorithm"`
+				ChecksumValue string `json:"checksumValue"`
+			} `json:"checksums"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(blob, &spdx); err == nil && spdx.SPDXID == "SPDXRef-DOCUMENT" {
+		for _, p := range spdx.Packages {
+			if !slices.Contains(spdx.DocumentDescribes, p.SPDXID) {
+				continue
+			}
+			for _, c := range p.Checksums {
+				if c.Algorithm == "SHA256" {
+					return &artifactDigest{Name: p.Name, SHA256: strings.ToLower(c.ChecksumValue)}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// artifactName strips the registry path, tag and digest from an artifact name
+// so that "ghcr.io/org/app:1.0" and "app" compare equal
+func artifactName(name string) string {
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+// crossCheckProvenance compares the subjects of provenance attestations with the
+// artifacts described by SBOMs uploaded in the same run. SBOMs and subjects are
+// paired by artifact name, SBOMs without a subject of their name are not
+// checked. Provenance of multi-arch images lists several subjects of the same
+// name, so an SBOM only mismatches if none of the subjects of its name has its
+// digest. It returns a description of every such SBOM.
+func crossCheckProvenance(sboms, subjects []artifactDigest) []string {
+	var mismatches []string
+	for _, sbom := range sboms {
+		var named []artifactDigest
+		matched := false
+		for _, subject := range subjects {
+			if artifactName(sbom.Name) != artifactName(subject.Name) {
+				continue
+			}
+			named = append(named, subject)
+			matched = matched || sbom.SHA256 == subject.SHA256
+		}
+		if len(named) > 0 && !matched {
+			mismatches = append(mismatches, describeMismatch(sbom, named))
+		}
+	}
+	return mismatches
+}
+
+func describeMismatch(sbom artifactDigest, subjects []artifactDigest) string {
+	var names []string
+	for _, subject := range subjects {
+		names = append(names, fmt.Sprintf("%s in provenance %s with sha256 %s", subject.Name, subject.Path, subject.SHA256))
+	}
+	return fmt.Sprintf("SBOM %s describes %s with sha256 %s, but no subject of that name has it: %s",
+		sbom.Path, sbom.Name, sbom.SHA256, strings.Join(names, ", "))
+}
+
+// verifyProvenanceSubjects checks that the provenance attestations in the file
+// list, or else under filePath, were generated for the artifacts described by
+// the SBOMs next to them
+func verifyProvenanceSubjects(filePath string, fileList []string) error {
+	var sboms, subjects []artifactDigest
+
+	addFile := func(path string, info os.FileInfo) error {
+		if info.IsDir() || info.Size() == 0 {
+			return nil
+		}
+
+		blob, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s, err: %w", path, err)
+		}
+
+		if digest := sbomArtifactDigest(blob); digest != nil {
+			digest.Path = path
+			sboms = append(sboms, *digest)
+		}
+		for _, subject := range provenanceSubjects(blob) {
+			subject.Path = path
+			subjects = append(subjects, subject)
+		}
+		return nil
+	}
+
+	if fileList != nil {
+		for _, path := range fileList {
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("failed to get stats on filepath: %s, with error: %w", path, err)
+			}
+			if err := addFile(path, info); err != nil {
+				return err
+			}
+		}
+	} else {
+		err := filepath.Walk(filePath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return addFile(path, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if mismatches := crossCheckProvenance(sboms, subjects); len(mismatches) > 0 {
+		return fmt.Errorf("provenance does not match the uploaded artifacts:\n  %s", strings.Join(mismatches, "\n  "))
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testProvenance = `{
+  "_type": "https://in-toto.io/Statement/v1",
+  "predicateType": "https://slsa.dev/provenance/v1",
+  "subject": [{"name": "ghcr.io/acme/app", "digest": {"sha256": "%s"}}]
+}`
+
+func Test_sbomArtifactDigest(t *testing.T) {
+	tests := []struct {
+		name string
+		blob string
+		want string
+	}{
+		{
+			name: "CycloneDX hash",
+			blob: `{"bomFormat": "CycloneDX", "metadata": {"component": {"name": "app", "hashes": [{"alg": "SHA-256", "content": "AAA"}]}}}`,
+			want: "aaa",
+		},
+		{
+			name: "CycloneDX container purl",
+			blob: `{"bomFormat": "CycloneDX", "metadata": {"component": {"name": "app", "purl": "pkg:oci/app@sha256:bbb?repository_url=ghcr.io/acme"}}}`,
+			want: "bbb",
+		},
+		{
+			name: "SPDX described package",
+			blob: `{"SPDXID": "SPDXRef-DOCUMENT", "documentDescribes": ["SPDXRef-app"], "packages": [
+				{"SPDXID": "SPDXRef-dep", "name": "dep", "checksums": [{"algorithm": "SHA256", "checksumValue": "ddd"}]},
+				{"SPDXID": "SPDXRef-app", "name": "app", "checksums": [{"algorithm": "SHA256", "checksumValue": "ccc"}]}]}`,
+			want: "ccc",
+		},
+		{
+			name: "no digest",
+			blob: `{"bomFormat": "CycloneDX", "metadata": {"component": {"name": "app"}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sbomArtifactDigest([]byte(tt.blob))
+			if (got == nil) != (tt.want == "") || (got != nil && got.SHA256 != tt.want) {
+				t.Errorf("sbomArtifactDigest() = %+v, want sha256 %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_verifyProvenanceSubjects(t *testing.T) {
+	sbom := `{"bomFormat": "CycloneDX", "metadata": {"component": {"name": "app", "version": "sha256:abc"}}}`
+	envelope := func(digest string) string {
+		payload := base64.StdEncoding.EncodeToString([]byte(fmtProvenance(digest)))
+		return `{"payloadType": "application/vnd.in-toto+json", "payload": "` + payload + `", "signatures": []}`
+	}
+
+	tests := []struct {
+		name       string
+		provenance string
+		wantErr    bool
+	}{
+		{name: "matching digest", provenance: fmtProvenance("abc")},
+		{name: "matching digest in DSSE envelope", provenance: envelope("abc")},
+		{name: "mismatching digest", provenance: fmtProvenance("def"), wantErr: true},
+		{name: "mismatching digest in DSSE envelope", provenance: envelope("def"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "sbom.json"), []byte(sbom), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "provenance.json"), []byte(tt.provenance), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := verifyProvenanceSubjects(dir, nil); (err != nil) != tt.wantErr {
+				t.Errorf("verifyProvenanceSubjects() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// the same files given as a files-from list or glob
+			fileList := []string{filepath.Join(dir, "sbom.json"), filepath.Join(dir, "provenance.json")}
+			if err := verifyProvenanceSubjects("", fileList); (err != nil) != tt.wantErr {
+				t.Errorf("verifyProvenanceSubjects() of file list error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_crossCheckProvenance(t *testing.T) {
+	tests := []struct {
+		name     string
+		sboms    []artifactDigest
+		subjects []artifactDigest
+		want     int
+	}{
+		{
+			name:     "paired by name",
+			sboms:    []artifactDigest{{Name: "app", SHA256: "1"}, {Name: "worker", SHA256: "2"}},
+			subjects: []artifactDigest{{Name: "ghcr.io/acme/app:1.0", SHA256: "1"}, {Name: "ghcr.io/acme/worker", SHA256: "3"}},
+			want:     1,
+		},
+		{
+			name:     "unrelated pair",
+			sboms:    []artifactDigest{{Name: "app", SHA256: "1"}},
+			subjects: []artifactDigest{{Name: "ghcr.io/acme/worker", SHA256: "2"}},
+		},
+		{
+			name:  "multi-arch subjects",
+			sboms: []artifactDigest{{Name: "app", SHA256: "arm64"}},
+			subjects: []artifactDigest{
+				{Name: "ghcr.io/acme/app", SHA256: "amd64"},
+				{Name: "ghcr.io/acme/app", SHA256: "arm64"},
+			},
+		},
+		{
+			name:  "no multi-arch subject matches",
+			sboms: []artifactDigest{{Name: "app", SHA256: "s390x"}},
+			subjects: []artifactDigest{
+				{Name: "ghcr.io/acme/app", SHA256: "amd64"},
+				{Name: "ghcr.io/acme/app", SHA256: "arm64"},
+			},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := crossCheckProvenance(tt.sboms, tt.subjects); len(got) != tt.want {
+				t.Errorf("crossCheckProvenance() = %v, want %d mismatch(es)", got, tt.want)
+			}
+		})
+	}
+}
+
+func fmtProvenance(digest string) string {
+	return fmt.Sprintf(testProvenance, digest)
+}
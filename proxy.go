@@ -0,0 +1,190 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	proxyAuthBasic = "basic"
+	proxyAuthNTLM  = "ntlm"
+)
+
+// proxyConfig selects the proxy of each request. All clients, including the
+// oauth2 client and the one uploading to presigned URLs, share it.
+type proxyConfig struct {
+	// proxy is the --proxy URL, nil to use HTTP_PROXY and HTTPS_PROXY
+	proxy *url.URL
+	// noProxy are the NO_PROXY entries used with --proxy
+	noProxy []string
+	auth    string
+	// user and password are the proxy credentials, when not in the proxy URL
+	user     string
+	password string
+}
+
+// resolveProxyConfig returns the proxy configuration of the proxy flags
+func resolveProxyConfig() (*proxyConfig, error) {
+	cfg := &proxyConfig{
+		auth:     strings.ToLower(viper.GetString("proxy-auth")),
+		user:     viper.GetString("proxy-user"),
+		password: viper.GetString("proxy-password"),
+	}
+	switch cfg.auth {
+	case "":
+		cfg.auth = proxyAuthBasic
+	case proxyAuthBasic, proxyAuthNTLM:
+	default:
+		return nil, fmt.Errorf("unsupported proxy-auth %q, must be basic or ntlm", cfg.auth)
+	}
+	if cfg.password != "" && cfg.user == "" {
+		return nil, errors.New("proxy-password requires proxy-user")
+	}
+
+	if value := viper.GetString("proxy"); value != "" {
+		// like HTTP_PROXY, a proxy without a scheme is an http:// proxy
+		if !strings.Contains(value, "://") {
+			value = "http://" + value
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", value)
+		}
+		cfg.proxy = u
+		cfg.noProxy = splitNoProxy(firstEnv("NO_PROXY", "no_proxy"))
+	}
+	return cfg, nil
+}
+
+// firstEnv returns the value of the first of the environment variables set
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// splitNoProxy splits a comma separated NO_PROXY value
+func splitNoProxy(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// bypassProxy reports whether host, with an optional port, matches one of the
+// NO_PROXY entries: *, a host name that also matches its subdomains, an IP
+// address or a CIDR range, each optionally with a port
+func bypassProxy(host string, noProxy []string) bool {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = host
+	}
+	hostname = strings.ToLower(hostname)
+	ip := net.ParseIP(hostname)
+
+	for _, entry := range noProxy {
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		entryHost = strings.TrimPrefix(entryHost, "*")
+		if hostname == strings.TrimPrefix(entryHost, ".") || strings.HasSuffix(hostname, "."+strings.TrimPrefix(entryHost, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyFor returns the proxy of req, or nil to connect directly. Proxy
+// credentials set with proxy-user are added to proxies without any.
+func (c *proxyConfig) proxyFor(req *http.Request) (*url.URL, error) {
+	var proxy *url.URL
+	if c.proxy == nil {
+		p, err := http.ProxyFromEnvironment(req)
+		if err != nil || p == nil {
+			return p, err
+		}
+		proxy = p
+	} else {
+		if bypassProxy(req.URL.Host, c.noProxy) {
+			return nil, nil
+		}
+		p := *c.proxy
+		proxy = &p
+	}
+
+	if proxy.User == nil && c.user != "" {
+		proxy.User = url.UserPassword(c.user, c.password)
+	}
+	return proxy, nil
+}
+
+// apply makes transport use the proxy. Basic authentication is sent by the
+// transport itself; NTLM needs several requests on the same connection, so
+// the transport dials through the proxy with CONNECT instead.
+func (c *proxyConfig) apply(transport *http.Transport) {
+	if c.auth != proxyAuthNTLM {
+		transport.Proxy = c.proxyFor
+		return
+	}
+
+	dialer := &net.Dialer{}
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// the dialer only gets the address, the scheme only matters for the
+		// choice between HTTP_PROXY and HTTPS_PROXY
+		scheme := "https"
+		if _, port, err := net.SplitHostPort(addr); err == nil && port == "80" {
+			scheme = "http"
+		}
+		proxy, err := c.proxyFor(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+		if err != nil {
+			return nil, err
+		}
+		if proxy == nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return dialNTLMProxy(ctx, dialer, proxy, addr)
+	}
+}
@@ -0,0 +1,117 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_bypassProxy(t *testing.T) {
+	noProxy := splitNoProxy("internal.example.com, .corp.example, 10.0.0.0/8,localhost:8080")
+	tests := map[string]bool{
+		"internal.example.com":     true,
+		"api.internal.example.com": true,
+		"example.com":              false,
+		"vault.corp.example:8200":  true,
+		"corp.example":             true,
+		"10.1.2.3:443":             true,
+		"11.1.2.3:443":             false,
+		"localhost:8080":           true,
+		"localhost:9090":           false,
+		"s3.amazonaws.com:443":     false,
+	}
+	for host, want := range tests {
+		if got := bypassProxy(host, noProxy); got != want {
+			t.Errorf("bypassProxy(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if !bypassProxy("anything:443", []string{"*"}) {
+		t.Error("bypassProxy() with * = false")
+	}
+}
+
+func Test_resolveProxyConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Setenv("NO_PROXY", "tenant.internal")
+
+	viper.Set("proxy", "proxy.corp:3128")
+	viper.Set("proxy-user", "alice")
+	viper.Set("proxy-password", "hunter2")
+	cfg, err := resolveProxyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	presigned := &http.Request{URL: &url.URL{Scheme: "https", Host: "bucket.s3.amazonaws.com"}}
+	proxy, err := cfg.proxyFor(presigned)
+	if err != nil || proxy == nil || proxy.Host != "proxy.corp:3128" || proxy.User.String() != "alice:hunter2" {
+		t.Errorf("proxyFor() = %v, %v, want the proxy with credentials", proxy, err)
+	}
+	if proxy, err := cfg.proxyFor(&http.Request{URL: &url.URL{Scheme: "https", Host: "tenant.internal"}}); proxy != nil || err != nil {
+		t.Errorf("proxyFor() of a NO_PROXY host = %v, %v, want no proxy", proxy, err)
+	}
+
+	viper.Set("proxy-auth", "kerberos")
+	if _, err := resolveProxyConfig(); err == nil {
+		t.Error("resolveProxyConfig() with an unknown proxy-auth returned no error")
+	}
+	viper.Set("proxy-auth", "")
+	viper.Set("proxy-user", "")
+	if _, err := resolveProxyConfig(); err == nil {
+		t.Error("resolveProxyConfig() with a password and no user returned no error")
+	}
+}
+
+func Test_newHTTPClient_proxy(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	var gotURL, gotAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL, gotAuth = r.URL.String(), r.Header.Get("Proxy-Authorization")
+	}))
+	defer proxy.Close()
+
+	viper.Set("proxy", proxy.URL)
+	viper.Set("proxy-user", "alice")
+	viper.Set("proxy-password", "hunter2")
+	client, err := newHTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, "http://bucket.example.com/upload?X-Amz-Signature=abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close() //nolint:errcheck
+
+	if gotURL != "http://bucket.example.com/upload?X-Amz-Signature=abc" {
+		t.Errorf("proxy received %q, want the presigned URL", gotURL)
+	}
+	if gotAuth != "Basic YWxpY2U6aHVudGVyMg==" {
+		t.Errorf("proxy received Proxy-Authorization %q, want basic credentials", gotAuth)
+	}
+}
@@ -0,0 +1,239 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// quarantineListName is the file of the quarantine directory the quarantined
+// files are listed in, one JSON object per line
+const quarantineListName = "quarantine.ndjson"
+
+// quarantinePolicy bounds the time spent on each file of a bulk upload and
+// sets aside files that keep failing, so one poison file doesn't stop the
+// thousands of good ones after it
+type quarantinePolicy struct {
+	// timeout bounds each attempt to upload a file, 0 for no limit
+	timeout time.Duration
+	// attempts is the number of attempts after which a file is quarantined,
+	// 0 to never quarantine
+	attempts int
+	// dir is the directory quarantined files are moved to, if set
+	dir string
+
+	mu sync.Mutex
+}
+
+// quarantineEntry is a line of the quarantine list
+type quarantineEntry struct {
+	Path          string    `json:"path"`
+	QuarantinedTo string    `json:"quarantined_to,omitempty"`
+	Reason        string    `json:"reason"`
+	Attempts      int       `json:"attempts"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// quarantinedError is returned for a file that was quarantined
+type quarantinedError struct {
+	attempts int
+	err      error
+}
+
+func (e *quarantinedError) Error() string {
+	return fmt.Sprintf("quarantined after %d attempt(s): %v", e.attempts, e.err)
+}
+
+func (e *quarantinedError) Unwrap() error { return e.err }
+
+// statusCodeError is an unexpected HTTP status of the tenant or storage
+type statusCodeError struct {
+	code int
+}
+
+func (e *statusCodeError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.code)
+}
+
+// addQuarantineFlags defines the flags of the quarantine policy
+func addQuarantineFlags(flags *pflag.FlagSet) {
+	flags.Duration("file-timeout", 0, "Time limit of each attempt to upload a file, e.g. 2m (optional, 0 for no limit)")
+	flags.Int("quarantine-after", 0, "Set aside files that still time out or are rejected with a 4xx status after this many attempts, and continue with the other files (optional, 0 to stop at the first failure)")
+	flags.String("quarantine-dir", "", "Move quarantined files to this directory and list them with their failure reason in its "+quarantineListName+" (optional, requires quarantine-after)")
+}
+
+// resolveQuarantinePolicy returns the quarantine policy of the flags
+func resolveQuarantinePolicy() (*quarantinePolicy, error) {
+	q := &quarantinePolicy{
+		timeout:  viper.GetDuration("file-timeout"),
+		attempts: viper.GetInt("quarantine-after"),
+		dir:      viper.GetString("quarantine-dir"),
+	}
+	if q.timeout < 0 {
+		return nil, fmt.Errorf("invalid file-timeout %s, must not be negative", q.timeout)
+	}
+	if q.attempts < 0 {
+		return nil, fmt.Errorf("invalid quarantine-after %d, must not be negative", q.attempts)
+	}
+	if q.dir != "" && q.attempts == 0 {
+		return nil, errors.New("quarantine-dir requires quarantine-after")
+	}
+	return q, nil
+}
+
+// quarantinable reports whether err is a failure of the file rather than of
+// the run: a timeout, or a 4xx status other than for the credentials
+func quarantinable(err error) bool {
+	if exitCodeOf(err, exitUpload) != exitUpload {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var statusErr *statusCodeError
+	return errors.As(err, &statusErr) && statusErr.code >= 400 && statusErr.code < 500 && statusErr.code != http.StatusUnauthorized
+}
+
+// run uploads the file at path with upload, passing it the clients to use.
+// Each attempt is bounded by the file timeout. Files failing in a way
+// quarantinable accepts are tried again, and quarantined with a
+// *quarantinedError once they failed the configured number of times.
+func (q *quarantinePolicy) run(ctx context.Context, path string, blob []byte, authorizedClient, defaultClient HttpClient,
+	upload func(authorizedClient, defaultClient HttpClient) error) error {
+	for attempt := 1; ; attempt++ {
+		err := q.attempt(ctx, authorizedClient, defaultClient, upload)
+		if err == nil || q.attempts == 0 || ctx.Err() != nil || !quarantinable(err) {
+			return err
+		}
+		if attempt >= q.attempts {
+			return q.quarantine(path, blob, attempt, err)
+		}
+		runRetries.record(path, retryPhaseFile, 0)
+		log.Warn().
+			Err(err).
+			Str("filePath", path).
+			Int("attempt", attempt).
+			Msg("File upload failed, trying again before quarantining it")
+	}
+}
+
+// attempt runs upload once, within the file timeout
+func (q *quarantinePolicy) attempt(ctx context.Context, authorizedClient, defaultClient HttpClient,
+	upload func(authorizedClient, defaultClient HttpClient) error) error {
+	if q.timeout <= 0 {
+		return upload(authorizedClient, defaultClient)
+	}
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	return upload(&contextClient{ctx: ctx, base: authorizedClient}, &contextClient{ctx: ctx, base: defaultClient})
+}
+
+// quarantine sets the file at path aside, moving it to the quarantine
+// directory if there is one, and returns its *quarantinedError
+func (q *quarantinePolicy) quarantine(path string, blob []byte, attempts int, cause error) error {
+	qErr := &quarantinedError{attempts: attempts, err: cause}
+	log.Warn().
+		Err(cause).
+		Str("filePath", path).
+		Int("attempts", attempts).
+		Msg("Quarantining file")
+	if q.dir == "" {
+		return qErr
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry := quarantineEntry{Path: path, Reason: cause.Error(), Attempts: attempts, QuarantinedAt: time.Now().UTC()}
+	// files listed from object stores stay where they are
+	if _, err := os.Lstat(path); err == nil {
+		dest, err := moveToQuarantine(q.dir, path, blob)
+		if err != nil {
+			return fmt.Errorf("failed to quarantine %s: %w", path, err)
+		}
+		entry.QuarantinedTo = dest
+	}
+
+	if err := os.MkdirAll(q.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(q.dir, quarantineListName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open the quarantine list: %w", err)
+	}
+	defer f.Close() //nolint:errcheck
+	if err := json.NewEncoder(f).Encode(entry); err != nil {
+		return fmt.Errorf("failed to write the quarantine list: %w", err)
+	}
+	return qErr
+}
+
+// moveToQuarantine moves the file at path under dir, keeping its path so
+// files of the same name don't collide, and returns where it was moved to.
+// Files on another device are written from blob and then removed.
+func moveToQuarantine(dir, path string, blob []byte) (string, error) {
+	rel := strings.TrimPrefix(path, filepath.VolumeName(path))
+	dest := filepath.Join(dir, filepath.Clean(string(filepath.Separator)+rel))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path, dest); err == nil {
+		return dest, nil
+	}
+	if err := os.WriteFile(dest, blob, 0o644); err != nil {
+		return "", err
+	}
+	return dest, os.Remove(path)
+}
+
+// contextClient makes the requests of a client with a context, so they are
+// cancelled when the file timeout expires
+type contextClient struct {
+	ctx  context.Context
+	base HttpClient
+}
+
+func (c *contextClient) Do(req *http.Request) (*http.Response, error) {
+	return c.base.Do(req.WithContext(c.ctx))
+}
+
+func (c *contextClient) Post(url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.base.Do(req)
+}
@@ -0,0 +1,159 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kusaridev/kusari-uploader/pkg/source"
+	"github.com/spf13/viper"
+)
+
+func Test_quarantinable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "timeout", err: fmt.Errorf("failed to http.Client Do with error: %w", context.DeadlineExceeded), want: true},
+		{name: "fault timeout", err: faultTimeoutError{}, want: true},
+		{name: "bad request", err: &statusCodeError{code: http.StatusBadRequest}, want: true},
+		{name: "payload too large", err: &statusCodeError{code: http.StatusRequestEntityTooLarge}, want: true},
+		{name: "server error", err: &statusCodeError{code: http.StatusInternalServerError}},
+		{name: "unauthorized", err: &statusCodeError{code: http.StatusUnauthorized}},
+		{name: "forbidden presign", err: tenantStatusError(http.StatusForbidden, &statusCodeError{code: http.StatusForbidden})},
+		{name: "validation", err: withExitCode(exitValidation, errors.New("typosquat"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quarantinable(tt.err); got != tt.want {
+				t.Errorf("quarantinable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_uploadSource_quarantine(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	quarantineDir := filepath.Join(t.TempDir(), "quarantine")
+	viper.Set("quarantine-after", 2)
+	viper.Set("quarantine-dir", quarantineDir)
+
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a.json", "poison.json", "b.json"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(`{"name": "`+name+`"}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+
+	authClient := &ClientMock{
+		PostFunc: func(url, contentType string, body io.Reader) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(`{"presignedUrl": "http://example.com/upload"}`))}, nil
+		},
+	}
+	attempts := map[string]int{}
+	uploadClient := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(req.Body)
+			status := http.StatusOK
+			for _, name := range []string{"a.json", "poison.json", "b.json"} {
+				if strings.Contains(string(body), name) {
+					attempts[name]++
+					if name == "poison.json" {
+						status = http.StatusBadRequest
+					}
+				}
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+		},
+	}
+
+	var out bytes.Buffer
+	ssaus, err := uploadSource(authClient, uploadClient, "http://example.com", source.NewList(files), map[string]string{"run_id": "run"}, nil, newResultStream(&out, "run"))
+	if err != nil {
+		t.Fatalf("uploadSource() error = %v, want the poison file quarantined", err)
+	}
+	if len(ssaus) != 3 || attempts["a.json"] != 1 || attempts["poison.json"] != 2 || attempts["b.json"] != 1 {
+		t.Errorf("uploadSource() returned %d SBOMs with attempts %v, want every file and 2 attempts of poison.json", len(ssaus), attempts)
+	}
+	if !strings.Contains(out.String(), `"status":"quarantined"`) {
+		t.Errorf("results %q do not contain the quarantined file", out.String())
+	}
+
+	if _, err := os.Stat(files[1]); !os.IsNotExist(err) {
+		t.Errorf("poison.json is still in the source directory, err = %v", err)
+	}
+	list, err := os.Open(filepath.Join(quarantineDir, quarantineListName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close() //nolint:errcheck
+	var entries []quarantineEntry
+	for scanner := bufio.NewScanner(list); scanner.Scan(); {
+		var entry quarantineEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 1 || entries[0].Path != files[1] || entries[0].Attempts != 2 || entries[0].Reason != "unexpected status code: 400" {
+		t.Fatalf("quarantine list = %+v, want poison.json with its reason", entries)
+	}
+	if data, err := os.ReadFile(entries[0].QuarantinedTo); err != nil || !strings.Contains(string(data), "poison.json") {
+		t.Errorf("quarantined file %s = %q, %v, want the moved file", entries[0].QuarantinedTo, data, err)
+	}
+}
+
+func Test_quarantinePolicy_timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	q := &quarantinePolicy{timeout: 20 * time.Millisecond, attempts: 1}
+	err := q.run(context.Background(), "slow.json", nil, srv.Client(), srv.Client(), func(_, defaultClient HttpClient) error {
+		req, err := http.NewRequest(http.MethodPut, srv.URL, nil)
+		if err != nil {
+			return err
+		}
+		res, err := defaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	})
+	var quarantined *quarantinedError
+	if !errors.As(err, &quarantined) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("run() error = %v, want the file quarantined after timing out", err)
+	}
+}
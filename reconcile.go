@@ -0,0 +1,203 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/kusaridev/kusari-uploader/pkg/source"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// reconcileResult lists the differences between local files and the tenant inventory
+type reconcileResult struct {
+	// Missing maps document refs that the tenant does not know about to the local files with that content
+	Missing map[string][]string
+	// Extra lists document refs known to the tenant that have no local file
+	Extra []string
+	// Matched is the number of local document refs known to the tenant
+	Matched int
+}
+
+func newReconcileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "reconcile <dir>",
+		Short:  "Compare local documents against the documents ingested by the tenant",
+		Args:   cobra.ExactArgs(1),
+		PreRun: bindUploadFlags,
+		Run:    reconcile,
+	}
+
+	// the document refs depend on the template and the metadata it uses
+	addMetadataFlags(cmd.Flags())
+	addDocumentFlags(cmd.Flags())
+	addConcurrencyFlags(cmd.Flags(), "hash-concurrency")
+
+	return cmd
+}
+
+func reconcile(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	dirPath := args[0]
+
+	ctx, defaultClient := mustNewHTTPClient(ctx)
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, defaultClient)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+
+	if !hasClientCredentials() || tenantEndPoint == "" || tokenEndPoint == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id, client-secret, tenant-endpoint (or org), token-endpoint")
+	}
+
+	mustValidateDocRefTemplate()
+	mustValidateCustomMetadata(cmd.Flags())
+	rules, err := loadRoutingRules()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid routing rules")
+	}
+	limits, err := resolvePhaseLimits()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid concurrency")
+	}
+	uploadMeta := metadataValues(resolveUploadMetadata(cmd.Flags(), nil, ""))
+	meta := func(relPath string) map[string]string {
+		return applyRoutingRules(rules, relPath, uploadMeta)
+	}
+
+	local, err := hashLocalDocuments(ctx, dirPath, meta, limits.Hash)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error hashing local documents")
+	}
+
+	creds, err := clientCredentials()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid client credentials")
+	}
+	authorizedClient := getAuthorizedClient(ctx, tokenEndPoint, creds)
+	remote, err := listDocumentRefs(ctx, authorizedClient, tenantEndPoint)
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Error listing tenant documents")
+	}
+
+	result := reconcileRefs(local, remote)
+	printReconcileResult(result)
+
+	if len(result.Missing) > 0 {
+		exitRun(exitUpload)
+	}
+}
+
+// hashLocalDocuments maps the document ref of every non-empty file under
+// dirPath to the paths that have that content. The refs are computed like those
+// of uploads, with --docref-template and the metadata returned by meta, on up
+// to workers goroutines.
+func hashLocalDocuments(ctx context.Context, dirPath string, meta func(relPath string) map[string]string, workers int) (map[string][]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	refs := map[string][]string{}
+	for file := range hashAhead(ctx, source.NewDirectory(dirPath, nil), meta, workers) {
+		if file.err != nil && file.path == "" {
+			return refs, file.err
+		}
+		if file.err != nil {
+			return refs, fmt.Errorf("error reading file: %s, err: %w", file.path, file.err)
+		}
+		if len(file.blob) == 0 {
+			continue
+		}
+		refs[file.docRef] = append(refs[file.docRef], file.path)
+	}
+	return refs, nil
+}
+
+// listDocumentRefs returns the document refs that the tenant reports as ingested
+func listDocumentRefs(ctx context.Context, client HttpClient, tenantEndpoint string) ([]string, error) {
+	var refs []string
+	err := forEachDocumentRef(ctx, client, tenantEndpoint, 0, func(ref string) error {
+		refs = append(refs, ref)
+		return nil
+	})
+	return refs, err
+}
+
+func reconcileRefs(local map[string][]string, remote []string) reconcileResult {
+	result := reconcileResult{Missing: map[string][]string{}}
+
+	known := map[string]bool{}
+	for _, ref := range remote {
+		known[ref] = true
+		if _, ok := local[ref]; !ok {
+			result.Extra = append(result.Extra, ref)
+		}
+	}
+	sort.Strings(result.Extra)
+
+	for ref, paths := range local {
+		if known[ref] {
+			result.Matched++
+		} else {
+			result.Missing[ref] = paths
+		}
+	}
+
+	return result
+}
+
+func printReconcileResult(result reconcileResult) {
+	fmt.Printf("Matched: %d, missing from tenant: %d, only in tenant: %d\n",
+		result.Matched, len(result.Missing), len(result.Extra))
+
+	if len(result.Missing) > 0 {
+		fmt.Println()
+		fmt.Println("Missing from tenant:")
+		refs := make([]string, 0, len(result.Missing))
+		for ref := range result.Missing {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		for _, ref := range refs {
+			for _, path := range result.Missing[ref] {
+				fmt.Printf("  %s %s\n", ref, path)
+			}
+		}
+	}
+
+	if len(result.Extra) > 0 {
+		fmt.Println()
+		fmt.Println("Only in tenant:")
+		for _, ref := range result.Extra {
+			fmt.Printf("  %s\n", ref)
+		}
+	}
+}
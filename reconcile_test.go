@@ -0,0 +1,104 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_hashLocalDocumentsTemplate(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("docref-template", "{meta.tag}-{sha256}")
+
+	meta := func(string) map[string]string { return map[string]string{"tag": "release"} }
+	local, err := hashLocalDocuments(context.Background(), "./testdata", meta, 2)
+	if err != nil {
+		t.Fatalf("hashLocalDocuments() error = %v", err)
+	}
+	want := "release-" + getHash([]byte("hello\n"))
+	if _, ok := local[want]; !ok || len(local) != 1 {
+		t.Errorf("hashLocalDocuments() = %v, want only %s", local, want)
+	}
+}
+
+func Test_reconcileRefs(t *testing.T) {
+	local, err := hashLocalDocuments(context.Background(), "./testdata", func(string) map[string]string { return nil }, 2)
+	if err != nil {
+		t.Fatalf("hashLocalDocuments() error = %v", err)
+	}
+	helloRef := getDocRef([]byte("hello\n"))
+	if _, ok := local[helloRef]; !ok || len(local) != 1 {
+		t.Fatalf("hashLocalDocuments() = %v, want only the hello file", local)
+	}
+
+	tests := []struct {
+		name        string
+		remote      []string
+		wantMatched int
+		wantMissing int
+		wantExtra   []string
+	}{
+		{
+			name:        "all ingested",
+			remote:      []string{helloRef},
+			wantMatched: 1,
+		},
+		{
+			name:        "missing and extra",
+			remote:      []string{"sha256_other"},
+			wantMissing: 1,
+			wantExtra:   []string{"sha256_other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := reconcileRefs(local, tt.remote)
+			if got.Matched != tt.wantMatched || len(got.Missing) != tt.wantMissing || !reflect.DeepEqual(got.Extra, tt.wantExtra) {
+				t.Errorf("reconcileRefs() = %+v, want matched %d, missing %d, extra %v", got, tt.wantMatched, tt.wantMissing, tt.wantExtra)
+			}
+		})
+	}
+}
+
+func Test_listDocumentRefs(t *testing.T) {
+	client := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/pico/v1/documents/refs" {
+				t.Errorf("unexpected request URL %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"document_refs": ["sha256_a", "sha256_b"]}`)),
+			}, nil
+		},
+	}
+
+	got, err := listDocumentRefs(context.Background(), client, "http://example.com")
+	if err != nil {
+		t.Fatalf("listDocumentRefs() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"sha256_a", "sha256_b"}) {
+		t.Errorf("listDocumentRefs() = %v", got)
+	}
+}
@@ -0,0 +1,453 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	reportIngested    = "ingested"
+	reportMissing     = "missing"
+	reportPassed      = "passed"
+	reportBlocked     = "blocked"
+	reportNotIngested = "not ingested"
+)
+
+// reportVerification is the current state of the documents of a run report
+type reportVerification struct {
+	RunID      string             `json:"run_id"`
+	VerifiedAt time.Time          `json:"verified_at"`
+	Documents  []verifiedDocument `json:"documents"`
+	SBOMs      []verifiedSBOM     `json:"sboms,omitempty"`
+}
+
+// verifiedDocument is an uploaded document of a run report and whether the
+// tenant still reports it as ingested
+type verifiedDocument struct {
+	Path        string `json:"path"`
+	DocumentRef string `json:"document_ref"`
+	Status      string `json:"status"`
+}
+
+// verifiedSBOM is the blocked package check of an SBOM of a run report
+type verifiedSBOM struct {
+	reportSBOM
+	SoftwareID      int64    `json:"software_id,omitempty"`
+	SbomID          int64    `json:"sbom_id,omitempty"`
+	Status          string   `json:"status"`
+	BlockedPackages []string `json:"blocked_packages,omitempty"`
+}
+
+// counts returns the number of documents that are missing and SBOMs that are
+// blocked or not ingested
+func (v reportVerification) counts() (missing, blocked, notIngested int) {
+	for _, d := range v.Documents {
+		if d.Status == reportMissing {
+			missing++
+		}
+	}
+	for _, s := range v.SBOMs {
+		switch s.Status {
+		case reportBlocked:
+			blocked++
+		case reportNotIngested:
+			notIngested++
+		}
+	}
+	return missing, blocked, notIngested
+}
+
+func newReportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Work with the reports of upload runs",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "verify [report.json]",
+		Short: "Check the documents of a run report against the tenant again",
+		Long: "Check that the documents uploaded by a run are still ingested by the tenant, and run the blocked " +
+			"package check for its SBOMs again, without uploading anything. Without an argument the report of the " +
+			"last run is verified. Exits with status 3 if a document is missing and 4 if an SBOM is blocked.",
+		Args: cobra.MaximumNArgs(1),
+		Run:  verifyReport,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "diff <old.json> <new.json>",
+		Short: "Compare the documents and policy status of two reports",
+		Long: "Compare two run reports, or two outputs of report verify --output ndjson, and list the documents " +
+			"that are new, the documents that disappeared and the SBOMs whose blocked package check changed. " +
+			"Only outputs of report verify have a policy status to compare.",
+		Args: cobra.ExactArgs(2),
+		Run:  diffReports,
+	})
+	return cmd
+}
+
+func verifyReport(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	var report runReport
+	var err error
+	if len(args) == 1 {
+		report, err = readRunReport(args[0])
+	} else {
+		report, err = loadRunReport()
+	}
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to read the run report")
+	}
+
+	ctx, defaultClient := mustNewHTTPClient(ctx)
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, defaultClient)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+
+	if !hasClientCredentials() || tenantEndPoint == "" || tokenEndPoint == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id, client-secret, tenant-endpoint (or org), token-endpoint")
+	}
+
+	creds, err := clientCredentials()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid client credentials")
+	}
+	authorizedClient := getAuthorizedClient(ctx, tokenEndPoint, creds)
+
+	v, err := verifyRunReport(ctx, authorizedClient, tenantEndPoint, report, generatedAt(time.Now()))
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Failed to verify the run report")
+	}
+
+	if viper.GetString("output") == outputNDJSON {
+		if err := json.NewEncoder(cmd.OutOrStdout()).Encode(v); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Failed to write the verification")
+		}
+	} else {
+		printReportVerification(cmd.OutOrStdout(), v, colorEnabled(cmd.OutOrStdout()))
+	}
+
+	missing, blocked, _ := v.counts()
+	switch {
+	case blocked > 0:
+		exitRun(exitBlocked)
+	case missing > 0:
+		exitRun(exitUpload)
+	}
+}
+
+// readRunReport reads a run report saved to path, e.g. a copy of the report of
+// the last run in the cache directory
+func readRunReport(path string) (runReport, error) {
+	var report runReport
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return report, fmt.Errorf("error reading run report: %s, err: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, fmt.Errorf("error unmarshaling run report: %s, err: %w", path, err)
+	}
+	return report, nil
+}
+
+// readReportStatus reads a run report, or the output of report verify, as a
+// verification. The documents of a run report are uploaded and its SBOMs have
+// no policy status.
+func readReportStatus(path string) (reportVerification, error) {
+	var v reportVerification
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return v, fmt.Errorf("error reading report: %s, err: %w", path, err)
+	}
+	var kind struct {
+		VerifiedAt *time.Time `json:"verified_at"`
+	}
+	if err := json.Unmarshal(data, &kind); err != nil {
+		return v, fmt.Errorf("error unmarshaling report: %s, err: %w", path, err)
+	}
+	if kind.VerifiedAt != nil {
+		err := json.Unmarshal(data, &v)
+		return v, err
+	}
+
+	report, err := readRunReport(path)
+	if err != nil {
+		return v, err
+	}
+	v.RunID = report.RunID
+	for _, r := range report.Results {
+		if r.Status == resultUploaded && r.DocumentRef != "" {
+			v.Documents = append(v.Documents, verifiedDocument{Path: r.Path, DocumentRef: r.DocumentRef, Status: resultUploaded})
+		}
+	}
+	for _, sbom := range report.SBOMs {
+		v.SBOMs = append(v.SBOMs, verifiedSBOM{reportSBOM: sbom})
+	}
+	return v, nil
+}
+
+// verifyRunReport looks up the uploaded documents of a report in the tenant's
+// document refs, and runs the blocked package check for its SBOMs with their
+// current results
+func verifyRunReport(ctx context.Context, client HttpClient, tenantEndpoint string, report runReport, now time.Time) (reportVerification, error) {
+	v := reportVerification{RunID: report.RunID, VerifiedAt: now.UTC()}
+
+	known := map[string]bool{}
+	for _, r := range report.Results {
+		if r.Status == resultUploaded && r.DocumentRef != "" {
+			known[r.DocumentRef] = false
+		}
+	}
+	if len(known) > 0 {
+		err := forEachDocumentRef(ctx, client, tenantEndpoint, 0, func(ref string) error {
+			if _, ok := known[ref]; ok {
+				known[ref] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return v, fmt.Errorf("error listing tenant documents: %w", err)
+		}
+	}
+	for _, r := range report.Results {
+		if r.Status != resultUploaded || r.DocumentRef == "" {
+			continue
+		}
+		status := reportMissing
+		if known[r.DocumentRef] {
+			status = reportIngested
+		}
+		v.Documents = append(v.Documents, verifiedDocument{Path: r.Path, DocumentRef: r.DocumentRef, Status: status})
+	}
+
+	for _, sbom := range report.SBOMs {
+		checked := verifiedSBOM{reportSBOM: sbom, Status: reportNotIngested}
+		ids, err := lookupSoftwareAndSbomID(ctx, client, tenantEndpoint, sbom.Subject, sbom.URI)
+		if err != nil {
+			return v, err
+		}
+		if ids != nil {
+			bps, err := getBlockedPackages(ctx, client, tenantEndpoint, ids)
+			if err != nil {
+				return v, err
+			}
+			checked.SoftwareID, checked.SbomID = ids.SoftwareID, ids.SbomID
+			checked.Status = reportPassed
+			if bps.Blocked {
+				checked.Status = reportBlocked
+				checked.BlockedPackages = bps.BlockedPackages
+			}
+		}
+		v.SBOMs = append(v.SBOMs, checked)
+	}
+
+	return v, nil
+}
+
+// printReportVerification writes the totals of a verification and a table of
+// its documents and SBOMs
+func printReportVerification(w io.Writer, v reportVerification, color bool) {
+	missing, blocked, notIngested := v.counts()
+
+	fmt.Fprintf(w, "Run ID:      %s\n", v.RunID)
+	fmt.Fprintf(w, "Verified at: %s\n", v.VerifiedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Documents:   %d ingested, %d missing\n", len(v.Documents)-missing, missing)
+	fmt.Fprintf(w, "SBOMs:       %d passed, %d blocked, %d not ingested\n", len(v.SBOMs)-blocked-notIngested, blocked, notIngested)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if len(v.Documents) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "  FILE\tDOCUMENT REF\tSTATUS")
+		for _, d := range v.Documents {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", d.Path, d.DocumentRef, paintStatus(d.Status, color))
+		}
+	}
+	if len(v.SBOMs) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "  SUBJECT\tURI\tSTATUS")
+		for _, s := range v.SBOMs {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", s.Subject, s.URI, paintStatus(s.Status, color))
+		}
+	}
+	tw.Flush() //nolint:errcheck
+
+	for _, s := range v.SBOMs {
+		if s.Status != reportBlocked {
+			continue
+		}
+		fmt.Fprintf(w, "\nBlocked packages found for SBOM subject %s with URI %s\n", s.Subject, s.URI)
+		for _, bp := range s.BlockedPackages {
+			fmt.Fprintln(w, bp)
+		}
+	}
+}
+
+// reportDiff is the difference between two reports
+type reportDiff struct {
+	OldRunID string             `json:"old_run_id"`
+	NewRunID string             `json:"new_run_id"`
+	Added    []verifiedDocument `json:"added"`
+	Removed  []verifiedDocument `json:"removed"`
+	Changed  []policyChange     `json:"changed"`
+}
+
+// policyChange is an SBOM whose blocked package check changed between reports
+type policyChange struct {
+	Subject string `json:"subject"`
+	URI     string `json:"uri"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+func diffReports(cmd *cobra.Command, args []string) {
+	reports := make([]reportVerification, len(args))
+	for i, path := range args {
+		v, err := readReportStatus(path)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Failed to read the report")
+		}
+		reports[i] = v
+	}
+
+	diff := compareReports(reports[0], reports[1])
+	if viper.GetString("output") == outputNDJSON {
+		if err := json.NewEncoder(cmd.OutOrStdout()).Encode(diff); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Failed to write the diff")
+		}
+		return
+	}
+	printReportDiff(cmd.OutOrStdout(), diff)
+}
+
+// compareReports lists the documents of newer that older doesn't have, the
+// documents of older that newer doesn't have or reports as missing, and the
+// SBOMs whose policy status changed. SBOMs without a policy status in either
+// report are not compared.
+func compareReports(older, newer reportVerification) reportDiff {
+	diff := reportDiff{OldRunID: older.RunID, NewRunID: newer.RunID}
+
+	present := func(v reportVerification) map[string]verifiedDocument {
+		docs := map[string]verifiedDocument{}
+		for _, d := range v.Documents {
+			if d.Status != reportMissing {
+				docs[d.DocumentRef] = d
+			}
+		}
+		return docs
+	}
+	oldDocs, newDocs := present(older), present(newer)
+	for ref, d := range newDocs {
+		if _, ok := oldDocs[ref]; !ok {
+			diff.Added = append(diff.Added, d)
+		}
+	}
+	for ref, d := range oldDocs {
+		if _, ok := newDocs[ref]; !ok {
+			diff.Removed = append(diff.Removed, d)
+		}
+	}
+	sortDocuments(diff.Added)
+	sortDocuments(diff.Removed)
+
+	oldStatus := map[reportSBOM]string{}
+	for _, s := range older.SBOMs {
+		oldStatus[reportSBOM{Subject: s.Subject, URI: s.URI}] = s.Status
+	}
+	for _, s := range newer.SBOMs {
+		old := oldStatus[reportSBOM{Subject: s.Subject, URI: s.URI}]
+		if old != "" && s.Status != "" && old != s.Status {
+			diff.Changed = append(diff.Changed, policyChange{Subject: s.Subject, URI: s.URI, Old: old, New: s.Status})
+		}
+	}
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		if diff.Changed[i].Subject != diff.Changed[j].Subject {
+			return diff.Changed[i].Subject < diff.Changed[j].Subject
+		}
+		return diff.Changed[i].URI < diff.Changed[j].URI
+	})
+
+	return diff
+}
+
+// sortDocuments sorts documents by path and then document ref
+func sortDocuments(docs []verifiedDocument) {
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].Path != docs[j].Path {
+			return docs[i].Path < docs[j].Path
+		}
+		return docs[i].DocumentRef < docs[j].DocumentRef
+	})
+}
+
+// printReportDiff writes the totals of a diff and its documents and SBOMs
+func printReportDiff(w io.Writer, diff reportDiff) {
+	fmt.Fprintf(w, "Comparing run %s with run %s\n", diff.OldRunID, diff.NewRunID)
+	fmt.Fprintf(w, "  New documents:          %d\n", len(diff.Added))
+	fmt.Fprintf(w, "  Disappeared documents:  %d\n", len(diff.Removed))
+	fmt.Fprintf(w, "  Policy status changes:  %d\n", len(diff.Changed))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, section := range []struct {
+		title string
+		docs  []verifiedDocument
+	}{
+		{title: "New documents:", docs: diff.Added},
+		{title: "Disappeared documents:", docs: diff.Removed},
+	} {
+		if len(section.docs) == 0 {
+			continue
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, section.title)
+		for _, d := range section.docs {
+			fmt.Fprintf(tw, "  %s\t%s\n", d.Path, d.DocumentRef)
+		}
+	}
+	if len(diff.Changed) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "Policy status changes:")
+		for _, c := range diff.Changed {
+			fmt.Fprintf(tw, "  %s\t%s\t%s -> %s\n", c.Subject, c.URI, c.Old, c.New)
+		}
+	}
+	tw.Flush() //nolint:errcheck
+}
@@ -0,0 +1,215 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_verifyRunReport(t *testing.T) {
+	client := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			var body string
+			switch {
+			case req.URL.Path == "/pico/v1/documents/refs":
+				body = `{"document_refs": ["sha256_a", "sha256_other"]}`
+			case req.URL.Path == "/pico/v1/software/id" && req.URL.Query().Get("software_name") == "app":
+				body = `{"software_id": 7, "sbom_id": 9}`
+			case req.URL.Path == "/pico/v1/software/id":
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(""))}, nil
+			case req.URL.Path == "/pico/v1/packages/blocked/check/software/7/sbom/9":
+				body = `{"blocked": true, "blocked_packages": ["pkg:npm/left-pad@1.0.0"]}`
+			default:
+				t.Errorf("unexpected request URL %s", req.URL)
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+		},
+	}
+
+	report := runReport{
+		RunID: "run-1",
+		Results: []fileResult{
+			{Path: "a.json", Status: resultUploaded, DocumentRef: "sha256_a"},
+			{Path: "b.json", Status: resultUploaded, DocumentRef: "sha256_b"},
+			{Path: "c.json", Status: resultFailed, DocumentRef: "sha256_c"},
+			{Path: "d.json", Status: resultSkipped},
+		},
+		SBOMs: []reportSBOM{
+			{Subject: "app", URI: "https://example.com/app", DocumentRef: "sha256_a"},
+			{Subject: "gone", URI: "https://example.com/gone", DocumentRef: "sha256_b"},
+		},
+	}
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	got, err := verifyRunReport(context.Background(), client, "http://example.com", report, now)
+	if err != nil {
+		t.Fatalf("verifyRunReport() error = %v", err)
+	}
+	want := reportVerification{
+		RunID:      "run-1",
+		VerifiedAt: now,
+		Documents: []verifiedDocument{
+			{Path: "a.json", DocumentRef: "sha256_a", Status: reportIngested},
+			{Path: "b.json", DocumentRef: "sha256_b", Status: reportMissing},
+		},
+		SBOMs: []verifiedSBOM{
+			{reportSBOM: report.SBOMs[0], SoftwareID: 7, SbomID: 9, Status: reportBlocked, BlockedPackages: []string{"pkg:npm/left-pad@1.0.0"}},
+			{reportSBOM: report.SBOMs[1], Status: reportNotIngested},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("verifyRunReport() = %+v, want %+v", got, want)
+	}
+
+	missing, blocked, notIngested := got.counts()
+	if missing != 1 || blocked != 1 || notIngested != 1 {
+		t.Errorf("counts() = %d, %d, %d, want 1, 1, 1", missing, blocked, notIngested)
+	}
+
+	var buf bytes.Buffer
+	printReportVerification(&buf, got, false)
+	for _, line := range []string{
+		"Documents:   1 ingested, 1 missing",
+		"SBOMs:       0 passed, 1 blocked, 1 not ingested",
+		"Blocked packages found for SBOM subject app with URI https://example.com/app",
+		"pkg:npm/left-pad@1.0.0",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("printReportVerification() = %q, want it to contain %q", buf.String(), line)
+		}
+	}
+}
+
+func Test_readRunReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(path, []byte(`{"run_id": "run-1", "sboms": [{"subject": "app", "uri": "u"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readRunReport(path)
+	if err != nil {
+		t.Fatalf("readRunReport() error = %v", err)
+	}
+	if got.RunID != "run-1" || len(got.SBOMs) != 1 || got.SBOMs[0].Subject != "app" {
+		t.Errorf("readRunReport() = %+v", got)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readRunReport(path); err == nil {
+		t.Error("readRunReport() error = nil, want an error for an invalid report")
+	}
+}
+
+func Test_compareReports(t *testing.T) {
+	app := reportSBOM{Subject: "app", URI: "https://example.com/app"}
+	lib := reportSBOM{Subject: "lib", URI: "https://example.com/lib"}
+	older := reportVerification{
+		RunID: "nightly-1",
+		Documents: []verifiedDocument{
+			{Path: "a.json", DocumentRef: "sha256_a", Status: reportIngested},
+			{Path: "b.json", DocumentRef: "sha256_b", Status: reportIngested},
+			{Path: "c.json", DocumentRef: "sha256_c", Status: reportMissing},
+		},
+		SBOMs: []verifiedSBOM{
+			{reportSBOM: app, Status: reportPassed},
+			{reportSBOM: lib, Status: reportPassed},
+		},
+	}
+	newer := reportVerification{
+		RunID: "nightly-2",
+		Documents: []verifiedDocument{
+			{Path: "a.json", DocumentRef: "sha256_a", Status: reportIngested},
+			{Path: "b.json", DocumentRef: "sha256_b", Status: reportMissing},
+			{Path: "c.json", DocumentRef: "sha256_c", Status: reportIngested},
+			{Path: "d.json", DocumentRef: "sha256_d", Status: resultUploaded},
+		},
+		SBOMs: []verifiedSBOM{
+			{reportSBOM: app, Status: reportBlocked},
+			{reportSBOM: lib},
+		},
+	}
+
+	got := compareReports(older, newer)
+	want := reportDiff{
+		OldRunID: "nightly-1",
+		NewRunID: "nightly-2",
+		Added: []verifiedDocument{
+			{Path: "c.json", DocumentRef: "sha256_c", Status: reportIngested},
+			{Path: "d.json", DocumentRef: "sha256_d", Status: resultUploaded},
+		},
+		Removed: []verifiedDocument{{Path: "b.json", DocumentRef: "sha256_b", Status: reportIngested}},
+		Changed: []policyChange{{Subject: "app", URI: "https://example.com/app", Old: reportPassed, New: reportBlocked}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("compareReports() = %+v, want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	printReportDiff(&buf, got)
+	for _, line := range []string{"New documents:          2", "Disappeared documents:", "app  https://example.com/app  passed -> blocked"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("printReportDiff() = %q, want it to contain %q", buf.String(), line)
+		}
+	}
+}
+
+func Test_readReportStatus(t *testing.T) {
+	dir := t.TempDir()
+	run := filepath.Join(dir, "run.json")
+	verified := filepath.Join(dir, "verified.json")
+	if err := os.WriteFile(run, []byte(`{"run_id": "run-1", "results": [
+		{"path": "a.json", "status": "uploaded", "document_ref": "sha256_a"},
+		{"path": "b.json", "status": "failed"}
+	], "sboms": [{"subject": "app", "uri": "u"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(verified, []byte(`{"run_id": "run-1", "verified_at": "2024-05-02T12:00:00Z",
+		"documents": [{"path": "a.json", "document_ref": "sha256_a", "status": "missing"}],
+		"sboms": [{"subject": "app", "uri": "u", "status": "passed"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readReportStatus(run)
+	if err != nil {
+		t.Fatalf("readReportStatus() error = %v", err)
+	}
+	want := reportVerification{
+		RunID:     "run-1",
+		Documents: []verifiedDocument{{Path: "a.json", DocumentRef: "sha256_a", Status: resultUploaded}},
+		SBOMs:     []verifiedSBOM{{reportSBOM: reportSBOM{Subject: "app", URI: "u"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readReportStatus() of a run report = %+v, want %+v", got, want)
+	}
+
+	got, err = readReportStatus(verified)
+	if err != nil {
+		t.Fatalf("readReportStatus() error = %v", err)
+	}
+	if got.VerifiedAt.IsZero() || got.Documents[0].Status != reportMissing || got.SBOMs[0].Status != reportPassed {
+		t.Errorf("readReportStatus() of a verification = %+v", got)
+	}
+}
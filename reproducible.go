@@ -0,0 +1,93 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// reproducible reports whether --reproducible is set, which makes generated
+// artifacts such as run reports, audit reports and bundles the same for the
+// same input, so they can be committed and diffed
+func reproducible() bool {
+	return viper.GetBool("reproducible")
+}
+
+// reproducibleTime is the time generated artifacts carry with --reproducible:
+// SOURCE_DATE_EPOCH if set, as with other reproducible build tools, or else
+// the Unix epoch
+func reproducibleTime() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// generatedAt returns the time to write to a generated artifact: now, or
+// reproducibleTime with --reproducible
+func generatedAt(now time.Time) time.Time {
+	if reproducible() {
+		return reproducibleTime()
+	}
+	return now
+}
+
+// reproducibleRunID derives the run ID of a run without --run-id from its
+// arguments, formatted as a UUID like generated run IDs
+func reproducibleRunID(args []string) string {
+	sum := sha256.Sum256([]byte("kusari-uploader run\x00" + strings.Join(args, "\x00")))
+	b := sum[:16]
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// normalizeRunReport sorts the results and SBOMs of a report and replaces its
+// times with reproducibleTime, as uploads complete in any order
+func normalizeRunReport(report runReport) runReport {
+	report.StartedAt = reproducibleTime()
+	report.FinishedAt = reproducibleTime()
+	for i := range report.Results {
+		report.Results[i].CompletedAt = reproducibleTime()
+	}
+	slices.SortStableFunc(report.Results, func(a, b fileResult) int {
+		return cmp.Compare(a.Path, b.Path)
+	})
+	slices.SortStableFunc(report.SBOMs, func(a, b reportSBOM) int {
+		return cmp.Or(cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.URI, b.URI), cmp.Compare(a.DocumentRef, b.DocumentRef))
+	})
+	return report
+}
+
+// sortBlockedFindings sorts findings by SBOM, and the blocked packages of each
+func sortBlockedFindings(findings []blockedFinding) {
+	for _, f := range findings {
+		slices.Sort(f.BlockedPackages)
+	}
+	slices.SortStableFunc(findings, func(a, b blockedFinding) int {
+		return cmp.Or(cmp.Compare(a.Subject, b.Subject), cmp.Compare(a.URI, b.URI),
+			cmp.Compare(a.SoftwareID, b.SoftwareID), cmp.Compare(a.SbomID, b.SbomID))
+	})
+}
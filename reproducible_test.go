@@ -0,0 +1,97 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func Test_generatedAt(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := generatedAt(now); !got.Equal(now) {
+		t.Errorf("generatedAt() = %v without reproducible, want now", got)
+	}
+	viper.Set("reproducible", true)
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	if got := generatedAt(now); !got.Equal(time.Unix(0, 0)) {
+		t.Errorf("generatedAt() = %v, want the Unix epoch", got)
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	if got := generatedAt(now); !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("generatedAt() = %v, want SOURCE_DATE_EPOCH", got)
+	}
+}
+
+func Test_reproducibleRunID(t *testing.T) {
+	args := []string{"upload", "-f", "sboms/"}
+	id := reproducibleRunID(args)
+	if id != reproducibleRunID([]string{"upload", "-f", "sboms/"}) {
+		t.Error("reproducibleRunID() differs for the same arguments")
+	}
+	if id == reproducibleRunID([]string{"upload", "-f", "sboms", "/"}) {
+		t.Error("reproducibleRunID() is the same for other arguments")
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Errorf("reproducibleRunID() = %q, want a UUID", id)
+	}
+}
+
+func Test_newRunReport_reproducible(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("reproducible", true)
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	report := func(start time.Time, paths []string, subjects []string) []byte {
+		s := newUploadSummary(start)
+		for i, path := range paths {
+			s.add(fileResult{Path: path, Status: resultUploaded, CompletedAt: start.Add(time.Duration(i) * time.Second)})
+		}
+		for _, subject := range subjects {
+			s.addSBOMs([]sbomSubjectAndURI{{subject: subject, uri: subject + "@1"}})
+		}
+		b, err := json.Marshal(newRunReport(s, "run-1", start.Add(time.Minute)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	first := report(time.Now(), []string{"b.json", "a.json"}, []string{"web", "api"})
+	second := report(time.Now().Add(time.Hour), []string{"a.json", "b.json"}, []string{"api", "web"})
+	if string(first) != string(second) {
+		t.Errorf("newRunReport() differs between runs:\n%s\n%s", first, second)
+	}
+}
+
+func Test_sortBlockedFindings(t *testing.T) {
+	findings := []blockedFinding{
+		{Subject: "web", URI: "web@1", BlockedPackages: []string{"pkg:npm/b@1", "pkg:npm/a@1"}},
+		{Subject: "api", URI: "api@1", BlockedPackages: []string{"pkg:npm/c@1"}},
+	}
+	sortBlockedFindings(findings)
+	if findings[0].Subject != "api" || findings[1].BlockedPackages[0] != "pkg:npm/a@1" {
+		t.Errorf("sortBlockedFindings() = %+v, want the SBOMs and their packages sorted", findings)
+	}
+}
@@ -0,0 +1,90 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// agentCommands are the only commands of restricted builds, which are meant
+// for build agents that should not be able to read or manage tenant data with
+// their credentials. Running the binary without a command still uploads.
+var agentCommands = map[string]bool{
+	"upload":      true,
+	"version":     true,
+	"doctor":      true,
+	"verify-self": true,
+}
+
+// agentRefusedFlags are the upload flags restricted builds refuse, as they
+// create components, change documents other than the uploaded ones, or read
+// tenant data
+var agentRefusedFlags = []string{
+	"auto-register-component", "propagate-tag", "push-run-summary", "check-only", "resolve-software-id",
+}
+
+// restrictCommands removes every command of root that is not one of
+// agentCommands, and refuses the agentRefusedFlags of the remaining ones
+func restrictCommands(root *cobra.Command) {
+	for _, cmd := range root.Commands() {
+		if !agentCommands[cmd.Name()] {
+			root.RemoveCommand(cmd)
+		}
+	}
+	for _, cmd := range append(root.Commands(), root) {
+		refuseAgentFlags(cmd)
+	}
+}
+
+// refuseAgentFlags hides the agentRefusedFlags of cmd, and makes it fail when
+// any of them is set on the command line, in the environment or in a config file
+func refuseAgentFlags(cmd *cobra.Command) {
+	found := false
+	for _, name := range agentRefusedFlags {
+		if f := cmd.Flags().Lookup(name); f != nil {
+			f.Hidden = true
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+
+	preRun := cmd.PreRun
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		if preRun != nil {
+			preRun(cmd, args)
+		}
+		if used := usedAgentRefusedFlags(); len(used) > 0 {
+			log.Fatal().Msg("Restricted builds can't use " + strings.Join(used, ", "))
+		}
+	}
+}
+
+// usedAgentRefusedFlags returns the agentRefusedFlags that are set
+func usedAgentRefusedFlags() []string {
+	var used []string
+	for _, name := range agentRefusedFlags {
+		if viper.GetBool(name) {
+			used = append(used, name)
+		}
+	}
+	return used
+}
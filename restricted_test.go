@@ -0,0 +1,71 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func Test_restrictCommands(t *testing.T) {
+	root := &cobra.Command{Use: "kusari-uploader"}
+	for _, name := range []string{"upload", "check", "version", "list-documents", "reconcile", "doctor", "verify-self", "import-bundle"} {
+		root.AddCommand(&cobra.Command{Use: name, Run: func(*cobra.Command, []string) {}})
+	}
+
+	restrictCommands(root)
+
+	var got []string
+	for _, cmd := range root.Commands() {
+		got = append(got, cmd.Name())
+	}
+	want := []string{"doctor", "upload", "verify-self", "version"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restrictCommands() left %v, want %v", got, want)
+	}
+}
+
+func Test_restrictCommands_refusedFlags(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	root := &cobra.Command{Use: "kusari-uploader"}
+	upload := &cobra.Command{Use: "upload", Run: func(*cobra.Command, []string) {}}
+	addUploadFlags(upload.Flags())
+	root.AddCommand(upload)
+
+	restrictCommands(root)
+
+	for _, name := range agentRefusedFlags {
+		if f := upload.Flags().Lookup(name); f == nil || !f.Hidden {
+			t.Errorf("flag %s is not hidden", name)
+		}
+	}
+	if upload.PreRun == nil {
+		t.Errorf("restrictCommands() did not refuse the flags of upload")
+	}
+
+	viper.Set("propagate-tag", true)
+	viper.Set("check-only", "true")
+	viper.Set("tag", "release")
+	want := []string{"propagate-tag", "check-only"}
+	if got := usedAgentRefusedFlags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("usedAgentRefusedFlags() = %v, want %v", got, want)
+	}
+}
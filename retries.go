@@ -0,0 +1,113 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// retry* name the phase of a run that was retried
+const (
+	// retryPhaseUpload is the upload to a presigned URL, retried with a new URL
+	retryPhaseUpload = "upload"
+	// retryPhaseIngestion is waiting for an SBOM to be ingested before the blocked package check
+	retryPhaseIngestion = "ingestion-wait"
+	// retryPhaseFile is a whole file, tried again before it is quarantined
+	retryPhaseFile = "file"
+	// retryPhaseToken is a tenant request that was rejected with 401, retried with a new token
+	retryPhaseToken = "token-refresh"
+)
+
+// retryRecord counts the retries of one phase for one file or SBOM
+type retryRecord struct {
+	Target  string
+	Phase   string
+	Count   int
+	Backoff time.Duration
+}
+
+// retryReport collects the retries of a run so they can be summarized at exit
+type retryReport struct {
+	mu      sync.Mutex
+	records map[[2]string]*retryRecord
+}
+
+// runRetries collects the retries of the current run
+var runRetries = &retryReport{}
+
+// record counts a retry of phase for target after waiting backoff
+func (r *retryReport) record(target, phase string, backoff time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.records == nil {
+		r.records = map[[2]string]*retryRecord{}
+	}
+	key := [2]string{target, phase}
+	rec, ok := r.records[key]
+	if !ok {
+		rec = &retryRecord{Target: target, Phase: phase}
+		r.records[key] = rec
+	}
+	rec.Count++
+	rec.Backoff += backoff
+}
+
+// summary returns the retries sorted by target and phase
+func (r *retryReport) summary() []retryRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make([]retryRecord, 0, len(r.records))
+	for _, rec := range r.records {
+		records = append(records, *rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Target != records[j].Target {
+			return records[i].Target < records[j].Target
+		}
+		return records[i].Phase < records[j].Phase
+	})
+	return records
+}
+
+// printRetrySummary writes the retries of the run, if there were any
+func printRetrySummary(w io.Writer, records []retryRecord) {
+	if len(records) == 0 {
+		return
+	}
+
+	var total time.Duration
+	count := 0
+	for _, rec := range records {
+		total += rec.Backoff
+		count += rec.Count
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Retries: %d, time lost to backoff: %s\n", count, total)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  TARGET\tPHASE\tRETRIES\tBACKOFF")
+	for _, rec := range records {
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%s\n", rec.Target, rec.Phase, rec.Count, rec.Backoff)
+	}
+	tw.Flush() //nolint:errcheck
+}
@@ -0,0 +1,52 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_retryReport(t *testing.T) {
+	r := &retryReport{}
+	r.record("b.json", retryPhaseUpload, 0)
+	r.record("app", retryPhaseIngestion, time.Second)
+	r.record("app", retryPhaseIngestion, time.Second)
+
+	want := []retryRecord{
+		{Target: "app", Phase: retryPhaseIngestion, Count: 2, Backoff: 2 * time.Second},
+		{Target: "b.json", Phase: retryPhaseUpload, Count: 1},
+	}
+	got := r.summary()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("summary() = %+v, want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	printRetrySummary(&buf, got)
+	if !strings.Contains(buf.String(), "Retries: 3, time lost to backoff: 2s") {
+		t.Errorf("printRetrySummary() = %q, want the totals", buf.String())
+	}
+
+	buf.Reset()
+	printRetrySummary(&buf, nil)
+	if buf.Len() != 0 {
+		t.Errorf("printRetrySummary() without retries = %q, want nothing", buf.String())
+	}
+}
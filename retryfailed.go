@@ -0,0 +1,295 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// ingestionFailed is the status of a document the platform failed to ingest
+	ingestionFailed = "failed"
+
+	fixupStripBOM     = "strip UTF-8 byte order mark"
+	fixupUTF16        = "convert UTF-16 to UTF-8"
+	fixupForceFormat  = "force format "
+	fixupForceOpenVEX = "force type OPENVEX"
+)
+
+// documentStatus is the ingestion status the tenant reports for a document
+// ref. Tenants that don't report the status only answer whether the document
+// is known.
+type documentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// documentFixup is a rewritten document and how it was rewritten
+type documentFixup struct {
+	Blob        []byte
+	Fixups      []string
+	ForceType   DocumentType
+	ForceFormat FormatType
+}
+
+// retryResult is the outcome of retrying a document the platform rejected
+type retryResult struct {
+	Path        string
+	DocumentRef string
+	Reason      string
+	Fixups      []string
+	// NewRef is the ref of the re-uploaded document
+	NewRef string
+	Error  string
+}
+
+func newRetryFailedCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "retry-failed [report.json]",
+		Short: "Fix and re-upload the documents of a run that the platform failed to ingest",
+		Long: "Look up the ingestion status of the documents uploaded by a run, and re-upload the documents " +
+			"the platform failed to ingest after applying the known fixups for their failure: converting their " +
+			"encoding to UTF-8 and forcing the locally detected format. Without an argument the report of the " +
+			"last run is used. Exits with status 3 if a failed document could not be fixed and re-uploaded.",
+		Args:   cobra.MaximumNArgs(1),
+		PreRun: bindUploadFlags,
+		Run:    retryFailed,
+	}
+
+	addMetadataFlags(cmd.Flags())
+	addDocumentFlags(cmd.Flags())
+	addSBOMCheckFlags(cmd.Flags())
+	addWrapperVersionFlag(cmd.Flags())
+	cmd.Flags().Bool("dry-run", false, "List the failed documents and the fixups that would be applied without uploading anything")
+
+	mustBindPFlag(cmd, "dry-run")
+
+	return cmd
+}
+
+func retryFailed(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	var report runReport
+	var err error
+	if len(args) == 1 {
+		report, err = readRunReport(args[0])
+	} else {
+		report, err = loadRunReport()
+	}
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to read the run report")
+	}
+
+	mustValidateDocRefTemplate()
+	mustValidateCustomMetadata(cmd.Flags())
+	uploadMeta := metadataValues(resolveUploadMetadata(cmd.Flags(), nil, ""))
+	if report.RunID != "" {
+		uploadMeta["run_id"] = report.RunID
+	}
+
+	ctx, defaultClient := mustNewHTTPClient(ctx)
+
+	tenantEndPoint, tokenEndPoint, err := resolveEndpoints(ctx, defaultClient)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to discover endpoints")
+	}
+
+	if !hasClientCredentials() || tenantEndPoint == "" || tokenEndPoint == "" {
+		log.Fatal().Msg("All required flag(s) must be provided: client-id, client-secret, tenant-endpoint (or org), token-endpoint")
+	}
+
+	creds, err := clientCredentials()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid client credentials")
+	}
+	authorizedClient := getAuthorizedClient(ctx, tokenEndPoint, creds)
+	mustNegotiateWrapperVersion(negotiateCapabilities(ctx, authorizedClient, tenantEndPoint))
+
+	var results []retryResult
+	for _, r := range report.Results {
+		if r.Status != resultUploaded || r.DocumentRef == "" {
+			continue
+		}
+		status, err := getDocumentStatus(ctx, authorizedClient, tenantEndPoint, r.DocumentRef)
+		if err != nil {
+			fatalErr(err, exitUsage).
+				Msg("Failed to look up the ingestion status")
+		}
+		if status == nil || status.Status != ingestionFailed {
+			continue
+		}
+
+		result := retryResult{Path: r.Path, DocumentRef: r.DocumentRef, Reason: status.Error}
+		blob, err := os.ReadFile(r.Path)
+		if err != nil {
+			result.Error = fmt.Sprintf("error reading file: %s", err)
+			results = append(results, result)
+			continue
+		}
+		fix := fixDocument(blob, status.Error)
+		result.Fixups = fix.Fixups
+		switch {
+		case len(fix.Fixups) == 0:
+			result.Error = "no known fixup"
+		case !viper.GetBool("dry-run"):
+			result.NewRef, err = reuploadDocument(authorizedClient, defaultClient, tenantEndPoint, r.Path, fix, uploadMeta)
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}
+		results = append(results, result)
+	}
+
+	printRetryResults(cmd.OutOrStdout(), results, viper.GetBool("dry-run"))
+
+	for _, r := range results {
+		if r.Error != "" {
+			exitRun(exitUpload)
+		}
+	}
+}
+
+// getDocumentStatus returns the ingestion status of a document ref, or nil if
+// the tenant doesn't know the document
+func getDocumentStatus(ctx context.Context, client HttpClient, tenantEndpoint, ref string) (*documentStatus, error) {
+	res, err := makePicoReq(ctx, client, tenantEndpoint, fmt.Sprintf("pico/v1/documents/ref/%s", url.PathEscape(ref)))
+	if err != nil {
+		return nil, fmt.Errorf("error making request for document ref %s: %w", ref, err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for document ref %s: %d", ref, res.StatusCode))
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body for document ref %s: %w", ref, err)
+	}
+	var status documentStatus
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &status); err != nil {
+			return nil, fmt.Errorf("error unmarshaling response body for document ref %s: %w", ref, err)
+		}
+	}
+	return &status, nil
+}
+
+// fixDocument applies the known fixups for a document the platform failed to
+// ingest with reason. The encoding is always converted to UTF-8, and the
+// locally detected format and type are forced if the platform failed to
+// detect them.
+func fixDocument(blob []byte, reason string) documentFixup {
+	fix := documentFixup{Blob: blob}
+
+	switch {
+	case bytes.HasPrefix(blob, utf8BOM):
+		fix.Blob = blob[len(utf8BOM):]
+		fix.Fixups = append(fix.Fixups, fixupStripBOM)
+	case bytes.HasPrefix(blob, utf16LEBOM), bytes.HasPrefix(blob, utf16BEBOM):
+		fix.Blob = decodeUTF16(blob)
+		fix.Fixups = append(fix.Fixups, fixupUTF16)
+	}
+
+	reason = strings.ToLower(reason)
+	if !strings.Contains(reason, "format") && !strings.Contains(reason, "detect") {
+		return fix
+	}
+	kind, format := detectDocument(fix.Blob)
+	if f, ok := formatNames[format]; ok {
+		fix.ForceFormat = f
+		fix.Fixups = append(fix.Fixups, fixupForceFormat+format)
+	}
+	if kind == kindOpenVEX {
+		fix.ForceType = DocumentOpenVEX
+		fix.Fixups = append(fix.Fixups, fixupForceOpenVEX)
+	}
+	return fix
+}
+
+// reuploadDocument uploads a fixed document with its type and format forced as
+// the fixups require, and returns its new document ref
+func reuploadDocument(authorizedClient, defaultClient HttpClient, tenantEndpoint, filePath string, fix documentFixup,
+	uploadMeta map[string]string) (string, error) {
+	// the forced type and format are settings of the run, so they are set for
+	// this document only
+	forceType, forceFormat := viper.GetString("force-type"), viper.GetString("force-format")
+	defer func() {
+		viper.Set("force-type", forceType)
+		viper.Set("force-format", forceFormat)
+	}()
+	if fix.ForceType != "" {
+		viper.Set("force-type", string(fix.ForceType))
+	}
+	if fix.ForceFormat != "" {
+		viper.Set("force-format", string(fix.ForceFormat))
+	}
+
+	ssau, err := uploadFileBlob(authorizedClient, defaultClient, tenantEndpoint, filePath, fix.Blob,
+		fix.ForceType == DocumentOpenVEX, uploadMeta)
+	return ssau.docRef, err
+}
+
+// printRetryResults writes a table of the failed documents, their failure
+// reasons, the fixups applied and the outcome of the retry
+func printRetryResults(w io.Writer, results []retryResult, dryRun bool) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "No documents of the run failed to ingest")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tREASON\tFIXUPS\tRESULT")
+	for _, r := range results {
+		outcome := "re-uploaded as " + r.NewRef
+		switch {
+		case r.Error != "":
+			outcome = "failed: " + r.Error
+		case dryRun:
+			outcome = "would re-upload"
+		}
+		fixups := strings.Join(r.Fixups, ", ")
+		if fixups == "" {
+			fixups = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Path, r.Reason, fixups, outcome)
+	}
+	tw.Flush() //nolint:errcheck
+}
@@ -0,0 +1,154 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_getDocumentStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    *documentStatus
+		wantErr bool
+	}{
+		{name: "failed", status: http.StatusOK, body: `{"status": "failed", "error": "unable to detect format"}`,
+			want: &documentStatus{Status: ingestionFailed, Error: "unable to detect format"}},
+		{name: "tenant without ingestion status", status: http.StatusOK, want: &documentStatus{}},
+		{name: "unknown document", status: http.StatusNotFound},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &ClientMock{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					if req.URL.Path != "/pico/v1/documents/ref/sha256_a" {
+						t.Errorf("unexpected request URL %s", req.URL)
+					}
+					return &http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body))}, nil
+				},
+			}
+			got, err := getDocumentStatus(context.Background(), client, "http://example.com", "sha256_a")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getDocumentStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getDocumentStatus() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fixDocument(t *testing.T) {
+	cdx := []byte(`{"bomFormat": "CycloneDX", "specVersion": "1.5"}`)
+	vex := []byte(`{"@context": "https://openvex.dev/ns/v0.2.0", "statements": []}`)
+	utf16LE := []byte{0xFF, 0xFE}
+	for _, r := range string(cdx) {
+		utf16LE = append(utf16LE, byte(r), 0)
+	}
+	utf16BE := []byte{0xFE, 0xFF}
+	for _, r := range string(cdx) {
+		utf16BE = append(utf16BE, 0, byte(r))
+	}
+
+	tests := []struct {
+		name   string
+		blob   []byte
+		reason string
+		want   documentFixup
+	}{
+		{
+			name:   "utf-8 byte order mark",
+			blob:   append([]byte{0xEF, 0xBB, 0xBF}, cdx...),
+			reason: "invalid character 'ï' looking for beginning of value",
+			want:   documentFixup{Blob: cdx, Fixups: []string{fixupStripBOM}},
+		},
+		{
+			name:   "utf-16 little endian",
+			blob:   utf16LE,
+			reason: "invalid JSON",
+			want:   documentFixup{Blob: cdx, Fixups: []string{fixupUTF16}},
+		},
+		{
+			name:   "utf-16 big endian and format",
+			blob:   utf16BE,
+			reason: "unable to detect document format",
+			want:   documentFixup{Blob: cdx, Fixups: []string{fixupUTF16, fixupForceFormat + "json"}, ForceFormat: FormatJSON},
+		},
+		{
+			name:   "openvex detected as sbom",
+			blob:   vex,
+			reason: "Unsupported format for SBOM",
+			want: documentFixup{Blob: vex, Fixups: []string{fixupForceFormat + "json", fixupForceOpenVEX},
+				ForceType: DocumentOpenVEX, ForceFormat: FormatJSON},
+		},
+		{
+			name:   "unknown format",
+			blob:   []byte("hello"),
+			reason: "unable to detect format",
+			want:   documentFixup{Blob: []byte("hello")},
+		},
+		{
+			name:   "no known fixup",
+			blob:   cdx,
+			reason: "schema validation failed: components[0].name is required",
+			want:   documentFixup{Blob: cdx},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fixDocument(tt.blob, tt.reason)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fixDocument() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_printRetryResults(t *testing.T) {
+	results := []retryResult{
+		{Path: "a.json", DocumentRef: "sha256_a", Reason: "invalid JSON", Fixups: []string{fixupStripBOM}, NewRef: "sha256_b"},
+		{Path: "c.json", DocumentRef: "sha256_c", Reason: "schema validation failed", Error: "no known fixup"},
+	}
+
+	var buf bytes.Buffer
+	printRetryResults(&buf, results, false)
+	for _, want := range []string{"re-uploaded as sha256_b", fixupStripBOM, "failed: no known fixup"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("printRetryResults() = %q, want it to contain %q", buf.String(), want)
+		}
+	}
+
+	buf.Reset()
+	printRetryResults(&buf, results[:1], true)
+	if !strings.Contains(buf.String(), "would re-upload") {
+		t.Errorf("printRetryResults() with dry run = %q", buf.String())
+	}
+
+	buf.Reset()
+	printRetryResults(&buf, nil, false)
+	if buf.String() != "No documents of the run failed to ingest\n" {
+		t.Errorf("printRetryResults() without results = %q", buf.String())
+	}
+}
@@ -0,0 +1,129 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// routingRule derives upload metadata from the path of a file. Rules are read
+// from the routing-rules key of the config file, for example:
+//
+//	routing-rules:
+//	  - pattern: services/api/
+//	    component-name: api
+//	    tag: backend
+type routingRule struct {
+	// Pattern is matched against the file path relative to the uploaded
+	// directory. "*" matches within a path segment, "**" matches across
+	// segments and a trailing "/" matches everything below a directory.
+	Pattern       string `mapstructure:"pattern"`
+	Tag           string `mapstructure:"tag"`
+	DocumentType  string `mapstructure:"document-type"`
+	ComponentName string `mapstructure:"component-name"`
+
+	re *regexp.Regexp
+}
+
+// loadRoutingRules reads and compiles the routing rules from the loaded configuration
+func loadRoutingRules() ([]routingRule, error) {
+	var rules []routingRule
+	if err := viper.UnmarshalKey("routing-rules", &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse routing-rules: %w", err)
+	}
+
+	for i := range rules {
+		if rules[i].Pattern == "" {
+			return nil, fmt.Errorf("routing rule %d has no pattern", i)
+		}
+		re, err := compilePathPattern(rules[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule %d has invalid pattern %q: %w", i, rules[i].Pattern, err)
+		}
+		rules[i].re = re
+	}
+
+	return rules, nil
+}
+
+// compilePathPattern converts a slash separated glob pattern into a regular expression
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	return regexp.Compile(sb.String())
+}
+
+// applyRoutingRules returns a copy of uploadMeta with the values of every rule
+// matching relPath applied on top. Later rules win over earlier ones.
+func applyRoutingRules(rules []routingRule, relPath string, uploadMeta map[string]string) map[string]string {
+	meta := make(map[string]string, len(uploadMeta))
+	for k, v := range uploadMeta {
+		meta[k] = v
+	}
+
+	relPath = filepath.ToSlash(relPath)
+	for _, rule := range rules {
+		if !rule.re.MatchString(relPath) {
+			continue
+		}
+		for key, value := range rule.values() {
+			meta[key] = value
+		}
+	}
+
+	return meta
+}
+
+// values returns the upload metadata set by the rule
+func (r routingRule) values() map[string]string {
+	values := map[string]string{}
+	if r.Tag != "" {
+		values["tag"] = r.Tag
+	}
+	if r.DocumentType != "" {
+		values["type"] = r.DocumentType
+	}
+	if r.ComponentName != "" {
+		values["component_name"] = r.ComponentName
+	}
+	return values
+}
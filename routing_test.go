@@ -0,0 +1,98 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_compilePathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "services/api/", path: "services/api/sbom.json", want: true},
+		{pattern: "services/api/", path: "services/api/nested/sbom.json", want: true},
+		{pattern: "services/api/", path: "services/apix/sbom.json", want: false},
+		{pattern: "*.cdx.json", path: "app.cdx.json", want: true},
+		{pattern: "*.cdx.json", path: "dir/app.cdx.json", want: false},
+		{pattern: "**/*.cdx.json", path: "dir/app.cdx.json", want: true},
+		{pattern: "build-?.json", path: "build-1.json", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			re, err := compilePathPattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("compilePathPattern() error = %v", err)
+			}
+			if got := re.MatchString(tt.path); got != tt.want {
+				t.Errorf("compilePathPattern(%q).MatchString(%q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applyRoutingRules(t *testing.T) {
+	var rules []routingRule
+	for _, r := range []routingRule{
+		{Pattern: "services/**", Tag: "service"},
+		{Pattern: "services/api/", ComponentName: "api", Tag: "backend"},
+		{Pattern: "**/*.vex.json", DocumentType: "build"},
+	} {
+		re, err := compilePathPattern(r.Pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+
+	base := map[string]string{"alias": "shop", "tag": "default"}
+
+	tests := []struct {
+		name string
+		path string
+		want map[string]string
+	}{
+		{
+			name: "no match keeps base metadata",
+			path: "other/sbom.json",
+			want: map[string]string{"alias": "shop", "tag": "default"},
+		},
+		{
+			name: "later rule wins",
+			path: "services/api/sbom.json",
+			want: map[string]string{"alias": "shop", "tag": "backend", "component_name": "api"},
+		},
+		{
+			name: "multiple rules combine",
+			path: "services/web/app.vex.json",
+			want: map[string]string{"alias": "shop", "tag": "service", "type": "build"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applyRoutingRules(rules, tt.path, base); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyRoutingRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if base["tag"] != "default" {
+		t.Errorf("applyRoutingRules() modified the base metadata")
+	}
+}
@@ -0,0 +1,185 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// gate* are the outcomes of a run pushed to the tenant
+const (
+	gatePassed  = "passed"
+	gateBlocked = "blocked"
+	gateFailed  = "failed"
+)
+
+// ciMetadata identifies the CI pipeline run the uploader runs in
+type ciMetadata struct {
+	Provider   string `json:"provider"`
+	Repository string `json:"repository,omitempty"`
+	RunID      string `json:"run_id,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+	Ref        string `json:"ref,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+// runSummaryPayload is the run summary pushed to the tenant's runs endpoint
+type runSummaryPayload struct {
+	runReport
+	Gate string      `json:"gate"`
+	CI   *ciMetadata `json:"ci,omitempty"`
+}
+
+// detectCI returns the pipeline run of the CI system the uploader runs in,
+// from the environment variables it sets, or nil outside of CI
+func detectCI() *ciMetadata {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		ci := &ciMetadata{
+			Provider:   "github-actions",
+			Repository: os.Getenv("GITHUB_REPOSITORY"),
+			RunID:      os.Getenv("GITHUB_RUN_ID"),
+			Commit:     os.Getenv("GITHUB_SHA"),
+			Ref:        os.Getenv("GITHUB_REF"),
+		}
+		if server := os.Getenv("GITHUB_SERVER_URL"); server != "" && ci.Repository != "" && ci.RunID != "" {
+			ci.URL = server + "/" + ci.Repository + "/actions/runs/" + ci.RunID
+		}
+		return ci
+	case os.Getenv("GITLAB_CI") == "true":
+		return &ciMetadata{
+			Provider:   "gitlab",
+			Repository: os.Getenv("CI_PROJECT_PATH"),
+			RunID:      os.Getenv("CI_PIPELINE_ID"),
+			Commit:     os.Getenv("CI_COMMIT_SHA"),
+			Ref:        os.Getenv("CI_COMMIT_REF_NAME"),
+			URL:        os.Getenv("CI_PIPELINE_URL"),
+		}
+	case os.Getenv("CIRCLECI") == "true":
+		return &ciMetadata{
+			Provider:   "circleci",
+			Repository: os.Getenv("CIRCLE_PROJECT_USERNAME") + "/" + os.Getenv("CIRCLE_PROJECT_REPONAME"),
+			RunID:      os.Getenv("CIRCLE_WORKFLOW_ID"),
+			Commit:     os.Getenv("CIRCLE_SHA1"),
+			Ref:        os.Getenv("CIRCLE_BRANCH"),
+			URL:        os.Getenv("CIRCLE_BUILD_URL"),
+		}
+	case os.Getenv("TF_BUILD") == "True":
+		return &ciMetadata{
+			Provider:   "azure-pipelines",
+			Repository: os.Getenv("BUILD_REPOSITORY_NAME"),
+			RunID:      os.Getenv("BUILD_BUILDID"),
+			Commit:     os.Getenv("BUILD_SOURCEVERSION"),
+			Ref:        os.Getenv("BUILD_SOURCEBRANCH"),
+		}
+	case os.Getenv("JENKINS_URL") != "":
+		return &ciMetadata{
+			Provider:   "jenkins",
+			Repository: os.Getenv("JOB_NAME"),
+			RunID:      os.Getenv("BUILD_NUMBER"),
+			Commit:     os.Getenv("GIT_COMMIT"),
+			Ref:        os.Getenv("GIT_BRANCH"),
+			URL:        os.Getenv("BUILD_URL"),
+		}
+	case os.Getenv("CI") != "":
+		return &ciMetadata{Provider: "unknown"}
+	default:
+		return nil
+	}
+}
+
+// singleRunReport returns the report of a run without an upload summary, a
+// single file or OpenVEX upload, from its results
+func singleRunReport(runID string, start, now time.Time, results ...fileResult) runReport {
+	report := runReport{RunID: runID, StartedAt: start.UTC(), FinishedAt: now.UTC()}
+	for _, r := range results {
+		if r.Path == "" {
+			continue
+		}
+		r.RunID = runID
+		if r.CompletedAt.IsZero() {
+			r.CompletedAt = now.UTC()
+		}
+		report.Results = append(report.Results, r)
+		switch r.Status {
+		case resultUploaded:
+			report.Uploaded++
+		case resultSkipped:
+			report.Skipped++
+		case resultFailed:
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// pushRunSummary posts the report of a run and its gate outcome to the
+// tenant, so the platform can show the pipeline run the documents came from
+func pushRunSummary(ctx context.Context, client HttpClient, tenantEndpoint string, report runReport, gate string) error {
+	body, err := json.Marshal(runSummaryPayload{runReport: report, Gate: gate, CI: detectCI()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tenantEndpoint+"/pico/v1/runs", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request to push the run summary: %w", err)
+	}
+	defer res.Body.Close()        //nolint:errcheck
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return errors.New("the tenant does not support run summaries")
+	default:
+		return tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for run summary: %d", res.StatusCode))
+	}
+}
+
+// mustPushRunSummary pushes the run summary if --push-run-summary is set. A
+// failed push is only logged, the documents were uploaded all the same.
+func mustPushRunSummary(ctx context.Context, client HttpClient, tenantEndpoint string, report runReport, gate string) {
+	if !viper.GetBool("push-run-summary") {
+		return
+	}
+	if err := pushRunSummary(ctx, client, tenantEndpoint, report, gate); err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Failed to push the run summary to the tenant")
+		return
+	}
+	log.Info().
+		Str("runID", report.RunID).
+		Str("gate", gate).
+		Msg("Pushed the run summary to the tenant")
+}
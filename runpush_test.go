@@ -0,0 +1,138 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// clearCIEnv unsets the environment variables CI systems are detected by
+func clearCIEnv(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{"GITHUB_ACTIONS", "GITLAB_CI", "CIRCLECI", "TF_BUILD", "JENKINS_URL", "CI"} {
+		t.Setenv(env, "")
+	}
+}
+
+func Test_detectCI(t *testing.T) {
+	clearCIEnv(t)
+	if ci := detectCI(); ci != nil {
+		t.Errorf("detectCI() = %+v outside of CI, want nil", ci)
+	}
+
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("GITHUB_REPOSITORY", "acme/app")
+	t.Setenv("GITHUB_RUN_ID", "42")
+	t.Setenv("GITHUB_SHA", "abc123")
+	t.Setenv("GITHUB_REF", "refs/heads/main")
+	t.Setenv("GITHUB_SERVER_URL", "https://github.com")
+	want := &ciMetadata{
+		Provider:   "github-actions",
+		Repository: "acme/app",
+		RunID:      "42",
+		Commit:     "abc123",
+		Ref:        "refs/heads/main",
+		URL:        "https://github.com/acme/app/actions/runs/42",
+	}
+	if got := detectCI(); !reflect.DeepEqual(got, want) {
+		t.Errorf("detectCI() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_singleRunReport(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := start.Add(time.Minute)
+
+	report := singleRunReport("run-1", start, now, fileResult{Path: "sbom.json", Status: resultUploaded, DocumentRef: "ref-1"})
+	want := runReport{
+		RunID:      "run-1",
+		StartedAt:  start,
+		FinishedAt: now,
+		Uploaded:   1,
+		Results:    []fileResult{{Path: "sbom.json", Status: resultUploaded, DocumentRef: "ref-1", RunID: "run-1", CompletedAt: now}},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("singleRunReport() = %+v, want %+v", report, want)
+	}
+
+	if report := singleRunReport("run-1", start, now, fileResult{}); report.Results != nil || report.Uploaded != 0 {
+		t.Errorf("singleRunReport() = %+v, want no results", report)
+	}
+}
+
+func Test_pushRunSummary(t *testing.T) {
+	clearCIEnv(t)
+	t.Setenv("GITLAB_CI", "true")
+	t.Setenv("CI_PIPELINE_ID", "7")
+
+	status := http.StatusCreated
+	var pushed map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/pico/v1/runs" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		pushed = nil
+		if err := json.NewDecoder(r.Body).Decode(&pushed); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	report := runReport{RunID: "run-1", Uploaded: 2, Failed: 1}
+	if err := pushRunSummary(context.Background(), srv.Client(), srv.URL, report, gateBlocked); err != nil {
+		t.Fatalf("pushRunSummary() error = %v", err)
+	}
+	if pushed["run_id"] != "run-1" || pushed["uploaded"] != float64(2) || pushed["gate"] != gateBlocked {
+		t.Errorf("pushed %v, want the report with its gate", pushed)
+	}
+	if ci, _ := pushed["ci"].(map[string]any); ci["provider"] != "gitlab" || ci["run_id"] != "7" {
+		t.Errorf("pushed ci = %v, want the GitLab pipeline", pushed["ci"])
+	}
+
+	status = http.StatusForbidden
+	if err := pushRunSummary(context.Background(), srv.Client(), srv.URL, report, gatePassed); err == nil || exitCodeOf(err, exitUpload) != exitAuth {
+		t.Errorf("pushRunSummary() error = %v, want an auth failure", err)
+	}
+	if err := pushRunSummary(context.Background(), srv.Client(), srv.URL+"/old", report, gatePassed); err == nil {
+		t.Error("pushRunSummary() succeeded for a tenant without the runs endpoint")
+	}
+}
+
+func Test_mustPushRunSummary_disabled(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	mustPushRunSummary(context.Background(), srv.Client(), srv.URL, runReport{RunID: "run-1"}, gatePassed)
+	if requests != 0 {
+		t.Errorf("pushed %d run summaries without push-run-summary", requests)
+	}
+}
@@ -0,0 +1,276 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// sample* are the formats gen-sample can write
+const (
+	sampleCycloneDX = "cyclonedx"
+	sampleSPDX      = "spdx"
+)
+
+// sampleNameRegexp restricts application names to those valid in purls and SPDX IDs
+var sampleNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// sampleEcosystems are the package types of the generated components
+var sampleEcosystems = []string{"npm", "pypi", "golang", "maven"}
+
+// sampleWords are combined into the names of the generated components
+var sampleWords = []string{
+	"async", "buffer", "cache", "codec", "config", "crypto", "date", "encoding",
+	"event", "fetch", "glob", "hash", "http", "json", "log", "parser",
+	"path", "queue", "retry", "semver", "stream", "template", "uuid", "yaml",
+}
+
+// sampleLicenses are the licenses of the generated components
+var sampleLicenses = []string{"Apache-2.0", "MIT", "BSD-3-Clause", "ISC"}
+
+// sampleComponent is a generated package
+type sampleComponent struct {
+	Name    string
+	Version string
+	Purl    string
+	License string
+}
+
+func newGenSampleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gen-sample [file]",
+		Short: "Generate a synthetic SBOM to exercise a pipeline before uploading real builds",
+		Long: "Generate a synthetic but valid CycloneDX or SPDX SBOM with the given number of components. " +
+			"The SBOM is written to file, or to stdout if no file is given. The same seed always generates the same components.",
+		Args: cobra.MaximumNArgs(1),
+		Run:  genSample,
+	}
+
+	cmd.Flags().String("format", sampleCycloneDX, "SBOM format: cyclonedx or spdx")
+	cmd.Flags().Int("components", 100, "Number of components in the SBOM")
+	cmd.Flags().String("name", "sample-app", "Name of the application the SBOM describes")
+	cmd.Flags().Int64("seed", 0, "Seed for the generated components (optional, random if not set)")
+
+	mustBindPFlag(cmd, "format")
+	mustBindPFlag(cmd, "components")
+	mustBindPFlag(cmd, "name")
+	mustBindPFlag(cmd, "seed")
+
+	return cmd
+}
+
+func genSample(cmd *cobra.Command, args []string) {
+	seed := viper.GetInt64("seed")
+	if seed == 0 && reproducible() {
+		seed = 1
+	} else if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	var w io.Writer = os.Stdout
+	if len(args) == 1 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Error creating sample file")
+		}
+		defer f.Close() //nolint:errcheck
+		w = f
+	}
+
+	err := writeSample(w, viper.GetString("format"), viper.GetString("name"), viper.GetInt("components"),
+		rand.New(rand.NewSource(seed)), generatedAt(time.Now()).UTC())
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error generating sample SBOM")
+	}
+}
+
+// writeSample writes a synthetic SBOM in format describing the application name
+// with count components drawn from rnd
+func writeSample(w io.Writer, format, name string, count int, rnd *rand.Rand, now time.Time) error {
+	if count < 0 {
+		return fmt.Errorf("components must not be negative")
+	}
+	if !sampleNameRegexp.MatchString(name) {
+		return fmt.Errorf("name %q may only contain letters, digits, '.', '_' and '-'", name)
+	}
+
+	components := sampleComponents(rnd, count)
+	digest := sha256.Sum256(fmt.Appendf(nil, "%s-%d", name, rnd.Int63()))
+	uuid := sampleUUID(rnd)
+
+	var doc any
+	switch format {
+	case sampleCycloneDX:
+		doc = sampleCycloneDXDocument(name, hex.EncodeToString(digest[:]), uuid, components, now)
+	case sampleSPDX:
+		doc = sampleSPDXDocument(name, hex.EncodeToString(digest[:]), uuid, components, now)
+	default:
+		return fmt.Errorf("unknown format %q, supported formats: %s, %s", format, sampleCycloneDX, sampleSPDX)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// sampleComponents returns count distinct components
+func sampleComponents(rnd *rand.Rand, count int) []sampleComponent {
+	components := make([]sampleComponent, 0, count)
+	seen := map[string]bool{}
+	for len(components) < count {
+		name := sampleWords[rnd.Intn(len(sampleWords))] + "-" + sampleWords[rnd.Intn(len(sampleWords))]
+		if seen[name] {
+			// distinguish the names once the combinations run out
+			name = fmt.Sprintf("%s-%d", name, len(components))
+		}
+		seen[name] = true
+
+		version := fmt.Sprintf("%d.%d.%d", rnd.Intn(5), rnd.Intn(20), rnd.Intn(10))
+		ecosystem := sampleEcosystems[rnd.Intn(len(sampleEcosystems))]
+		purlName := name
+		switch ecosystem {
+		case "golang":
+			purlName = "github.com/example/" + name
+			version = "v" + version
+		case "maven":
+			purlName = "com.example/" + name
+		}
+
+		components = append(components, sampleComponent{
+			Name:    name,
+			Version: version,
+			Purl:    fmt.Sprintf("pkg:%s/%s@%s", ecosystem, purlName, version),
+			License: sampleLicenses[rnd.Intn(len(sampleLicenses))],
+		})
+	}
+	return components
+}
+
+// sampleUUID returns a version 4 UUID drawn from rnd, so seeded samples are reproducible
+func sampleUUID(rnd *rand.Rand) string {
+	var b [16]byte
+	_, _ = rnd.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func sampleCycloneDXDocument(name, digest, uuid string, components []sampleComponent, now time.Time) map[string]any {
+	ref := "pkg:generic/" + name + "@1.0.0"
+	deps := make([]string, 0, len(components))
+	list := make([]map[string]any, 0, len(components))
+	for _, c := range components {
+		deps = append(deps, c.Purl)
+		list = append(list, map[string]any{
+			"type":     "library",
+			"bom-ref":  c.Purl,
+			"name":     c.Name,
+			"version":  c.Version,
+			"purl":     c.Purl,
+			"licenses": []map[string]any{{"license": map[string]string{"id": c.License}}},
+		})
+	}
+
+	return map[string]any{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + uuid,
+		"version":      1,
+		"metadata": map[string]any{
+			"timestamp": now.Format(time.RFC3339),
+			"tools":     map[string]any{"components": []map[string]string{{"type": "application", "name": "kusari-uploader"}}},
+			"component": map[string]any{
+				"type":    "application",
+				"bom-ref": ref,
+				"name":    name,
+				"version": "1.0.0",
+				"purl":    ref,
+				"hashes":  []map[string]string{{"alg": "SHA-256", "content": digest}},
+			},
+		},
+		"components":   list,
+		"dependencies": []map[string]any{{"ref": ref, "dependsOn": deps}},
+	}
+}
+
+func sampleSPDXDocument(name, digest, uuid string, components []sampleComponent, now time.Time) map[string]any {
+	rootID := "SPDXRef-Package-" + name
+	packages := []map[string]any{{
+		"SPDXID":           rootID,
+		"name":             name,
+		"versionInfo":      "1.0.0",
+		"downloadLocation": "NOASSERTION",
+		"filesAnalyzed":    false,
+		"checksums":        []map[string]string{{"algorithm": "SHA256", "checksumValue": digest}},
+	}}
+	relationships := []map[string]string{{
+		"spdxElementId":      "SPDXRef-DOCUMENT",
+		"relationshipType":   "DESCRIBES",
+		"relatedSpdxElement": rootID,
+	}}
+	for i, c := range components {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i)
+		packages = append(packages, map[string]any{
+			"SPDXID":           id,
+			"name":             c.Name,
+			"versionInfo":      c.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"licenseConcluded": c.License,
+			"externalRefs": []map[string]string{{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  c.Purl,
+			}},
+		})
+		relationships = append(relationships, map[string]string{
+			"spdxElementId":      rootID,
+			"relationshipType":   "DEPENDS_ON",
+			"relatedSpdxElement": id,
+		})
+	}
+
+	return map[string]any{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              name,
+		"documentNamespace": "https://example.com/spdx/" + name + "-" + uuid,
+		"creationInfo": map[string]any{
+			"created":  now.Format(time.RFC3339),
+			"creators": []string{"Tool: kusari-uploader"},
+		},
+		"documentDescribes": []string{rootID},
+		"packages":          packages,
+		"relationships":     relationships,
+	}
+}
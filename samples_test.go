@@ -0,0 +1,75 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func Test_writeSample(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, format := range []string{sampleCycloneDX, sampleSPDX} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeSample(&buf, format, "app", 500, rand.New(rand.NewSource(1)), now); err != nil {
+				t.Fatalf("writeSample() error = %v", err)
+			}
+
+			var doc struct {
+				Components []json.RawMessage `json:"components"`
+				Packages   []json.RawMessage `json:"packages"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+				t.Fatalf("writeSample() wrote invalid JSON: %v", err)
+			}
+			want := 500
+			if format == sampleSPDX {
+				// SPDX lists the application as a package too
+				want++
+			}
+			if got := len(doc.Components) + len(doc.Packages); got != want {
+				t.Errorf("writeSample() wrote %d components and packages, want %d", got, want)
+			}
+
+			if ssau := localSBOMSubject(buf.Bytes()); ssau.subject != "app" || ssau.uri == "" {
+				t.Errorf("localSBOMSubject() = %+v, want the subject and URI of the sample", ssau)
+			}
+			if digest := sbomArtifactDigest(buf.Bytes()); digest == nil || digest.SHA256 == "" {
+				t.Errorf("sbomArtifactDigest() = %+v, want the digest of the sample", digest)
+			}
+
+			var again bytes.Buffer
+			if err := writeSample(&again, format, "app", 500, rand.New(rand.NewSource(1)), now); err != nil {
+				t.Fatalf("writeSample() error = %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+				t.Errorf("writeSample() is not reproducible with the same seed")
+			}
+		})
+	}
+
+	if err := writeSample(&bytes.Buffer{}, "swid", "app", 1, rand.New(rand.NewSource(1)), now); err == nil {
+		t.Errorf("writeSample() accepted an unknown format")
+	}
+	if err := writeSample(&bytes.Buffer{}, sampleSPDX, "my app", 1, rand.New(rand.NewSource(1)), now); err == nil {
+		t.Errorf("writeSample() accepted a name with a space")
+	}
+}
@@ -0,0 +1,243 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// cronMacros are the shorthands accepted in place of a cron expression
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is a parsed five field cron expression. Each field is a bit set
+// of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record unrestricted (*) day fields: when both day fields
+	// are restricted, a day matching either of them matches, as in cron
+	domAny, dowAny bool
+}
+
+// cronField describes the values a field of a cron expression accepts
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// parseCron parses a cron expression with the fields minute, hour, day of
+// month, month and day of week, or one of cronMacros. Fields take *, numbers,
+// ranges such as 1-5, steps such as */15 or 0-30/10, and lists of them. Sunday
+// is 0 or 7.
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule %q, must have 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	s := &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}
+	if s.next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: it never runs", expr)
+	}
+	return s, nil
+}
+
+// parseCronField returns the bit set of the values a field matches
+func parseCronField(field string, f cronField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, f.name)
+			}
+			step = n
+		}
+
+		first, last := f.min, f.max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if first, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q in %s field", part, f.name)
+			}
+			last = first
+			if isRange {
+				if last, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q in %s field", part, f.name)
+				}
+			} else if hasStep {
+				// 5/15 means from 5 to the end in steps of 15
+				last = f.max
+			}
+		}
+		if first < f.min || last > f.max || first > last {
+			return 0, fmt.Errorf("value %q out of range %d-%d in %s field", part, f.min, f.max, f.name)
+		}
+		for v := first; v <= last; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// next returns the first time after t the schedule matches, in the location of
+// t, or the zero time if it does not match within five years
+func (s *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the day of month and day of
+// week fields
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// uploadOnSchedule keeps running and runs the upload every time the schedule
+// set with --schedule matches, until it is interrupted. Each upload runs as its
+// own process with the same arguments, so a failed upload is logged and the
+// next one still runs. An interrupt waits for a running upload to finish.
+func uploadOnSchedule(expr string) {
+	schedule, err := parseCron(expr)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid schedule")
+	}
+	if viper.GetBool("interactive") || viper.GetString("files-from") == "-" {
+		log.Fatal().Msg("schedule can't be used with interactive or files-from from stdin")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to find the running binary")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// installed with service install, the service manager stops the uploads
+	ctx, stopped := serviceContext(ctx)
+	defer stopped()
+
+	// an empty --schedule makes the scheduled uploads run once
+	args := append(withoutFlag(os.Args[1:], "schedule"), "--schedule=")
+	for {
+		next := schedule.next(time.Now())
+		log.Info().Str("schedule", expr).Time("next", next).Msg("Waiting for the next scheduled upload")
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Info().Msg("Stopping scheduled uploads")
+			return
+		case <-timer.C:
+		}
+
+		start := time.Now()
+		sweep := exec.Command(exe, args...)
+		sweep.Stdout = os.Stdout
+		sweep.Stderr = os.Stderr
+		err := sweep.Run()
+
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			log.Info().Dur("elapsed", time.Since(start)).Msg("Scheduled upload completed")
+		case errors.As(err, &exitErr):
+			log.Error().Int("exitCode", exitErr.ExitCode()).Dur("elapsed", time.Since(start)).Msg("Scheduled upload failed")
+		default:
+			log.Error().Err(err).Msg("Failed to run scheduled upload")
+		}
+	}
+}
@@ -0,0 +1,73 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// secretFiles memoizes the secrets read with readSecretFile, as stdin and file
+// descriptors can only be read once
+var secretFiles sync.Map
+
+// readSecretFile reads a secret from a file such as a Kubernetes mounted
+// secret, from stdin when path is "-", or from an inherited file descriptor
+// when path is fd:N. Trailing newlines are removed.
+func readSecretFile(path string) (string, error) {
+	if secret, ok := secretFiles.Load(path); ok {
+		return secret.(string), nil
+	}
+
+	var r io.Reader
+	switch fd, isFD := strings.CutPrefix(path, "fd:"); {
+	case path == "-":
+		r = os.Stdin
+	case isFD:
+		n, err := strconv.ParseUint(fd, 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid file descriptor %q", path)
+		}
+		f := os.NewFile(uintptr(n), path)
+		if f == nil {
+			return "", fmt.Errorf("invalid file descriptor %q", path)
+		}
+		defer f.Close() //nolint:errcheck
+		r = f
+	default:
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to open secret file: %w", err)
+		}
+		defer f.Close() //nolint:errcheck
+		r = f
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, 64*1024))
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret from %s: %w", path, err)
+	}
+	secret := strings.TrimRight(string(b), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	secretFiles.Store(path, secret)
+	return secret, nil
+}
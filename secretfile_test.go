@@ -0,0 +1,67 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_readSecretFile(t *testing.T) {
+	t.Cleanup(secretFiles.Clear)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "client-secret")
+	if err := os.WriteFile(path, []byte("mounted-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readSecretFile(path); err != nil || got != "mounted-secret" {
+		t.Errorf("readSecretFile() = %q, %v, want the secret without the trailing newline", got, err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{empty, filepath.Join(dir, "missing"), "fd:three"} {
+		if _, err := readSecretFile(path); err == nil {
+			t.Errorf("readSecretFile(%q) expected an error", path)
+		}
+	}
+}
+
+func Test_clientSecret_file(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Cleanup(secretFiles.Clear)
+	useMemoryKeyring(t)
+
+	path := filepath.Join(t.TempDir(), "client-secret")
+	if err := os.WriteFile(path, []byte("from-file\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("client-id", "id")
+	viper.Set("client-secret-file", path)
+	if got := clientSecret(); got != "from-file" {
+		t.Errorf("clientSecret() = %q, want the secret of client-secret-file", got)
+	}
+	if !hasClientCredentials() {
+		t.Error("hasClientCredentials() = false with client-secret-file")
+	}
+}
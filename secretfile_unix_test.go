@@ -0,0 +1,49 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func Test_readSecretFile_fd(t *testing.T) {
+	t.Cleanup(secretFiles.Clear)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close() //nolint:errcheck
+	fmt.Fprint(w, "piped-secret")
+	w.Close() //nolint:errcheck
+
+	// readSecretFile closes the descriptor, so it gets a copy of the pipe's
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("fd:%d", fd)
+	for i := 0; i < 2; i++ {
+		if got, err := readSecretFile(path); err != nil || got != "piped-secret" {
+			t.Errorf("readSecretFile(%q) = %q, %v, want the secret written to the pipe, also when read again", path, got, err)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// secretRefFlags are the flags whose value can be a secret manager reference
+// such as vault://secret/kusari#client-secret instead of the value itself. The
+// proxy password comes first, so the other secrets are fetched through the
+// proxy with it.
+var secretRefFlags = []string{"proxy-password", "client-id", "client-secret", "secondary-client-id", "secondary-client-secret", "token"}
+
+// secretRefTimeout bounds the requests made to resolve the secret references
+// of a run
+const secretRefTimeout = 30 * time.Second
+
+// gcpSecretManagerEndpoint is the API of GCP Secret Manager
+var gcpSecretManagerEndpoint = "https://secretmanager.googleapis.com"
+
+// secretRef is a parsed scheme://name?query#field secret manager reference.
+// It is not parsed as a URL as AWS ARNs aren't valid hosts.
+type secretRef struct {
+	scheme string
+	name   string
+	query  map[string]string
+	field  string
+}
+
+// parseSecretRef parses value if it is a vault://, awssm:// or gcpsm://
+// reference, and reports whether it is one
+func parseSecretRef(value string) (secretRef, bool) {
+	scheme, rest, ok := strings.Cut(value, "://")
+	if !ok || (scheme != "vault" && scheme != "awssm" && scheme != "gcpsm") {
+		return secretRef{}, false
+	}
+	ref := secretRef{scheme: scheme, query: map[string]string{}}
+	rest, ref.field, _ = strings.Cut(rest, "#")
+	rest, query, _ := strings.Cut(rest, "?")
+	for _, pair := range strings.Split(query, "&") {
+		if key, value, _ := strings.Cut(pair, "="); key != "" {
+			ref.query[key] = value
+		}
+	}
+	ref.name = strings.Trim(rest, "/")
+	return ref, true
+}
+
+// resolveSecretRefs replaces the secret manager references in secretRefFlags
+// with the secrets they point to, before anything else reads them
+func resolveSecretRefs(cmd *cobra.Command, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretRefTimeout)
+	defer cancel()
+
+	for _, name := range secretRefFlags {
+		ref, ok := parseSecretRef(viper.GetString(name))
+		if !ok {
+			continue
+		}
+		secret, err := fetchSecret(ctx, ref)
+		if err != nil {
+			fatalErr(err, exitAuth).
+				Str("flag", name).
+				Msg("Failed to read the secret manager reference")
+		}
+		viper.Set(name, secret)
+	}
+}
+
+// fetchSecret reads the secret ref points to. With a #field the secret is
+// read as a JSON object and the field is returned.
+func fetchSecret(ctx context.Context, ref secretRef) (string, error) {
+	if ref.name == "" {
+		return "", fmt.Errorf("secret reference %s:// has no secret name", ref.scheme)
+	}
+	client, err := newHTTPClient()
+	if err != nil {
+		return "", err
+	}
+
+	switch ref.scheme {
+	case "vault":
+		data, err := fetchVaultSecret(ctx, client, ref)
+		if err != nil {
+			return "", err
+		}
+		return secretField(data, ref)
+	case "awssm":
+		secret, err := fetchAWSSecret(ctx, client, ref)
+		if err != nil || ref.field == "" {
+			return secret, err
+		}
+		return jsonSecretField(secret, ref)
+	default:
+		secret, err := fetchGCPSecret(ctx, client, ref)
+		if err != nil || ref.field == "" {
+			return secret, err
+		}
+		return jsonSecretField(secret, ref)
+	}
+}
+
+// jsonSecretField returns the #field of a secret holding a JSON object
+func jsonSecretField(secret string, ref secretRef) (string, error) {
+	var data map[string]any
+	if err := json.Unmarshal([]byte(secret), &data); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object, so it has no field %s", ref.name, ref.field)
+	}
+	return secretField(data, ref)
+}
+
+// secretField returns the #field of a secret's key/value data, or its only
+// value when no field is given
+func secretField(data map[string]any, ref secretRef) (string, error) {
+	field := ref.field
+	if field == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("secret %s has %d fields, select one with #field", ref.name, len(data))
+		}
+		for key := range data {
+			field = key
+		}
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no string field %s", ref.name, field)
+	}
+	return value, nil
+}
+
+// fetchVaultSecret reads a secret from HashiCorp Vault at VAULT_ADDR with
+// VAULT_TOKEN, or the token vault login stored in ~/.vault-token. The name is
+// the API path of the secret, for KV version 2 with or without the data/
+// segment after the mount.
+func fetchVaultSecret(ctx context.Context, client HttpClient, ref secretRef) (map[string]any, error) {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return nil, errors.New("vault:// references need the VAULT_ADDR environment variable")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if b, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				token = strings.TrimSpace(string(b))
+			}
+		}
+	}
+	if token == "" {
+		return nil, errors.New("vault:// references need the VAULT_TOKEN environment variable or a vault login")
+	}
+
+	paths := []string{ref.name}
+	if mount, rest, ok := strings.Cut(ref.name, "/"); ok && !strings.HasPrefix(rest, "data/") {
+		paths = append(paths, mount+"/data/"+rest)
+	}
+	for i, p := range paths {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+"/v1/"+p, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Vault-Token", token)
+		if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+			req.Header.Set("X-Vault-Namespace", namespace)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("error making request to Vault: %w", err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close() //nolint:errcheck
+		if err != nil {
+			return nil, fmt.Errorf("error reading Vault response: %w", err)
+		}
+		if res.StatusCode == http.StatusNotFound && i < len(paths)-1 {
+			continue
+		}
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected response status code from Vault for %s: %d", p, res.StatusCode)
+		}
+
+		var secret struct {
+			Data map[string]any `json:"data"`
+		}
+		if err := json.Unmarshal(body, &secret); err != nil {
+			return nil, fmt.Errorf("error unmarshaling Vault response: %w", err)
+		}
+		// KV version 2 nests the secret with its metadata
+		if nested, ok := secret.Data["data"].(map[string]any); ok && secret.Data["metadata"] != nil {
+			return nested, nil
+		}
+		return secret.Data, nil
+	}
+	return nil, fmt.Errorf("secret %s not found in Vault", ref.name)
+}
+
+// fetchAWSSecret reads the secret string of an AWS Secrets Manager secret by
+// name or ARN, with the credentials of the standard AWS environment variables.
+// The region is the region query parameter or AWS_REGION, and the endpoint
+// query parameter selects a compatible service.
+func fetchAWSSecret(ctx context.Context, client HttpClient, ref secretRef) (string, error) {
+	region := ref.query["region"]
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = os.Getenv(env)
+		}
+	}
+	if region == "" {
+		return "", errors.New("awssm:// references need a region, e.g. awssm://name?region=us-east-1")
+	}
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return "", errors.New("awssm:// references need the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	}
+	endpoint := ref.query["endpoint"]
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": ref.name})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSv4(req, body, region, "secretsmanager", creds, time.Now())
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error making request to AWS Secrets Manager: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status code from AWS Secrets Manager for %s: %d", ref.name, res.StatusCode)
+	}
+
+	var secret struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("error unmarshaling AWS Secrets Manager response: %w", err)
+	}
+	if secret.SecretString == nil {
+		return "", fmt.Errorf("secret %s has no secret string", ref.name)
+	}
+	return *secret.SecretString, nil
+}
+
+// fetchGCPSecret reads a version of a GCP Secret Manager secret, named
+// projects/PROJECT/secrets/SECRET with the latest version unless
+// /versions/VERSION is added. The access token is GOOGLE_OAUTH_ACCESS_TOKEN,
+// or else the one of the service account of the GCP metadata server.
+func fetchGCPSecret(ctx context.Context, client HttpClient, ref secretRef) (string, error) {
+	name := ref.name
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if token == "" {
+		var err error
+		if token, err = gcpMetadataToken(ctx, client); err != nil {
+			return "", err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpSecretManagerEndpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error making request to GCP Secret Manager: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status code from GCP Secret Manager for %s: %d", name, res.StatusCode)
+	}
+
+	var secret struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("error unmarshaling GCP Secret Manager response: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(secret.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("error decoding secret %s: %w", name, err)
+	}
+	return string(data), nil
+}
+
+// gcpMetadataToken gets an access token for the service account of the GCP
+// metadata server, at GCE_METADATA_HOST if set
+func gcpMetadataToken(ctx context.Context, client HttpClient) (string, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("gcpsm:// references need GOOGLE_OAUTH_ACCESS_TOKEN outside of GCP: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status code from the GCP metadata server: %d", res.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error unmarshaling GCP metadata server token: %w", err)
+	}
+	return token.AccessToken, nil
+}
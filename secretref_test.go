@@ -0,0 +1,183 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_parseSecretRef(t *testing.T) {
+	tests := []struct {
+		value string
+		want  secretRef
+		ok    bool
+	}{
+		{value: "vault://secret/kusari#client-secret", want: secretRef{scheme: "vault", name: "secret/kusari", query: map[string]string{}, field: "client-secret"}, ok: true},
+		{value: "awssm://arn:aws:secretsmanager:us-east-1:123:secret:kusari?region=us-east-1#secret",
+			want: secretRef{scheme: "awssm", name: "arn:aws:secretsmanager:us-east-1:123:secret:kusari", query: map[string]string{"region": "us-east-1"}, field: "secret"}, ok: true},
+		{value: "gcpsm://projects/p/secrets/kusari", want: secretRef{scheme: "gcpsm", name: "projects/p/secrets/kusari", query: map[string]string{}}, ok: true},
+		{value: "plain-secret"},
+		{value: "https://example.com/secret"},
+	}
+	for _, tt := range tests {
+		got, ok := parseSecretRef(tt.value)
+		if ok != tt.ok || (ok && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("parseSecretRef(%q) = %+v, %v, want %+v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func Test_fetchSecret_vault(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/kusari":
+			_, _ = w.Write([]byte(`{"data": {"data": {"client-id": "id", "client-secret": "from-kv2"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv1/kusari":
+			_, _ = w.Write([]byte(`{"data": {"secret": "from-kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "vault://secret/kusari#client-secret", want: "from-kv2"},
+		{value: "vault://secret/data/kusari#client-id", want: "id"},
+		{value: "vault://kv1/kusari", want: "from-kv1"},
+		{value: "vault://secret/kusari", wantErr: true},
+		{value: "vault://secret/kusari#missing", wantErr: true},
+		{value: "vault://secret/unknown#secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		ref, _ := parseSecretRef(tt.value)
+		got, err := fetchSecret(context.Background(), ref)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("fetchSecret(%q) = %q, %v, want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func Test_fetchSecret_awssm(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-west-2/secretsmanager/aws4_request") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"Name": "kusari", "SecretString": "{\"client_secret\": \"from-aws\"}"}`))
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	ref, _ := parseSecretRef("awssm://kusari?region=us-west-2&endpoint=" + srv.URL + "#client_secret")
+	if got, err := fetchSecret(context.Background(), ref); err != nil || got != "from-aws" {
+		t.Errorf("fetchSecret() = %q, %v, want the field of the secret string", got, err)
+	}
+	ref, _ = parseSecretRef("awssm://kusari?region=us-west-2&endpoint=" + srv.URL)
+	if got, err := fetchSecret(context.Background(), ref); err != nil || got != `{"client_secret": "from-aws"}` {
+		t.Errorf("fetchSecret() = %q, %v, want the whole secret string without a field", got, err)
+	}
+}
+
+func Test_fetchSecret_gcpsm(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "gcp-token", "token_type": "Bearer"}`))
+		case "/v1/projects/p/secrets/kusari/versions/latest:access":
+			if r.Header.Get("Authorization") != "Bearer gcp-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			// "from-gcp"
+			_, _ = w.Write([]byte(`{"payload": {"data": "ZnJvbS1nY3A="}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	endpoint := gcpSecretManagerEndpoint
+	gcpSecretManagerEndpoint = srv.URL
+	t.Cleanup(func() { gcpSecretManagerEndpoint = endpoint })
+
+	ref, _ := parseSecretRef("gcpsm://projects/p/secrets/kusari")
+	if got, err := fetchSecret(context.Background(), ref); err != nil || got != "from-gcp" {
+		t.Errorf("fetchSecret() = %q, %v, want the latest version of the secret", got, err)
+	}
+	ref, _ = parseSecretRef("gcpsm://projects/p/secrets/kusari/versions/2")
+	if _, err := fetchSecret(context.Background(), ref); err == nil {
+		t.Error("fetchSecret() expected an error for a missing version")
+	}
+}
+
+func Test_resolveSecretRefs(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data": {"id": "resolved-id", "secret": "resolved-secret"}}`))
+	}))
+	defer srv.Close()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	viper.Set("client-id", "vault://kv/kusari#id")
+	viper.Set("client-secret", "vault://kv/kusari#secret")
+	viper.Set("tenant-endpoint", "vault://kv/kusari#id")
+	resolveSecretRefs(nil, nil)
+	if got := viper.GetString("client-id"); got != "resolved-id" {
+		t.Errorf("client-id = %q, want the resolved reference", got)
+	}
+	if got := viper.GetString("client-secret"); got != "resolved-secret" {
+		t.Errorf("client-secret = %q, want the resolved reference", got)
+	}
+	if got := viper.GetString("tenant-endpoint"); got != "vault://kv/kusari#id" {
+		t.Errorf("tenant-endpoint = %q, want it left as is", got)
+	}
+}
@@ -0,0 +1,235 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// defaultServiceName is the name services are installed under unless --name
+// is set, which is needed to install more than one
+const defaultServiceName = "kusari-uploader"
+
+// serviceDefinition is a scheduled upload registered with the service manager
+// of the OS, a Windows service or a launchd agent
+type serviceDefinition struct {
+	Name       string
+	Executable string
+	Args       []string
+	LogFile    string
+}
+
+func newServiceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service",
+		Short: "Run scheduled uploads as a Windows service or launchd agent",
+	}
+
+	install := &cobra.Command{
+		Use:   "install --schedule CRON -- [upload flags]",
+		Short: "Register an upload on a schedule with the service manager of the OS and start it",
+		Long: "Register upload --schedule with the flags after -- as a Windows service or a launchd agent, which " +
+			"starts at boot or login and is restarted if it stops, and start it. Logs are written to a file with " +
+			"--log-file. Paths in the upload flags should be absolute, and settings such as credentials are best " +
+			"given with --config, as the service doesn't see the environment of the shell it was installed from. " +
+			"Secrets can't be set in the upload flags, as they would be stored in the service definition.",
+		PreRun: func(cmd *cobra.Command, args []string) {
+			mustBindPFlag(cmd, "name")
+			mustBindPFlag(cmd, "schedule")
+		},
+		Run: installServiceCmd,
+	}
+	install.Flags().String("name", defaultServiceName, "Name of the service, to install more than one")
+	install.Flags().String("schedule", "", "Cron schedule the upload runs on, e.g. \"0 2 * * *\" or @hourly, in local time (required)")
+	cmd.AddCommand(install)
+
+	uninstall := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop a service installed with service install and remove it",
+		Args:  cobra.NoArgs,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			mustBindPFlag(cmd, "name")
+		},
+		Run: uninstallServiceCmd,
+	}
+	uninstall.Flags().String("name", defaultServiceName, "Name of the service")
+	cmd.AddCommand(uninstall)
+
+	return cmd
+}
+
+func installServiceCmd(cmd *cobra.Command, args []string) {
+	def, err := newServiceDefinition(viper.GetString("name"), viper.GetString("schedule"), args)
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Invalid service")
+	}
+	if err := installService(def); err != nil {
+		log.Fatal().
+			Err(err).
+			Str("name", def.Name).
+			Msg("Failed to install the service")
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Installed and started service %s, logging to %s\n", def.Name, def.LogFile)
+}
+
+func uninstallServiceCmd(cmd *cobra.Command, args []string) {
+	name := viper.GetString("name")
+	if err := uninstallService(name); err != nil {
+		log.Fatal().
+			Err(err).
+			Str("name", name).
+			Msg("Failed to uninstall the service")
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Uninstalled service %s\n", name)
+}
+
+// newServiceDefinition returns the service running this binary with upload
+// and the upload flags on the schedule, logging to the log file of the service.
+// Where the service manager discards the output, the logs are written with
+// --log-file, unless the upload flags set it.
+func newServiceDefinition(name, schedule string, uploadArgs []string) (serviceDefinition, error) {
+	if name == "" || strings.ContainsAny(name, `/\ `) {
+		return serviceDefinition{}, fmt.Errorf("invalid service name %q", name)
+	}
+	if schedule == "" {
+		return serviceDefinition{}, fmt.Errorf("schedule must be set")
+	}
+	if _, err := parseCron(schedule); err != nil {
+		return serviceDefinition{}, err
+	}
+	if len(withoutFlag(uploadArgs, "schedule")) != len(uploadArgs) {
+		return serviceDefinition{}, fmt.Errorf("set the schedule with service install --schedule, not in the upload flags")
+	}
+	if err := checkServiceSecrets(uploadArgs); err != nil {
+		return serviceDefinition{}, err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return serviceDefinition{}, fmt.Errorf("failed to find the running binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	def := serviceDefinition{
+		Name:       name,
+		Executable: exe,
+		Args:       append(append([]string{"upload"}, uploadArgs...), "--schedule", schedule),
+		LogFile:    serviceLogFile(name),
+	}
+	if serviceLogFlag {
+		if len(withoutFlag(uploadArgs, "log-file")) == len(uploadArgs) {
+			def.Args = append(def.Args, "--log-file", def.LogFile)
+		} else {
+			def.LogFile = flagValue(uploadArgs, "log-file")
+		}
+	}
+	return def, nil
+}
+
+// serviceSecretFlags are the upload flags whose values are secrets. The
+// arguments of a service are stored in its definition, which other users of
+// the machine may be able to read.
+var serviceSecretFlags = []string{"client-secret", "secondary-client-secret", "token", "proxy-password"}
+
+// checkServiceSecrets returns an error if the upload flags of a service set a
+// secret, unless it is a secret manager reference
+func checkServiceSecrets(args []string) error {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		for _, name := range serviceSecretFlags {
+			value, ok := "", false
+			switch {
+			case arg == "--"+name || (name == "client-secret" && arg == "-s"):
+				if i+1 < len(args) {
+					value, ok = args[i+1], true
+				}
+			case strings.HasPrefix(arg, "--"+name+"="):
+				value, ok = strings.TrimPrefix(arg, "--"+name+"="), true
+			case name == "client-secret" && strings.HasPrefix(arg, "-s") && len(arg) > 2:
+				value, ok = strings.TrimPrefix(strings.TrimPrefix(arg, "-s"), "="), true
+			}
+			if !ok {
+				continue
+			}
+			if _, isRef := parseSecretRef(value); !isRef {
+				return fmt.Errorf("--%s would be stored in the service definition, set it with --config, --client-secret-file or a secret manager reference instead", name)
+			}
+		}
+	}
+	return nil
+}
+
+// flagValue returns the value of the last occurrence of the long flag name in args
+func flagValue(args []string, name string) string {
+	value := ""
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+name && i+1 < len(args) {
+			value = args[i+1]
+		} else if v, ok := strings.CutPrefix(arg, "--"+name+"="); ok {
+			value = v
+		}
+	}
+	return value
+}
+
+// launchdLabel is the launchd label of the service name
+func launchdLabel(name string) string {
+	return "com.kusari." + name
+}
+
+// renderLaunchdPlist returns the launchd property list of a service: started at
+// login, restarted if it stops, and with its output appended to the log file
+func renderLaunchdPlist(def serviceDefinition) []byte {
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s)) //nolint:errcheck
+		return b.String()
+	}
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	fmt.Fprintf(&b, "  <key>Label</key>\n  <string>%s</string>\n", escape(launchdLabel(def.Name)))
+	b.WriteString("  <key>ProgramArguments</key>\n  <array>\n")
+	for _, arg := range append([]string{def.Executable}, def.Args...) {
+		fmt.Fprintf(&b, "    <string>%s</string>\n", escape(arg))
+	}
+	b.WriteString("  </array>\n")
+	b.WriteString("  <key>RunAtLoad</key>\n  <true/>\n")
+	b.WriteString("  <key>KeepAlive</key>\n  <true/>\n")
+	b.WriteString("  <key>ThrottleInterval</key>\n  <integer>60</integer>\n")
+	fmt.Fprintf(&b, "  <key>StandardOutPath</key>\n  <string>%s</string>\n", escape(def.LogFile))
+	fmt.Fprintf(&b, "  <key>StandardErrorPath</key>\n  <string>%s</string>\n", escape(def.LogFile))
+	b.WriteString("</dict>\n</plist>\n")
+	return []byte(b.String())
+}
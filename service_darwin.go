@@ -0,0 +1,98 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// serviceLogFlag is false as launchd appends the output of agents to their log file
+const serviceLogFlag = false
+
+// serviceLogFile is the log file of a service, in the logs of the user
+func serviceLogFile(name string) string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Library", "Logs", "kusari-uploader", name+".log")
+}
+
+// launchdPlistPath is the property list of the launch agent of a service
+func launchdPlistPath(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel(name)+".plist"), nil
+}
+
+// installService writes a launch agent for the user and loads it
+func installService(def serviceDefinition) error {
+	path, err := launchdPlistPath(def.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("service %s is already installed at %s, uninstall it first", def.Name, path)
+	}
+	for _, dir := range []string{filepath.Dir(path), filepath.Dir(def.LogFile)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, renderLaunchdPlist(def), 0o600); err != nil {
+		return err
+	}
+
+	if out, err := launchctl("bootstrap", launchdDomain(), path); err != nil {
+		os.Remove(path) //nolint:errcheck
+		return fmt.Errorf("launchctl bootstrap failed: %w: %s", err, out)
+	}
+	return nil
+}
+
+// uninstallService unloads the launch agent of a service and removes it
+func uninstallService(name string) error {
+	path, err := launchdPlistPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	// an agent that isn't loaded can still be removed
+	launchctl("bootout", launchdDomain()+"/"+launchdLabel(name)) //nolint:errcheck
+	return os.Remove(path)
+}
+
+// launchdDomain is the launchd domain of the agents of the user
+func launchdDomain() string {
+	return fmt.Sprintf("gui/%d", os.Getuid())
+}
+
+func launchctl(args ...string) ([]byte, error) {
+	return exec.Command("launchctl", args...).CombinedOutput()
+}
+
+// serviceContext returns ctx, as launchd stops agents with SIGTERM
+func serviceContext(ctx context.Context) (context.Context, func()) {
+	return ctx, func() {}
+}
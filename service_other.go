@@ -0,0 +1,47 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin && !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// serviceLogFlag is unused as services can't be installed on this OS
+const serviceLogFlag = false
+
+// errServiceUnsupported is returned by service install and uninstall on OSes
+// other than macOS and Windows
+var errServiceUnsupported = errors.New("services are only supported on macOS and Windows, use a systemd unit or a container running upload --schedule instead")
+
+func serviceLogFile(name string) string {
+	return ""
+}
+
+func installService(def serviceDefinition) error {
+	return errServiceUnsupported
+}
+
+func uninstallService(name string) error {
+	return errServiceUnsupported
+}
+
+// serviceContext returns ctx, as there is no service manager to report to
+func serviceContext(ctx context.Context) (context.Context, func()) {
+	return ctx, func() {}
+}
@@ -0,0 +1,116 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_newServiceDefinition(t *testing.T) {
+	def, err := newServiceDefinition("sboms", "0 2 * * *", []string{"-f", "/var/sboms", "--config", "/etc/kusari.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"upload", "-f", "/var/sboms", "--config", "/etc/kusari.yaml", "--schedule", "0 2 * * *"}
+	if !reflect.DeepEqual(def.Args[:len(want)], want) {
+		t.Errorf("Args = %q, want %q first", def.Args, want)
+	}
+	if def.Name != "sboms" || def.Executable == "" {
+		t.Errorf("newServiceDefinition() = %+v, want the name and the running binary", def)
+	}
+
+	tests := []struct {
+		name     string
+		schedule string
+		args     []string
+	}{
+		{name: "", schedule: "@daily"},
+		{name: `kusari\uploader`, schedule: "@daily"},
+		{name: "sboms"},
+		{name: "sboms", schedule: "61 * * * *"},
+		{name: "sboms", schedule: "@daily", args: []string{"-f", "/var/sboms", "--schedule=@hourly"}},
+		{name: "sboms", schedule: "@daily", args: []string{"-f", "/var/sboms", "--client-secret", "s3cret"}},
+		{name: "sboms", schedule: "@daily", args: []string{"-f", "/var/sboms", "-s", "s3cret"}},
+		{name: "sboms", schedule: "@daily", args: []string{"-f", "/var/sboms", "--token=eyJ"}},
+		{name: "sboms", schedule: "@daily", args: []string{"-f", "/var/sboms", "--proxy-password", "hunter2"}},
+	}
+	for _, tt := range tests {
+		if _, err := newServiceDefinition(tt.name, tt.schedule, tt.args); err == nil {
+			t.Errorf("newServiceDefinition(%q, %q, %q) expected an error", tt.name, tt.schedule, tt.args)
+		}
+	}
+}
+
+func Test_checkServiceSecrets(t *testing.T) {
+	allowed := [][]string{
+		{"-f", "/var/sboms", "--config", "/etc/kusari.yaml"},
+		{"--client-secret-file", "/etc/kusari/secret"},
+		{"--client-secret", "vault://secret/kusari#client-secret"},
+		{"-f", "/var/sboms", "--", "--token", "not a flag"},
+	}
+	for _, args := range allowed {
+		if err := checkServiceSecrets(args); err != nil {
+			t.Errorf("checkServiceSecrets(%q) error = %v", args, err)
+		}
+	}
+}
+
+func Test_flagValue(t *testing.T) {
+	args := []string{"--log-file", "a.log", "--log-file=b.log", "--", "--log-file", "c.log"}
+	if got := flagValue(args, "log-file"); got != "b.log" {
+		t.Errorf("flagValue() = %q, want the last value before --", got)
+	}
+	if got := flagValue(args, "config"); got != "" {
+		t.Errorf("flagValue() = %q for a flag that isn't set", got)
+	}
+}
+
+func Test_renderLaunchdPlist(t *testing.T) {
+	def := serviceDefinition{
+		Name:       "sboms",
+		Executable: "/usr/local/bin/kusari-uploader",
+		Args:       []string{"upload", "-f", "/Users/me/R&D <sboms>", "--schedule", "@daily"},
+		LogFile:    "/Users/me/Library/Logs/kusari-uploader/sboms.log",
+	}
+	plist := renderLaunchdPlist(def)
+
+	var parsed struct {
+		Dict struct {
+			Keys    []string `xml:"key"`
+			Strings []string `xml:"string"`
+			Args    []string `xml:"array>string"`
+		} `xml:"dict"`
+	}
+	decoder := xml.NewDecoder(strings.NewReader(string(plist)))
+	decoder.Strict = false
+	if err := decoder.Decode(&parsed); err != nil {
+		t.Fatalf("renderLaunchdPlist() is not valid XML: %v\n%s", err, plist)
+	}
+	if want := append([]string{def.Executable}, def.Args...); !reflect.DeepEqual(parsed.Dict.Args, want) {
+		t.Errorf("ProgramArguments = %q, want %q", parsed.Dict.Args, want)
+	}
+	if want := []string{"com.kusari.sboms", def.LogFile, def.LogFile}; !reflect.DeepEqual(parsed.Dict.Strings, want) {
+		t.Errorf("strings = %q, want the label and log file", parsed.Dict.Strings)
+	}
+	for _, key := range []string{"RunAtLoad", "KeepAlive", "ThrottleInterval"} {
+		if !strings.Contains(string(plist), "<key>"+key+"</key>") {
+			t.Errorf("renderLaunchdPlist() has no %s", key)
+		}
+	}
+}
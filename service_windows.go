@@ -0,0 +1,163 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceLogFlag is true as Windows services have no output
+const serviceLogFlag = true
+
+// serviceRestartDelay is how long the service manager waits before restarting
+// a service that stopped unexpectedly
+const serviceRestartDelay = time.Minute
+
+// serviceLogFile is the log file of a service, in the program data of the machine
+func serviceLogFile(name string) string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "kusari-uploader", name+".log")
+}
+
+// installService creates a service that starts at boot and is restarted when
+// it fails, and starts it
+func installService(def serviceDefinition) error {
+	if err := os.MkdirAll(filepath.Dir(def.LogFile), 0o755); err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager, run as administrator: %w", err)
+	}
+	defer m.Disconnect() //nolint:errcheck
+
+	if s, err := m.OpenService(def.Name); err == nil {
+		s.Close() //nolint:errcheck
+		return fmt.Errorf("service %s already exists, uninstall it first", def.Name)
+	}
+	s, err := m.CreateService(def.Name, def.Executable, mgr.Config{
+		DisplayName: "Kusari Uploader (" + def.Name + ")",
+		Description: "Uploads documents to the Kusari Platform on a schedule",
+		StartType:   mgr.StartAutomatic,
+	}, def.Args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close() //nolint:errcheck
+
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: serviceRestartDelay}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, uint32((24 * time.Hour).Seconds())); err != nil {
+		s.Delete() //nolint:errcheck
+		return fmt.Errorf("failed to set the restart policy: %w", err)
+	}
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("service %s was installed but failed to start: %w", def.Name, err)
+	}
+	return nil
+}
+
+// uninstallService stops a service and deletes it
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager, run as administrator: %w", err)
+	}
+	defer m.Disconnect() //nolint:errcheck
+
+	s, err := m.OpenService(name)
+	if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close() //nolint:errcheck
+
+	// a service that isn't running can still be deleted
+	s.Control(svc.Stop) //nolint:errcheck
+	return s.Delete()
+}
+
+// serviceContext returns a context that is canceled when the service manager
+// stops the service, if the uploader runs as a Windows service, and a function
+// to call once the service stopped its work
+func serviceContext(ctx context.Context) (context.Context, func()) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		if err := svc.Run("", &serviceHandler{cancel: cancel, done: done}); err != nil {
+			log.Error().
+				Err(err).
+				Msg("Service failed")
+			cancel()
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		<-exited
+	}
+}
+
+// serviceHandler reports the state of the service to the service manager and
+// cancels the scheduled uploads when it is stopped
+type serviceHandler struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-h.done:
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.cancel()
+				<-h.done
+				return false, 0
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// resolveSoftwareID looks up the software ID of the alias, or else the
+// component name, of uploadMeta and records it in uploadMeta, if
+// --resolve-software-id is set, so the platform attaches the documents to that
+// software rather than to the one an sbom-subject substring matches. Software
+// that does not exist yet is created by the upload, unless required is set.
+func resolveSoftwareID(ctx context.Context, client HttpClient, tenantEndpoint string, uploadMeta map[string]string, required bool) error {
+	if !viper.GetBool("resolve-software-id") || uploadMeta["software_id"] != "" {
+		return nil
+	}
+
+	name := uploadMeta["alias"]
+	if name == "" {
+		name = uploadMeta["component_name"]
+	}
+	if name == "" {
+		return errors.New("resolve-software-id requires alias or component-name")
+	}
+
+	ids, err := lookupSoftwareAndSbomID(ctx, client, tenantEndpoint, name, "")
+	if err != nil {
+		return err
+	}
+	if ids == nil {
+		if required {
+			return fmt.Errorf("no software found for %s", name)
+		}
+		log.Warn().
+			Str("software", name).
+			Msg("No software found to resolve the software ID of, it is created by the upload")
+		return nil
+	}
+
+	uploadMeta["software_id"] = strconv.FormatInt(ids.SoftwareID, 10)
+	log.Info().
+		Str("software", name).
+		Int64("softwareID", ids.SoftwareID).
+		Msg("Resolved software ID")
+
+	return nil
+}
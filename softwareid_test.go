@@ -0,0 +1,106 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_resolveSoftwareID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pico/v1/software/id" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.URL.Query().Get("software_name") {
+		case "payments-api":
+			_, _ = w.Write([]byte(`{"software_id": 12, "sbom_id": 120}`))
+		case "api":
+			_, _ = w.Write([]byte(`{"software_id": 34, "sbom_id": 340}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		disabled bool
+		meta     map[string]string
+		required bool
+		want     map[string]string
+		wantErr  bool
+	}{
+		{
+			name: "alias",
+			meta: map[string]string{"alias": "payments-api", "component_name": "api"},
+			want: map[string]string{"alias": "payments-api", "component_name": "api", "software_id": "12"},
+		},
+		{
+			name: "component name",
+			meta: map[string]string{"component_name": "api"},
+			want: map[string]string{"component_name": "api", "software_id": "34"},
+		},
+		{
+			name: "software ID given",
+			meta: map[string]string{"alias": "payments-api", "software_id": "5"},
+			want: map[string]string{"alias": "payments-api", "software_id": "5"},
+		},
+		{
+			name: "not found",
+			meta: map[string]string{"alias": "new"},
+			want: map[string]string{"alias": "new"},
+		},
+		{
+			name:     "not found but required",
+			meta:     map[string]string{"alias": "new"},
+			required: true,
+			wantErr:  true,
+		},
+		{
+			name:    "no name",
+			meta:    map[string]string{"tag": "backend"},
+			wantErr: true,
+		},
+		{
+			name:     "disabled",
+			disabled: true,
+			meta:     map[string]string{"alias": "payments-api"},
+			want:     map[string]string{"alias": "payments-api"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			viper.Set("resolve-software-id", !tt.disabled)
+
+			err := resolveSoftwareID(context.Background(), srv.Client(), srv.URL, tt.meta, tt.required)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSoftwareID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.meta, tt.want) {
+				t.Errorf("resolveSoftwareID() meta = %v, want %v", tt.meta, tt.want)
+			}
+		})
+	}
+}
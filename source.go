@@ -0,0 +1,166 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kusaridev/kusari-uploader/pkg/source"
+)
+
+// isGlobPattern reports whether a --file-path is a glob pattern rather than a
+// file or directory
+func isGlobPattern(p string) bool {
+	return strings.ContainsAny(p, "*?")
+}
+
+// expandGlob returns the files matching a glob pattern such as
+// "build/**/*.cdx.json", in lexical order. The pattern is matched like the
+// patterns of routing rules, so ** matches any number of directories.
+func expandGlob(pattern string) ([]string, error) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	root := path.Dir(pattern[:strings.IndexAny(pattern, "*?")+1])
+	re, err := compilePathPattern(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+
+	var files []string
+	err = filepath.WalkDir(filepath.FromSlash(root), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && re.MatchString(filepath.ToSlash(p)) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// objectStoreSource returns the objects below the prefix of an
+// s3://bucket/prefix?region=...&endpoint=... URL, listing a page of keys at a
+// time. Keys ending in / are folder markers and are skipped.
+type objectStoreSource struct {
+	store  *s3Store
+	prefix string
+	keys   []string
+	token  string
+	done   bool
+}
+
+// s3ListResult is the response of the S3 ListObjectsV2 API
+type s3ListResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func newObjectStoreSource(raw string) (*objectStoreSource, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 URL %q: %w", raw, err)
+	}
+	if u.Scheme != "s3" {
+		return nil, fmt.Errorf("invalid S3 URL %q, must start with s3://", u.Redacted())
+	}
+	store, err := newS3Store(u, "")
+	if err != nil {
+		return nil, err
+	}
+	prefix := store.prefix
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &objectStoreSource{store: store, prefix: prefix}, nil
+}
+
+func (s *objectStoreSource) Next(ctx context.Context) (source.Item, error) {
+	for len(s.keys) == 0 {
+		if s.done {
+			return source.Item{}, io.EOF
+		}
+		if err := s.list(ctx); err != nil {
+			return source.Item{}, err
+		}
+	}
+
+	key := s.keys[0]
+	s.keys = s.keys[1:]
+	return source.Item{
+		Path:    "s3://" + s.store.bucket + "/" + key,
+		RelPath: strings.TrimPrefix(key, s.prefix),
+		Read: func(ctx context.Context) ([]byte, error) {
+			return s.get(ctx, key)
+		},
+	}, nil
+}
+
+// list fetches the next page of keys
+func (s *objectStoreSource) list(ctx context.Context) error {
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+	if s.token != "" {
+		query.Set("continuation-token", s.token)
+	}
+	res, err := s.store.request(ctx, http.MethodGet, "", query, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status code listing s3://%s/%s: %d", s.store.bucket, s.prefix, res.StatusCode)
+	}
+
+	var page s3ListResult
+	if err := xml.NewDecoder(res.Body).Decode(&page); err != nil {
+		return fmt.Errorf("error decoding the object list of s3://%s/%s: %w", s.store.bucket, s.prefix, err)
+	}
+	for _, object := range page.Contents {
+		if !strings.HasSuffix(object.Key, "/") {
+			s.keys = append(s.keys, object.Key)
+		}
+	}
+	s.token = page.NextContinuationToken
+	s.done = !page.IsTruncated || s.token == ""
+	return nil
+}
+
+// get downloads the object of key
+func (s *objectStoreSource) get(ctx context.Context, key string) ([]byte, error) {
+	res, err := s.store.request(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status code for s3://%s/%s: %d", s.store.bucket, key, res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
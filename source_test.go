@@ -0,0 +1,142 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeSourceFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_expandGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeSourceFiles(t, dir, "app.cdx.json", "app.spdx.json", "svc/api/bom.cdx.json", "svc/web/bom.cdx.json", "svc/readme.md")
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "*.cdx.json", want: []string{"app.cdx.json"}},
+		{pattern: "app.*.json", want: []string{"app.cdx.json", "app.spdx.json"}},
+		{pattern: "svc/*/bom.cdx.json", want: []string{"svc/api/bom.cdx.json", "svc/web/bom.cdx.json"}},
+		{pattern: "**.cdx.json", want: []string{"app.cdx.json", "svc/api/bom.cdx.json", "svc/web/bom.cdx.json"}},
+		{pattern: "svc/a?i/*", want: []string{"svc/api/bom.cdx.json"}},
+		{pattern: "*.xml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := expandGlob(filepath.Join(dir, tt.pattern))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, name := range tt.want {
+				want = append(want, filepath.Join(dir, filepath.FromSlash(name)))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expandGlob() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func Test_objectStoreSource(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	keys := []string{"sboms/", "sboms/a.json", "sboms/b.json", "sboms/svc/c.json"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/bucket" {
+			fmt.Fprint(w, "content of "+strings.TrimPrefix(r.URL.Path, "/bucket/"))
+			return
+		}
+		// two keys per page
+		q := r.URL.Query()
+		if q.Get("list-type") != "2" || q.Get("prefix") != "sboms/" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		start := 0
+		if token := q.Get("continuation-token"); token != "" {
+			fmt.Sscan(token, &start) //nolint:errcheck
+		}
+		end := min(start+2, len(keys))
+		fmt.Fprint(w, "<ListBucketResult>")
+		for _, key := range keys[start:end] {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>1</Size></Contents>", key)
+		}
+		if end < len(keys) {
+			fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	}))
+	defer srv.Close()
+
+	source, err := newObjectStoreSource("s3://bucket/sboms?region=us-east-1&endpoint=" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.store.client = srv.Client()
+
+	var relPaths []string
+	for {
+		item, err := source.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		data, err := item.Content(context.Background())
+		if want := "content of " + strings.TrimPrefix(item.Path, "s3://bucket/"); err != nil || string(data) != want {
+			t.Errorf("Content() of %s = %q, %v, want %q", item.Path, data, err, want)
+		}
+		relPaths = append(relPaths, item.RelPath)
+	}
+	if want := []string{"a.json", "b.json", "svc/c.json"}; !reflect.DeepEqual(relPaths, want) {
+		t.Errorf("objectStoreSource = %v, want %v", relPaths, want)
+	}
+
+	if _, err := newObjectStoreSource("s3://bucket"); err == nil {
+		t.Errorf("newObjectStoreSource() without a region expected an error")
+	}
+}
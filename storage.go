@@ -0,0 +1,487 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// errStateNotFound is returned by state stores for keys that were never stored
+var errStateNotFound = errors.New("not found in the state store")
+
+// stateStore persists the state of the uploader between runs: the progress of
+// backfills, cached enrichment API responses and the last run report. By
+// default they are files on the local disk, and --state-store moves them to
+// S3 or Redis so that ephemeral CI runners can share them and resume each
+// other's work.
+type stateStore interface {
+	// Get returns the data stored under key, or errStateNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put replaces the data stored under key
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// openStateStore returns the store of one kind of state. Without
+// --state-store the state is kept in localDir, which is the current directory
+// if empty. Otherwise it is kept under area in the configured store, e.g.
+// s3://bucket/prefix/<area>/<key>.
+func openStateStore(localDir, area string) (stateStore, error) {
+	raw := viper.GetString("state-store")
+	if raw == "" {
+		return &fileStore{dir: localDir}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state-store %q: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid state-store %q, file stores need a path such as file:///var/lib/kusari-uploader", u.Redacted())
+		}
+		return &fileStore{dir: filepath.Join(filepath.FromSlash(u.Path), area)}, nil
+	case "s3":
+		return newS3Store(u, area)
+	case "redis", "rediss":
+		return newRedisStore(u, area)
+	default:
+		return nil, fmt.Errorf("invalid state-store %q, must be a file://, s3://, redis:// or rediss:// URL", u.Redacted())
+	}
+}
+
+// storeKey joins a key prefix, area and key with slashes
+func storeKey(prefix, area, key string) string {
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	return strings.TrimPrefix(prefix+"/"+area+"/"+key, "/")
+}
+
+// fileStore keeps state in files below dir
+type fileStore struct {
+	dir string
+}
+
+func (s *fileStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *fileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", s.path(key), errStateNotFound)
+	}
+	return data, err
+}
+
+// Put writes the data to a temporary file first so that an interrupted write
+// never leaves a truncated file behind, and concurrent runs never read one
+func (s *fileStore) Put(ctx context.Context, key string, data []byte) error {
+	p := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// awsCredentials are the credentials S3 requests are signed with
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// s3Store keeps state as objects of an S3 bucket, or of a bucket of an S3
+// compatible service with the endpoint query parameter
+type s3Store struct {
+	client   HttpClient
+	endpoint string
+	bucket   string
+	prefix   string
+	area     string
+	region   string
+	creds    awsCredentials
+	now      func() time.Time
+}
+
+// newS3Store returns the store of an s3://bucket/prefix?region=...&endpoint=...
+// URL. The credentials are read from the standard AWS environment variables.
+func newS3Store(u *url.URL, area string) (*s3Store, error) {
+	q := u.Query()
+	region := q.Get("region")
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = os.Getenv(env)
+		}
+	}
+	if u.Host == "" || region == "" {
+		return nil, fmt.Errorf("invalid S3 URL %q, it needs a bucket and a region, e.g. s3://bucket/prefix?region=us-east-1", u.Redacted())
+	}
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("S3 access needs the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	}
+	endpoint := q.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	client, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &s3Store{
+		client:   client,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		area:     area,
+		region:   region,
+		creds:    creds,
+		now:      time.Now,
+	}, nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	res, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("s3://%s/%s: %w", s.bucket, storeKey(s.prefix, s.area, key), errStateNotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status code for s3://%s/%s: %d", s.bucket, storeKey(s.prefix, s.area, key), res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, data []byte) error {
+	res, err := s.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close() //nolint:errcheck
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status code for s3://%s/%s: %d", s.bucket, storeKey(s.prefix, s.area, key), res.StatusCode)
+	}
+	return nil
+}
+
+// do makes a path style request for the object of key
+func (s *s3Store) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	return s.request(ctx, method, storeKey(s.prefix, s.area, key), nil, body)
+}
+
+// request makes a path style request for the object with the full key
+// objectKey, or for the bucket itself when objectKey is empty
+func (s *s3Store) request(ctx context.Context, method, objectKey string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Path = path.Join(req.URL.Path, "/"+s.bucket, objectKey)
+	req.URL.RawPath = awsURIEncode(req.URL.Path, false)
+	req.URL.RawQuery = canonicalQuery(query)
+	signAWSv4(req, body, s.region, "s3", s.creds, s.now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to S3: %w", err)
+	}
+	return res, nil
+}
+
+// signAWSv4 signs a request with AWS Signature Version 4, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signAWSv4(req *http.Request, body []byte, region, service string, creds awsCredentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); name == "host" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalPath := req.URL.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, canonicalPath, canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestSum[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery encodes query sorted by key and value, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes every byte of s except the unreserved
+// characters, and slashes unless encodeSlash is set
+func awsURIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			sb.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// redisStore keeps state as string keys of a Redis database
+type redisStore struct {
+	addr     string
+	tls      bool
+	username string
+	password string
+	db       int
+	prefix   string
+	area     string
+}
+
+// newRedisStore returns the store of a redis://[user:password@]host:port/db?prefix=...
+// URL, or rediss:// for TLS. Keys are prefixed with kusari-uploader unless the
+// prefix query parameter is set.
+func newRedisStore(u *url.URL, area string) (*redisStore, error) {
+	s := &redisStore{
+		addr:   u.Host,
+		tls:    u.Scheme == "rediss",
+		prefix: "kusari-uploader",
+		area:   area,
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid state-store %q, the path of Redis stores is the database number", u.Redacted())
+		}
+		s.db = n
+	}
+	if prefix, ok := u.Query()["prefix"]; ok {
+		s.prefix = strings.Trim(prefix[0], "/")
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid state-store %q, Redis stores need a host", u.Redacted())
+	}
+	return s, nil
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	key = storeKey(s.prefix, s.area, key)
+	reply, err := s.do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, fmt.Errorf("redis key %s: %w", key, errStateNotFound)
+	}
+	return reply, nil
+}
+
+func (s *redisStore) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.do(ctx, "SET", storeKey(s.prefix, s.area, key), string(data))
+	return err
+}
+
+// do runs a command on a new connection, after authenticating and selecting
+// the database, and returns its reply
+func (s *redisStore) do(ctx context.Context, args ...string) ([]byte, error) {
+	var dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	} = &net.Dialer{}
+	if s.tls {
+		dialer = &tls.Dialer{}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the Redis state store: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	conn.SetDeadline(deadline) //nolint:errcheck
+
+	var commands [][]string
+	switch {
+	case s.password != "" && s.username != "":
+		commands = append(commands, []string{"AUTH", s.username, s.password})
+	case s.password != "":
+		commands = append(commands, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	commands = append(commands, args)
+
+	var buf bytes.Buffer
+	for _, command := range commands {
+		fmt.Fprintf(&buf, "*%d\r\n", len(command))
+		for _, arg := range command {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("error writing to the Redis state store: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	var reply []byte
+	for _, command := range commands {
+		reply, err = readRedisReply(r)
+		if err != nil {
+			return nil, fmt.Errorf("redis %s: %w", command[0], err)
+		}
+	}
+	return reply, nil
+}
+
+// readRedisReply reads a simple string, error, integer or bulk string reply.
+// A nil bulk string is returned as nil.
+func readRedisReply(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk string length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	default:
+		return nil, fmt.Errorf("unexpected reply %q", line)
+	}
+}
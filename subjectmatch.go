@@ -0,0 +1,154 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// subjectMatch* are the ways --subject-match matches sbom-subject against the
+// names of the platform's software
+const (
+	subjectMatchSubstring = "substring"
+	subjectMatchExact     = "exact"
+	subjectMatchRegex     = "regex"
+)
+
+// platformSoftware is a software of the tenant as listed by pico/v1/software
+type platformSoftware struct {
+	SoftwareID int64  `json:"software_id"`
+	Name       string `json:"name"`
+}
+
+type softwareList struct {
+	Software []platformSoftware `json:"software"`
+}
+
+// compileSubjectMatch returns the function matching software names against
+// subject the way mode does
+func compileSubjectMatch(mode, subject string) (func(name string) bool, error) {
+	switch mode {
+	case subjectMatchSubstring:
+		return func(name string) bool { return strings.Contains(name, subject) }, nil
+	case subjectMatchExact:
+		return func(name string) bool { return name == subject }, nil
+	case subjectMatchRegex:
+		re, err := regexp.Compile(subject)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sbom-subject regex: %w", err)
+		}
+		return re.MatchString, nil
+	default:
+		return nil, fmt.Errorf("unknown subject-match %q, must be %s, %s or %s", mode, subjectMatchSubstring, subjectMatchExact, subjectMatchRegex)
+	}
+}
+
+// matchingSoftware returns the software of the tenant whose names match
+func matchingSoftware(ctx context.Context, client HttpClient, tenantEndpoint string, match func(name string) bool) ([]platformSoftware, error) {
+	var matches []platformSoftware
+	err := forEachPage(ctx, client, tenantEndpoint, "pico/v1/software", defaultPageSize, func(body []byte) (bool, error) {
+		var list softwareList
+		if err := json.Unmarshal(body, &list); err != nil {
+			return false, fmt.Errorf("error unmarshaling response body for software: %w", err)
+		}
+		for _, software := range list.Software {
+			if match(software.Name) {
+				matches = append(matches, software)
+			}
+		}
+		return true, nil
+	})
+	return matches, err
+}
+
+// softwareNames returns the names of software for messages
+func softwareNames(software []platformSoftware) string {
+	names := make([]string, len(software))
+	for i, s := range software {
+		names[i] = s.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// resolveSbomSubject matches the sbom-subject of uploadMeta against the
+// platform's software with --subject-match. A substring that matches several
+// software is warned about, as the platform attaches the documents to only one
+// of them. An exact or regex subject must match one software, whose ID
+// replaces the subject in uploadMeta, so the platform doesn't match it as a
+// substring again.
+func resolveSbomSubject(ctx context.Context, client HttpClient, tenantEndpoint string, uploadMeta map[string]string) error {
+	mode := viper.GetString("subject-match")
+	subject := uploadMeta["sbom_subject"]
+	if subject == "" {
+		if mode != subjectMatchSubstring {
+			return fmt.Errorf("subject-match %s requires sbom-subject", mode)
+		}
+		return nil
+	}
+
+	match, err := compileSubjectMatch(mode, subject)
+	if err != nil {
+		return err
+	}
+	matches, err := matchingSoftware(ctx, client, tenantEndpoint, match)
+	if mode == subjectMatchSubstring {
+		if err != nil {
+			// the platform matches the substring itself, as it always has
+			log.Debug().
+				Err(err).
+				Msg("Could not check which software the sbom-subject matches")
+		} else if len(matches) > 1 {
+			log.Warn().
+				Str("sbomSubject", subject).
+				Str("software", softwareNames(matches)).
+				Msg("sbom-subject matches several software and the documents are attached to one of them, use --subject-match exact or regex to choose")
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("no software matches sbom-subject %s", subject)
+	case 1:
+	default:
+		return fmt.Errorf("sbom-subject %s matches %d software: %s", subject, len(matches), softwareNames(matches))
+	}
+
+	softwareID := strconv.FormatInt(matches[0].SoftwareID, 10)
+	if current := uploadMeta["software_id"]; current != "" && current != softwareID {
+		return fmt.Errorf("sbom-subject %s matches software %s with ID %s, not software-id %s", subject, matches[0].Name, softwareID, current)
+	}
+	uploadMeta["software_id"] = softwareID
+	delete(uploadMeta, "sbom_subject")
+	log.Info().
+		Str("sbomSubject", subject).
+		Str("software", matches[0].Name).
+		Int64("softwareID", matches[0].SoftwareID).
+		Msg("Resolved sbom-subject")
+
+	return nil
+}
@@ -0,0 +1,142 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_compileSubjectMatch(t *testing.T) {
+	tests := []struct {
+		mode    string
+		subject string
+		name    string
+		want    bool
+		wantErr bool
+	}{
+		{mode: subjectMatchSubstring, subject: "api", name: "payments-api", want: true},
+		{mode: subjectMatchExact, subject: "api", name: "payments-api", want: false},
+		{mode: subjectMatchExact, subject: "api", name: "api", want: true},
+		{mode: subjectMatchRegex, subject: "^payments-", name: "payments-api", want: true},
+		{mode: subjectMatchRegex, subject: "^api$", name: "payments-api", want: false},
+		{mode: subjectMatchRegex, subject: "(", wantErr: true},
+		{mode: "fuzzy", subject: "api", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.subject, func(t *testing.T) {
+			match, err := compileSubjectMatch(tt.mode, tt.subject)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileSubjectMatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && match(tt.name) != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.name, !tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveSbomSubject(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/pico/v1/software" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("cursor") == "" {
+			_, _ = w.Write([]byte(`{"software": [{"software_id": 1, "name": "api"}, {"software_id": 2, "name": "payments-api"}], "next_cursor": "2"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"software": [{"software_id": 3, "name": "web"}]}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		mode    string
+		meta    map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "substring keeps the subject",
+			mode: subjectMatchSubstring,
+			meta: map[string]string{"sbom_subject": "api"},
+			want: map[string]string{"sbom_subject": "api"},
+		},
+		{
+			name: "exact",
+			mode: subjectMatchExact,
+			meta: map[string]string{"sbom_subject": "api"},
+			want: map[string]string{"software_id": "1"},
+		},
+		{
+			name: "regex on a later page",
+			mode: subjectMatchRegex,
+			meta: map[string]string{"sbom_subject": "^w"},
+			want: map[string]string{"software_id": "3"},
+		},
+		{
+			name:    "regex matching several",
+			mode:    subjectMatchRegex,
+			meta:    map[string]string{"sbom_subject": "api$"},
+			wantErr: true,
+		},
+		{
+			name:    "exact matching none",
+			mode:    subjectMatchExact,
+			meta:    map[string]string{"sbom_subject": "ap"},
+			wantErr: true,
+		},
+		{
+			name:    "conflicting software ID",
+			mode:    subjectMatchExact,
+			meta:    map[string]string{"sbom_subject": "api", "software_id": "2"},
+			wantErr: true,
+		},
+		{
+			name:    "exact without subject",
+			mode:    subjectMatchExact,
+			meta:    map[string]string{},
+			wantErr: true,
+		},
+		{
+			name: "substring without subject",
+			mode: subjectMatchSubstring,
+			meta: map[string]string{},
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			viper.Set("subject-match", tt.mode)
+
+			err := resolveSbomSubject(context.Background(), srv.Client(), srv.URL, tt.meta)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveSbomSubject() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(tt.meta, tt.want) {
+				t.Errorf("resolveSbomSubject() meta = %v, want %v", tt.meta, tt.want)
+			}
+		})
+	}
+}
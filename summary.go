@@ -0,0 +1,119 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// uploadSummary collects the results of the files of a directory or file list
+// upload so they can be summarized at the end of the run
+type uploadSummary struct {
+	mu      sync.Mutex
+	start   time.Time
+	results []fileResult
+	counts  map[string]int
+	sboms   []reportSBOM
+}
+
+func newUploadSummary(start time.Time) *uploadSummary {
+	return &uploadSummary{start: start, counts: map[string]int{}}
+}
+
+// add records the result of a file and shows the progress of the run in the
+// status line of terminals
+func (s *uploadSummary) add(r fileResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, r)
+	s.counts[r.Status]++
+	progress.set(progressText(s.counts))
+}
+
+// count returns the number of files with status
+func (s *uploadSummary) count(status string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[status]
+}
+
+// addSBOMs records the uploaded SBOMs that have a subject for the run report
+func (s *uploadSummary) addSBOMs(ssaus []sbomSubjectAndURI) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ssau := range ssaus {
+		if ssau.subject == "" && ssau.uri == "" {
+			continue
+		}
+		s.sboms = append(s.sboms, reportSBOM{Subject: ssau.subject, URI: ssau.uri, DocumentRef: ssau.docRef})
+	}
+}
+
+// printUploadSummary writes the totals of the run and a table of its files
+// with the reasons files were skipped or failed. Failed files are listed
+// first, then quarantined, skipped and uploaded files.
+func printUploadSummary(w io.Writer, s *uploadSummary, now time.Time) {
+	progress.clear()
+	color := colorEnabled(w)
+
+	s.mu.Lock()
+	results := append([]fileResult(nil), s.results...)
+	s.mu.Unlock()
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	order := map[string]int{resultFailed: 0, resultQuarantined: 1, resultSkipped: 2, resultUploaded: 3}
+	sort.SliceStable(results, func(i, j int) bool {
+		if order[results[i].Status] != order[results[j].Status] {
+			return order[results[i].Status] < order[results[j].Status]
+		}
+		return results[i].Path < results[j].Path
+	})
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Upload summary")
+	fmt.Fprintf(w, "  Uploaded:          %d\n", counts[resultUploaded])
+	fmt.Fprintf(w, "  Skipped (empty):   %d\n", counts[resultSkipped])
+	fmt.Fprintf(w, "  Failed:            %d\n", counts[resultFailed])
+	if counts[resultQuarantined] > 0 {
+		fmt.Fprintf(w, "  Quarantined:       %d\n", counts[resultQuarantined])
+	}
+	fmt.Fprintf(w, "  Total:             %d\n", len(results))
+	fmt.Fprintf(w, "  Elapsed:           %s\n", now.Sub(s.start).Round(time.Millisecond))
+
+	if len(results) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  FILE\t%s\tREASON\n", paintStatus("STATUS", color))
+	for _, r := range results {
+		reason := r.Error
+		if r.Status == resultSkipped && reason == "" {
+			reason = "empty file"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", r.Path, paintStatus(r.Status, color), reason)
+	}
+	tw.Flush() //nolint:errcheck
+}
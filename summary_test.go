@@ -0,0 +1,71 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_printUploadSummary(t *testing.T) {
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	summary := newUploadSummary(start)
+
+	// results reach the summary through the stream, also for text output
+	var results *resultStream
+	results = results.withSummary(summary)
+	results.write(fileResult{Path: "sboms/b.json", Status: resultUploaded})
+	results.write(fileResult{Path: "sboms/a.json", Status: resultUploaded})
+	results.write(fileResult{Path: "sboms/empty.json", Status: resultSkipped})
+	results.write(fileResult{Path: "sboms/c.json", Status: resultFailed, Error: "unexpected status code: 500"})
+
+	var buf bytes.Buffer
+	printUploadSummary(&buf, summary, start.Add(4200*time.Millisecond))
+	out := buf.String()
+
+	for _, want := range []string{
+		"Uploaded:          2",
+		"Skipped (empty):   1",
+		"Failed:            1",
+		"Total:             4",
+		"Elapsed:           4.2s",
+		"empty file",
+		"unexpected status code: 500",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("printUploadSummary() output does not contain %q:\n%s", want, out)
+		}
+	}
+
+	// failed files come first, then skipped and uploaded files by path
+	order := []string{"sboms/c.json", "sboms/empty.json", "sboms/a.json", "sboms/b.json"}
+	for i := 1; i < len(order); i++ {
+		if strings.Index(out, order[i-1]) > strings.Index(out, order[i]) {
+			t.Errorf("printUploadSummary() lists %s after %s:\n%s", order[i-1], order[i], out)
+		}
+	}
+}
+
+func Test_printUploadSummary_empty(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Now()
+	printUploadSummary(&buf, newUploadSummary(now), now)
+	if out := buf.String(); !strings.Contains(out, "Total:             0") || strings.Contains(out, "FILE") {
+		t.Errorf("printUploadSummary() of an empty run = %q, want totals without a table", out)
+	}
+}
@@ -0,0 +1,321 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultSupportBundle is the archive support-bundle writes without an argument
+	defaultSupportBundle = "kusari-uploader-support.tar.gz"
+	// runReportName is the file in the cache directory the report of the last
+	// directory, file list or bundle upload is saved to
+	runReportName = "last-run.json"
+	// redacted replaces secrets in support bundles
+	redacted = "REDACTED"
+)
+
+// proxyEnvVars are the proxy settings included in support bundles, as they
+// often explain connection problems
+var proxyEnvVars = []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "NO_PROXY", "no_proxy"}
+
+// runReport is the report of an upload run, saved so that it can be sent to
+// support after the fact
+type runReport struct {
+	RunID      string    `json:"run_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Uploaded   int       `json:"uploaded"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+	// Quarantined files are also listed in Results with their failure reason
+	Quarantined int          `json:"quarantined,omitempty"`
+	Results     []fileResult `json:"results"`
+	// SBOMs are the uploaded SBOMs with a subject, which report verify runs
+	// the blocked package check for again
+	SBOMs []reportSBOM `json:"sboms,omitempty"`
+}
+
+// reportSBOM is an uploaded SBOM of a run report
+type reportSBOM struct {
+	Subject     string `json:"subject"`
+	URI         string `json:"uri"`
+	DocumentRef string `json:"document_ref,omitempty"`
+}
+
+// supportSetting is a setting of the uploader and where it came from
+type supportSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// supportFile is a file of a support bundle
+type supportFile struct {
+	Name string
+	Data []byte
+}
+
+func newSupportBundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "support-bundle [bundle.tar.gz]",
+		Short: "Gather diagnostics for Kusari support into one archive",
+		Long: "Gather the version, the configuration with secrets redacted, the doctor results, the most recent " +
+			"entries of --log-file and the report of the last directory, file list or bundle upload into one archive " +
+			"to attach to a support request. Writes " + defaultSupportBundle + " unless a path is given.",
+		Args: cobra.MaximumNArgs(1),
+		Run:  supportBundle,
+	}
+
+	cmd.Flags().Bool("skip-doctor", false, "Do not run the doctor checks, e.g. on a host without network access")
+	cmd.Flags().Int("log-lines", 1000, "Number of most recent lines of --log-file to include")
+
+	mustBindPFlag(cmd, "skip-doctor")
+	mustBindPFlag(cmd, "log-lines")
+
+	return cmd
+}
+
+func supportBundle(cmd *cobra.Command, args []string) {
+	bundlePath := defaultSupportBundle
+	if len(args) > 0 {
+		bundlePath = args[0]
+	}
+
+	files := collectSupportFiles(context.Background(), cmd.Root())
+
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Error creating support bundle")
+	}
+	err = writeSupportBundle(f, time.Now(), files)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(bundlePath) //nolint:errcheck
+		log.Fatal().
+			Err(err).
+			Msg("Error writing support bundle")
+	}
+
+	fmt.Printf("Wrote support bundle to %s:\n", bundlePath)
+	for _, file := range files {
+		fmt.Printf("  %s\n", file.Name)
+	}
+	fmt.Println("Secrets are redacted, but review the contents before sending it.")
+}
+
+// collectSupportFiles gathers the contents of a support bundle. Parts that
+// can't be gathered are left out with a warning rather than failing the bundle.
+func collectSupportFiles(ctx context.Context, root *cobra.Command) []supportFile {
+	var files []supportFile
+	addJSON := func(name string, v any) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			log.Warn().Err(err).Str("file", name).Msg("Leaving file out of support bundle")
+			return
+		}
+		files = append(files, supportFile{Name: name, Data: append(data, '\n')})
+	}
+
+	addJSON("version.json", getVersionInfo())
+	addJSON("config.json", map[string]any{
+		"config_file": viper.ConfigFileUsed(),
+		"settings":    supportSettings(root),
+	})
+
+	if !viper.GetBool("skip-doctor") {
+		addJSON("doctor.json", doctorChecks(ctx, root.PersistentFlags()))
+	}
+
+	if logFile := viper.GetString("log-file"); logFile != "" {
+		data, err := tailLines(logFile, viper.GetInt("log-lines"))
+		if err != nil {
+			log.Warn().Err(err).Msg("Leaving log file out of support bundle")
+		} else {
+			files = append(files, supportFile{Name: "log.ndjson", Data: data})
+		}
+	}
+
+	if report, err := loadRunReport(); err == nil {
+		addJSON(runReportName, report)
+	} else if !os.IsNotExist(err) {
+		log.Warn().Err(err).Msg("Leaving last run report out of support bundle")
+	}
+
+	return files
+}
+
+// writeSupportBundle writes files as a gzipped tarball
+func writeSupportBundle(w io.Writer, now time.Time, files []supportFile) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		if err := writeTarFile(tw, file.Name, file.Data, now); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// supportSettings returns every flag of root and its subcommands that is set,
+// with secrets redacted, followed by the proxy environment variables
+func supportSettings(root *cobra.Command) []supportSetting {
+	var settings []supportSetting
+	seen := map[string]bool{"help": true}
+	add := func(flags *pflag.FlagSet) {
+		flags.VisitAll(func(f *pflag.Flag) {
+			if seen[f.Name] {
+				return
+			}
+			seen[f.Name] = true
+			if value, source := lookupSetting(flags, f.Name); source != "" {
+				settings = append(settings, supportSetting{Name: f.Name, Value: redactSetting(f.Name, value), Source: source})
+			}
+		})
+	}
+
+	add(root.PersistentFlags())
+	for _, cmd := range root.Commands() {
+		add(cmd.LocalFlags())
+	}
+
+	for _, env := range proxyEnvVars {
+		if value := os.Getenv(env); value != "" {
+			settings = append(settings, supportSetting{Name: env, Value: redactSetting(env, value), Source: "env " + env})
+		}
+	}
+	return settings
+}
+
+// redactSetting replaces the value of secret settings and the bearer token,
+// and the password of URLs such as proxies with credentials
+func redactSetting(name, value string) string {
+	lower := strings.ToLower(name)
+	if strings.Contains(lower, "secret") || strings.Contains(lower, "password") || lower == "token" {
+		return redacted
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// tailLines returns the last n lines of a file
+func tailLines(path string, n int) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if n >= 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return bytes.Join(lines, nil), nil
+}
+
+// reportUploadSummary prints the summary of an upload run and saves its report
+// for support-bundle
+func reportUploadSummary(w io.Writer, s *uploadSummary, runID string) {
+	now := time.Now()
+	printUploadSummary(w, s, now)
+	if err := saveRunReport(newRunReport(s, runID, now)); err != nil {
+		log.Debug().Err(err).Msg("Failed to save the run report")
+	}
+}
+
+func newRunReport(s *uploadSummary, runID string, now time.Time) runReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := runReport{
+		RunID:       runID,
+		StartedAt:   s.start.UTC(),
+		FinishedAt:  now.UTC(),
+		Uploaded:    s.counts[resultUploaded],
+		Skipped:     s.counts[resultSkipped],
+		Failed:      s.counts[resultFailed],
+		Quarantined: s.counts[resultQuarantined],
+		Results:     append([]fileResult(nil), s.results...),
+		SBOMs:       append([]reportSBOM(nil), s.sboms...),
+	}
+	if reproducible() {
+		report = normalizeRunReport(report)
+	}
+	return report
+}
+
+// saveRunReport replaces the report of the last run in the cache directory,
+// or in the state store
+func saveRunReport(report runReport) error {
+	store, err := runReportStore()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Put(context.Background(), runReportName, data)
+}
+
+// loadRunReport reads the report of the last run from the cache directory, or
+// from the state store
+func loadRunReport() (runReport, error) {
+	var report runReport
+	store, err := runReportStore()
+	if err != nil {
+		return report, err
+	}
+	data, err := store.Get(context.Background(), runReportName)
+	if err != nil {
+		return report, err
+	}
+	err = json.Unmarshal(data, &report)
+	return report, err
+}
+
+func runReportStore() (stateStore, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return openStateStore(dir, "runs")
+}
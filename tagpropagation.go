@@ -0,0 +1,125 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+// vexTagTargets returns the SBOMs the OpenVEX documents of the run are
+// attached to: the latest SBOM of each software ID of a product map, the
+// pinned SBOM, the SBOM the sbom-subject resolves to, or else the latest SBOM
+// of the software-id
+func vexTagTargets(ctx context.Context, client HttpClient, tenantEndpoint string, uploadMeta map[string]string,
+	softwareIDs []string) ([]*softwareIDAndSbomID, error) {
+	if len(softwareIDs) == 0 {
+		switch {
+		case uploadMeta["sbom_id"] != "":
+			softwareID, err := strconv.ParseInt(uploadMeta["software_id"], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid software ID %q", uploadMeta["software_id"])
+			}
+			sbomID, err := strconv.ParseInt(uploadMeta["sbom_id"], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SBOM ID %q", uploadMeta["sbom_id"])
+			}
+			return []*softwareIDAndSbomID{{SoftwareID: softwareID, SbomID: sbomID}}, nil
+		case uploadMeta["sbom_subject"] != "":
+			ids, err := lookupSoftwareAndSbomID(ctx, client, tenantEndpoint, uploadMeta["sbom_subject"], "")
+			if err != nil {
+				return nil, err
+			}
+			if ids == nil {
+				return nil, fmt.Errorf("no SBOM found for sbom-subject %s", uploadMeta["sbom_subject"])
+			}
+			return []*softwareIDAndSbomID{ids}, nil
+		case uploadMeta["software_id"] != "":
+			softwareIDs = []string{uploadMeta["software_id"]}
+		}
+	}
+
+	targets := make([]*softwareIDAndSbomID, 0, len(softwareIDs))
+	for _, id := range softwareIDs {
+		softwareID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid software ID %q", id)
+		}
+		ids, err := lookupLatestSbomID(ctx, client, tenantEndpoint, softwareID)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, ids)
+	}
+	return targets, nil
+}
+
+// addSBOMTag adds tag to the metadata of an SBOM. Adding a tag the SBOM
+// already has is not an error.
+func addSBOMTag(ctx context.Context, client HttpClient, tenantEndpoint, tag string, ids *softwareIDAndSbomID) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s/pico/v1/software/%d/sbom/%d/tags/%s",
+		tenantEndpoint, ids.SoftwareID, ids.SbomID, url.PathEscape(tag)), nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request to tag SBOM: %w", err)
+	}
+	defer res.Body.Close()        //nolint:errcheck
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("SBOM ID %d of software ID %d not found", ids.SbomID, ids.SoftwareID)
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("the tenant does not support tagging SBOMs")
+	default:
+		return tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for tagging SBOM: %d", res.StatusCode))
+	}
+}
+
+// mustPropagateVEXTag adds the --tag of an OpenVEX upload to the SBOMs the
+// documents are attached to, if --propagate-tag is set, so both show up under
+// the same tag
+func mustPropagateVEXTag(ctx context.Context, messages io.Writer, client HttpClient, tenantEndpoint string,
+	uploadMeta map[string]string, softwareIDs []string) {
+	if !viper.GetBool("propagate-tag") {
+		return
+	}
+
+	tag := uploadMeta["tag"]
+	targets, err := vexTagTargets(ctx, client, tenantEndpoint, uploadMeta, softwareIDs)
+	if err != nil {
+		fatalErr(err, exitUpload).
+			Msg("Failed to find the SBOMs to propagate the tag to")
+	}
+	for _, ids := range targets {
+		if err := addSBOMTag(ctx, client, tenantEndpoint, tag, ids); err != nil {
+			fatalErr(err, exitUpload).
+				Msg("Failed to propagate the tag to the SBOM")
+		}
+		fmt.Fprintf(messages, "Tag %s added to SBOM ID %d of software ID %d\n", tag, ids.SbomID, ids.SoftwareID)
+	}
+}
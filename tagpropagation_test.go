@@ -0,0 +1,129 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_vexTagTargets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/pico/v1/software/id":
+			_, _ = w.Write([]byte(`{"software_id": 7, "sbom_id": 70}`))
+		case "/pico/v1/software/1/sbom/latest":
+			_, _ = w.Write([]byte(`{"sbom_id": 10}`))
+		case "/pico/v1/software/2/sbom/latest":
+			_, _ = w.Write([]byte(`{"sbom_id": 20}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		meta        map[string]string
+		softwareIDs []string
+		want        []*softwareIDAndSbomID
+		wantErr     bool
+	}{
+		{
+			name:        "product map",
+			meta:        map[string]string{"software_id": "9"},
+			softwareIDs: []string{"1", "2"},
+			want:        []*softwareIDAndSbomID{{SoftwareID: 1, SbomID: 10}, {SoftwareID: 2, SbomID: 20}},
+		},
+		{
+			name: "pinned",
+			meta: map[string]string{"software_id": "3", "sbom_id": "30", "sbom_subject": "web"},
+			want: []*softwareIDAndSbomID{{SoftwareID: 3, SbomID: 30}},
+		},
+		{
+			name: "sbom subject",
+			meta: map[string]string{"software_id": "1", "sbom_subject": "web"},
+			want: []*softwareIDAndSbomID{{SoftwareID: 7, SbomID: 70}},
+		},
+		{
+			name: "software ID",
+			meta: map[string]string{"software_id": "2"},
+			want: []*softwareIDAndSbomID{{SoftwareID: 2, SbomID: 20}},
+		},
+		{
+			name:    "software without SBOM",
+			meta:    map[string]string{"software_id": "5"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := vexTagTargets(context.Background(), srv.Client(), srv.URL, tt.meta, tt.softwareIDs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("vexTagTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("vexTagTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_addSBOMTag(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+		switch r.URL.Path {
+		case "/pico/v1/software/1/sbom/10/tags/release 1.2":
+			w.WriteHeader(http.StatusNoContent)
+		case "/pico/v1/software/2/sbom/20/tags/release 1.2":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	if err := addSBOMTag(context.Background(), srv.Client(), srv.URL, "release 1.2", &softwareIDAndSbomID{SoftwareID: 1, SbomID: 10}); err != nil {
+		t.Errorf("addSBOMTag() error = %v", err)
+	}
+	if want := "PUT /pico/v1/software/1/sbom/10/tags/release%201.2"; len(requests) != 1 || requests[0] != want {
+		t.Errorf("requests = %v, want %q", requests, want)
+	}
+	for _, ids := range []*softwareIDAndSbomID{{SoftwareID: 2, SbomID: 20}, {SoftwareID: 3, SbomID: 30}} {
+		if err := addSBOMTag(context.Background(), srv.Client(), srv.URL, "release 1.2", ids); err == nil {
+			t.Errorf("addSBOMTag(%v) expected an error", ids)
+		}
+	}
+}
+
+func Test_mustPropagateVEXTag_disabled(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	var out bytes.Buffer
+	mustPropagateVEXTag(context.Background(), &out, &ClientMock{}, "http://127.0.0.1:1", map[string]string{"tag": "release"}, []string{"1"})
+	if out.Len() != 0 {
+		t.Errorf("mustPropagateVEXTag() wrote %q without propagate-tag", out.String())
+	}
+}
@@ -0,0 +1,178 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// telemetry* are the values of --telemetry
+const (
+	telemetryOff   = "off"
+	telemetryOn    = "on"
+	telemetryPrint = "print"
+)
+
+// defaultTelemetryEndpoint receives the usage reports of runs that opted in
+const defaultTelemetryEndpoint = "https://telemetry.kusari.cloud/v1/uploader"
+
+// telemetryTimeout bounds how long a run waits for its report to be sent
+const telemetryTimeout = 2 * time.Second
+
+// telemetryReport is the anonymous usage report of one run. It records which
+// flags were used but never their values, and the category of a fatal error
+// but never the error itself, so it can't contain paths, names or credentials.
+type telemetryReport struct {
+	Version         string   `json:"version"`
+	Command         string   `json:"command"`
+	Flags           []string `json:"flags"`
+	OS              string   `json:"os"`
+	Arch            string   `json:"arch"`
+	Outcome         string   `json:"outcome"`
+	ErrorCategory   string   `json:"error_category,omitempty"`
+	DurationSeconds float64  `json:"duration_seconds"`
+}
+
+// telemetryRecorder builds the report of the current run and sends it once
+type telemetryRecorder struct {
+	mode     string
+	endpoint string
+	client   *http.Client
+	out      io.Writer
+	started  time.Time
+	report   telemetryReport
+	once     sync.Once
+}
+
+// runTelemetry records the current run, or is nil if telemetry is off
+var runTelemetry *telemetryRecorder
+
+// startTelemetry starts recording the run of cmd if --telemetry is on or print
+func startTelemetry(cmd *cobra.Command, args []string) {
+	mode := viper.GetString("telemetry")
+	switch mode {
+	case telemetryOff, "":
+		return
+	case telemetryOn, telemetryPrint:
+	default:
+		log.Fatal().Msg(fmt.Sprintf("telemetry must be %s, %s or %s", telemetryOff, telemetryOn, telemetryPrint))
+	}
+
+	client, err := newHTTPClient()
+	if err != nil {
+		// the command reports the invalid configuration itself
+		return
+	}
+	client.Timeout = telemetryTimeout
+
+	recorder := newTelemetryRecorder(mode, cmd, client, os.Stderr, time.Now())
+	if mode == telemetryOn {
+		if err := checkOutboundEndpoint("telemetry endpoint", recorder.endpoint); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Telemetry endpoint not allowed")
+		}
+	}
+	// runs that exit early are reported by exitRun
+	runTelemetry = recorder
+}
+
+func newTelemetryRecorder(mode string, cmd *cobra.Command, client *http.Client, out io.Writer, now time.Time) *telemetryRecorder {
+	endpoint := viper.GetString("telemetry-endpoint")
+	if endpoint == "" {
+		endpoint = defaultTelemetryEndpoint
+	}
+	return &telemetryRecorder{
+		mode:     mode,
+		endpoint: endpoint,
+		client:   client,
+		out:      out,
+		started:  now,
+		report: telemetryReport{
+			Version: buildVersion(),
+			Command: cmd.CommandPath(),
+			Flags:   usedFlags(cmd.Flags()),
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+		},
+	}
+}
+
+// usedFlags returns the names of the flags set on the command line
+func usedFlags(flags *pflag.FlagSet) []string {
+	names := []string{}
+	flags.Visit(func(f *pflag.Flag) {
+		names = append(names, f.Name)
+	})
+	sort.Strings(names)
+	return names
+}
+
+// stopTelemetry reports the run as successful
+func stopTelemetry(cmd *cobra.Command, args []string) {
+	if runTelemetry != nil {
+		runTelemetry.finish("success", "", time.Now())
+	}
+}
+
+// failTelemetry reports the run as failed, using the category of its exit code
+// as the error category. The error is not used, as some errors and log
+// messages include paths or flag values.
+func failTelemetry(code int) {
+	if runTelemetry != nil {
+		runTelemetry.finish("error", exitCategories[code], time.Now())
+	}
+}
+
+// finish completes the report and sends it, or prints it in print mode. It
+// only reports the first outcome, and failures to send are ignored.
+func (t *telemetryRecorder) finish(outcome, category string, now time.Time) {
+	t.once.Do(func() {
+		t.report.Outcome = outcome
+		t.report.ErrorCategory = category
+		t.report.DurationSeconds = now.Sub(t.started).Round(time.Millisecond).Seconds()
+
+		payload, err := json.Marshal(t.report)
+		if err != nil {
+			return
+		}
+
+		if t.mode == telemetryPrint {
+			fmt.Fprintf(t.out, "Telemetry report (not sent): %s\n", payload)
+			return
+		}
+
+		res, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return
+		}
+		res.Body.Close() //nolint:errcheck
+	})
+}
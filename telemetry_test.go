@@ -0,0 +1,139 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func Test_telemetryRecorder(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	reports := make(chan telemetryReport, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report telemetryReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("invalid report: %v", err)
+		}
+		reports <- report
+	}))
+	defer server.Close()
+	viper.Set("telemetry-endpoint", server.URL)
+
+	cmd := &cobra.Command{Use: "upload"}
+	cmd.Flags().String("file-path", "", "")
+	cmd.Flags().String("tag", "", "")
+	if err := cmd.Flags().Parse([]string{"--file-path", "/secret/path"}); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	recorder := newTelemetryRecorder(telemetryOn, cmd, server.Client(), nil, started)
+	t.Cleanup(func() { runTelemetry = nil })
+	runTelemetry = recorder
+	failTelemetry(exitUsage)
+	recorder.finish("success", "", started.Add(time.Minute))
+
+	got := <-reports
+	want := telemetryReport{
+		Version:         buildVersion(),
+		Command:         "upload",
+		Flags:           []string{"file-path"},
+		OS:              got.OS,
+		Arch:            got.Arch,
+		Outcome:         "error",
+		ErrorCategory:   "usage",
+		DurationSeconds: got.DurationSeconds,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report = %+v, want %+v", got, want)
+	}
+	if len(reports) != 0 {
+		t.Errorf("more than one report was sent")
+	}
+}
+
+func Test_telemetryRecorder_print(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("telemetry-endpoint", "http://127.0.0.1:0")
+
+	var out bytes.Buffer
+	recorder := newTelemetryRecorder(telemetryPrint, &cobra.Command{Use: "check"}, http.DefaultClient, &out, time.Now())
+	recorder.finish("success", "", time.Now())
+
+	if !strings.Contains(out.String(), `"command":"check"`) {
+		t.Errorf("printed report = %q", out.String())
+	}
+}
+
+func Test_telemetryRecorder_errorCategory(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("telemetry-endpoint", "http://127.0.0.1:0")
+
+	oldExit, oldOutput, oldLogger := exitFunc, logOutput, log.Logger
+	t.Cleanup(func() { exitFunc, logOutput, log.Logger, runTelemetry = oldExit, oldOutput, oldLogger, nil })
+	exitFunc = func(code int) {}
+	logOutput = io.Discard
+	log.Logger = zerolog.New(io.Discard).Hook(exitHook{})
+
+	tests := []struct {
+		name string
+		exit func()
+		want string
+	}{
+		{
+			name: "fatal error",
+			exit: func() {
+				fatalErr(withExitCode(exitAuth, errors.New("rejected")), exitUsage).Msg("No files match /secret/path/*.json")
+			},
+			want: `"error_category":"auth"`,
+		},
+		{
+			name: "exit code",
+			exit: func() { exitRun(exitBlocked) },
+			want: `"error_category":"blocked"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			runTelemetry = newTelemetryRecorder(telemetryPrint, &cobra.Command{Use: "upload"}, http.DefaultClient, &out, time.Now())
+
+			tt.exit()
+
+			if !strings.Contains(out.String(), tt.want) || strings.Contains(out.String(), "/secret/path") {
+				t.Errorf("printed report = %q, want %s", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// tokenCacheMargin is how long a cached token must still be valid to be used,
+// so it doesn't expire in the middle of a run's first requests
+const tokenCacheMargin = time.Minute
+
+// cachingTokenSource keeps the tokens of source on disk until they are about
+// to expire, so repeated runs with the same client credentials share one
+// token instead of each getting a new one from the token endpoint
+type cachingTokenSource struct {
+	source oauth2.TokenSource
+	store  stateStore
+	key    string
+	now    func() time.Time
+
+	mu sync.Mutex
+	// rejected is the access token the tenant rejected, which is not used
+	// from the cache again
+	rejected string
+}
+
+// newTokenCache wraps source with the token cache of --token-cache. Tokens are
+// kept in the OS keyring, or without one in the tokens directory of
+// --cache-dir, never in --state-store, so they don't leave the machine.
+func newTokenCache(source oauth2.TokenSource, tokenURL string, creds []clientCredential) oauth2.TokenSource {
+	key := tokenCacheKey(tokenURL, creds)
+	if viper.GetBool("keyring") {
+		if kr, err := openKeyring(); err == nil {
+			return &cachingTokenSource{source: source, store: &keyringStore{keyring: kr, prefix: "token/"}, key: key, now: time.Now}
+		}
+	}
+
+	dir, err := cacheDir()
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Token cache disabled")
+		return source
+	}
+	return &cachingTokenSource{
+		source: source,
+		store:  &fileStore{dir: filepath.Join(dir, "tokens")},
+		key:    key,
+		now:    time.Now,
+	}
+}
+
+// tokenCacheKey identifies the tokens of a token endpoint and credentials. The
+// secrets are part of the hash, so a rotated secret never uses a token cached
+// with the old one, and the file name reveals none of them.
+func tokenCacheKey(tokenURL string, creds []clientCredential) string {
+	h := sha256.New()
+	h.Write([]byte(tokenURL))
+	for _, cred := range creds {
+		h.Write([]byte{0})
+		h.Write([]byte(cred.ID))
+		h.Write([]byte{0})
+		h.Write([]byte(cred.Secret))
+	}
+	return hex.EncodeToString(h.Sum(nil)) + ".json"
+}
+
+func (s *cachingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx := context.Background()
+	if data, err := s.store.Get(ctx, s.key); err == nil {
+		var token oauth2.Token
+		if err := json.Unmarshal(data, &token); err == nil && token.AccessToken != "" && token.AccessToken != s.rejected &&
+			token.Expiry.After(s.now().Add(tokenCacheMargin)) {
+			log.Debug().Time("expiry", token.Expiry).Msg("Using cached token")
+			return &token, nil
+		}
+	}
+
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	// tokens without an expiry can't be known to still be valid later
+	if token.Expiry.IsZero() {
+		return token, nil
+	}
+	data, err := json.Marshal(&oauth2.Token{AccessToken: token.AccessToken, TokenType: token.TokenType, Expiry: token.Expiry})
+	if err == nil {
+		err = s.store.Put(ctx, s.key, data)
+	}
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("Failed to cache token")
+	}
+	return token, nil
+}
+
+// forceRefresh skips the cached token next time, and gets a new one from
+// source, which replaces it in the cache
+func (s *cachingTokenSource) forceRefresh() bool {
+	refresher, ok := s.source.(tokenRefresher)
+	if !ok || !refresher.forceRefresh() {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data, err := s.store.Get(context.Background(), s.key); err == nil {
+		var token oauth2.Token
+		if err := json.Unmarshal(data, &token); err == nil {
+			s.rejected = token.AccessToken
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// countingTokenSource issues a new token with expiry on every call
+type countingTokenSource struct {
+	calls  int
+	expiry time.Time
+}
+
+func (s *countingTokenSource) Token() (*oauth2.Token, error) {
+	s.calls++
+	return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", s.calls), TokenType: "Bearer", Expiry: s.expiry}, nil
+}
+
+func Test_cachingTokenSource(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	upstream := &countingTokenSource{expiry: now.Add(time.Hour)}
+	cached := func(key string) *cachingTokenSource {
+		return &cachingTokenSource{source: upstream, store: &fileStore{dir: dir}, key: key, now: func() time.Time { return now }}
+	}
+
+	first, err := cached("a.json").Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a later run with the same key reuses the token
+	second, err := cached("a.json").Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream.calls != 1 || second.AccessToken != first.AccessToken {
+		t.Errorf("Token() fetched %d tokens, got %q then %q, want the first token reused", upstream.calls, first.AccessToken, second.AccessToken)
+	}
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(filepath.Join(dir, "a.json")); err != nil || info.Mode().Perm() != 0o600 {
+			t.Errorf("cached token file = %v, %v, want mode 0600", info, err)
+		}
+	}
+
+	if _, err := cached("b.json").Token(); err != nil || upstream.calls != 2 {
+		t.Errorf("Token() with another key fetched %d tokens, %v, want a new token", upstream.calls, err)
+	}
+
+	// tokens about to expire are replaced
+	now = now.Add(time.Hour - tokenCacheMargin/2)
+	if token, err := cached("a.json").Token(); err != nil || upstream.calls != 3 || token.AccessToken == first.AccessToken {
+		t.Errorf("Token() near expiry = %v, %v after %d fetches, want a new token", token, err, upstream.calls)
+	}
+
+	// tokens without an expiry are not cached
+	upstream.expiry = time.Time{}
+	if _, err := cached("c.json").Token(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.json")); !os.IsNotExist(err) {
+		t.Errorf("token without expiry was cached, stat error = %v", err)
+	}
+}
+
+func Test_tokenCacheKey(t *testing.T) {
+	creds := []clientCredential{{Name: "primary", ID: "id", Secret: "secret"}}
+	rotated := []clientCredential{{Name: "primary", ID: "id", Secret: "rotated"}}
+	key := tokenCacheKey("https://auth.us.kusari.cloud/oauth2/token", creds)
+
+	if key != tokenCacheKey("https://auth.us.kusari.cloud/oauth2/token", creds) {
+		t.Error("tokenCacheKey() is not stable")
+	}
+	if key == tokenCacheKey("https://auth.us.kusari.cloud/oauth2/token", rotated) {
+		t.Error("tokenCacheKey() is the same for a rotated secret")
+	}
+	if key == tokenCacheKey("https://auth.eu.kusari.cloud/oauth2/token", creds) {
+		t.Error("tokenCacheKey() is the same for another token endpoint")
+	}
+}
+
+func Test_newTokenSource_tokenCache(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("client-id", "id")
+	viper.Set("client-secret", "secret")
+	viper.Set("cache-dir", t.TempDir())
+
+	creds := []clientCredential{{Name: "primary", ID: "id", Secret: "secret"}}
+	if _, ok := newTokenSource(context.Background(), "http://127.0.0.1:1/token", creds).(*cachingTokenSource); ok {
+		t.Error("newTokenSource() cached tokens without token-cache")
+	}
+	viper.Set("token-cache", true)
+	source, ok := newTokenSource(context.Background(), "http://127.0.0.1:1/token", creds).(*cachingTokenSource)
+	if !ok {
+		t.Fatal("newTokenSource() did not cache tokens with token-cache")
+	}
+	if want := filepath.Join(viper.GetString("cache-dir"), "tokens"); source.store.(*fileStore).dir != want {
+		t.Errorf("token cache directory = %s, want %s", source.store.(*fileStore).dir, want)
+	}
+}
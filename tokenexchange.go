@@ -0,0 +1,168 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// tokenExchangeGrant is the OAuth 2.0 token exchange grant, RFC 8693
+	tokenExchangeGrant = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// jwtTokenType and accessTokenType are RFC 8693 token type identifiers
+	jwtTokenType    = "urn:ietf:params:oauth:token-type:jwt"
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenExchangeResponse is the response of the token endpoint to a token
+// exchange, or its error
+type tokenExchangeResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// subjectTokenFunc gets the token of a platform identity, e.g. of a
+// Kubernetes service account or a cloud workload, that is exchanged for a
+// Kusari token
+type subjectTokenFunc func(ctx context.Context, client HttpClient) (string, error)
+
+// workloadIdentityAudience returns --workload-identity-audience, or else the
+// token endpoint, the audience platform identity tokens are requested for
+func workloadIdentityAudience(tokenURL string) string {
+	if audience := viper.GetString("workload-identity-audience"); audience != "" {
+		return audience
+	}
+	return tokenURL
+}
+
+// readSubjectTokenFile returns a subjectTokenFunc reading the token in file,
+// which is read for every exchange as projected tokens are rotated before they
+// expire. subject names the token in errors.
+func readSubjectTokenFile(file, subject string) subjectTokenFunc {
+	return func(ctx context.Context, client HttpClient) (string, error) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("error reading the %s: %w", subject, err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("the %s %s is empty", subject, file)
+		}
+		return token, nil
+	}
+}
+
+// tokenExchangeSource exchanges the token of a platform identity for a Kusari
+// token at the token endpoint, with the OAuth 2.0 token exchange grant. The
+// platform token is got anew for every exchange.
+type tokenExchangeSource struct {
+	ctx      context.Context
+	client   HttpClient
+	tokenURL string
+	clientID string
+	// subject names the platform token in errors
+	subject      string
+	subjectToken subjectTokenFunc
+	now          func() time.Time
+}
+
+func newTokenExchangeSource(ctx context.Context, tokenURL, clientID, subject string, subjectToken subjectTokenFunc) *tokenExchangeSource {
+	var client HttpClient = http.DefaultClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok {
+		client = c
+	}
+	return &tokenExchangeSource{
+		ctx:          ctx,
+		client:       client,
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		subject:      subject,
+		subjectToken: subjectToken,
+		now:          time.Now,
+	}
+}
+
+func (s *tokenExchangeSource) Token() (*oauth2.Token, error) {
+	subjectToken, err := s.subjectToken(s.ctx, s.client)
+	if err != nil {
+		return nil, withExitCode(exitAuth, err)
+	}
+
+	form := url.Values{
+		"grant_type":           {tokenExchangeGrant},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {jwtTokenType},
+		"requested_token_type": {accessTokenType},
+	}
+	if s.clientID != "" {
+		form.Set("client_id", s.clientID)
+	}
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making token exchange request: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading token exchange response: %w", err)
+	}
+	var token tokenExchangeResponse
+	_ = json.Unmarshal(body, &token)
+	switch {
+	case res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		reason := token.ErrorDescription
+		if reason == "" {
+			reason = token.Error
+		}
+		return nil, withExitCode(exitAuth, fmt.Errorf("the token endpoint rejected the %s: %d %s", s.subject, res.StatusCode, reason))
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected response status code for token exchange: %d", res.StatusCode)
+	case token.AccessToken == "":
+		return nil, errors.New("the token endpoint issued no access token for the " + s.subject)
+	}
+
+	issued := &oauth2.Token{AccessToken: token.AccessToken, TokenType: token.TokenType}
+	if token.ExpiresIn > 0 {
+		issued.Expiry = s.now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return issued, nil
+}
+
+// forceRefresh is always possible, every call exchanges a platform token anew
+func (s *tokenExchangeSource) forceRefresh() bool {
+	return true
+}
@@ -0,0 +1,142 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+// tokenRefresher is a token source that can be made to get a new token rather
+// than returning the one it holds, e.g. because the tenant rejected it
+type tokenRefresher interface {
+	// forceRefresh discards the current token, and reports whether the next
+	// one is new
+	forceRefresh() bool
+}
+
+// refreshingTokenSource reuses the tokens of source until they expire, or
+// until the tenant rejects them and source can get a new one
+type refreshingTokenSource struct {
+	source oauth2.TokenSource
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+func (s *refreshingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.Valid() {
+		return s.token, nil
+	}
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+// refresh discards the rejected token, and reports whether a new one can be
+// used. A token that a concurrent request already replaced isn't refreshed
+// again.
+func (s *refreshingTokenSource) refresh(rejected *oauth2.Token) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.token.AccessToken != rejected.AccessToken {
+		return true
+	}
+	refresher, ok := s.source.(tokenRefresher)
+	if !ok || !refresher.forceRefresh() {
+		return false
+	}
+	s.token = nil
+	return true
+}
+
+// refreshingTransport authorizes requests with the tokens of source. A request
+// the tenant answers with 401 is sent once more with a new token, so a run
+// that outlives its token, or whose token is revoked, isn't aborted.
+type refreshingTransport struct {
+	base   http.RoundTripper
+	source *refreshingTokenSource
+}
+
+// newRefreshingClient returns a client authorizing its requests with the
+// tokens of source, sent with the client of ctx like oauth2.NewClient
+func newRefreshingClient(ctx context.Context, source oauth2.TokenSource) *http.Client {
+	base := http.DefaultTransport
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && client.Transport != nil {
+		base = client.Transport
+	}
+	return &http.Client{Transport: &refreshingTransport{base: base, source: &refreshingTokenSource{source: source}}}
+}
+
+func (t *refreshingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close() //nolint:errcheck
+		}
+		return nil, err
+	}
+
+	res, err := t.base.RoundTrip(authorize(req, token))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	// the body of the first attempt was consumed
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return res, nil
+	}
+	if !t.source.refresh(token) {
+		return res, nil
+	}
+	io.Copy(io.Discard, res.Body) //nolint:errcheck
+	res.Body.Close()              //nolint:errcheck
+
+	log.Warn().
+		Str("url", req.URL.Redacted()).
+		Msg("Access token was rejected, retrying with a new token")
+	runRetries.record(req.URL.Path, retryPhaseToken, 0)
+
+	token, err = t.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	retry := authorize(req, token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// authorize returns a copy of req with the Authorization header of token
+func authorize(req *http.Request, token *oauth2.Token) *http.Request {
+	req = req.Clone(req.Context())
+	token.SetAuthHeader(req)
+	return req
+}
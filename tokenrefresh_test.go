@@ -0,0 +1,166 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+func Test_getAuthorizedClientRefreshOn401(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	var issued int
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issued++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": 3600}`, issued)
+	}))
+	defer tokenServer.Close()
+
+	var bodies []string
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		// the first token is revoked in the middle of the run
+		if r.Header.Get("Authorization") == "Bearer token-1" && len(bodies) > 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer apiServer.Close()
+
+	client := getAuthorizedClient(context.Background(), tokenServer.URL, []clientCredential{{Name: "primary", ID: "id", Secret: "secret"}})
+	for i := 0; i < 3; i++ {
+		res, err := client.Post(apiServer.URL+"/presign", "application/json", strings.NewReader(fmt.Sprintf(`{"n": %d}`, i)))
+		if err != nil {
+			t.Fatalf("Post() error = %v", err)
+		}
+		res.Body.Close() //nolint:errcheck
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Post() status = %d, want the request retried with a new token", res.StatusCode)
+		}
+	}
+
+	if want := []string{`{"n": 0}`, `{"n": 1}`, `{"n": 1}`, `{"n": 2}`}; fmt.Sprint(bodies) != fmt.Sprint(want) {
+		t.Errorf("requests = %v, want %v", bodies, want)
+	}
+	if issued != 2 {
+		t.Errorf("issued %d tokens, want 2", issued)
+	}
+}
+
+func Test_getAuthorizedClientStaticToken401(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("token", "static")
+
+	var requests int
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer apiServer.Close()
+
+	client := getAuthorizedClient(context.Background(), "", nil)
+	res, err := client.Post(apiServer.URL+"/presign", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	res.Body.Close() //nolint:errcheck
+
+	// a static token can't be refreshed, so the 401 is returned as is
+	if res.StatusCode != http.StatusUnauthorized || requests != 1 {
+		t.Errorf("Post() status = %d after %d requests, want 401 after 1", res.StatusCode, requests)
+	}
+}
+
+// refreshCountingSource is a countingTokenSource that can be refreshed
+type refreshCountingSource struct {
+	countingTokenSource
+	refreshed int
+}
+
+func (s *refreshCountingSource) forceRefresh() bool {
+	s.refreshed++
+	return true
+}
+
+func Test_refreshingTokenSource(t *testing.T) {
+	upstream := &refreshCountingSource{countingTokenSource: countingTokenSource{expiry: time.Now().Add(time.Hour)}}
+	source := &refreshingTokenSource{source: upstream}
+
+	first, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !source.refresh(first) {
+		t.Fatal("refresh() = false, want true")
+	}
+	second, err := source.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.AccessToken == first.AccessToken {
+		t.Errorf("Token() = %q after refresh, want a new token", second.AccessToken)
+	}
+
+	// a concurrent request rejected with the first token doesn't refresh again
+	if !source.refresh(first) || upstream.refreshed != 1 {
+		t.Errorf("refreshed %d times, want the replaced token not refreshed again", upstream.refreshed)
+	}
+	if third, _ := source.Token(); third.AccessToken != second.AccessToken {
+		t.Errorf("Token() = %q, want %q", third.AccessToken, second.AccessToken)
+	}
+}
+
+func Test_cachingTokenSource_forceRefresh(t *testing.T) {
+	upstream := &refreshCountingSource{countingTokenSource: countingTokenSource{expiry: time.Now().Add(time.Hour)}}
+	cached := &cachingTokenSource{source: upstream, store: &fileStore{dir: t.TempDir()}, key: "a.json", now: time.Now}
+
+	first, err := cached.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.forceRefresh() {
+		t.Fatal("forceRefresh() = false, want true")
+	}
+	second, err := cached.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.AccessToken == first.AccessToken || upstream.calls != 2 {
+		t.Errorf("Token() = %q after %d calls, want the cached token skipped", second.AccessToken, upstream.calls)
+	}
+	// the new token replaced the rejected one in the cache
+	if third, _ := cached.Token(); third.AccessToken != second.AccessToken || upstream.calls != 2 {
+		t.Errorf("Token() = %q, want the new token from the cache", third.AccessToken)
+	}
+
+	static := &cachingTokenSource{source: oauth2.StaticTokenSource(first), store: &fileStore{dir: t.TempDir()}, key: "a.json", now: time.Now}
+	if static.forceRefresh() {
+		t.Error("forceRefresh() = true for a source that can't refresh")
+	}
+}
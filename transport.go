@@ -0,0 +1,256 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// mustNewHTTPClient builds the HTTP client and returns a context that makes
+// the oauth2 client use it as well
+func mustNewHTTPClient(ctx context.Context) (context.Context, *http.Client) {
+	client, err := newHTTPClient()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to configure HTTP client")
+	}
+
+	return context.WithValue(ctx, oauth2.HTTPClient, client), client
+}
+
+// newHTTPClient returns the client used for all requests, including the ones
+// made by the oauth2 client, configured from the TLS and proxy flags
+func newHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if bundle := viper.GetString("ca-bundle"); bundle != "" {
+		pool, err := loadCABundle(bundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certs := viper.GetStringSlice("ca-cert"); len(certs) > 0 {
+		pool, err := addCACerts(tlsConfig.RootCAs, certs)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if viper.GetBool("insecure-skip-tls-verify") {
+		warnInsecureSkipVerify.Do(func() {
+			log.Warn().
+				Msg("INSECURE: TLS certificate verification is disabled by insecure-skip-tls-verify, anyone on the network path " +
+					"can read and change the requests, including the credentials. Only use it in lab environments, and use ca-cert " +
+					"to trust a private CA instead.")
+		})
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if certFile, keyFile := viper.GetString("tls-cert"), viper.GetString("tls-key"); certFile != "" || keyFile != "" {
+		cert, err := loadClientCertificate(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if version := viper.GetString("tls-min-version"); version != "" {
+		minVersion, err := parseTLSVersion(version)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MinVersion = minVersion
+	}
+
+	if names := viper.GetStringSlice("tls-cipher-suites"); len(names) > 0 {
+		suites, err := parseCipherSuites(names)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = suites
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	proxy, err := resolveProxyConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy.apply(transport)
+
+	var rt http.RoundTripper = &caHintTransport{base: transport}
+	if values := viper.GetStringSlice("inject-fault"); len(values) > 0 {
+		faults, err := parseFaults(values)
+		if err != nil {
+			return nil, err
+		}
+		log.Warn().
+			Strs("faults", values).
+			Msg("Injecting faults, requests will fail on purpose")
+		rt = newFaultTransport(rt, faults)
+	}
+
+	return &http.Client{Transport: &logTransport{base: rt}}, nil
+}
+
+// loadCABundle reads a PEM bundle to use instead of the system CA store
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle %s: %w", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+
+	return pool, nil
+}
+
+// addCACerts returns pool, or the system CA store if pool is nil, with the
+// certificates of the PEM files added, for private CAs such as the one of a
+// TLS-intercepting proxy
+func addCACerts(pool *x509.CertPool, paths []string) (*x509.CertPool, error) {
+	if pool == nil {
+		system, err := x509.SystemCertPool()
+		if err != nil {
+			system = x509.NewCertPool()
+		}
+		pool = system
+	} else {
+		pool = pool.Clone()
+	}
+
+	// environment variables and config file strings are comma separated too
+	for _, value := range paths {
+		for _, path := range strings.Split(value, ",") {
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA certificate %s: %w", path, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA certificate %s", path)
+			}
+		}
+	}
+	return pool, nil
+}
+
+// warnInsecureSkipVerify warns about --insecure-skip-tls-verify once per run,
+// as several clients are built
+var warnInsecureSkipVerify sync.Once
+
+// loadClientCertificate reads the PEM client certificate and private key used
+// for mutual TLS. It is only sent to servers that ask for one.
+func loadClientCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, errors.New("tls-cert and tls-key must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load client certificate %s: %w", certFile, err)
+	}
+	return cert, nil
+}
+
+// tlsVersions are the accepted values of --tls-min-version
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a minimum TLS version such as "1.2" or "1.3"
+func parseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToUpper(version), "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS minimum version %q, must be 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites parses cipher suite names as listed by crypto/tls, such as
+// TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384. Suites crypto/tls considers insecure
+// are rejected. The suites only apply to TLS 1.2, TLS 1.3 suites are not
+// configurable.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	secure := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	insecure := map[string]bool{}
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	// environment variables and config file strings are comma separated too
+	var split []string
+	for _, name := range names {
+		for _, n := range strings.Split(name, ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				split = append(split, n)
+			}
+		}
+	}
+
+	ids := make([]uint16, 0, len(split))
+	for _, name := range split {
+		if insecure[name] {
+			return nil, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// caHintTransport explains certificate verification failures, which in
+// minimal container images are usually caused by a missing CA store
+type caHintTransport struct {
+	base http.RoundTripper
+}
+
+func (t *caHintTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		return nil, fmt.Errorf("%w: the certificate of %s is not signed by a trusted CA; if the system CA store is missing, "+
+			"mount a CA bundle and point --ca-bundle or SSL_CERT_FILE at it, or add a private CA with --ca-cert", err, req.URL.Host)
+	}
+
+	return res, err
+}
@@ -0,0 +1,205 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func Test_parseTLSVersion(t *testing.T) {
+	for input, want := range map[string]uint16{"1.2": tls.VersionTLS12, "TLS1.3": tls.VersionTLS13} {
+		if got, err := parseTLSVersion(input); err != nil || got != want {
+			t.Errorf("parseTLSVersion(%q) = %v, %v, want %v", input, got, err, want)
+		}
+	}
+	if _, err := parseTLSVersion("1.0"); err == nil {
+		t.Error("parseTLSVersion(1.0) expected an error")
+	}
+}
+
+func Test_parseCipherSuites(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []uint16
+		wantErr bool
+	}{
+		{
+			name:  "flag values",
+			names: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+			want:  []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		},
+		{
+			name:  "comma separated environment variable",
+			names: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+			want:  []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+		},
+		{
+			name:    "insecure",
+			names:   []string{"TLS_RSA_WITH_RC4_128_SHA"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown",
+			names:   []string{"TLS_MADE_UP"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCipherSuites(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCipherSuites() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCipherSuites() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newHTTPClient_clientCertificate(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "uploader"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile, caFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.pem")
+	for path, block := range map[string]*pem.Block{certFile: {Type: "CERTIFICATE", Bytes: der}, keyFile: {Type: "EC PRIVATE KEY", Bytes: keyDER}} {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("ca-bundle", caFile)
+
+	get := func() error {
+		client, err := newHTTPClient()
+		if err != nil {
+			return err
+		}
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+	if err := get(); err == nil {
+		t.Error("request without a client certificate expected an error")
+	}
+
+	viper.Set("tls-cert", certFile)
+	if err := get(); err == nil {
+		t.Error("newHTTPClient() accepted tls-cert without tls-key")
+	}
+
+	viper.Set("tls-key", keyFile)
+	if err := get(); err != nil {
+		t.Errorf("request with a client certificate failed: %v", err)
+	}
+
+	viper.Set("tls-key", certFile)
+	if _, err := newHTTPClient(); err == nil {
+		t.Error("newHTTPClient() accepted a key file without a private key")
+	}
+}
+
+func Test_newHTTPClient_caCert(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "proxy-ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() error {
+		client, err := newHTTPClient()
+		if err != nil {
+			return err
+		}
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	if err := get(); err == nil {
+		t.Error("request to a server with a private CA expected an error")
+	}
+
+	viper.Set("ca-cert", []string{caFile})
+	if err := get(); err != nil {
+		t.Errorf("request with ca-cert failed: %v", err)
+	}
+
+	viper.Set("ca-cert", []string{filepath.Join(t.TempDir(), "missing.pem")})
+	if _, err := newHTTPClient(); err == nil {
+		t.Error("newHTTPClient() accepted a missing ca-cert")
+	}
+
+	viper.Set("ca-cert", nil)
+	viper.Set("insecure-skip-tls-verify", true)
+	if err := get(); err != nil {
+		t.Errorf("request with insecure-skip-tls-verify failed: %v", err)
+	}
+}
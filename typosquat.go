@@ -0,0 +1,361 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// typosquatCheck* are the values of --typosquat-check
+const (
+	typosquatCheckOff  = "off"
+	typosquatCheckWarn = "warn"
+	typosquatCheckFail = "fail"
+)
+
+// popularPackages is the built in corpus of well known packages by purl type
+// that SBOM components are compared against. --typosquat-corpus replaces it.
+var popularPackages = map[string][]string{
+	"npm": {
+		"react", "react-dom", "preact", "lodash", "express", "axios", "chalk", "commander", "debug",
+		"moment", "request", "webpack", "typescript", "eslint", "prettier", "jquery", "vue", "angular",
+		"underscore", "async", "uuid", "dotenv", "colors", "cross-env", "electron", "mongoose", "nodemon",
+		"@types/node", "@babel/core",
+	},
+	"pypi": {
+		"requests", "urllib3", "numpy", "pandas", "django", "flask", "boto3", "botocore", "setuptools",
+		"cryptography", "pyyaml", "python-dateutil", "jinja2", "pillow", "matplotlib", "scipy",
+		"pytest", "colorama", "beautifulsoup4", "tensorflow", "pytorch", "torch", "selenium",
+	},
+	"gem": {
+		"rails", "rake", "bundler", "rack", "nokogiri", "activesupport", "json", "rspec", "sinatra",
+		"devise", "puma", "thor",
+	},
+	"cargo": {
+		"serde", "serde_json", "tokio", "rand", "regex", "clap", "syn", "quote", "reqwest", "hyper",
+		"libc", "log", "anyhow", "thiserror",
+	},
+	"golang": {
+		"github.com/spf13/cobra", "github.com/spf13/viper", "github.com/sirupsen/logrus",
+		"github.com/stretchr/testify", "github.com/gin-gonic/gin", "github.com/gorilla/mux",
+		"github.com/rs/zerolog", "github.com/google/uuid", "github.com/pkg/errors",
+		"golang.org/x/crypto", "golang.org/x/net", "google.golang.org/grpc",
+	},
+	"maven": {
+		"org.apache.logging.log4j/log4j-core", "org.apache.commons/commons-lang3",
+		"com.fasterxml.jackson.core/jackson-databind", "com.google.guava/guava",
+		"org.springframework/spring-core", "junit/junit", "org.slf4j/slf4j-api",
+	},
+	"nuget": {
+		"newtonsoft.json", "serilog", "automapper", "dapper", "moq", "xunit", "nunit",
+	},
+}
+
+// typosquatFinding is an SBOM component whose name is close to, but not the
+// same as, a popular package
+type typosquatFinding struct {
+	File     string
+	Purl     string
+	Similar  string
+	Distance int
+}
+
+// typosquatReport collects the findings of a run so they can be reported at exit
+type typosquatReport struct {
+	mu       sync.Mutex
+	findings []typosquatFinding
+}
+
+// runTyposquats collects the typosquat findings of the current run
+var runTyposquats = &typosquatReport{}
+
+func (r *typosquatReport) record(findings ...typosquatFinding) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.findings = append(r.findings, findings...)
+}
+
+// summary returns the findings sorted by file and purl
+func (r *typosquatReport) summary() []typosquatFinding {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	findings := append([]typosquatFinding(nil), r.findings...)
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Purl < findings[j].Purl
+	})
+	return findings
+}
+
+// printTyposquatReport writes the likely typosquats of the run, if there were any
+func printTyposquatReport(w io.Writer, findings []typosquatFinding) {
+	if len(findings) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Possible typosquats: %d\n", len(findings))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  FILE\tPURL\tSIMILAR TO\tDISTANCE")
+	for _, f := range findings {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%d\n", f.File, f.Purl, f.Similar, f.Distance)
+	}
+	tw.Flush() //nolint:errcheck
+}
+
+// checkTyposquats compares the components of an SBOM against the popular
+// package corpus and records the likely typosquats of the run. With
+// --typosquat-check fail an SBOM with findings is rejected, with warn they are
+// only reported, and with off (the default) nothing is checked.
+func checkTyposquats(filePath string, blob []byte) error {
+	mode := viper.GetString("typosquat-check")
+	switch mode {
+	case typosquatCheckOff, "":
+		return nil
+	case typosquatCheckWarn, typosquatCheckFail:
+	default:
+		return fmt.Errorf("typosquat-check must be %s, %s or %s", typosquatCheckOff, typosquatCheckWarn, typosquatCheckFail)
+	}
+
+	corpus, err := loadTyposquatCorpus(viper.GetString("typosquat-corpus"))
+	if err != nil {
+		return err
+	}
+
+	findings := findTyposquats(filePath, sbomPurls(blob), corpus)
+	if len(findings) == 0 {
+		return nil
+	}
+	runTyposquats.record(findings...)
+
+	for _, f := range findings {
+		log.Warn().
+			Str("filePath", filePath).
+			Str("purl", f.Purl).
+			Str("similarTo", f.Similar).
+			Msg("Component name is similar to a popular package, it may be a typosquat")
+	}
+	if mode == typosquatCheckWarn {
+		return nil
+	}
+	return fmt.Errorf("%s has %d possible typosquat(s), e.g. %s is similar to %s",
+		filePath, len(findings), findings[0].Purl, findings[0].Similar)
+}
+
+// findTyposquats returns the purls whose name is within the edit distance
+// allowed for its length of a package of the same type in corpus. Names that
+// are in the corpus themselves are never findings.
+func findTyposquats(filePath string, purls []string, corpus map[string]map[string]bool) []typosquatFinding {
+	var findings []typosquatFinding
+	for _, purl := range purls {
+		purlType, name, ok := purlTypeAndName(purl)
+		if !ok {
+			continue
+		}
+		popular := corpus[purlType]
+		maxDistance := typosquatMaxDistance(name)
+		if len(popular) == 0 || maxDistance == 0 || popular[name] {
+			continue
+		}
+
+		best, bestDistance := "", maxDistance+1
+		for candidate := range popular {
+			d := editDistance(name, candidate)
+			if d < bestDistance || (d == bestDistance && candidate < best) {
+				best, bestDistance = candidate, d
+			}
+		}
+		if best != "" {
+			findings = append(findings, typosquatFinding{
+				File:     filePath,
+				Purl:     purl,
+				Similar:  purlType + "/" + best,
+				Distance: bestDistance,
+			})
+		}
+	}
+	return findings
+}
+
+// typosquatMaxDistance returns the largest edit distance to a popular package
+// that is considered a typosquat. Short names are skipped because most of them
+// are one edit away from some other short name.
+func typosquatMaxDistance(name string) int {
+	switch n := len(name); {
+	case n < 5:
+		return 0
+	case n < 10:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// purlTypeAndName returns the lowercased type and the namespace and name of a
+// purl, e.g. npm and @types/node for pkg:npm/%40types/node@20.0.0. PyPI names
+// are normalized as pip does, so python_dateutil is python-dateutil.
+func purlTypeAndName(purl string) (string, string, bool) {
+	purlType, name, ok := parsePurl(purl)
+	if !ok {
+		return "", "", false
+	}
+	return strings.ToLower(purlType), normalizePackageName(purlType, name), true
+}
+
+// parsePurl returns the type and the unescaped namespace and name of a purl as
+// written, without its version, qualifiers and subpath
+func parsePurl(purl string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(purl, "pkg:")
+	if !ok {
+		return "", "", false
+	}
+	rest, _, _ = strings.Cut(rest, "#")
+	rest, _, _ = strings.Cut(rest, "?")
+	purlType, name, _ := strings.Cut(rest, "/")
+	if i := strings.LastIndex(name, "@"); i > 0 {
+		name = name[:i]
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	name = strings.Trim(name, "/")
+	if purlType == "" || name == "" {
+		return "", "", false
+	}
+	return purlType, name, true
+}
+
+// normalizePackageName lowercases a package name and, for PyPI, replaces its
+// separators the way pip does
+func normalizePackageName(purlType, name string) string {
+	name = strings.ToLower(name)
+	if strings.EqualFold(purlType, "pypi") {
+		name = pypiNameSeparators.ReplaceAllString(name, "-")
+	}
+	return name
+}
+
+// pypiNameSeparators are the runs of characters PyPI treats as the same separator
+var pypiNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// editDistance returns the optimal string alignment distance of a and b: the
+// number of insertions, deletions, substitutions and transpositions of
+// adjacent characters needed to turn one into the other
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// typosquatCorpora caches the corpus files, which are read once per run
+var typosquatCorpora = struct {
+	mu     sync.Mutex
+	byPath map[string]map[string]map[string]bool
+}{byPath: map[string]map[string]map[string]bool{}}
+
+// loadTyposquatCorpus returns the popular packages by purl type, from the
+// corpus file at path or else the built in corpus
+func loadTyposquatCorpus(path string) (map[string]map[string]bool, error) {
+	typosquatCorpora.mu.Lock()
+	defer typosquatCorpora.mu.Unlock()
+
+	if corpus, ok := typosquatCorpora.byPath[path]; ok {
+		return corpus, nil
+	}
+
+	var corpus map[string]map[string]bool
+	if path == "" {
+		corpus = map[string]map[string]bool{}
+		for purlType, names := range popularPackages {
+			corpus[purlType] = map[string]bool{}
+			for _, name := range names {
+				corpus[purlType][normalizePackageName(purlType, name)] = true
+			}
+		}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("error opening typosquat corpus: %w", err)
+		}
+		defer f.Close() //nolint:errcheck
+		if corpus, err = readTyposquatCorpus(f); err != nil {
+			return nil, fmt.Errorf("invalid typosquat corpus %s: %w", path, err)
+		}
+	}
+
+	typosquatCorpora.byPath[path] = corpus
+	return corpus, nil
+}
+
+// readTyposquatCorpus reads a corpus with one package per line as the purl
+// type and name, e.g. npm/lodash or golang/github.com/spf13/cobra. Blank lines
+// and lines starting with # are ignored.
+func readTyposquatCorpus(r io.Reader) (map[string]map[string]bool, error) {
+	corpus := map[string]map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		purlType, name, ok := strings.Cut(strings.ToLower(entry), "/")
+		if !ok || purlType == "" || name == "" {
+			return nil, fmt.Errorf("line %d: %q is not <type>/<name>", line, entry)
+		}
+		if corpus[purlType] == nil {
+			corpus[purlType] = map[string]bool{}
+		}
+		corpus[purlType][normalizePackageName(purlType, name)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return corpus, nil
+}
@@ -0,0 +1,147 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_editDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"lodash", "lodash", 0},
+		{"lodahs", "lodash", 1},
+		{"lodas", "lodash", 1},
+		{"1odash", "lodash", 1},
+		{"requestss", "requests", 1},
+		{"reqeusts", "requests", 1},
+		{"axios", "express", 5},
+		{"", "abc", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func Test_purlTypeAndName(t *testing.T) {
+	tests := []struct {
+		purl     string
+		wantType string
+		wantName string
+		wantOK   bool
+	}{
+		{"pkg:npm/lodash@4.17.21", "npm", "lodash", true},
+		{"pkg:npm/%40types/node@20.0.0", "npm", "@types/node", true},
+		{"pkg:npm/@types/node", "npm", "@types/node", true},
+		{"pkg:PyPI/Python_DateUtil@2.9.0?x=y#sub", "pypi", "python-dateutil", true},
+		{"pkg:golang/github.com/spf13/cobra@v1.8.0", "golang", "github.com/spf13/cobra", true},
+		{"pkg:generic", "", "", false},
+		{"not-a-purl", "", "", false},
+	}
+	for _, tt := range tests {
+		purlType, name, ok := purlTypeAndName(tt.purl)
+		if purlType != tt.wantType || name != tt.wantName || ok != tt.wantOK {
+			t.Errorf("purlTypeAndName(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.purl, purlType, name, ok, tt.wantType, tt.wantName, tt.wantOK)
+		}
+	}
+}
+
+func Test_findTyposquats(t *testing.T) {
+	corpus, err := readTyposquatCorpus(strings.NewReader("# popular\nnpm/lodash\nnpm/preact\nnpm/react\npypi/requests\n\nnpm/ms\n"))
+	if err != nil {
+		t.Fatalf("readTyposquatCorpus() error = %v", err)
+	}
+
+	purls := []string{
+		"pkg:npm/lodash@4.17.21",  // popular itself
+		"pkg:npm/lodahs@1.0.0",    // transposition
+		"pkg:npm/preact@10.0.0",   // popular, although one edit from react
+		"pkg:npm/mss@1.0.0",       // too short to compare
+		"pkg:pypi/reqeusts@2.0.0", // transposition
+		"pkg:npm/requests@1.0.0",  // popular in another ecosystem only
+		"pkg:npm/express@4.0.0",   // not close to anything
+	}
+	want := []typosquatFinding{
+		{File: "sbom.json", Purl: "pkg:npm/lodahs@1.0.0", Similar: "npm/lodash", Distance: 1},
+		{File: "sbom.json", Purl: "pkg:pypi/reqeusts@2.0.0", Similar: "pypi/requests", Distance: 1},
+	}
+	if got := findTyposquats("sbom.json", purls, corpus); !reflect.DeepEqual(got, want) {
+		t.Errorf("findTyposquats() = %v, want %v", got, want)
+	}
+}
+
+func Test_readTyposquatCorpus_invalid(t *testing.T) {
+	if _, err := readTyposquatCorpus(strings.NewReader("npm/lodash\nlodash\n")); err == nil {
+		t.Error("readTyposquatCorpus() expected an error for an entry without a type")
+	}
+}
+
+func Test_checkTyposquats(t *testing.T) {
+	blob := []byte(`{"bomFormat": "CycloneDX", "components": [{"purl": "pkg:npm/expresss@4.0.0"}]}`)
+
+	tests := []struct {
+		mode      string
+		wantErr   bool
+		wantFound int
+	}{
+		{mode: typosquatCheckOff},
+		{mode: typosquatCheckWarn, wantFound: 1},
+		{mode: typosquatCheckFail, wantErr: true, wantFound: 1},
+		{mode: "block", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			runTyposquats = &typosquatReport{}
+			t.Cleanup(func() { runTyposquats = &typosquatReport{} })
+			viper.Set("typosquat-check", tt.mode)
+
+			err := checkTyposquats("sbom.json", blob)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkTyposquats() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(runTyposquats.summary()); got != tt.wantFound {
+				t.Errorf("checkTyposquats() recorded %d findings, want %d", got, tt.wantFound)
+			}
+		})
+	}
+}
+
+func Test_printTyposquatReport(t *testing.T) {
+	var buf bytes.Buffer
+	printTyposquatReport(&buf, nil)
+	if buf.Len() != 0 {
+		t.Errorf("printTyposquatReport(nil) wrote %q, want nothing", buf.String())
+	}
+
+	printTyposquatReport(&buf, []typosquatFinding{{File: "sbom.json", Purl: "pkg:npm/lodahs@1.0.0", Similar: "npm/lodash", Distance: 1}})
+	for _, want := range []string{"Possible typosquats: 1", "pkg:npm/lodahs@1.0.0", "npm/lodash"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("printTyposquatReport() output %q does not contain %q", buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// uploadFlags are the flags of the upload command, which the root command also
+// accepts for backward compatibility
+var uploadFlags = []string{
+	"file-path", "files-from", "null",
+	"alias", "document-type", "tag", "software-id", "sbom-subject", "component-name", "meta", "metadata-file",
+	"meta-namespace", "require-meta", "subject-match", "resolve-software-id", "auto-register-component",
+	"open-vex", "vex-product-map", "fail-on-vex-conflict", "pin-sbom-id", "propagate-tag",
+	"verify-provenance", "check-blocked-packages", "check-only", "interactive",
+	"profile", "schedule", "report", "report-file", "pacing-window",
+	"file-timeout", "quarantine-after", "quarantine-dir", "push-run-summary",
+	"docref-template", "omit-file-metadata", "force-type", "force-format", "wrapper-version",
+	"allowed-registries", "registry-violations", "deny-license", "typosquat-check", "typosquat-corpus",
+	"maintenance-check", "max-release-age", "deps-dev-endpoint", "cache-ttl", "offline", "blocked-output",
+	"hash-concurrency", "check-concurrency",
+}
+
+func newUploadCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "upload",
+		Short:  "Upload files to the Kusari Platform using OAuth client credentials",
+		Args:   cobra.NoArgs,
+		PreRun: bindUploadFlags,
+		Run:    uploadFiles,
+	}
+
+	addUploadFlags(cmd.Flags())
+
+	return cmd
+}
+
+// addUploadFlags defines the flags of the upload command
+func addUploadFlags(flags *pflag.FlagSet) {
+	flags.StringP("file-path", "f", "", "Path to file or directory to upload (required unless files-from is set)")
+	addFilesFromFlags(flags)
+	addMetadataFlags(flags)
+	addDocumentFlags(flags)
+	addWrapperVersionFlag(flags)
+	flags.String("subject-match", subjectMatchSubstring, "How sbom-subject is matched against the names of the Kusari Platform Software: substring, exact or regex; exact and regex must match one Software, whose ID is set in the document wrapper upload meta instead (optional)")
+	flags.Bool("resolve-software-id", false, "Look up the Kusari Platform Software ID of the alias, or else the component-name, and set it in the document wrapper upload meta instead of relying on sbom-subject matching (optional)")
+	flags.Bool("auto-register-component", false, "Create the component-name components, including those of routing rules, in the Kusari Platform before uploading if they don't exist yet (optional)")
+	flags.Bool("open-vex", false, "Indicate that this is an OpenVEX document (optional, only works with files)")
+	flags.String("vex-product-map", "", "JSON or YAML file mapping OpenVEX product IDs to Kusari Platform Software IDs; the document is split and uploaded once per Software ID (optional, requires open-vex)")
+	flags.Bool("fail-on-vex-conflict", false, "Fail instead of warning when OpenVEX statements are older than or roll back the platform's current statements (optional)")
+	flags.Bool("pin-sbom-id", false, "Resolve the current SBOM of --sbom-subject at upload time and pin the OpenVEX document to its ID (optional, requires open-vex)")
+	flags.Bool("propagate-tag", false, "After an OpenVEX upload, add its tag to the SBOMs the document is attached to, so both are found under the same tag (optional, requires open-vex)")
+	flags.Bool("verify-provenance", false, "When a directory, files-from list or glob contains both SBOMs and provenance attestations, fail if no attestation subject of an SBOM's artifact name has its digest (optional)")
+	flags.Bool("check-blocked-packages", false, "Check if any of the SBOMs uses a package contained in the blocked package list")
+	addBlockedOutputFlag(flags)
+	addSBOMCheckFlags(flags)
+	addMaintenanceFlags(flags)
+	addConcurrencyFlags(flags, "hash-concurrency", "check-concurrency")
+	flags.Bool("check-only", false, "Run the blocked package check for SBOMs that were already uploaded, using the subjects in the local files, without uploading anything (same as the check command)")
+	flags.StringSlice("profile", nil, "Comma separated profiles of the config file to run the upload for at the same time, e.g. us-prod,eu-prod; each profile sets flags such as its tenant and credentials (optional)")
+	flags.String("schedule", "", "Keep running and run the upload on this cron schedule, e.g. \"0 2 * * *\" or @hourly, in local time (optional)")
+	flags.Bool("interactive", false, "List the files found with their detected type and format, and choose which of them to upload before anything is uploaded (requires a terminal)")
+	addReportFlags(flags)
+	flags.Bool("push-run-summary", false, "At the end of the run, push its summary with the document refs, CI pipeline and gate outcome to the tenant, so the platform shows the pipeline run (optional)")
+	addPacingFlag(flags)
+	addQuarantineFlags(flags)
+}
+
+// addDocumentFlags defines the flags that change how documents are identified
+// and wrapped, shared by the commands that upload or validate documents
+func addDocumentFlags(flags *pflag.FlagSet) {
+	flags.String("docref-template", "", "Template for the document ref of uploaded documents, e.g. {artifact_digest} or build-{env.GITHUB_RUN_ID}-{sha256}; defaults to the sha256 of the content (optional)")
+	flags.Bool("omit-file-metadata", false, "Do not record the file name, extension, size and modification time of uploaded files in the upload metadata (optional)")
+	flags.String("force-type", "", "Upload documents as this type instead of the detected one: SBOM or OPEN_VEX (optional)")
+	flags.String("force-format", "", "Upload documents in this format instead of letting the platform detect it: json, jsonl or xml (optional)")
+}
+
+// addWrapperVersionFlag defines the flag pinning the document wrapper schema
+// version, for the commands that negotiate it with the tenant
+func addWrapperVersionFlag(flags *pflag.FlagSet) {
+	flags.Int("wrapper-version", 0, "Schema version of the document wrapper (optional, defaults to the newest version the tenant supports)")
+}
+
+// addSBOMCheckFlags defines the flags of the local checks of SBOM components,
+// which run before an SBOM is uploaded or validated
+func addSBOMCheckFlags(flags *pflag.FlagSet) {
+	flags.StringSlice("allowed-registries", nil, "Comma separated registry patterns SBOM components must come from, matched against the purl repository_url or the default registry of the package type, e.g. artifactory.example.com/** (optional)")
+	flags.String("registry-violations", registryViolationFail, "What to do with SBOMs that have components from registries that are not allowed: fail the upload, or warn")
+	flags.StringSlice("deny-license", nil, "Comma separated SPDX license IDs SBOM components must not be licensed under, where * matches any characters, e.g. GPL-3.0-only,AGPL-*; checked locally before upload, a component is only denied when every license it offers is (optional)")
+	flags.String("typosquat-check", typosquatCheckOff, "Compare SBOM component names against popular packages before upload and report likely typosquats: off, warn, or fail the upload")
+	flags.String("typosquat-corpus", "", "File of popular packages to compare against with typosquat-check, one <purl type>/<name> per line, replacing the built in list (optional)")
+}
+
+// addMaintenanceFlags defines the flags of the maintenance check, which runs
+// next to the blocked package check
+func addMaintenanceFlags(flags *pflag.FlagSet) {
+	flags.String("maintenance-check", maintenanceCheckOff, "Look up SBOM components in deps.dev after upload, next to the blocked package check, and report packages without a recent release or with an archived source repository: off, warn, or fail the run")
+	flags.Int("max-release-age", 2, "Years since the latest release of a package after which maintenance-check reports it")
+	flags.String("deps-dev-endpoint", defaultDepsDevEndpoint, "deps.dev API endpoint used by maintenance-check")
+	flags.Duration("cache-ttl", 24*time.Hour, "How long cached enrichment API responses are used before they are fetched again, 0 disables the cache")
+	flags.Bool("offline", false, "Use only cached enrichment API responses, however old, and skip lookups that are not cached")
+}
+
+// addBlockedOutputFlag defines the flag of the destinations of blocked package
+// findings, for the commands that run the blocked package check
+func addBlockedOutputFlag(flags *pflag.FlagSet) {
+	flags.StringSlice("blocked-output", nil, "Comma separated FORMAT:TARGET destinations of blocked package findings, where FORMAT is text, json or sarif and TARGET is - for stdout, a file or a webhook URL, e.g. text:-,sarif:blocked.sarif (optional, defaults to text:-)")
+}
+
+// addFilesFromFlags defines the flags that read the files to process from a list
+func addFilesFromFlags(flags *pflag.FlagSet) {
+	flags.String("files-from", "", "Read the paths of the files to upload from this file, or from stdin if \"-\" (optional)")
+	flags.BoolP("null", "0", false, "Paths read with files-from are separated by NUL characters instead of newlines, as written by find -print0 (optional)")
+}
+
+// bindUploadFlags binds the upload flags of the command being run. The root and
+// upload commands share the flag names, so they are bound when one of them
+// runs rather than when they are created.
+func bindUploadFlags(cmd *cobra.Command, args []string) {
+	for _, name := range uploadFlags {
+		if cmd.Flags().Lookup(name) != nil {
+			mustBindPFlag(cmd, name)
+		}
+	}
+}
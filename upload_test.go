@@ -0,0 +1,44 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func Test_addUploadFlags(t *testing.T) {
+	flags := pflag.NewFlagSet("upload", pflag.ContinueOnError)
+	addUploadFlags(flags)
+
+	// every upload flag must be bound, or its environment variables and config
+	// file values would be ignored
+	bound := map[string]bool{}
+	for _, name := range uploadFlags {
+		bound[name] = true
+	}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !bound[f.Name] {
+			t.Errorf("flag %s is not in uploadFlags", f.Name)
+		}
+	})
+	for _, name := range uploadFlags {
+		if flags.Lookup(name) == nil {
+			t.Errorf("uploadFlags lists %s, which is not an upload flag", name)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// base64ChunkSize is how many bytes of a blob are encoded at a time, a
+// multiple of 3 so the encoded chunks concatenate without padding
+const base64ChunkSize = 48 * 1024
+
+// documentBody is the JSON encoding of a Document or DocumentWrapper used as
+// the body of an upload. The base64 encoding of the blob, the bulk of the
+// body, is streamed from the blob in memory instead of being built as a second
+// copy a third larger than the file.
+type documentBody struct {
+	head, tail string
+	blob       []byte
+}
+
+// newDocumentBody encodes v, whose Document's Blob must be blob. The blob is
+// the first field of a Document, so the encoding of v without it is split
+// where the blob goes.
+func newDocumentBody(v any, doc *Document, blob []byte) (*documentBody, error) {
+	if len(blob) == 0 {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return &documentBody{head: string(data)}, nil
+	}
+
+	doc.Blob = nil
+	data, err := json.Marshal(v)
+	doc.Blob = blob
+	if err != nil {
+		return nil, err
+	}
+	rest, ok := strings.CutPrefix(string(data), `{"Blob":null`)
+	if !ok {
+		return nil, fmt.Errorf("unexpected document encoding %.20q", data)
+	}
+	return &documentBody{head: `{"Blob":"`, tail: `"` + rest, blob: blob}, nil
+}
+
+// Len returns the length of the body in bytes
+func (b *documentBody) Len() int64 {
+	n := len(b.head) + len(b.tail)
+	if len(b.blob) > 0 {
+		n += base64.StdEncoding.EncodedLen(len(b.blob))
+	}
+	return int64(n)
+}
+
+// Reader returns a new reader of the body
+func (b *documentBody) Reader() io.ReadCloser {
+	return io.NopCloser(io.MultiReader(strings.NewReader(b.head), &base64Reader{src: b.blob}, strings.NewReader(b.tail)))
+}
+
+// base64Reader reads the standard base64 encoding of src, base64ChunkSize
+// bytes of src at a time
+type base64Reader struct {
+	src   []byte
+	chunk []byte
+	buf   []byte
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if len(r.src) == 0 {
+			return 0, io.EOF
+		}
+		n := min(len(r.src), base64ChunkSize)
+		r.chunk = base64.StdEncoding.AppendEncode(r.chunk[:0], r.src[:n])
+		r.src = r.src[n:]
+		r.buf = r.chunk
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,71 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_documentBody(t *testing.T) {
+	for _, size := range []int{0, 1, 2, 3, 4, base64ChunkSize - 1, base64ChunkSize, base64ChunkSize + 1, 3*base64ChunkSize + 5} {
+		blob := make([]byte, size)
+		for i := range blob {
+			blob[i] = byte(i * 7)
+		}
+		meta := map[string]string{"run_id": "run", "filename": "a\"b.json"}
+
+		for _, wrapped := range []bool{false, true} {
+			doc := &Document{
+				Blob:              blob,
+				Type:              DocumentSBOM,
+				Format:            FormatJSON,
+				SourceInformation: SourceInformation{Collector: "Kusari-Uploader", Source: "file:///a.json", DocumentRef: "sha256_x"},
+			}
+			var v any = doc
+			if wrapped {
+				v = DocumentWrapper{Document: doc, UploadMetaData: &meta, SchemaVersion: 2}
+			}
+			want, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := newDocumentBody(v, doc, blob)
+			if err != nil {
+				t.Fatalf("newDocumentBody() error = %v", err)
+			}
+			if !bytes.Equal(doc.Blob, blob) {
+				t.Fatalf("newDocumentBody() did not restore the blob of the document")
+			}
+			for i := 0; i < 2; i++ {
+				got, err := io.ReadAll(iotest.HalfReader(body.Reader()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("size %d, wrapped %v: body differs from json.Marshal", size, wrapped)
+				}
+			}
+			if body.Len() != int64(len(want)) {
+				t.Errorf("size %d, wrapped %v: Len() = %d, want %d", size, wrapped, body.Len(), len(want))
+			}
+		}
+	}
+}
@@ -0,0 +1,230 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// validateWarn is the status of a check that passed with a problem worth
+// fixing before the upload
+const validateWarn = "WARN"
+
+// fileCheck is the result of one local check of a file
+type fileCheck struct {
+	Path   string `json:"path"`
+	Name   string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+func newValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate <file-or-dir>",
+		Short: "Run the local checks of an upload against a file or directory without contacting any endpoint",
+		Long: "Run the checks the upload command runs locally against a file or every file of a directory: format " +
+			"detection, SBOM parsing, subject extraction, size limits, metadata completeness, and the component " +
+			"origin and typosquat checks if they are configured. Nothing is sent anywhere. Exits with status 5 if any check fails.",
+		Args:   cobra.ExactArgs(1),
+		PreRun: bindUploadFlags,
+		Run:    validate,
+	}
+
+	addMetadataFlags(cmd.Flags())
+	addDocumentFlags(cmd.Flags())
+	addSBOMCheckFlags(cmd.Flags())
+	cmd.Flags().Bool("open-vex", false, "Validate the files as OpenVEX documents (optional)")
+	cmd.Flags().Int64("max-size", 0, "Largest document size in bytes the tenant accepts, which can't be looked up offline (optional, 0 for no limit)")
+
+	mustBindPFlag(cmd, "max-size")
+
+	return cmd
+}
+
+func validate(cmd *cobra.Command, args []string) {
+	mustValidateDocRefTemplate()
+	mustResolveForceFlags()
+	mustValidateCustomMetadata(cmd.Flags())
+
+	rules, err := loadRoutingRules()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid routing rules")
+	}
+	constraints, err := loadConstraints()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Invalid constraints")
+	}
+
+	isOpenVex := viper.GetBool("open-vex")
+	caps := &tenantCapabilities{MaxDocumentSize: viper.GetInt64("max-size")}
+	uploadMeta := metadataValues(resolveUploadMetadata(cmd.Flags(), nil, ""))
+
+	root := args[0]
+	info, err := os.Stat(root)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to get stats on filepath")
+	}
+
+	var checks []fileCheck
+	validateFile := func(path, relPath string) error {
+		blob, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading file: %s, err: %w", path, err)
+		}
+		checks = append(checks, validateDocument(path, blob, isOpenVex, caps, constraints,
+			applyRoutingRules(rules, relPath, uploadMeta))...)
+		return nil
+	}
+	if info.IsDir() {
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			relPath, err := filepath.Rel(root, path)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path of %s: %w", path, err)
+			}
+			return validateFile(path, relPath)
+		})
+	} else {
+		err = validateFile(root, root)
+	}
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to validate files")
+	}
+
+	printFileChecks(cmd.OutOrStdout(), checks, viper.GetString("output") == outputNDJSON)
+	for _, check := range checks {
+		if check.Status == doctorFail {
+			exitRun(exitValidation)
+		}
+	}
+}
+
+// validateDocument runs the local checks of an upload against a document
+// with the upload metadata it would be uploaded with
+func validateDocument(path string, blob []byte, isOpenVex bool, caps *tenantCapabilities, constraints *runConstraints,
+	uploadMeta map[string]string) []fileCheck {
+	check := func(name, status, detail string) fileCheck {
+		return fileCheck{Path: path, Name: name, Status: status, Detail: detail}
+	}
+	result := func(name string, err error, detail string) fileCheck {
+		if err != nil {
+			return check(name, doctorFail, err.Error())
+		}
+		return check(name, doctorPass, detail)
+	}
+
+	if len(blob) == 0 {
+		return []fileCheck{check("format", validateWarn, "empty file, it is skipped by uploads")}
+	}
+
+	kind, format := detectDocument(blob)
+	checks := []fileCheck{result("format", parseDocument(blob, format), kind+", "+format)}
+	if forced := viper.GetString("force-format"); forced != "" {
+		checks[0].Detail += ", uploaded as " + forced
+	}
+
+	isSBOM := kind == kindCycloneDX || kind == kindSPDX
+	switch ssau := localSBOMSubject(blob); {
+	case isOpenVex || !isSBOM:
+		checks = append(checks, check("subject", doctorSkip, "not an SBOM"))
+	case ssau.subject != "":
+		checks = append(checks, check("subject", doctorPass, ssau.subject+" "+ssau.uri))
+	default:
+		checks = append(checks, check("subject", validateWarn, "no subject and URI, the blocked package check can't find this SBOM"))
+	}
+
+	sizeDetail := fmt.Sprintf("%d bytes", len(blob))
+	checks = append(checks, result("size", caps.checkSize(path, int64(len(blob))), sizeDetail))
+
+	sum := getHash(blob)
+	docRef, err := documentRef(blob, sum, uploadMeta)
+	if err == nil {
+		err = constraints.checkMetadata(path, documentMetadata(path, blob, sum, docRef, uploadMeta))
+	}
+	checks = append(checks, result("metadata", err, "document ref "+docRef))
+
+	if !isOpenVex && isSBOM {
+		// in warn mode the checks pass, so their findings are looked up again
+		if patterns := viper.GetStringSlice("allowed-registries"); len(patterns) > 0 {
+			c := result("origins", checkComponentOrigins(path, blob), "components come from allowed registries")
+			if allowed, err := compileRegistryPatterns(patterns); c.Status == doctorPass && err == nil {
+				if v := registryViolations(sbomComponentOrigins(blob), allowed); len(v) > 0 {
+					c = check("origins", validateWarn, fmt.Sprintf("%d component(s) from registries that are not allowed, e.g. %s from %s",
+						len(v), v[0].Purl, v[0].Registry))
+				}
+			}
+			checks = append(checks, c)
+		}
+		if patterns := viper.GetStringSlice("deny-license"); len(patterns) > 0 {
+			checks = append(checks, result("licenses", checkDeniedLicenses(path, blob), "no denied licenses"))
+		}
+		if mode := viper.GetString("typosquat-check"); mode != "" && mode != typosquatCheckOff {
+			c := result("typosquats", checkTyposquats(path, blob), "no likely typosquats")
+			if corpus, err := loadTyposquatCorpus(viper.GetString("typosquat-corpus")); c.Status == doctorPass && err == nil {
+				if f := findTyposquats(path, sbomPurls(blob), corpus); len(f) > 0 {
+					c = check("typosquats", validateWarn, fmt.Sprintf("%d possible typosquat(s), e.g. %s is similar to %s",
+						len(f), f[0].Purl, f[0].Similar))
+				}
+			}
+			checks = append(checks, c)
+		}
+	}
+
+	return checks
+}
+
+// printFileChecks writes a table of the checks of every file, or one JSON
+// object per check
+func printFileChecks(w io.Writer, checks []fileCheck, asJSON bool) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		for _, check := range checks {
+			enc.Encode(check) //nolint:errcheck
+		}
+		return
+	}
+
+	color := colorEnabled(w)
+	counts := map[string]int{}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "FILE\tCHECK\t%s\tDETAIL\n", paintStatus("STATUS", color))
+	for _, check := range checks {
+		counts[check.Status]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", check.Path, check.Name, paintStatus(check.Status, color), check.Detail)
+	}
+	tw.Flush() //nolint:errcheck
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed, %d skipped\n",
+		counts[doctorPass], counts[validateWarn], counts[doctorFail], counts[doctorSkip])
+}
@@ -0,0 +1,148 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_validateDocument(t *testing.T) {
+	cdx := []byte(`{"bomFormat": "CycloneDX", "serialNumber": "urn:uuid:1", "metadata": {"component": {"name": "app"}}}`)
+	tests := []struct {
+		name       string
+		blob       []byte
+		isOpenVex  bool
+		maxSize    int64
+		required   []string
+		uploadMeta map[string]string
+		want       map[string]string
+	}{
+		{
+			name:       "valid SBOM",
+			blob:       cdx,
+			required:   []string{"team"},
+			uploadMeta: map[string]string{"team": "payments"},
+			want:       map[string]string{"format": doctorPass, "subject": doctorPass, "size": doctorPass, "metadata": doctorPass},
+		},
+		{
+			name:     "missing metadata and too large",
+			blob:     cdx,
+			maxSize:  10,
+			required: []string{"team"},
+			want:     map[string]string{"format": doctorPass, "subject": doctorPass, "size": doctorFail, "metadata": doctorFail},
+		},
+		{
+			name: "SBOM without subject",
+			blob: []byte(`{"bomFormat": "CycloneDX"}`),
+			want: map[string]string{"format": doctorPass, "subject": validateWarn, "size": doctorPass, "metadata": doctorPass},
+		},
+		{
+			name:      "OpenVEX",
+			blob:      []byte(`{"@context": "https://openvex.dev/ns/v0.2.0"}`),
+			isOpenVex: true,
+			want:      map[string]string{"format": doctorPass, "subject": doctorSkip, "size": doctorPass, "metadata": doctorPass},
+		},
+		{
+			name: "invalid XML",
+			blob: []byte(`<bom xmlns="http://cyclonedx.org/schema/bom/1.5"><components></bom>`),
+			want: map[string]string{"format": doctorFail, "subject": validateWarn, "size": doctorPass, "metadata": doctorPass},
+		},
+		{
+			name: "unknown format",
+			blob: []byte("hello"),
+			want: map[string]string{"format": doctorFail, "subject": doctorSkip, "size": doctorPass, "metadata": doctorPass},
+		},
+		{
+			name: "empty",
+			blob: []byte{},
+			want: map[string]string{"format": validateWarn},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			viper.Set("omit-file-metadata", true)
+
+			caps := &tenantCapabilities{MaxDocumentSize: tt.maxSize}
+			constraints := &runConstraints{RequiredMetadata: tt.required}
+			checks := validateDocument("sbom.json", tt.blob, tt.isOpenVex, caps, constraints, tt.uploadMeta)
+
+			got := map[string]string{}
+			for _, c := range checks {
+				if c.Path != "sbom.json" {
+					t.Errorf("check %s has path %s", c.Name, c.Path)
+				}
+				got[c.Name] = c.Status
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("validateDocument() = %+v, want %v", checks, tt.want)
+			}
+			for name, status := range tt.want {
+				if got[name] != status {
+					t.Errorf("validateDocument() check %s = %s, want %s (%+v)", name, got[name], status, checks)
+				}
+			}
+		})
+	}
+}
+
+func Test_validateDocument_typosquats(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.Set("typosquat-check", typosquatCheckWarn)
+
+	blob := []byte(`{"bomFormat": "CycloneDX", "components": [{"purl": "pkg:npm/lodahs@4.17.21"}]}`)
+	var status, detail string
+	for _, c := range validateDocument("sbom.json", blob, false, &tenantCapabilities{}, &runConstraints{}, nil) {
+		if c.Name == "typosquats" {
+			status, detail = c.Status, c.Detail
+		}
+	}
+	if status != validateWarn || !strings.Contains(detail, "lodash") {
+		t.Errorf("typosquats check = %s %q, want a warning about lodash", status, detail)
+	}
+
+	viper.Set("typosquat-check", typosquatCheckFail)
+	for _, c := range validateDocument("sbom.json", blob, false, &tenantCapabilities{}, &runConstraints{}, nil) {
+		if c.Name == "typosquats" && c.Status != doctorFail {
+			t.Errorf("typosquats check in fail mode = %s, want %s", c.Status, doctorFail)
+		}
+	}
+}
+
+func Test_printFileChecks(t *testing.T) {
+	checks := []fileCheck{
+		{Path: "a.json", Name: "format", Status: doctorPass, Detail: "CycloneDX SBOM, json"},
+		{Path: "a.json", Name: "metadata", Status: doctorFail, Detail: "a.json is missing required upload metadata: team"},
+	}
+
+	var buf bytes.Buffer
+	printFileChecks(&buf, checks, false)
+	if !strings.Contains(buf.String(), "a.json  metadata  FAIL") || !strings.Contains(buf.String(), "1 passed, 0 warnings, 1 failed, 0 skipped") {
+		t.Errorf("printFileChecks() = %q", buf.String())
+	}
+
+	buf.Reset()
+	printFileChecks(&buf, checks[:1], true)
+	if buf.String() != `{"path":"a.json","check":"format","status":"PASS","detail":"CycloneDX SBOM, json"}`+"\n" {
+		t.Errorf("printFileChecks() as JSON = %q", buf.String())
+	}
+}
@@ -0,0 +1,230 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// defaultReleaseURL is where release assets are downloaded from, followed by /<tag>/<asset>
+const defaultReleaseURL = "https://github.com/kusaridev/kusari-uploader/releases/download"
+
+// defaultAttestationsURL lists the GitHub artifact attestations of a digest, followed by /sha256:<digest>
+const defaultAttestationsURL = "https://api.github.com/repos/kusaridev/kusari-uploader/attestations"
+
+// releaseChecksumsAsset is the checksums file published with every release
+const releaseChecksumsAsset = "checksums.txt"
+
+// selfVerification is the result of verifying the running binary
+type selfVerification struct {
+	Version string
+	Asset   string
+	SHA256  string
+	// Published is the checksum published for Asset, or empty if it has none
+	Published string
+	// Attestations is the number of build provenance attestations of the
+	// binary's digest, or -1 if they were not looked up
+	Attestations int
+}
+
+// ok reports whether the binary matches its published checksum
+func (v selfVerification) ok() bool {
+	return v.Published != "" && v.Published == v.SHA256
+}
+
+func newVerifySelfCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify-self",
+		Short: "Verify the running binary against the checksums and attestations published for its version",
+		Long: "Verify that the running binary is the one released for its version: its sha256 must match the " +
+			"published checksums, and build provenance attestations must exist for it. Exits with status 5 otherwise.",
+		Args: cobra.NoArgs,
+		Run:  verifySelf,
+	}
+
+	cmd.Flags().String("release-url", defaultReleaseURL, "Base URL of the release assets, for mirrors")
+	cmd.Flags().String("attestations-url", defaultAttestationsURL, "Base URL of the attestations API, or empty to skip the attestation check")
+
+	mustBindPFlag(cmd, "release-url")
+	mustBindPFlag(cmd, "attestations-url")
+
+	return cmd
+}
+
+func verifySelf(cmd *cobra.Command, args []string) {
+	ctx := context.Background()
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to find the running binary")
+	}
+
+	ctx, client := mustNewHTTPClient(ctx)
+	result, err := verifyBinary(ctx, client, exe, buildVersion(), runtime.GOOS, runtime.GOARCH,
+		viper.GetString("release-url"), viper.GetString("attestations-url"))
+	if err != nil {
+		fatalErr(err, exitUsage).
+			Msg("Failed to verify the running binary")
+	}
+
+	printSelfVerification(cmd.OutOrStdout(), result)
+	if !result.ok() || result.Attestations == 0 {
+		exitRun(exitValidation)
+	}
+}
+
+// releaseAsset returns the name of the release binary for an OS and architecture, see .goreleaser.yaml
+func releaseAsset(goos, goarch string) string {
+	name := binaryName + "-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// releaseTag returns the git tag of a release version. Release builds get the
+// version without the "v" prefix from goreleaser, go install builds with it.
+func releaseTag(version string) (string, error) {
+	if version == "" || version == "(devel)" || strings.Contains(version, "snapshot") || strings.Contains(version, "+dirty") ||
+		strings.Count(version, "-") >= 2 {
+		return "", fmt.Errorf("version %q is not a release build, only released binaries can be verified", version)
+	}
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return version, nil
+}
+
+// verifyBinary hashes the binary at path and compares it with the checksum
+// published for the release of version, then looks up its attestations
+func verifyBinary(ctx context.Context, client HttpClient, path, version, goos, goarch, releaseURL, attestationsURL string) (selfVerification, error) {
+	tag, err := releaseTag(version)
+	if err != nil {
+		return selfVerification{}, err
+	}
+
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return selfVerification{}, fmt.Errorf("error reading binary: %s, err: %w", path, err)
+	}
+	sum := sha256.Sum256(blob)
+
+	result := selfVerification{
+		Version:      tag,
+		Asset:        releaseAsset(goos, goarch),
+		SHA256:       hex.EncodeToString(sum[:]),
+		Attestations: -1,
+	}
+
+	checksums, err := getURL(ctx, client, strings.TrimSuffix(releaseURL, "/")+"/"+tag+"/"+releaseChecksumsAsset)
+	if err != nil {
+		return selfVerification{}, fmt.Errorf("error downloading the checksums of %s: %w", tag, err)
+	}
+	result.Published = publishedChecksum(checksums, result.Asset)
+
+	if attestationsURL != "" {
+		body, err := getURL(ctx, client, strings.TrimSuffix(attestationsURL, "/")+"/sha256:"+result.SHA256)
+		if err != nil {
+			return selfVerification{}, fmt.Errorf("error looking up attestations: %w", err)
+		}
+		var attestations struct {
+			Attestations []json.RawMessage `json:"attestations"`
+		}
+		if err := json.Unmarshal(body, &attestations); err != nil {
+			return selfVerification{}, fmt.Errorf("error unmarshaling attestations: %w", err)
+		}
+		result.Attestations = len(attestations.Attestations)
+	}
+
+	return result, nil
+}
+
+// publishedChecksum returns the checksum of asset in a sha256sum style checksums file
+func publishedChecksum(checksums []byte, asset string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == asset {
+			return strings.ToLower(fields[0])
+		}
+	}
+	return ""
+}
+
+// getURL returns the body of a GET request. A 404 returns an empty body, since
+// a missing checksum or attestation is reported as a failed verification.
+func getURL(ctx context.Context, client HttpClient, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	if res.StatusCode == http.StatusNotFound {
+		return []byte("{}"), nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status code for %s: %d", url, res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// printSelfVerification writes the result of verifying the running binary
+func printSelfVerification(w io.Writer, v selfVerification) {
+	fmt.Fprintf(w, "Version: %s\n", v.Version)
+	fmt.Fprintf(w, "Binary:  %s\n", v.Asset)
+	fmt.Fprintf(w, "SHA256:  %s\n", v.SHA256)
+
+	switch {
+	case v.Published == "":
+		fmt.Fprintf(w, "Checksum: FAILED, no checksum is published for %s in %s\n", v.Asset, v.Version)
+	case !v.ok():
+		fmt.Fprintf(w, "Checksum: FAILED, the published checksum is %s\n", v.Published)
+	default:
+		fmt.Fprintln(w, "Checksum: OK")
+	}
+
+	switch {
+	case v.Attestations < 0:
+		fmt.Fprintln(w, "Attestations: skipped")
+	case v.Attestations == 0:
+		fmt.Fprintln(w, "Attestations: FAILED, no build provenance attestation was published for this binary")
+	default:
+		fmt.Fprintf(w, "Attestations: %d found; verify their signatures with: gh attestation verify %s --repo kusaridev/kusari-uploader\n",
+			v.Attestations, v.Asset)
+	}
+}
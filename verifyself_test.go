@@ -0,0 +1,97 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_releaseTag(t *testing.T) {
+	for version, want := range map[string]string{"1.2.3": "v1.2.3", "v1.2.3": "v1.2.3", "v1.3.0-rc.1": "v1.3.0-rc.1"} {
+		if got, err := releaseTag(version); err != nil || got != want {
+			t.Errorf("releaseTag(%q) = %q, %v, want %q", version, got, err, want)
+		}
+	}
+	for _, version := range []string{"(devel)", "v0.0.0-20240501120000-abcdef123456", "v1.2.3+dirty", "0.0.0-snapshot-tag"} {
+		if _, err := releaseTag(version); err == nil {
+			t.Errorf("releaseTag(%q) accepted a build that is not a release", version)
+		}
+	}
+}
+
+func Test_verifyBinary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kusari-uploader")
+	if err := os.WriteFile(path, []byte("binary"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("binary"))
+	digest := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name      string
+		checksums string
+		wantOK    bool
+	}{
+		{
+			name:      "matching checksum",
+			checksums: "0000  " + binaryName + "-darwin-arm64\n" + digest + "  " + binaryName + "-linux-amd64\n",
+			wantOK:    true,
+		},
+		{
+			name:      "tampered binary",
+			checksums: "0000  " + binaryName + "-linux-amd64\n",
+		},
+		{
+			name:      "asset not published",
+			checksums: digest + "  " + binaryName + "-linux-arm64\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &ClientMock{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					body := ""
+					switch req.URL.String() {
+					case "https://releases.example.com/v1.2.3/checksums.txt":
+						body = tt.checksums
+					case "https://api.example.com/attestations/sha256:" + digest:
+						body = `{"attestations": [{"bundle": {}}]}`
+					default:
+						t.Errorf("unexpected request to %s", req.URL)
+					}
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+				},
+			}
+
+			got, err := verifyBinary(context.Background(), client, path, "1.2.3", "linux", "amd64",
+				"https://releases.example.com/", "https://api.example.com/attestations")
+			if err != nil {
+				t.Fatalf("verifyBinary() error = %v", err)
+			}
+			if got.ok() != tt.wantOK || got.SHA256 != digest || got.Attestations != 1 {
+				t.Errorf("verifyBinary() = %+v, want ok %v", got, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// version, commit and date are set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=...",
+// as goreleaser does
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// versionInfo describes the build of the uploader
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+	// Restricted is set for builds that only expose the agent commands
+	Restricted bool `json:"restricted,omitempty"`
+}
+
+func newVersionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version, git commit, build time and Go version of the uploader",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			writeVersion(cmd.OutOrStdout(), getVersionInfo(), viper.GetString("output") == outputNDJSON)
+		},
+	}
+}
+
+// buildVersion returns the version set at build time, or else the module
+// version recorded by go install
+func buildVersion() string {
+	return getVersionInfo().Version
+}
+
+// getVersionInfo returns the build information set with ldflags, falling back
+// to the module and VCS information embedded by the Go toolchain, in which case
+// the build time is the time of the commit
+func getVersionInfo() versionInfo {
+	info := versionInfo{Version: version, Commit: commit, BuildTime: date, GoVersion: runtime.Version(), Restricted: restrictedBuild}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" {
+			info.Version = bi.Main.Version
+		}
+		modified := false
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && commit == "" && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+	}
+
+	for _, v := range []*string{&info.Version, &info.Commit, &info.BuildTime} {
+		if *v == "" {
+			*v = "unknown"
+		}
+	}
+	if info.Version == "unknown" {
+		info.Version = "(devel)"
+	}
+	return info
+}
+
+// writeVersion writes the build information as text, or as a JSON object
+func writeVersion(w io.Writer, info versionInfo, asJSON bool) {
+	if asJSON {
+		json.NewEncoder(w).Encode(info) //nolint:errcheck
+		return
+	}
+	fmt.Fprint(w, versionText(info))
+}
+
+// versionText formats the build information for people
+func versionText(info versionInfo) string {
+	text := fmt.Sprintf("kusari-uploader %s\n  commit:     %s\n  build time: %s\n  go version: %s\n",
+		info.Version, info.Commit, info.BuildTime, info.GoVersion)
+	if info.Restricted {
+		text += "  restricted: upload only\n"
+	}
+	return text
+}
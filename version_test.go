@@ -0,0 +1,56 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func Test_getVersionInfo(t *testing.T) {
+	version, commit, date = "v1.2.3", "abc123", "2024-05-01T12:00:00Z"
+	t.Cleanup(func() { version, commit, date = "", "", "" })
+
+	want := versionInfo{Version: "v1.2.3", Commit: "abc123", BuildTime: "2024-05-01T12:00:00Z", GoVersion: runtime.Version(), Restricted: restrictedBuild}
+	if got := getVersionInfo(); got != want {
+		t.Errorf("getVersionInfo() = %+v, want %+v", got, want)
+	}
+	if got := buildVersion(); got != "v1.2.3" {
+		t.Errorf("buildVersion() = %q, want v1.2.3", got)
+	}
+}
+
+func Test_writeVersion(t *testing.T) {
+	info := versionInfo{Version: "v1.2.3", Commit: "abc123", BuildTime: "2024-05-01T12:00:00Z", GoVersion: "go1.25.0"}
+
+	var text bytes.Buffer
+	writeVersion(&text, info, false)
+	for _, s := range []string{"kusari-uploader v1.2.3", "commit:     abc123", "build time: 2024-05-01T12:00:00Z", "go version: go1.25.0"} {
+		if !strings.Contains(text.String(), s) {
+			t.Errorf("writeVersion() = %q, want it to contain %q", text.String(), s)
+		}
+	}
+
+	var js bytes.Buffer
+	writeVersion(&js, info, true)
+	var got versionInfo
+	if err := json.Unmarshal(js.Bytes(), &got); err != nil || got != info {
+		t.Errorf("writeVersion() JSON = %s, %v, want %+v", js.String(), err, info)
+	}
+}
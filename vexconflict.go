@@ -0,0 +1,238 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// vexStatement is a single product/vulnerability status from an OpenVEX document
+type vexStatement struct {
+	Product       string    `json:"product"`
+	Vulnerability string    `json:"vulnerability"`
+	Status        string    `json:"status"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type existingVEXStatements struct {
+	Statements []vexStatement `json:"statements"`
+}
+
+// vexConflict describes a new statement that is older than, or rolls back, the
+// statement the platform currently has for the same product and vulnerability
+type vexConflict struct {
+	New     vexStatement
+	Current vexStatement
+	Reason  string
+}
+
+// resolvedVEXStatuses are statuses that must not silently go back to an open status
+var resolvedVEXStatuses = map[string]bool{
+	"fixed":        true,
+	"not_affected": true,
+}
+
+// parseVEXStatements flattens an OpenVEX document into one statement per
+// product and vulnerability. Statements without a timestamp inherit the
+// timestamp of the document.
+func parseVEXStatements(blob []byte) ([]vexStatement, error) {
+	var doc struct {
+		Timestamp  time.Time `json:"timestamp"`
+		Statements []struct {
+			Vulnerability json.RawMessage   `json:"vulnerability"`
+			Products      []json.RawMessage `json:"products"`
+			Status        string            `json:"status"`
+			Timestamp     *time.Time        `json:"timestamp"`
+		} `json:"statements"`
+	}
+	if err := json.Unmarshal(blob, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenVEX document: %w", err)
+	}
+
+	var statements []vexStatement
+	for _, s := range doc.Statements {
+		// the vulnerability is a plain string in OpenVEX v0.0.x and an object in v0.2
+		var vuln string
+		if err := json.Unmarshal(s.Vulnerability, &vuln); err != nil {
+			var v struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(s.Vulnerability, &v); err != nil {
+				return nil, fmt.Errorf("invalid vulnerability: %s", s.Vulnerability)
+			}
+			vuln = v.Name
+		}
+
+		timestamp := doc.Timestamp
+		if s.Timestamp != nil {
+			timestamp = *s.Timestamp
+		}
+
+		for _, p := range s.Products {
+			product, err := vexProductID(p)
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, vexStatement{
+				Product:       product,
+				Vulnerability: vuln,
+				Status:        s.Status,
+				Timestamp:     timestamp,
+			})
+		}
+	}
+
+	return statements, nil
+}
+
+// warnVEXConflicts logs a warning for every statement in the OpenVEX document at
+// filePath that conflicts with the platform's current statements, and reports
+// whether there were any
+func warnVEXConflicts(ctx context.Context, client HttpClient, tenantEndpoint, filePath string) (bool, error) {
+	blob, err := os.ReadFile(filePath)
+	if err != nil {
+		return false, fmt.Errorf("error reading file: %s, err: %w", filePath, err)
+	}
+
+	conflicts, err := findVEXConflicts(ctx, client, tenantEndpoint, blob)
+	if err != nil {
+		return false, err
+	}
+
+	for _, c := range conflicts {
+		log.Warn().
+			Str("product", c.New.Product).
+			Str("vulnerability", c.New.Vulnerability).
+			Str("status", c.New.Status).
+			Str("currentStatus", c.Current.Status).
+			Time("currentTimestamp", c.Current.Timestamp).
+			Msg("Conflicting VEX statement: " + c.Reason)
+	}
+
+	return len(conflicts) > 0, nil
+}
+
+// checkVEXConflicts warns about the statements in the OpenVEX document at
+// filePath that conflict with the platform's current statements. With
+// failOnConflict, conflicts and failures to look up the current statements are
+// returned as an error, otherwise a failed lookup is only logged so the
+// document is still uploaded. A failed lookup is an upload failure rather than
+// a validation failure, as the document itself was not found to be invalid.
+func checkVEXConflicts(ctx context.Context, client HttpClient, tenantEndpoint, filePath string, failOnConflict bool) error {
+	conflicted, err := warnVEXConflicts(ctx, client, tenantEndpoint, filePath)
+	if err != nil {
+		err = fmt.Errorf("could not check the platform's current VEX statements for conflicts: %w", err)
+		if failOnConflict {
+			return err
+		}
+		log.Warn().Err(err).Msg("Uploading the OpenVEX document without checking for conflicts")
+		return nil
+	}
+	if conflicted && failOnConflict {
+		return withExitCode(exitValidation, errors.New("OpenVEX document conflicts with the platform's current statements"))
+	}
+	return nil
+}
+
+// findVEXConflicts compares the statements of a new OpenVEX document with the
+// statements the platform already has for the same products
+func findVEXConflicts(ctx context.Context, client HttpClient, tenantEndpoint string, blob []byte) ([]vexConflict, error) {
+	statements, err := parseVEXStatements(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	current := map[[2]string]vexStatement{}
+	fetched := map[string]bool{}
+	for _, s := range statements {
+		if fetched[s.Product] {
+			continue
+		}
+		fetched[s.Product] = true
+
+		existing, err := getVEXStatements(ctx, client, tenantEndpoint, s.Product)
+		if err != nil {
+			return nil, withExitCode(exitCodeOf(err, exitUpload), err)
+		}
+		for _, e := range existing {
+			current[[2]string{e.Product, e.Vulnerability}] = e
+		}
+	}
+
+	var conflicts []vexConflict
+	for _, s := range statements {
+		cur, ok := current[[2]string{s.Product, s.Vulnerability}]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case !s.Timestamp.IsZero() && s.Timestamp.Before(cur.Timestamp):
+			conflicts = append(conflicts, vexConflict{New: s, Current: cur, Reason: "statement is older than the current one"})
+		case resolvedVEXStatuses[cur.Status] && !resolvedVEXStatuses[s.Status]:
+			conflicts = append(conflicts, vexConflict{New: s, Current: cur,
+				Reason: fmt.Sprintf("status goes back from %s to %s", cur.Status, s.Status)})
+		}
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].New.Product != conflicts[j].New.Product {
+			return conflicts[i].New.Product < conflicts[j].New.Product
+		}
+		return conflicts[i].New.Vulnerability < conflicts[j].New.Vulnerability
+	})
+
+	return conflicts, nil
+}
+
+// getVEXStatements returns the current VEX statements the platform has for a product
+func getVEXStatements(ctx context.Context, client HttpClient, tenantEndpoint, product string) ([]vexStatement, error) {
+	res, err := makePicoReq(ctx, client, tenantEndpoint, fmt.Sprintf("pico/v1/vex/statements?product=%s", url.QueryEscape(product)))
+	if err != nil {
+		return nil, fmt.Errorf("error making request for VEX statements: %w", err)
+	}
+	defer res.Body.Close() //nolint:errcheck
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, tenantStatusError(res.StatusCode, fmt.Errorf("unexpected response status code for VEX statements: %d", res.StatusCode))
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body for VEX statements: %w", err)
+	}
+
+	var existing existingVEXStatements
+	if err := json.Unmarshal(body, &existing); err != nil {
+		return nil, fmt.Errorf("error unmarshaling response body for VEX statements: %w", err)
+	}
+
+	return existing.Statements, nil
+}
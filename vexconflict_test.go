@@ -0,0 +1,129 @@
+//
+// Copyright 2024 Kusari, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_findVEXConflicts(t *testing.T) {
+	newDoc := `{
+  "timestamp": "2024-06-01T00:00:00Z",
+  "statements": [
+    {"vulnerability": {"name": "CVE-1"}, "products": [{"@id": "pkg:oci/api"}], "status": "affected"},
+    {"vulnerability": "CVE-2", "products": ["pkg:oci/api"], "status": "fixed", "timestamp": "2024-01-01T00:00:00Z"},
+    {"vulnerability": {"name": "CVE-3"}, "products": [{"@id": "pkg:oci/api"}], "status": "fixed"},
+    {"vulnerability": {"name": "CVE-4"}, "products": [{"@id": "pkg:oci/api"}], "status": "under_investigation"}
+  ]
+}`
+	existing := `{"statements": [
+  {"product": "pkg:oci/api", "vulnerability": "CVE-1", "status": "fixed", "timestamp": "2024-05-01T00:00:00Z"},
+  {"product": "pkg:oci/api", "vulnerability": "CVE-2", "status": "affected", "timestamp": "2024-03-01T00:00:00Z"},
+  {"product": "pkg:oci/api", "vulnerability": "CVE-3", "status": "affected", "timestamp": "2024-03-01T00:00:00Z"}
+]}`
+
+	requests := 0
+	client := &ClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			requests++
+			if got := req.URL.Query().Get("product"); got != "pkg:oci/api" {
+				t.Errorf("product = %q, want pkg:oci/api", got)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(existing)),
+			}, nil
+		},
+	}
+
+	conflicts, err := findVEXConflicts(context.Background(), client, "http://example.com", []byte(newDoc))
+	if err != nil {
+		t.Fatalf("findVEXConflicts() error = %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("findVEXConflicts() made %d requests, want 1 per product", requests)
+	}
+
+	want := map[string]string{
+		"CVE-1": "status goes back from fixed to affected",
+		"CVE-2": "statement is older than the current one",
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("findVEXConflicts() = %+v, want %d conflicts", conflicts, len(want))
+	}
+	for _, c := range conflicts {
+		if want[c.New.Vulnerability] != c.Reason {
+			t.Errorf("conflict for %s = %q, want %q", c.New.Vulnerability, c.Reason, want[c.New.Vulnerability])
+		}
+	}
+}
+
+func Test_checkVEXConflicts(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "vex.json")
+	doc := `{"timestamp": "2024-06-01T00:00:00Z", "statements": [
+  {"vulnerability": "CVE-1", "products": ["pkg:oci/api"], "status": "affected"}
+]}`
+	if err := os.WriteFile(filePath, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	respond := func(status int, body string) *ClientMock {
+		return &ClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+			},
+		}
+	}
+	conflicting := respond(http.StatusOK, `{"statements": [
+  {"product": "pkg:oci/api", "vulnerability": "CVE-1", "status": "fixed", "timestamp": "2024-05-01T00:00:00Z"}
+]}`)
+
+	tests := []struct {
+		name           string
+		client         HttpClient
+		failOnConflict bool
+		wantCode       int
+	}{
+		{name: "conflict warns", client: conflicting},
+		{name: "conflict fails", client: conflicting, failOnConflict: true, wantCode: exitValidation},
+		{name: "no current statements", client: respond(http.StatusNotFound, ""), failOnConflict: true},
+		{name: "lookup error warns", client: respond(http.StatusInternalServerError, "")},
+		{name: "lookup error fails", client: respond(http.StatusInternalServerError, ""), failOnConflict: true, wantCode: exitUpload},
+		{name: "lookup rejected", client: respond(http.StatusForbidden, ""), failOnConflict: true, wantCode: exitAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkVEXConflicts(context.Background(), tt.client, "http://example.com", filePath, tt.failOnConflict)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Errorf("checkVEXConflicts() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("checkVEXConflicts() expected an error")
+			}
+			if got := exitCodeOf(err, exitValidation); got != tt.wantCode {
+				t.Errorf("checkVEXConflicts() exit code = %d, want %d", got, tt.wantCode)
+			}
+		})
+	}
+}